@@ -1,6 +1,7 @@
 package config
 
 import (
+	"encoding/json"
 	"log"
 	"net/http"
 	"net/url"
@@ -8,7 +9,9 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	_ "time/tzdata" // 内嵌时区数据，精简镜像中也能加载 BOT_TZ
 
 	"github.com/joho/godotenv"
 )
@@ -24,13 +27,183 @@ type Config struct {
 	BotWSURL       string
 	BotToken       string
 	ProxyURL       string
+	BotName        string // 机器人的名字：人设中的自称，同时用于识别"叫名字"的消息（等同于 @ 机器人）
 	SuperUsers     []int64
+	// GroupAllowlist 群组白名单（为空表示不限制），启动后以 Redis 中的名单为准，可用工具运行时增删
+	GroupAllowlist []int64
+
+	// OneBot 连接断开或启动时连不上时的重连间隔：从 WSReconnectInterval 开始每次翻倍，不超过 WSReconnectMaxInterval
+	WSReconnectInterval    time.Duration
+	WSReconnectMaxInterval time.Duration
+	// HealthAddr 健康检查 HTTP 服务监听地址（/healthz、/readyz），为空不启动
+	HealthAddr string
+
+	// 按服务单独设置的代理（为空沿用 ProxyURL，填 direct 表示该服务直连）
+	LLMProxy       string // 对话、分类等模型请求
+	EmbeddingProxy string // 向量化请求
+
+	// Location 机器人所在时区（BOT_TZ），用于提示词中的时间信息、相对时间和定时任务
+	Location *time.Location
+
+	// EmbeddingTimeout 单次 Embedding 请求的超时时间
+	EmbeddingTimeout time.Duration
+	// EmbeddingModel 向量模型名称（为空使用内置默认模型），EmbeddingDim 写入/检索使用的向量维度（需与 Pinecone 索引一致）
+	EmbeddingModel string
+	EmbeddingDim   int
+	// EmbeddingTruncationWarnRatio 原生维度是 EmbeddingDim 的多少倍及以上时警告截断过多（0 表示不检查，也不做启动探测）
+	EmbeddingTruncationWarnRatio float64
+
+	// SummarizeThreshold 超过该字数的消息先摘要再存入向量库（0 表示关闭）
+	SummarizeThreshold int
+	// RAGWindow 多条消息合成一条记忆的方式：off 逐条存储，consecutive 同一用户连发的消息合并，
+	// reply 用户提问与机器人回复成对存储，both 两者都开启
+	RAGWindow string
+	// RAGWindowSize 连发合并时一条记忆最多包含的消息数，RAGWindowGap 相邻两条消息的最大间隔
+	RAGWindowSize int
+	RAGWindowGap  time.Duration
+	// 每次回复注入上下文的记忆条数上限（按 namespace 分别控制）
+	PersonalTopK int
+	ChatTopK     int
+	// ReconcileHour 每天几点执行向量对账（0-23，-1 表示关闭）
+	ReconcileHour int
+	// 聊天记录保留策略（均为 0 表示不清理），仍被记忆或反馈引用的记录始终保留
+	HistoryMaxAge       time.Duration // 删除早于该时长的消息
+	HistoryKeepPerGroup int           // 每个群（私聊按用户）最多保留的消息条数
+	HistoryPruneHour    int           // 每天几点执行清理（0-23，-1 表示关闭）
+	HistoryHardDelete   bool          // 物理删除（默认软删除）
+	// CustomPersonalPatterns 自定义个人信息正则（分类器降级时使用）
+	CustomPersonalPatterns []string
+
+	// 分类器 prompt（调整主动关怀的敏感度无需重新编译）
+	ClassifierPromptFile     string   // 自定义 prompt 模板文件路径（Go text/template），为空则使用内置模板
+	ClassifierCategories     []string // 覆盖类别定义，形如 "personal:定义"
+	ClassifierProactiveRules []string // 覆盖主动关怀触发条件
+
+	// 分类请求的超时与重试（与主对话独立，分类对延迟更敏感）
+	ClassifierTimeout    time.Duration // 单次请求超时
+	ClassifierAttempts   int           // 最多尝试次数（限流/网络错误时重试），之后退回正则分类
+	ClassifierRetryDelay time.Duration // 重试前的等待时间
+
+	// 主动关怀消息
+	CareUseLLM           bool   // 是否由 LLM 生成关怀消息（关闭则直接使用模板，更可控也更省）
+	CareFallbackTemplate string // 关怀消息模板，支持 {reason}、{content} 占位符
+	// CareConfirmMode 主动关怀的确认方式：auto 直接安排，ask 先询问用户，review 由超级用户审核
+	CareConfirmMode     string
+	CareConfirmTemplate string        // ask 模式下询问用户的话，占位符同关怀模板
+	CareConfirmTimeout  time.Duration // ask 模式下等待用户回应的时间
+	CareUserCooldown    time.Duration // 同一用户两次随访之间的最短间隔（0 表示不限制）
+	// CarePersistTemporary 触发随访的临时状态同时存入聊天向量库（临时状态本身只在 Redis 中短期保留）
+	CarePersistTemporary bool
+
+	// ProactiveMaxMemoryAge 主动插嘴时可引用记忆的最大年龄（0 表示不限制）
+	ProactiveMaxMemoryAge time.Duration
+	// ProactiveDailyCap 每个群每天主动插嘴的默认上限（群组可单独配置，0 表示不限制）
+	ProactiveDailyCap int
+	// 群活跃度门槛：最近 ProactiveActivityWindow 内至少 ProactiveMinMessages 条消息才主动插嘴（0 表示不限制）
+	ProactiveMinMessages    int
+	ProactiveActivityWindow time.Duration
+	// 冷场预热：群里沉寂超过 ProactiveIdleThreshold 后重新活跃时，前 ProactiveWarmup 内不主动插嘴、不随口接话（0 表示不限制）
+	ProactiveIdleThreshold time.Duration
+	ProactiveWarmup        time.Duration
+	// ProactiveQuietHours 免打扰时段（机器人时区的"起始时-结束时"，如 23-8，可跨午夜），期间不主动插嘴、不随口接话；留空表示不限制
+	ProactiveQuietHours string
+
+	// 新群组的默认开关（群组记录首次创建时写入；注重隐私的部署可默认关闭记忆，由各群自行开启）
+	GroupDefaultActive bool
+	GroupDefaultRAG    bool
+
+	// MoodTTL 对话情绪的保留时间：几轮对话间的语气保持连贯，超过该时间没有新消息则重新开始（0 表示不记录）
+	MoodTTL time.Duration
+
+	// 不同场景（"变脸"）下对话回复的采样温度：技术场景更严谨，闲聊更活泼
+	SceneTempTech     float64
+	SceneTempPersonal float64
+	SceneTempCasual   float64
+
+	// ModelExtraParams 按模型合并进 Chat Completions 请求体的额外参数（如 top_p），键为模型名，"*" 对所有模型生效
+	ModelExtraParams map[string]map[string]interface{}
+	// LLMExtraHeaders 模型请求附加的 HTTP 请求头
+	LLMExtraHeaders map[string]string
+
+	// MaxReplyTokens 对话类回复的 max_tokens（群组可单独配置），超出时在句末软截断
+	MaxReplyTokens int
+
+	// 同一群同时生成的 AI 回复数上限（0 表示不限制），超出的请求排队等待，超时则放弃
+	MaxConcurrentReplies int
+	ReplyQueueTimeout    time.Duration
+
+	// ReplyDebounce 连发合并窗口：同一用户短时间内连发的几条消息合并成一个提问再回复（0 表示关闭）
+	ReplyDebounce time.Duration
+	// ReplyPrefix / ReplySuffix 加在每条 AI 回复前后的文字（如 "🤖{bot_name}：" 或 " [AI生成]"），{bot_name} 替换为机器人名字
+	ReplyPrefix string
+	ReplySuffix string
+
+	// AddressedDirective 被 @ 或私聊时追加到系统提示词的要求，减少用"我不知道"搪塞直接提问（为空则不追加）
+	AddressedDirective string
+
+	// 模型返回空回复时的兜底
+	EmptyReplyVariety   bool     // 是否随机选用兜底回复（关闭则固定为"我不知道该怎么回答你..."）
+	EmptyReplyFallbacks []string // 自定义兜底回复池，为空则使用内置文案
+
+	// 工具调用
+	ToolTimeout     time.Duration // 单个工具的默认执行超时（工具可单独声明 Timeout）
+	ToolMaxParallel int           // 只读工具并发执行的最大数量
+	// FCActionCheck 模型声称执行了操作却没有调用工具时的处理：off 不检查，log 记录日志，retry 记录并强制调用工具重试一次
+	FCActionCheck string
+
+	// GroupMetaRefresh 定期同步群名称与成员数的间隔（0 表示不同步）
+	GroupMetaRefresh time.Duration
+
+	// BroadcastInterval 广播公告时相邻两个群之间的发送间隔（防止被判定刷屏）
+	BroadcastInterval time.Duration
+
+	// 小黄脸表情转文字：只发表情的消息也能参与分类、检索和接话；StickerText 为表情 ID → 文字，覆盖或补充内置表
+	StickerTextEnabled bool
+	StickerText        map[string]string
+
+	// UnknownCommandHint 收到未注册的斜杠命令时提示 /help 或最接近的命令
+	UnknownCommandHint bool
+
+	// CommandTools 斜杠命令 → 工具（如 /关机 → toggle_bot），命令名不含斜杠
+	CommandTools map[string]CommandTool
+
+	// TaskAssignPolicy 谁可以给群里的其他人设置提醒：anyone 所有人，admin 仅超级用户，off 不允许
+	TaskAssignPolicy string
+
+	// TaskAttachmentCacheKB 创建提醒时把图片/语音/视频附件下载并内联保存的大小上限（KB），0 表示不缓存
+	// （QQ 图片链接会过期，不缓存的话延后较久的提醒可能发不出附件）
+	TaskAttachmentCacheKB int
+
+	// TaskMemoryFallback Redis 不可用时把一次性提醒暂存在内存中（重启会丢失），恢复后写回 Redis
+	TaskMemoryFallback bool
+
+	// ArchiveBotMessages 同时登录的其他机器人账号在群里发的消息是否存档（仅存 ChatHistory 并标记，不回复、不进入 RAG）
+	ArchiveBotMessages bool
+
+	// DebugLog 输出调试日志（如消息被过滤、未处理的原因）
+	DebugLog bool
+
+	// 夜间人设摘要任务
+	PersonaUpdateHour     int           // 每天几点执行（0-23，-1 表示关闭）
+	PersonaUpdateBatch    int           // 每次最多处理的用户数
+	PersonaUpdateInterval time.Duration // 相邻两次 LLM 调用的间隔（限流）
+}
+
+// CommandTool 斜杠命令映射的工具：配置了 Args 时直接以固定参数执行，
+// 否则强制模型调用该工具（tool_choice），参数由模型根据命令后的文字填写
+type CommandTool struct {
+	Tool string                 `json:"tool"`
+	Args map[string]interface{} `json:"args,omitempty"`
+	Desc string                 `json:"desc,omitempty"` // /help 中的说明
 }
 
 var (
-	Cfg        *Config
+	current    atomic.Pointer[Config]
 	httpClient *http.Client
 	once       sync.Once
+
+	llmClient     *http.Client
+	llmClientOnce sync.Once
 )
 
 // Init 初始化配置
@@ -40,7 +213,23 @@ func Init() {
 		log.Println("未找到 .env 文件，将从系统环境变量读取配置")
 	}
 
-	Cfg = &Config{
+	Set(load())
+}
+
+// Get 返回当前配置（未初始化时为 nil）。重载时整体替换为新对象，已取得的配置不会再被修改，
+// 一次处理中需要前后一致的多个配置项时可先取一次再读取
+func Get() *Config {
+	return current.Load()
+}
+
+// Set 替换当前配置（由 Init 与 Reload 调用，测试中也用它注入配置）
+func Set(c *Config) {
+	current.Store(c)
+}
+
+// load 从环境变量构建配置
+func load() *Config {
+	return &Config{
 		NvidiaAPIKey:   MustGetEnv("NVIDIA_API_KEY"),
 		PineconeAPIKey: MustGetEnv("PINECONE_API_KEY"),
 		PineconeIndex:  GetEnv("PINECONE_INDEX", "gin-bot"),
@@ -50,7 +239,117 @@ func Init() {
 		BotWSURL:       GetEnv("BOT_WS_URL", "ws://127.0.0.1:3001"),
 		BotToken:       GetEnv("BOT_TOKEN", ""),
 		ProxyURL:       GetEnv("HTTP_PROXY", ""),
-		SuperUsers:     parseSuperUsers(GetEnv("BOT_SUPER_USERS", "")),
+		BotName:        GetEnv("BOT_NAME", "小黄"),
+		SuperUsers:     parseIDList(GetEnv("BOT_SUPER_USERS", "")),
+		GroupAllowlist: parseIDList(GetEnv("BOT_GROUP_ALLOWLIST", "")),
+
+		WSReconnectInterval:    max(GetEnvDuration("WS_RECONNECT_INTERVAL", 2*time.Second), 100*time.Millisecond),
+		WSReconnectMaxInterval: GetEnvDuration("WS_RECONNECT_MAX_INTERVAL", time.Minute),
+		HealthAddr:             GetEnv("HEALTH_ADDR", ""),
+
+		LLMProxy:       GetEnv("LLM_PROXY", ""),
+		EmbeddingProxy: GetEnv("EMBEDDING_PROXY", ""),
+
+		Location: loadLocation(GetEnv("BOT_TZ", "Asia/Shanghai")),
+
+		EmbeddingTimeout: GetEnvDuration("EMBEDDING_TIMEOUT", 10*time.Second),
+		EmbeddingModel:   GetEnv("EMBEDDING_MODEL", ""),
+		EmbeddingDim:     GetEnvInt("EMBEDDING_DIM", 1024),
+
+		EmbeddingTruncationWarnRatio: GetEnvFloat("EMBEDDING_TRUNCATION_WARN_RATIO", 8),
+
+		SummarizeThreshold:     GetEnvInt("RAG_SUMMARIZE_THRESHOLD", 200),
+		RAGWindow:              strings.ToLower(GetEnv("RAG_WINDOW", "off")),
+		RAGWindowSize:          GetEnvInt("RAG_WINDOW_SIZE", 3),
+		RAGWindowGap:           GetEnvDuration("RAG_WINDOW_GAP", 2*time.Minute),
+		PersonalTopK:           GetEnvInt("RAG_PERSONAL_TOPK", 3),
+		ChatTopK:               GetEnvInt("RAG_CHAT_TOPK", 3),
+		ReconcileHour:          GetEnvInt("RAG_RECONCILE_HOUR", 4),
+		CustomPersonalPatterns: splitList(GetEnv("RAG_PERSONAL_PATTERNS", ""), ";"),
+
+		HistoryMaxAge:       GetEnvDuration("HISTORY_MAX_AGE", 0),
+		HistoryKeepPerGroup: GetEnvInt("HISTORY_KEEP_PER_GROUP", 0),
+		HistoryPruneHour:    GetEnvInt("HISTORY_PRUNE_HOUR", 5),
+		HistoryHardDelete:   GetEnvBool("HISTORY_HARD_DELETE", false),
+
+		ClassifierPromptFile:     GetEnv("CLASSIFIER_PROMPT_FILE", ""),
+		ClassifierCategories:     splitList(GetEnv("CLASSIFIER_CATEGORIES", ""), ";"),
+		ClassifierProactiveRules: splitList(GetEnv("CLASSIFIER_PROACTIVE_RULES", ""), ";"),
+
+		ClassifierTimeout:    GetEnvDuration("CLASSIFIER_TIMEOUT", 5*time.Second),
+		ClassifierAttempts:   GetEnvInt("CLASSIFIER_ATTEMPTS", 2),
+		ClassifierRetryDelay: GetEnvDuration("CLASSIFIER_RETRY_DELAY", 300*time.Millisecond),
+
+		CareUseLLM:           GetEnvBool("CARE_USE_LLM", true),
+		CareFallbackTemplate: GetEnv("CARE_FALLBACK_TEMPLATE", "记得你说今天有事，一切还顺利吗？"),
+		CareConfirmMode:      strings.ToLower(GetEnv("CARE_CONFIRM_MODE", "auto")),
+		CareConfirmTemplate:  GetEnv("CARE_CONFIRM_TEMPLATE", "晚点我再来问问你情况怎么样？（回复「好」或「不用」）"),
+		CareConfirmTimeout:   GetEnvDuration("CARE_CONFIRM_TIMEOUT", 30*time.Minute),
+		CareUserCooldown:     GetEnvDuration("CARE_USER_COOLDOWN", 6*time.Hour),
+		CarePersistTemporary: GetEnvBool("CARE_PERSIST_TEMPORARY", true),
+
+		ProactiveMaxMemoryAge: GetEnvDuration("PROACTIVE_MAX_MEMORY_AGE", 30*24*time.Hour),
+		ProactiveDailyCap:     GetEnvInt("PROACTIVE_DAILY_CAP", 10),
+
+		ProactiveMinMessages:    GetEnvInt("PROACTIVE_MIN_MESSAGES", 3),
+		ProactiveActivityWindow: GetEnvDuration("PROACTIVE_ACTIVITY_WINDOW", 10*time.Minute),
+
+		ProactiveIdleThreshold: GetEnvDuration("PROACTIVE_IDLE_THRESHOLD", 2*time.Hour),
+		ProactiveWarmup:        GetEnvDuration("PROACTIVE_WARMUP", 3*time.Minute),
+		ProactiveQuietHours:    GetEnv("PROACTIVE_QUIET_HOURS", ""),
+
+		GroupDefaultActive: GetEnvBool("GROUP_DEFAULT_ACTIVE", true),
+		GroupDefaultRAG:    GetEnvBool("GROUP_DEFAULT_RAG", true),
+
+		MoodTTL: GetEnvDuration("MOOD_TTL", 30*time.Minute),
+
+		SceneTempTech:     GetEnvFloat("SCENE_TEMP_TECH", 0.2),
+		SceneTempPersonal: GetEnvFloat("SCENE_TEMP_PERSONAL", 0.4),
+		SceneTempCasual:   GetEnvFloat("SCENE_TEMP_CASUAL", 0.5),
+
+		ModelExtraParams: GetEnvJSON[map[string]map[string]interface{}]("MODEL_EXTRA_PARAMS"),
+		LLMExtraHeaders:  GetEnvJSON[map[string]string]("LLM_EXTRA_HEADERS"),
+
+		MaxReplyTokens: GetEnvInt("MAX_REPLY_TOKENS", 512),
+
+		MaxConcurrentReplies: GetEnvInt("AI_MAX_CONCURRENT_PER_GROUP", 2),
+		ReplyQueueTimeout:    GetEnvDuration("AI_REPLY_QUEUE_TIMEOUT", time.Minute),
+
+		ReplyDebounce: GetEnvDuration("REPLY_DEBOUNCE", 0),
+		ReplyPrefix:   GetEnv("REPLY_PREFIX", ""),
+		ReplySuffix:   GetEnv("REPLY_SUFFIX", ""),
+
+		AddressedDirective: GetEnv("ADDRESSED_DIRECTIVE", defaultAddressedDirective),
+
+		EmptyReplyVariety:   GetEnvBool("EMPTY_REPLY_VARIETY", true),
+		EmptyReplyFallbacks: splitList(GetEnv("EMPTY_REPLY_FALLBACKS", ""), ";"),
+
+		ToolTimeout:     GetEnvDuration("TOOL_TIMEOUT", 10*time.Second),
+		ToolMaxParallel: GetEnvInt("TOOL_MAX_PARALLEL", 4),
+		FCActionCheck:   strings.ToLower(GetEnv("FC_ACTION_CHECK", "log")),
+
+		GroupMetaRefresh: GetEnvDuration("GROUP_META_REFRESH", 0),
+
+		BroadcastInterval: GetEnvDuration("BROADCAST_INTERVAL", 3*time.Second),
+
+		UnknownCommandHint: GetEnvBool("UNKNOWN_COMMAND_HINT", false),
+		CommandTools:       GetEnvJSON[map[string]CommandTool]("COMMAND_TOOLS"),
+
+		StickerTextEnabled: GetEnvBool("STICKER_TEXT_ENABLED", true),
+		StickerText:        GetEnvJSON[map[string]string]("STICKER_TEXT"),
+
+		TaskMemoryFallback: GetEnvBool("TASK_MEMORY_FALLBACK", true),
+		TaskAssignPolicy:   strings.ToLower(GetEnv("TASK_ASSIGN_POLICY", "anyone")),
+
+		TaskAttachmentCacheKB: GetEnvInt("TASK_ATTACHMENT_CACHE_KB", 2048),
+
+		ArchiveBotMessages: GetEnvBool("ARCHIVE_BOT_MESSAGES", false),
+
+		DebugLog: GetEnvBool("DEBUG_LOG", false),
+
+		PersonaUpdateHour:     GetEnvInt("PERSONA_UPDATE_HOUR", 3),
+		PersonaUpdateBatch:    GetEnvInt("PERSONA_UPDATE_BATCH", 50),
+		PersonaUpdateInterval: GetEnvDuration("PERSONA_UPDATE_INTERVAL", 2*time.Second),
 	}
 }
 
@@ -63,6 +362,9 @@ func MustGetEnv(key string) string {
 	return value
 }
 
+// defaultAddressedDirective 默认的"被直接提问"要求
+const defaultAddressedDirective = "你被直接问到了，必须正面回答：回忆里有相关内容就直接用上；确实不知道时说清楚哪部分不确定，并给出你的猜测或建议，不要只回一句\"我不知道\"。"
+
 // GetEnv 获取环境变量，不存在则返回默认值
 func GetEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
@@ -71,8 +373,89 @@ func GetEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
-// parseSuperUsers 解析超级用户列表（逗号分隔）
-func parseSuperUsers(s string) []int64 {
+// GetEnvInt 获取整数类型环境变量，不存在或解析失败则返回默认值
+func GetEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if n, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+			return n
+		}
+		log.Printf("环境变量 %s 不是合法整数，使用默认值 %d", key, defaultValue)
+	}
+	return defaultValue
+}
+
+// GetEnvFloat 获取浮点类型环境变量，不存在或解析失败则返回默认值
+func GetEnvFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if f, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+			return f
+		}
+		log.Printf("环境变量 %s 不是合法数字，使用默认值 %g", key, defaultValue)
+	}
+	return defaultValue
+}
+
+// GetEnvJSON 获取 JSON 格式的环境变量，不存在或解析失败则返回零值
+func GetEnvJSON[T any](key string) T {
+	var v T
+	if value := os.Getenv(key); value != "" {
+		if err := json.Unmarshal([]byte(value), &v); err != nil {
+			log.Printf("环境变量 %s 不是合法 JSON，已忽略: %v", key, err)
+			var zero T
+			return zero
+		}
+	}
+	return v
+}
+
+// GetEnvBool 获取布尔类型环境变量（true/false/1/0），不存在或解析失败则返回默认值
+func GetEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if b, err := strconv.ParseBool(strings.TrimSpace(value)); err == nil {
+			return b
+		}
+		log.Printf("环境变量 %s 不是合法布尔值，使用默认值 %t", key, defaultValue)
+	}
+	return defaultValue
+}
+
+// GetEnvDuration 获取时长类型环境变量（如 "30m"、"72h"），不存在或解析失败则返回默认值
+func GetEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(strings.TrimSpace(value)); err == nil {
+			return d
+		}
+		log.Printf("环境变量 %s 不是合法时长，使用默认值 %s", key, defaultValue)
+	}
+	return defaultValue
+}
+
+// splitList 按分隔符拆分配置项，去除空白与空项
+func splitList(s, sep string) []string {
+	if s == "" {
+		return nil
+	}
+	var items []string
+	for _, p := range strings.Split(s, sep) {
+		if p = strings.TrimSpace(p); p != "" {
+			items = append(items, p)
+		}
+	}
+	return items
+}
+
+// loadLocation 加载时区，名称无效时退回 UTC+8
+func loadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("无法加载时区 %s，使用 UTC+8: %v", name, err)
+		return time.FixedZone("CST", 8*3600)
+	}
+	return loc
+}
+
+// parseIDList 解析 QQ 号 / 群号列表（逗号分隔）
+func parseIDList(s string) []int64 {
 	if s == "" {
 		return nil
 	}
@@ -90,26 +473,70 @@ func parseSuperUsers(s string) []int64 {
 // GetHTTPClient 获取复用的 HTTP Client（带可选代理）
 func GetHTTPClient() *http.Client {
 	once.Do(func() {
-		transport := &http.Transport{}
-		if Cfg != nil && Cfg.ProxyURL != "" {
-			if proxyURL, err := url.Parse(Cfg.ProxyURL); err == nil {
-				transport.Proxy = http.ProxyURL(proxyURL)
-			}
-		}
-		httpClient = &http.Client{
-			Timeout:   30 * time.Second,
-			Transport: transport,
-		}
+		httpClient = newHTTPClient(30*time.Second, globalProxy())
 	})
 	return httpClient
 }
 
 // GetHTTPClientWithTimeout 获取指定超时时间的 HTTP Client
 func GetHTTPClientWithTimeout(timeout time.Duration) *http.Client {
+	return newHTTPClient(timeout, globalProxy())
+}
+
+// GetLLMHTTPClient 获取复用的模型请求 HTTP Client（使用 LLM_PROXY）
+func GetLLMHTTPClient() *http.Client {
+	llmClientOnce.Do(func() {
+		llmClient = GetLLMHTTPClientWithTimeout(30 * time.Second)
+	})
+	return llmClient
+}
+
+// GetLLMHTTPClientWithTimeout 获取指定超时时间的模型请求 HTTP Client（使用 LLM_PROXY）
+func GetLLMHTTPClientWithTimeout(timeout time.Duration) *http.Client {
+	var proxy string
+	if cfg := Get(); cfg != nil {
+		proxy = cfg.LLMProxy
+	}
+	return newHTTPClient(timeout, serviceProxy(proxy))
+}
+
+// GetEmbeddingHTTPClientWithTimeout 获取指定超时时间的向量化请求 HTTP Client（使用 EMBEDDING_PROXY）
+func GetEmbeddingHTTPClientWithTimeout(timeout time.Duration) *http.Client {
+	var proxy string
+	if cfg := Get(); cfg != nil {
+		proxy = cfg.EmbeddingProxy
+	}
+	return newHTTPClient(timeout, serviceProxy(proxy))
+}
+
+// globalProxy 返回全局代理（HTTP_PROXY）
+func globalProxy() string {
+	cfg := Get()
+	if cfg == nil {
+		return ""
+	}
+	return cfg.ProxyURL
+}
+
+// serviceProxy 解析单个服务的代理：为空沿用全局代理，direct 表示直连
+func serviceProxy(proxy string) string {
+	switch strings.ToLower(strings.TrimSpace(proxy)) {
+	case "":
+		return globalProxy()
+	case "direct":
+		return ""
+	}
+	return proxy
+}
+
+// newHTTPClient 创建 HTTP Client，proxy 为空时直连
+func newHTTPClient(timeout time.Duration, proxy string) *http.Client {
 	transport := &http.Transport{}
-	if Cfg != nil && Cfg.ProxyURL != "" {
-		if proxyURL, err := url.Parse(Cfg.ProxyURL); err == nil {
+	if proxy != "" {
+		if proxyURL, err := url.Parse(proxy); err == nil {
 			transport.Proxy = http.ProxyURL(proxyURL)
+		} else {
+			log.Printf("[Config] Invalid proxy URL %q: %v", proxy, err)
 		}
 	}
 	return &http.Client{
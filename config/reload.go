@@ -0,0 +1,79 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+
+	"github.com/joho/godotenv"
+)
+
+// requiredEnv 必填的环境变量（重载时缺失则放弃本次重载，而不是像启动时那样退出）
+var requiredEnv = []string{"NVIDIA_API_KEY", "PINECONE_API_KEY", "DB_DSN"}
+
+// restartOnly 需要重启才能生效的配置：连接、身份、时区、HTTP Client、定时任务时间点等在启动时
+// 就已使用，重载时保留旧值；其余配置（阈值、模型参数、提示词、关怀、工具等）重载后立即生效
+var restartOnly = []struct {
+	env   string
+	field func(c *Config) any // 返回字段指针
+}{
+	{"PINECONE_API_KEY", func(c *Config) any { return &c.PineconeAPIKey }},
+	{"PINECONE_INDEX", func(c *Config) any { return &c.PineconeIndex }},
+	{"DB_DSN", func(c *Config) any { return &c.DBDSN }},
+	{"REDIS_ADDR", func(c *Config) any { return &c.RedisAddr }},
+	{"REDIS_PASSWORD", func(c *Config) any { return &c.RedisPassword }},
+	{"BOT_WS_URL", func(c *Config) any { return &c.BotWSURL }},
+	{"BOT_TOKEN", func(c *Config) any { return &c.BotToken }},
+	{"WS_RECONNECT_INTERVAL", func(c *Config) any { return &c.WSReconnectInterval }},
+	{"WS_RECONNECT_MAX_INTERVAL", func(c *Config) any { return &c.WSReconnectMaxInterval }},
+	{"HEALTH_ADDR", func(c *Config) any { return &c.HealthAddr }},
+	{"BOT_NAME", func(c *Config) any { return &c.BotName }},
+	{"BOT_SUPER_USERS", func(c *Config) any { return &c.SuperUsers }},
+	{"BOT_GROUP_ALLOWLIST", func(c *Config) any { return &c.GroupAllowlist }},
+	{"BOT_TZ", func(c *Config) any { return &c.Location }},
+	{"HTTP_PROXY", func(c *Config) any { return &c.ProxyURL }},
+	{"LLM_PROXY", func(c *Config) any { return &c.LLMProxy }},
+	{"EMBEDDING_PROXY", func(c *Config) any { return &c.EmbeddingProxy }},
+	{"EMBEDDING_TIMEOUT", func(c *Config) any { return &c.EmbeddingTimeout }},
+	{"EMBEDDING_MODEL", func(c *Config) any { return &c.EmbeddingModel }},
+	{"EMBEDDING_DIM", func(c *Config) any { return &c.EmbeddingDim }},
+	{"RAG_RECONCILE_HOUR", func(c *Config) any { return &c.ReconcileHour }},
+	{"HISTORY_PRUNE_HOUR", func(c *Config) any { return &c.HistoryPruneHour }},
+	{"PERSONA_UPDATE_HOUR", func(c *Config) any { return &c.PersonaUpdateHour }},
+	{"GROUP_META_REFRESH", func(c *Config) any { return &c.GroupMetaRefresh }},
+	// 分类器 prompt 与自定义正则在首次分类时加载并缓存
+	{"CLASSIFIER_PROMPT_FILE", func(c *Config) any { return &c.ClassifierPromptFile }},
+	{"CLASSIFIER_CATEGORIES", func(c *Config) any { return &c.ClassifierCategories }},
+	{"CLASSIFIER_PROACTIVE_RULES", func(c *Config) any { return &c.ClassifierProactiveRules }},
+	{"RAG_PERSONAL_PATTERNS", func(c *Config) any { return &c.CustomPersonalPatterns }},
+}
+
+// Reload 重新读取 .env（覆盖已有环境变量）并替换当前配置，不影响已建立的连接与定时任务。
+// 返回值为已修改但需要重启才能生效的配置项
+func Reload() ([]string, error) {
+	if err := godotenv.Overload(); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("读取 .env 失败: %w", err)
+	}
+	for _, key := range requiredEnv {
+		if os.Getenv(key) == "" {
+			return nil, fmt.Errorf("环境变量 %s 未设置", key)
+		}
+	}
+
+	next := load()
+	var pending []string
+	if cur := Get(); cur != nil {
+		for _, f := range restartOnly {
+			oldVal := reflect.ValueOf(f.field(cur)).Elem()
+			newVal := reflect.ValueOf(f.field(next)).Elem()
+			// 按文本比较（时区等指针类型每次加载都是新对象）
+			if fmt.Sprint(oldVal.Interface()) != fmt.Sprint(newVal.Interface()) {
+				pending = append(pending, f.env)
+			}
+			newVal.Set(oldVal)
+		}
+	}
+	// 整体替换指针，并发读取的 goroutine 要么拿到旧配置要么拿到新配置，不会读到一半
+	Set(next)
+	return pending, nil
+}
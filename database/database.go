@@ -18,7 +18,7 @@ var DB *gorm.DB
 
 // InitDB 初始化数据库连接
 func InitDB() {
-	dsn := config.Cfg.DBDSN
+	dsn := config.Get().DBDSN
 
 	// 配置 GORM Logger，忽略 RecordNotFound 错误
 	gormLogger := logger.New(
@@ -48,6 +48,8 @@ func InitDB() {
 		&models.ChatHistory{},
 		&models.MemberEmbedding{},
 		&models.Group{},
+		&models.ReplyFeedback{},
+		&models.GoldTransaction{},
 	)
 	if err != nil {
 		log.Fatalf("Failed to migrate database: %v", err)
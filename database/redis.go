@@ -4,6 +4,10 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"gin-bot/config"
@@ -11,47 +15,93 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
-var RDB *redis.Client
+// rdb 当前的 Redis 客户端，启动时连接失败则为 nil，之后由调度器重连成功后补上；
+// 调度器 goroutine 写入、各处理 goroutine 读取，因此用原子指针发布
+var rdb atomic.Pointer[redis.Client]
 
-// InitRedis 初始化 Redis 客户端
-func InitRedis() {
-	RDB = redis.NewClient(&redis.Options{
-		Addr:     config.Cfg.RedisAddr,
-		Password: config.Cfg.RedisPassword,
+// Redis 返回当前的 Redis 客户端，未连接时为 nil
+func Redis() *redis.Client {
+	return rdb.Load()
+}
+
+// SetRedis 替换当前的 Redis 客户端（测试中可传 nil 模拟 Redis 不可用）
+func SetRedis(client *redis.Client) {
+	rdb.Store(client)
+}
+
+// newRedisClient 按配置创建客户端并 Ping 一次，连接失败时关闭客户端并返回错误
+func newRedisClient(timeout time.Duration) (*redis.Client, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     config.Get().RedisAddr,
+		Password: config.Get().RedisPassword,
 		DB:       0,
 	})
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
-	if err := RDB.Ping(ctx).Err(); err != nil {
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// InitRedis 初始化 Redis 客户端
+func InitRedis() {
+	client, err := newRedisClient(5 * time.Second)
+	if err != nil {
 		fmt.Printf("Warning: Failed to connect to Redis: %v\n", err)
-		RDB = nil
+		SetRedis(nil)
 		return
 	}
-
+	SetRedis(client)
 	log.Println("Connected to Redis successfully")
 }
 
+// ReconnectRedis 启动时连接失败后重试连接，成功返回 true
+func ReconnectRedis() bool {
+	client, err := newRedisClient(3 * time.Second)
+	if err != nil {
+		return false
+	}
+	// 只在仍未连接时发布新客户端，避免并发重连时覆盖（并泄漏）已在使用的客户端
+	if !rdb.CompareAndSwap(nil, client) {
+		client.Close()
+		return true
+	}
+	log.Println("Reconnected to Redis successfully")
+	return true
+}
+
 // SaveTemporaryMemory 保存临时记忆到 Redis（带 TTL）
 // key 格式: temp:group:{groupID}:user:{userQQ}:{msgID}
 func SaveTemporaryMemory(ctx context.Context, groupID int64, userQQ string, msgID uint, content string, ttl time.Duration) error {
-	if RDB == nil {
+	client := Redis()
+	if client == nil {
 		return fmt.Errorf("redis not connected")
 	}
 
 	key := fmt.Sprintf("temp:group:%d:user:%s:%d", groupID, userQQ, msgID)
-	return RDB.Set(ctx, key, content, ttl).Err()
+	return client.Set(ctx, key, content, ttl).Err()
+}
+
+// TemporaryMemory 一条临时记忆（从 key 中解析出发言人与消息 ID）
+type TemporaryMemory struct {
+	UserQQ  string
+	MsgID   uint
+	Content string
 }
 
-// GetRecentTemporaryMemories 获取群组的最近临时记忆
-func GetRecentTemporaryMemories(ctx context.Context, groupID int64, limit int) ([]string, error) {
-	if RDB == nil {
+// GetRecentTemporaryMemories 获取群组的最近临时记忆（按消息 ID 从旧到新排列）
+func GetRecentTemporaryMemories(ctx context.Context, groupID int64, limit int) ([]TemporaryMemory, error) {
+	client := Redis()
+	if client == nil {
 		return nil, fmt.Errorf("redis not connected")
 	}
 
 	pattern := fmt.Sprintf("temp:group:%d:*", groupID)
-	keys, err := RDB.Keys(ctx, pattern).Result()
+	keys, err := client.Keys(ctx, pattern).Result()
 	if err != nil {
 		return nil, err
 	}
@@ -60,22 +110,44 @@ func GetRecentTemporaryMemories(ctx context.Context, groupID int64, limit int) (
 		return nil, nil
 	}
 
-	// 限制返回数量
-	if len(keys) > limit {
-		keys = keys[len(keys)-limit:]
+	// 解析 key，按消息 ID 排序后保留最近的若干条
+	memories := make([]TemporaryMemory, 0, len(keys))
+	for _, key := range keys {
+		// key 格式: temp:group:{groupID}:user:{userQQ}:{msgID}
+		parts := strings.Split(key, ":")
+		if len(parts) != 6 {
+			continue
+		}
+		msgID, err := strconv.ParseUint(parts[5], 10, 64)
+		if err != nil {
+			continue
+		}
+		memories = append(memories, TemporaryMemory{UserQQ: parts[4], MsgID: uint(msgID)})
+	}
+	sort.Slice(memories, func(i, j int) bool { return memories[i].MsgID < memories[j].MsgID })
+	if len(memories) > limit {
+		memories = memories[len(memories)-limit:]
+	}
+	if len(memories) == 0 {
+		return nil, nil
 	}
 
-	values, err := RDB.MGet(ctx, keys...).Result()
+	keys = keys[:0]
+	for _, m := range memories {
+		keys = append(keys, fmt.Sprintf("temp:group:%d:user:%s:%d", groupID, m.UserQQ, m.MsgID))
+	}
+	values, err := client.MGet(ctx, keys...).Result()
 	if err != nil {
 		return nil, err
 	}
 
-	var memories []string
-	for _, v := range values {
+	result := memories[:0]
+	for i, v := range values {
 		if s, ok := v.(string); ok && s != "" {
-			memories = append(memories, s)
+			memories[i].Content = s
+			result = append(result, memories[i])
 		}
 	}
 
-	return memories, nil
+	return result, nil
 }
@@ -0,0 +1,100 @@
+package embedding
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"gin-bot/config"
+)
+
+// modelInfo Embedding 模型的原生维度，以及是否支持 Matryoshka 截断到更小维度
+type modelInfo struct {
+	Dim        int
+	Matryoshka bool
+}
+
+// knownModels 已知模型的维度信息，未列出的模型只在返回结果时校验维度
+var knownModels = map[string]modelInfo{
+	"nvidia/llama-3.2-nemoretriever-300m-embed-v2": {Dim: 2048, Matryoshka: true},
+	"nvidia/llama-3.2-nv-embedqa-1b-v2":            {Dim: 2048, Matryoshka: true},
+	"nvidia/nv-embedqa-e5-v5":                      {Dim: 1024},
+	"nvidia/nv-embedqa-mistral-7b-v2":              {Dim: 4096},
+}
+
+// Model 当前使用的 Embedding 模型（EMBEDDING_MODEL）
+func Model() string {
+	if config.Get().EmbeddingModel != "" {
+		return config.Get().EmbeddingModel
+	}
+	return NVIDIA_MODEL
+}
+
+// checkDimension 校验模型返回的向量能否得到所需维度
+func checkDimension(model string, nativeDim int, targetDim int) error {
+	if targetDim <= 0 || nativeDim == targetDim {
+		return nil
+	}
+	if nativeDim < targetDim {
+		return fmt.Errorf("model %s returns %d-dim vectors, cannot provide %d", model, nativeDim, targetDim)
+	}
+	if info, ok := knownModels[model]; ok && !info.Matryoshka {
+		return fmt.Errorf("model %s does not support truncating %d-dim vectors to %d", model, nativeDim, targetDim)
+	}
+	return nil
+}
+
+// ValidateConfig 启动时检查配置的模型与 EMBEDDING_DIM 是否匹配，不匹配时打印警告
+func ValidateConfig() {
+	model := Model()
+	info, ok := knownModels[model]
+	if !ok {
+		log.Printf("[Embedding] Unknown model %s, native dimension unchecked (EMBEDDING_DIM=%d)", model, config.Get().EmbeddingDim)
+		return
+	}
+	if info.Dim == config.Get().EmbeddingDim {
+		return
+	}
+	if err := checkDimension(model, info.Dim, config.Get().EmbeddingDim); err != nil {
+		log.Printf("[Embedding] Warning: EMBEDDING_DIM=%d is incompatible: %v", config.Get().EmbeddingDim, err)
+		return
+	}
+	log.Printf("[Embedding] Warning: model %s is natively %d-dim, vectors will be truncated to EMBEDDING_DIM=%d", model, info.Dim, config.Get().EmbeddingDim)
+}
+
+// probeTimeout 启动探测的请求时限
+const probeTimeout = 30 * time.Second
+
+// ProbeDimension 启动时请求一次向量，记录模型实际返回的原生维度与 EMBEDDING_DIM 的对比；
+// 截断比例达到 EMBEDDING_TRUNCATION_WARN_RATIO 时警告检索质量可能下降。只在启动时调用一次
+func ProbeDimension() {
+	ratio := config.Get().EmbeddingTruncationWarnRatio
+	if ratio <= 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+
+	model := Model()
+	targetDim := config.Get().EmbeddingDim
+	vec, err := GetEmbedding(ctx, "dimension probe", "query", 0)
+	if err != nil {
+		log.Printf("[Embedding] Dimension probe failed: %v", err)
+		return
+	}
+	nativeDim := len(vec)
+	log.Printf("[Embedding] Probe: model %s returns %d-dim vectors, EMBEDDING_DIM=%d", model, nativeDim, targetDim)
+
+	if info, ok := knownModels[model]; ok && info.Dim != nativeDim {
+		log.Printf("[Embedding] Warning: model %s is listed as %d-dim but returned %d-dim vectors", model, info.Dim, nativeDim)
+	}
+	if err := checkDimension(model, nativeDim, targetDim); err != nil {
+		log.Printf("[Embedding] Warning: EMBEDDING_DIM=%d is incompatible: %v", targetDim, err)
+		return
+	}
+	if targetDim > 0 && float64(nativeDim) >= float64(targetDim)*ratio {
+		log.Printf("[Embedding] Warning: truncating %d-dim vectors to %d (%.0fx) may noticeably reduce retrieval quality, consider a larger EMBEDDING_DIM",
+			nativeDim, targetDim, float64(nativeDim)/float64(targetDim))
+	}
+}
@@ -2,19 +2,34 @@ package embedding
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
 
 	"gin-bot/config"
 )
 
 const (
 	NVIDIA_API_URL = "https://integrate.api.nvidia.com/v1/embeddings"
-	NVIDIA_MODEL   = "nvidia/llama-3.2-nemoretriever-300m-embed-v2"
+	NVIDIA_MODEL   = "nvidia/llama-3.2-nemoretriever-300m-embed-v2" // 默认模型，可通过 EMBEDDING_MODEL 覆盖
 )
 
+var (
+	client     *http.Client
+	clientOnce sync.Once
+)
+
+// getClient 获取带超时的 Embedding HTTP Client（超时由 EMBEDDING_TIMEOUT 配置）
+func getClient() *http.Client {
+	clientOnce.Do(func() {
+		client = config.GetEmbeddingHTTPClientWithTimeout(config.Get().EmbeddingTimeout)
+	})
+	return client
+}
+
 type EmbeddingRequest struct {
 	Input     []string `json:"input"`
 	Model     string   `json:"model"`
@@ -40,11 +55,13 @@ type EmbeddingResponse struct {
 
 // GetEmbedding 调用 NVIDIA API 获取文本向量
 // inputType: "query" 用于检索，"passage" 用于建立索引
-// targetDim: 目标维度，如果为 0 则返回原始维度（该模型默认为 2048）
-func GetEmbedding(text string, inputType string, targetDim int) ([]float32, error) {
+// targetDim: 目标维度，如果为 0 则返回原始维度；模型无法提供该维度时返回错误
+// 请求随 ctx 取消或超时而中止
+func GetEmbedding(ctx context.Context, text string, inputType string, targetDim int) ([]float32, error) {
+	model := Model()
 	reqBody := EmbeddingRequest{
 		Input:     []string{text},
-		Model:     NVIDIA_MODEL,
+		Model:     model,
 		InputType: inputType,
 		Encoding:  "float",
 	}
@@ -54,16 +71,15 @@ func GetEmbedding(text string, inputType string, targetDim int) ([]float32, erro
 		return nil, fmt.Errorf("failed to marshal request: %v", err)
 	}
 
-	req, err := http.NewRequest("POST", NVIDIA_API_URL, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", NVIDIA_API_URL, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+config.Cfg.NvidiaAPIKey)
+	req.Header.Set("Authorization", "Bearer "+config.Get().NvidiaAPIKey)
 
-	client := config.GetHTTPClient()
-	resp, err := client.Do(req)
+	resp, err := getClient().Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send request: %v", err)
 	}
@@ -84,6 +100,9 @@ func GetEmbedding(text string, inputType string, targetDim int) ([]float32, erro
 	}
 
 	embeddings := result.Data[0].Embedding
+	if err := checkDimension(model, len(embeddings), targetDim); err != nil {
+		return nil, err
+	}
 
 	// 如果指定了目标维度且小于原始维度，执行截断 (Matryoshka Truncation)
 	if targetDim > 0 && len(embeddings) > targetDim {
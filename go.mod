@@ -3,8 +3,10 @@ module gin-bot
 go 1.25.0
 
 require (
+	github.com/RomiChan/websocket v1.4.3-0.20251002072000-d3eb41798438
 	github.com/pinecone-io/go-pinecone v1.1.1
 	github.com/redis/go-redis/v9 v9.17.2
+	github.com/tidwall/gjson v1.18.0
 	github.com/wdvxdr1123/ZeroBot v1.8.2
 	google.golang.org/protobuf v1.34.1
 	gorm.io/driver/postgres v1.6.0
@@ -14,7 +16,6 @@ require (
 require (
 	github.com/FloatTech/ttl v0.0.0-20250224045156-012b1463287d // indirect
 	github.com/RomiChan/syncx v0.0.0-20240418144900-b7402ffdebc7 // indirect
-	github.com/RomiChan/websocket v1.4.3-0.20251002072000-d3eb41798438 // indirect
 	github.com/apapsch/go-jsonmerge/v2 v2.0.0 // indirect
 	github.com/cespare/xxhash/v2 v2.3.0 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
@@ -35,7 +36,6 @@ require (
 	github.com/rogpeppe/go-internal v1.14.1 // indirect
 	github.com/sirupsen/logrus v1.9.3 // indirect
 	github.com/stretchr/testify v1.11.1 // indirect
-	github.com/tidwall/gjson v1.18.0 // indirect
 	github.com/tidwall/match v1.1.1 // indirect
 	github.com/tidwall/pretty v1.2.0 // indirect
 	golang.org/x/crypto v0.47.0 // indirect
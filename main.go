@@ -1,20 +1,26 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"maps"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 	"unicode/utf8"
 
 	"gin-bot/config"
 	"gin-bot/database"
+	"gin-bot/embedding"
+	"gin-bot/onebot"
 	"gin-bot/pinecone"
 	"gin-bot/service"
 
 	zero "github.com/wdvxdr1123/ZeroBot"
-	"github.com/wdvxdr1123/ZeroBot/driver"
 )
 
 // cqCodeRegex 匹配 CQ 码的正则表达式
@@ -25,6 +31,104 @@ func cleanCQCodes(s string) string {
 	return cqCodeRegex.ReplaceAllString(s, "")
 }
 
+// cqAtRegex 匹配 at CQ 码并捕获 qq 参数（QQ 号或 all），兼容附带 name 等额外参数的写法
+var cqAtRegex = regexp.MustCompile(`\[CQ:at,qq=([^,\]]+)[^\]]*\]`)
+
+// forwardCQPrefix 合并转发消息的 CQ 码前缀
+const forwardCQPrefix = "[CQ:forward"
+
+// stripForwardCQ 移除合并转发 CQ 码（含其中内联的转发内容）
+// 部分实现会把转发内容以 content 参数内联，其中可能嵌套未转义的 [CQ:at]，因此按方括号配对截取整段
+func stripForwardCQ(content string) string {
+	var sb strings.Builder
+	for {
+		start := strings.Index(content, forwardCQPrefix)
+		if start < 0 {
+			sb.WriteString(content)
+			return sb.String()
+		}
+		sb.WriteString(content[:start])
+
+		depth, end := 0, len(content)
+		for i := start; i < len(content); i++ {
+			if content[i] == '[' {
+				depth++
+			} else if content[i] == ']' {
+				depth--
+				if depth == 0 {
+					end = i + 1
+					break
+				}
+			}
+		}
+		content = content[end:]
+	}
+}
+
+// isAtSelf 精确解析 at CQ 码，判断消息是否 at 了机器人本身
+// 只比较完整的 qq 参数，避免子串误匹配；at 全体成员（qq=all）不视为 at 机器人
+// 合并转发中嵌套的 at 是转发的历史内容，不视为 at 机器人（转发者需要在转发之外另行 at）
+func isAtSelf(content string, selfID int64) bool {
+	selfIDStr := strconv.FormatInt(selfID, 10)
+	for _, m := range cqAtRegex.FindAllStringSubmatch(stripForwardCQ(content), -1) {
+		if strings.TrimSpace(m[1]) == selfIDStr {
+			return true
+		}
+	}
+	return false
+}
+
+// stripAtSelf 移除消息中 at 机器人本身的 CQ 码，保留 at 其他人的部分
+func stripAtSelf(content string, selfID int64) string {
+	selfIDStr := strconv.FormatInt(selfID, 10)
+	return cqAtRegex.ReplaceAllStringFunc(content, func(code string) string {
+		if m := cqAtRegex.FindStringSubmatch(code); m != nil && strings.TrimSpace(m[1]) == selfIDStr {
+			return ""
+		}
+		return code
+	})
+}
+
+// isCalledByName 消息是否以机器人的名字开头（如"小黄，今天吃啥"），视同 @ 机器人
+// 名字后必须是结尾、空白或标点/符号（含 emoji），"小黄鸭真可爱""小黄图"这样名字只是词的一部分时不算
+func isCalledByName(content string) bool {
+	name := config.Get().BotName
+	if name == "" {
+		return false
+	}
+	rest, ok := strings.CutPrefix(strings.TrimSpace(content), name)
+	if !ok {
+		return false
+	}
+	if rest == "" {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(rest)
+	return unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+// stripBotName 去掉消息开头的机器人名字及紧随其后的标点
+func stripBotName(content string) string {
+	if !isCalledByName(content) {
+		return content
+	}
+	rest := strings.TrimPrefix(strings.TrimSpace(content), config.Get().BotName)
+	return strings.TrimLeft(rest, " ,，:：!！~")
+}
+
+// cqReplyRegex 匹配引用回复 CQ 码并捕获被引用的消息 ID
+var cqReplyRegex = regexp.MustCompile(`\[CQ:reply,id=(-?\d+)[^\]]*\]`)
+
+// parseReplyID 解析消息中引用的消息 ID
+func parseReplyID(content string) (int64, bool) {
+	m := cqReplyRegex.FindStringSubmatch(content)
+	if m == nil {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(m[1], 10, 64)
+	return id, err == nil
+}
+
 // hasMeaningfulContent 检查消息是否有意义（清理 CQ 码后至少 5 个字符）
 func hasMeaningfulContent(content string) bool {
 	cleaned := strings.TrimSpace(cleanCQCodes(content))
@@ -32,19 +136,178 @@ func hasMeaningfulContent(content string) bool {
 	return utf8.RuneCountInString(cleaned) >= 5
 }
 
-func main() {
-	// 强制设置全局时区为北京时间 (UTC+8)
-	time.Local = time.FixedZone("CST", 8*3600)
+// botCommand 斜杠命令及其说明
+type botCommand struct {
+	Name string
+	Desc string
+}
+
+// knownCommands 已注册的斜杠命令（用于 /help 与未知命令提示，新增命令时同步维护）
+var knownCommands = []botCommand{
+	{Name: "hello", Desc: "打个招呼"},
+	{Name: "help", Desc: "查看可用命令"},
+	{Name: "reload", Desc: "重新加载配置（仅超级用户）"},
+}
 
+// commandNameRegex 命令名只允许字母、数字和下划线，避免把 "/狗头" 之类的文字当成命令
+var commandNameRegex = regexp.MustCompile(`^/([A-Za-z0-9_]+)`)
+
+// commandSuggestDistance 未知命令与已知命令的最大编辑距离，超过则只提示 /help
+const commandSuggestDistance = 2
+
+// unknownCommandHint 对未注册的斜杠命令给出提示，已注册命令或非命令返回空字符串
+func unknownCommandHint(content string) string {
+	m := commandNameRegex.FindStringSubmatch(content)
+	if m == nil {
+		return ""
+	}
+	name := strings.ToLower(m[1])
+
+	best, bestDist := "", commandSuggestDistance+1
+	for _, cmd := range knownCommands {
+		if cmd.Name == name {
+			return ""
+		}
+		if d := editDistance(name, cmd.Name); d < bestDist {
+			best, bestDist = cmd.Name, d
+		}
+	}
+	if best != "" {
+		return fmt.Sprintf("没有 /%s 这个命令哦，你是不是想用 /%s？", name, best)
+	}
+	return fmt.Sprintf("没有 /%s 这个命令哦，发送 /help 查看可用命令", name)
+}
+
+// editDistance 计算两个字符串的编辑距离（Levenshtein）
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// refreshAllGroupMeta 通过 OneBot 拉取机器人所在的全部群，保存群名称与成员数
+func refreshAllGroupMeta() {
+	zero.RangeBot(func(id int64, ctx *zero.Ctx) bool {
+		updated := 0
+		for _, g := range ctx.GetGroupList().Array() {
+			groupID := g.Get("group_id").Int()
+			err := service.UpdateGroupMeta(groupID, g.Get("group_name").String(), int(g.Get("member_count").Int()))
+			if err != nil {
+				log.Printf("[Group] Failed to update meta for group %d: %v", groupID, err)
+				continue
+			}
+			updated++
+		}
+		log.Printf("[Group] Refreshed meta for %d groups", updated)
+		return true
+	})
+}
+
+// messageEditNotices 视为"消息已编辑"的通知类型
+var messageEditNotices = map[string]bool{
+	"group_msg_edit":  true,
+	"friend_msg_edit": true,
+}
+
+// chatEvent 规范化后的消息事件
+type chatEvent struct {
+	SelfID    int64
+	UserID    int64
+	GroupID   int64 // 私聊时恒为 0
+	IsPrivate bool
+	FromBot   bool // 机器人自己或同时登录的其他机器人账号发出的消息
+	MessageID int64
+	Nickname  string
+	Content   string
+}
+
+// chatErrorMessage 按错误类型给用户不同的提示
+func chatErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, service.ErrRateLimited):
+		return "我现在有点忙不过来，稍等一下再找我吧～"
+	case errors.Is(err, service.ErrModelInvalidOutput):
+		return "我刚刚脑子打了个结，换个说法再问我一次？"
+	default:
+		return "抱歉，我的大脑暂时断网了..."
+	}
+}
+
+// groupAllowedRule 命令规则：白名单外的群只接受超级用户的命令
+func groupAllowedRule(ctx *zero.Ctx) bool {
+	if ctx.Event.GroupID == 0 || service.IsGroupAllowed(ctx.Event.GroupID) {
+		return true
+	}
+	return zero.SuperUserPermission(ctx)
+}
+
+// normalizeEvent 校验并规范化消息事件，返回 false 表示应忽略该事件：
+// 缺少 SelfID/UserID、非私聊/群聊消息（如频道）、群消息缺少群号；机器人账号发出的消息标记 FromBot 交由调用方处理
+func normalizeEvent(e *zero.Event) (chatEvent, bool) {
+	if e == nil || e.SelfID == 0 || e.UserID == 0 {
+		return chatEvent{}, false
+	}
+
+	ev := chatEvent{
+		SelfID:   e.SelfID,
+		UserID:   e.UserID,
+		Content:  e.RawMessage,
+		Nickname: "未知用户",
+		FromBot:  e.UserID == e.SelfID || zero.GetBot(e.UserID) != nil,
+	}
+	if e.Sender != nil && e.Sender.NickName != "" {
+		ev.Nickname = e.Sender.NickName
+	}
+	ev.MessageID, _ = e.MessageID.(int64)
+
+	switch e.MessageType {
+	case "private":
+		ev.IsPrivate = true // 私聊（含临时会话）不使用群号，避免被当作某个群处理
+	case "group":
+		if e.GroupID == 0 {
+			return chatEvent{}, false
+		}
+		ev.GroupID = e.GroupID
+	default:
+		return chatEvent{}, false
+	}
+	return ev, true
+}
+
+func main() {
 	// 初始化配置
 	config.Init()
 
+	// 全局时区使用配置的机器人时区（BOT_TZ，默认 Asia/Shanghai）
+	time.Local = config.Get().Location
+
+	// 检查向量模型与 EMBEDDING_DIM 是否匹配，并在后台探测一次实际返回的维度
+	embedding.ValidateConfig()
+	go embedding.ProbeDimension()
+
 	// 初始化数据库
 	database.InitDB()
 
 	// 初始化 Redis（短期记忆）
 	database.InitRedis()
 
+	// 初始化群组白名单（首次启动时写入 Redis）
+	service.InitGroupAllowlist()
+
 	// 初始化 Pinecone
 	pinecone.InitPinecone()
 
@@ -54,7 +317,7 @@ func main() {
 			if groupID != 0 {
 				msg := content
 				if userID != 0 {
-					msg = "[CQ:at,qq=" + strconv.FormatInt(userID, 10) + "] " + msg
+					msg = service.MentionCQ(userID) + " " + msg
 				}
 				ctx.SendGroupMessage(groupID, msg)
 			} else {
@@ -65,90 +328,360 @@ func main() {
 	})
 
 	// 注册一个简单的 hello 命令作为示例
-	zero.OnCommand("hello").Handle(func(ctx *zero.Ctx) {
+	zero.OnCommand("hello", groupAllowedRule).Handle(func(ctx *zero.Ctx) {
 		ctx.Send("Hello World!")
 	})
 
-	// 冷却时间记录：groupID -> 上次主动发言时间
-	proactiveCooldown := make(map[int64]time.Time)
+	zero.OnCommand("help", groupAllowedRule).Handle(func(ctx *zero.Ctx) {
+		var sb strings.Builder
+		sb.WriteString("可用命令：")
+		for _, cmd := range knownCommands {
+			sb.WriteString(fmt.Sprintf("\n/%s %s", cmd.Name, cmd.Desc))
+		}
+		for _, name := range slices.Sorted(maps.Keys(config.Get().CommandTools)) {
+			desc := config.Get().CommandTools[name].Desc
+			if desc == "" {
+				desc = config.Get().CommandTools[name].Tool
+			}
+			sb.WriteString(fmt.Sprintf("\n/%s %s", name, desc))
+		}
+		sb.WriteString("\n也可以直接 @我 聊天")
+		ctx.Send(sb.String())
+	})
+
+	// 热重载配置：不断开连接、不影响已有定时任务；群组配置每次从数据库读取，无需额外刷新
+	zero.OnCommand("reload", zero.SuperUserPermission).Handle(func(ctx *zero.Ctx) {
+		pending, err := config.Reload()
+		if err != nil {
+			log.Printf("[Config] Reload failed: %v", err)
+			ctx.Send("重新加载配置失败：" + err.Error())
+			return
+		}
+		log.Printf("[Config] Reloaded by %d, restart required for: %v", ctx.Event.UserID, pending)
+		msg := "配置已重新加载"
+		if len(pending) > 0 {
+			msg += "\n以下配置需要重启才能生效：" + strings.Join(pending, ", ")
+		}
+		ctx.Send(msg)
+	})
+
+	// 群信息同步（可选）：定期拉取群名称与成员数，成员变动时即时刷新
+	if interval := config.Get().GroupMetaRefresh; interval > 0 {
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for range ticker.C {
+				refreshAllGroupMeta()
+			}
+		}()
+
+		zero.OnNotice(func(ctx *zero.Ctx) bool {
+			return ctx.Event.DetailType == "group_increase" || ctx.Event.DetailType == "group_decrease"
+		}).Handle(func(ctx *zero.Ctx) {
+			info := ctx.GetGroupInfo(ctx.Event.GroupID, true)
+			if info.ID == 0 {
+				return
+			}
+			if err := service.UpdateGroupMeta(info.ID, info.Name, int(info.MemberCount)); err != nil {
+				log.Printf("[Group] Failed to update meta for group %d: %v", info.ID, err)
+			}
+		})
+	}
+
+	// 消息编辑：部分 OneBot 实现会推送消息编辑通知，据此更新已归档的记忆
+	// 目前主流实现（如 NapCat）尚不推送此类事件，此时该处理器不会被触发
+	zero.OnNotice(func(ctx *zero.Ctx) bool {
+		return messageEditNotices[ctx.Event.DetailType]
+	}).Handle(func(ctx *zero.Ctx) {
+		messageID := ctx.Event.RawEvent.Get("message_id").Int()
+		newContent := ctx.Event.RawEvent.Get("raw_message").String()
+		if messageID == 0 || newContent == "" {
+			return
+		}
+		go func() {
+			if err := service.UpdateEditedMessage(ctx.Event.GroupID, messageID, newContent); err != nil {
+				log.Printf("[RAG] Failed to handle edited message %d: %v", messageID, err)
+			}
+		}()
+	})
+
+	// 回复反馈：群友给机器人的消息贴表情（NapCat group_msg_emoji_like 事件）
+	zero.OnNotice(func(ctx *zero.Ctx) bool {
+		return ctx.Event.DetailType == "group_msg_emoji_like"
+	}).Handle(func(ctx *zero.Ctx) {
+		if isAdd := ctx.Event.RawEvent.Get("is_add"); isAdd.Exists() && !isAdd.Bool() {
+			return // 取消表情不处理
+		}
+		messageID := ctx.Event.RawEvent.Get("message_id").Int()
+		for _, like := range ctx.Event.RawEvent.Get("likes").Array() {
+			if rating := service.FeedbackRatingFromEmoji(like.Get("emoji_id").String()); rating != 0 {
+				err := service.RecordReplyFeedback(ctx.Event.SelfID, messageID, strconv.FormatInt(ctx.Event.UserID, 10), ctx.Event.GroupID, rating)
+				if err != nil {
+					log.Printf("[Feedback] Ignored emoji reaction: %v", err)
+				}
+				return
+			}
+		}
+	})
 
 	// RAG 核心：统一消息处理器
 	zero.OnMessage().Handle(func(ctx *zero.Ctx) {
-		content := ctx.Event.RawMessage
-		selfIDStr := strconv.FormatInt(ctx.Event.SelfID, 10)
-		atMe := strings.Contains(content, "[CQ:at,qq="+selfIDStr+"]") || ctx.Event.MessageType == "private"
-		groupID := ctx.Event.GroupID
-		userID := ctx.Event.UserID
-		nickname := ctx.Event.Sender.NickName
-		if nickname == "" {
-			nickname = "未知用户"
+		ev, ok := normalizeEvent(ctx.Event)
+		if !ok {
+			service.LogDrop("invalid_event", ctx.Event.GroupID, ctx.Event.UserID)
+			return
+		}
+		// 重连后 OneBot 可能重放同一条消息，已处理过的直接丢弃，避免重复归档和重复回复
+		if !service.MarkMessageSeen(ev.SelfID, ev.MessageID) {
+			service.LogDrop("duplicate_message", ev.GroupID, ev.UserID)
+			return
+		}
+		// 机器人自己（或同时登录的其他机器人账号）发出的消息不进入任何回复、触发或 RAG 流程，避免自言自语循环；
+		// 本账号的回复发送时已由 SaveBotReply 存档，其他账号的消息可按配置存档（保留其昵称，不可被评价）
+		if ev.FromBot {
+			if ev.UserID != ev.SelfID && !ev.IsPrivate && config.Get().ArchiveBotMessages && service.IsGroupAllowed(ev.GroupID) {
+				nickname := "" // 缺少发送者信息时不用"未知用户"覆盖已存的昵称
+				if ctx.Event.Sender != nil {
+					nickname = ctx.Event.Sender.NickName
+				}
+				go service.ArchiveBotMessage(ev.UserID, ev.GroupID, ev.MessageID, nickname, ev.Content)
+			}
+			service.LogDrop("bot_message", ev.GroupID, ev.UserID)
+			return
+		}
+		content := ev.Content
+		isPrivate := ev.IsPrivate
+		atMe := isPrivate || isAtSelf(content, ev.SelfID) || isCalledByName(content)
+		groupID := ev.GroupID
+		userID := ev.UserID
+		nickname := ev.Nickname
+
+		// 群组白名单：不在名单内的群只响应超级用户 @ 机器人（用于管理名单），其余消息一律忽略
+		allowed := isPrivate || service.IsGroupAllowed(groupID)
+		if !allowed && !(atMe && zero.SuperUserPermission(ctx)) {
+			service.LogDrop("group_not_allowlisted", groupID, userID)
+			return
+		}
+		if !isPrivate {
+			service.RecordGroupActivity(groupID)
+		}
+
+		// 0. 引用机器人回复并发送 👍/👎（或"好评"/"差评"）视为反馈，记录后不再继续处理
+		if replyID, ok := parseReplyID(content); ok {
+			if rating := service.ParseFeedbackRating(content); rating != 0 {
+				err := service.RecordReplyFeedback(ev.SelfID, replyID, strconv.FormatInt(userID, 10), groupID, rating)
+				if err == nil {
+					return
+				}
+				log.Printf("[Feedback] Not a feedback on bot reply: %v", err)
+			}
+		}
+
+		// 用户对"晚点再问问你"的回应（CARE_CONFIRM_MODE=ask）：引用消息时取出被引用的内容，用于确认引用的是询问本身
+		quotedText := ""
+		if replyID, ok := parseReplyID(content); ok && config.Get().CareConfirmMode == service.CareConfirmAsk && service.IsCareAckText(content) {
+			quotedText = ctx.GetMessage(replyID).Elements.ExtractPlainText()
+		}
+		if reply, ok := service.HandleCareAck(groupID, userID, content, quotedText); ok {
+			ctx.Send(service.DecorateReply(reply, true, true))
+			return
 		}
 
+		// 映射到工具的斜杠命令（COMMAND_TOOLS）：直接执行工具，不依赖模型决定是否调用
+		// 机器人关闭时只响应超级用户（如 /开机），其余消息照常进入后续流程
+		if _, ok := service.MatchCommandTool(content); ok {
+			isSuperUser := zero.SuperUserPermission(ctx)
+			if isPrivate || isSuperUser || service.IsBotActive(groupID) {
+				go func() {
+					reply, err := service.RunCommandTool(content, groupID, userID, isSuperUser)
+					if err != nil {
+						log.Printf("[Command] Failed to run command tool: %v", err)
+						reply = chatErrorMessage(err)
+					}
+					if reply != "" {
+						ctx.Send(service.DecorateReply(cleanCQCodes(reply), true, true))
+					}
+				}()
+				return
+			}
+		}
+
+		// 未注册的斜杠命令：提示 /help 或最接近的命令（需配置开启）
+		if config.Get().UnknownCommandHint && (isPrivate || service.IsBotActive(groupID)) {
+			if hint := unknownCommandHint(content); hint != "" {
+				ctx.Send(hint)
+				return
+			}
+		}
+
+		// 表情转文字放在反馈识别之后（👍/👎 表情反馈按原始 CQ 码识别），
+		// 之后的有效内容判断、回复、插嘴与归档都使用转换后的内容
+		content = service.ExpandStickers(content)
+
 		// 1. 如果是艾特机器人或私聊，则进入常规 AI 回复流程
 		if atMe {
 			isSuperUser := zero.SuperUserPermission(ctx)
-			if ctx.Event.MessageType != "private" && !service.IsBotActive(groupID) {
+			if !isPrivate && !service.IsBotActive(groupID) {
 				if !isSuperUser {
+					// 按群配置静默，或限频提示"已被静音"
+					if notice, ok := service.GetOffNotice(groupID); ok {
+						ctx.Send(notice)
+					}
+					service.LogDrop("bot_off", groupID, userID)
 					return
 				}
 			}
 
-			prompt := strings.TrimSpace(content)
-			prompt = strings.ReplaceAll(prompt, "[CQ:at,qq="+selfIDStr+"]", "")
-			prompt = strings.TrimSpace(prompt)
+			prompt := strings.TrimSpace(stripBotName(stripAtSelf(content, ev.SelfID)))
 
-			if prompt == "" {
-				if service.IsBotActive(groupID) {
-					ctx.Send("在呢，找我有什么事吗？")
+			// 超级用户可在消息中附带 --verbose，回复末尾会列出参考的记忆来源
+			verbose := false
+			if isSuperUser && strings.Contains(prompt, "--verbose") {
+				verbose = true
+				prompt = strings.TrimSpace(strings.ReplaceAll(prompt, "--verbose", ""))
+			}
+
+			// "你刚说啥"/"再说一遍"：直接重发缓存的上一条回复，不重新生成
+			if prompt != "" && service.IsRepeatRequest(prompt) {
+				if last, ok := service.GetLastReply(groupID, userID); ok {
+					last = service.DecorateReply(last, true, true)
+					if !isPrivate {
+						last = service.ReplyPrefixCQ(groupID, userID, ev.MessageID) + last
+					}
+					ctx.Send(last)
+					return
 				}
-				return
 			}
 
-			isPrivate := ctx.Event.MessageType == "private"
-			userID := ctx.Event.UserID
-			go func() {
-				reply, err := service.GetAIResponseWithFC(prompt, groupID, userID, isSuperUser)
+			// respond 生成并发送回复；messageID 为被 @ 的那条消息（引用回复时使用）
+			respond := func(prompt string, messageID int64) {
+				if prompt == "" {
+					if service.IsBotActive(groupID) {
+						ctx.Send("在呢，找我有什么事吗？")
+					}
+					return
+				}
+
+				// 同一群同时生成的回复数有上限：超出时先告知排队，依次回复，避免限流和回复交错
+				release, ok := service.AcquireReplySlot(groupID, func() {
+					ctx.Send(service.MentionCQ(userID) + " 稍等，我一个一个回~")
+				})
+				if !ok {
+					service.LogDrop("reply_queue_timeout", groupID, userID)
+					return
+				}
+				defer release()
+
+				reply, provenance, err := service.GetAIResponseWithProvenance(prompt, groupID, userID, isSuperUser)
 				if err != nil {
 					log.Printf("[Chat] AI Response Error: %v", err)
 					if service.IsBotActive(groupID) {
-						ctx.Send("抱歉，我的大脑暂时断网了...")
+						ctx.Send(chatErrorMessage(err))
 					}
 					return
 				}
 				reply = cleanCQCodes(reply)
+				if verbose {
+					reply += service.FormatProvenance(provenance)
+				}
+				service.CacheLastReply(groupID, userID, reply)
+				// 模型输出的 CQ 码已在上面清理，这里加上的前后缀与 @/引用 CQ 码会原样发出（前缀在 @ 之后）；
+				// 存档只保存正文，前后缀不会进入之后的对话历史
+				out := service.DecorateReply(reply, true, true)
 				if !isPrivate {
-					reply = "[CQ:at,qq=" + strconv.FormatInt(userID, 10) + "] " + reply
+					out = service.ReplyPrefixCQ(groupID, userID, messageID) + out
 				}
-				ctx.Send(reply)
-			}()
-		} else if ctx.Event.MessageType == "group" && service.IsBotActive(groupID) {
+				msgID := ctx.Send(out)
+				saved := service.SaveBotReply(ev.SelfID, groupID, msgID.ID(), reply)
+				// RAG_WINDOW 为 reply/both 时提问与回复成对存为一条记忆
+				if allowed {
+					go service.ArchiveReplyPair(saved, prompt, groupID, userID)
+				}
+			}
+
+			// 连发合并（REPLY_DEBOUNCE）：等一小会儿，把接着发的几条消息合并成一个问题再回复
+			if !service.DebounceAddressed(groupID, userID, ev.MessageID, prompt, respond) {
+				if prompt == "" {
+					respond("", ev.MessageID)
+					return
+				}
+				go respond(prompt, ev.MessageID)
+			} else if prompt == "" {
+				return
+			}
+		} else if !isPrivate && service.AppendPendingPrompt(groupID, userID, content) {
+			// 刚 @ 过机器人的用户接着发的话，已并入等待合并的提问，不再触发插嘴
+			service.LogDrop("merged_into_prompt", groupID, userID)
+		} else if !isPrivate && service.IsBotActive(groupID) {
 			// 2. 主动插嘴逻辑 (Proactive Interjection)
 			// 只有清理完内容后长度足够的才考虑
 			if !hasMeaningfulContent(content) {
+				service.LogDrop("no_meaningful_content", groupID, userID)
 				return
 			}
 
-			// 冷却检查：同一群聊 5 分钟内最多主动插嘴一次
-			if lastTime, ok := proactiveCooldown[groupID]; ok && time.Since(lastTime) < 5*time.Minute {
-				// 虽然不插嘴，但还是要把消息存入 RAG（在后面统一处理）
-			} else {
-				// 尝试获取主动回复
-				go func() {
+			// 冷却检查：免打扰时段不插嘴；同一群聊 5 分钟内最多主动插嘴一次，且每天不超过群组上限
+			// 虽然不插嘴，但还是要把消息存入 RAG（在后面统一处理）
+			tryProactive := false
+			switch {
+			case !service.IsProactiveEnabled(groupID):
+				service.LogDrop("proactive_disabled", groupID, userID)
+			case service.InQuietHours():
+				service.LogDrop("proactive_quiet_hours", groupID, userID)
+			case service.ProactiveCoolingDown(groupID):
+				service.LogDrop("proactive_cooldown", groupID, userID)
+			default:
+				tryProactive = true
+			}
+
+			go func() {
+				// 冷场后刚恢复活跃：预热期内既不主动插嘴也不随口接话
+				if service.GroupWarmingUp(groupID) {
+					service.LogDrop("proactive_warmup", groupID, userID)
+					return
+				}
+				if tryProactive && service.ProactiveCapReached(groupID) {
+					service.LogDrop("proactive_daily_cap", groupID, userID)
+					tryProactive = false
+				}
+				if tryProactive && !service.GroupActiveEnough(groupID) {
+					service.LogDrop("proactive_group_inactive", groupID, userID)
+					tryProactive = false
+				}
+				if tryProactive {
 					// 这个函数会内部判断 RAG 匹配分和语义触发
 					reply, shouldReply := service.GetProactiveResponse(content, groupID, userID)
 					if shouldReply && reply != "" {
-						proactiveCooldown[groupID] = time.Now()
-						ctx.Send(cleanCQCodes(reply))
+						service.RecordProactiveReply(groupID)
+						reply = cleanCQCodes(reply)
+						msgID := ctx.Send(service.DecorateReply(reply, true, true))
+						service.SaveBotReply(ev.SelfID, groupID, msgID.ID(), reply)
+						return
 					}
-				}()
-			}
+				}
+
+				// 没有触发主动插嘴时，按群配置的概率随口接话（有独立冷却）
+				if reply, ok := service.GetRandomReply(content, groupID); ok {
+					reply = cleanCQCodes(reply)
+					msgID := ctx.Send(service.DecorateReply(reply, true, true))
+					service.SaveBotReply(ev.SelfID, groupID, msgID.ID(), reply)
+				}
+			}()
 		}
 
 		// 3. 归档到 RAG（包含消息过滤）
-		if !hasMeaningfulContent(content) || content[0] == '/' {
+		switch {
+		case !allowed:
+			service.LogDrop("archive_group_not_allowlisted", groupID, userID)
 			return
-		}
-		if !service.IsRAGEnabled(groupID) {
+		case !hasMeaningfulContent(content):
+			service.LogDrop("archive_no_meaningful_content", groupID, userID)
+			return
+		case content[0] == '/':
+			service.LogDrop("archive_command", groupID, userID)
+			return
+		case !service.IsRAGEnabled(groupID):
+			service.LogDrop("rag_disabled", groupID, userID)
 			return
 		}
 
@@ -156,17 +689,22 @@ func main() {
 			strconv.FormatInt(userID, 10),
 			nickname,
 			groupID,
+			ev.MessageID,
 			content,
+			atMe,
 		)
 	})
 
+	// OneBot 连接（可配置重连退避），健康检查在连接前启动，连不上时 /readyz 也能反映
+	ws := onebot.NewWSClient(config.Get().BotWSURL, config.Get().BotToken,
+		config.Get().WSReconnectInterval, config.Get().WSReconnectMaxInterval)
+	service.StartHealthServer(config.Get().HealthAddr, ws)
+
 	// 运行机器人
 	zero.RunAndBlock(&zero.Config{
-		NickName:      []string{"bot"},
+		NickName:      []string{config.Get().BotName},
 		CommandPrefix: "/",
-		SuperUsers:    config.Cfg.SuperUsers,
-		Driver: []zero.Driver{
-			driver.NewWebSocketClient(config.Cfg.BotWSURL, config.Cfg.BotToken),
-		},
+		SuperUsers:    config.Get().SuperUsers,
+		Driver:        []zero.Driver{ws},
 	}, nil)
 }
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"testing"
+
+	"gin-bot/config"
+
+	zero "github.com/wdvxdr1123/ZeroBot"
+)
+
+const testSelfID int64 = 10001
+
+func TestIsAtSelf(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    bool
+	}{
+		{"text only", "今天吃什么", false},
+		{"at other", "[CQ:at,qq=20002] 今天吃什么", false},
+		{"at other with self id as prefix", "[CQ:at,qq=100011] 在吗", false},
+		{"at all", "[CQ:at,qq=all] 开会了", false},
+		{"at self", "[CQ:at,qq=10001] 今天吃什么", true},
+		{"at self with extra params", "[CQ:at,qq=10001,name=小黄] 今天吃什么", true},
+		{"at self after at other", "[CQ:at,qq=20002] [CQ:at,qq=10001] 你们好", true},
+		{"at self only inside forward", "[CQ:forward,id=abc,content=[CQ:at,qq=10001] 旧消息] 看看这个", false},
+		{"at self outside forward", "[CQ:forward,id=abc] [CQ:at,qq=10001] 看看这个", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isAtSelf(tt.content, testSelfID); got != tt.want {
+				t.Errorf("isAtSelf(%q) = %v, want %v", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripAtSelf(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"text only", "今天吃什么", "今天吃什么"},
+		{"at other kept", "[CQ:at,qq=20002] 今天吃什么", "[CQ:at,qq=20002] 今天吃什么"},
+		{"at self removed", "[CQ:at,qq=10001] 今天吃什么", " 今天吃什么"},
+		{"at self with extra params removed", "[CQ:at,qq=10001,name=小黄]今天吃什么", "今天吃什么"},
+		{"only self removed", "[CQ:at,qq=10001][CQ:at,qq=20002] 问问他", "[CQ:at,qq=20002] 问问他"},
+		{"at all kept", "[CQ:at,qq=all] 开会了", "[CQ:at,qq=all] 开会了"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stripAtSelf(tt.content, testSelfID); got != tt.want {
+				t.Errorf("stripAtSelf(%q) = %q, want %q", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeEvent(t *testing.T) {
+	sender := &zero.User{ID: 20002, NickName: "阿明"}
+	tests := []struct {
+		name   string
+		event  *zero.Event
+		want   chatEvent
+		wantOK bool
+	}{
+		{
+			name: "group",
+			event: &zero.Event{SelfID: testSelfID, UserID: 20002, GroupID: 30003, MessageType: "group",
+				MessageID: int64(42), RawMessage: "大家好", Sender: sender},
+			want:   chatEvent{SelfID: testSelfID, UserID: 20002, GroupID: 30003, MessageID: 42, Nickname: "阿明", Content: "大家好"},
+			wantOK: true,
+		},
+		{
+			name: "private ignores group id",
+			event: &zero.Event{SelfID: testSelfID, UserID: 20002, GroupID: 30003, MessageType: "private",
+				MessageID: int64(43), RawMessage: "在吗", Sender: sender},
+			want:   chatEvent{SelfID: testSelfID, UserID: 20002, IsPrivate: true, MessageID: 43, Nickname: "阿明", Content: "在吗"},
+			wantOK: true,
+		},
+		{
+			name:   "missing sender and non-int message id",
+			event:  &zero.Event{SelfID: testSelfID, UserID: 20002, MessageType: "private", MessageID: "abc", RawMessage: "hi"},
+			want:   chatEvent{SelfID: testSelfID, UserID: 20002, IsPrivate: true, Nickname: "未知用户", Content: "hi"},
+			wantOK: true,
+		},
+		{
+			name:   "own message marked from bot",
+			event:  &zero.Event{SelfID: testSelfID, UserID: testSelfID, GroupID: 30003, MessageType: "group", RawMessage: "回复"},
+			want:   chatEvent{SelfID: testSelfID, UserID: testSelfID, GroupID: 30003, FromBot: true, Nickname: "未知用户", Content: "回复"},
+			wantOK: true,
+		},
+		{name: "nil event", event: nil},
+		{name: "missing self id", event: &zero.Event{UserID: 20002, GroupID: 30003, MessageType: "group"}},
+		{name: "missing user id", event: &zero.Event{SelfID: testSelfID, GroupID: 30003, MessageType: "group"}},
+		{name: "group without group id", event: &zero.Event{SelfID: testSelfID, UserID: 20002, MessageType: "group"}},
+		{name: "guild message", event: &zero.Event{SelfID: testSelfID, UserID: 20002, MessageType: "guild"}},
+		{name: "empty message type", event: &zero.Event{SelfID: testSelfID, UserID: 20002}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := normalizeEvent(tt.event)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("normalizeEvent = %+v, %v; want %+v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestStripForwardCQ(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"no forward", "普通消息[CQ:face,id=178]", "普通消息[CQ:face,id=178]"},
+		{"id only", "看这个[CQ:forward,id=7268410563]", "看这个"},
+		{"inline content", "[CQ:forward,id=abc,content=小明：早上好] 你们看", " 你们看"},
+		{"nested at inside content", "[CQ:forward,id=abc,content=[CQ:at,qq=10001] 旧消息[CQ:image,file=a.jpg]]后面", "后面"},
+		{"text around", "前[CQ:forward,id=1]中[CQ:forward,id=2]后", "前中后"},
+		{"unterminated drops rest", "前[CQ:forward,id=1,content=[CQ:at,qq=1]", "前"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stripForwardCQ(tt.content); got != tt.want {
+				t.Errorf("stripForwardCQ(%q) = %q, want %q", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsCalledByName(t *testing.T) {
+	prev := config.Get()
+	config.Set(&config.Config{BotName: "小黄"})
+	t.Cleanup(func() { config.Set(prev) })
+
+	tests := []struct {
+		content string
+		want    bool
+	}{
+		{"小黄", true},
+		{"  小黄  ", true},
+		{"小黄，今天吃啥", true},
+		{"小黄 今天吃啥", true},
+		{"小黄!在吗", true},
+		{"小黄？", true},
+		{"小黄~", true},
+		{"小黄😂", true},
+		{"小黄[CQ:face,id=178]", true},
+		{"小黄鸭真可爱", false},
+		{"小黄图", false},
+		{"小黄ab", false},
+		{"我说小黄，你好", false},
+		{"今天吃啥", false},
+	}
+	for _, tt := range tests {
+		if got := isCalledByName(tt.content); got != tt.want {
+			t.Errorf("isCalledByName(%q) = %v, want %v", tt.content, got, tt.want)
+		}
+	}
+	if got := stripBotName("小黄鸭真可爱"); got != "小黄鸭真可爱" {
+		t.Errorf("stripBotName kept name inside a word: %q", got)
+	}
+	if got := stripBotName("小黄，今天吃啥"); got != "今天吃啥" {
+		t.Errorf("stripBotName = %q, want %q", got, "今天吃啥")
+	}
+}
@@ -11,9 +11,11 @@ type User struct {
 	ID        uint           `gorm:"primaryKey" json:"id"`
 	QQ        string         `gorm:"uniqueIndex;not null" json:"qq"`
 	Nickname  string         `json:"nickname"`
+	Alias     string         `json:"alias"` // 用户希望机器人使用的称呼（为空则用昵称）
 	Gold      int64          `gorm:"default:0" json:"gold"`
 	LastSign  *time.Time     `json:"last_sign"`
-	Persona   string         `json:"persona"` // AI 总结的人设摘要
+	Persona   string         `json:"persona"`    // AI 总结的人设摘要
+	PersonaAt *time.Time     `json:"persona_at"` // 人设摘要最近一次生成时间
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
@@ -26,7 +28,10 @@ type ChatHistory struct {
 	ID        uint           `gorm:"primaryKey" json:"id"`
 	UserID    uint           `gorm:"index" json:"user_id"`
 	GroupID   int64          `gorm:"index" json:"group_id"`
+	MessageID int64          `gorm:"index" json:"message_id"` // QQ 消息 ID（用于引用回复、反馈等关联）
 	Content   string         `gorm:"type:text" json:"content"`
+	FromBot   bool           `gorm:"index;default:false" json:"from_bot"` // 机器人账号发出的消息（不参与检索与触发）
+	Archived  bool           `gorm:"default:false" json:"archived"`       // 同时登录的其他机器人账号的消息，仅作存档（不可被评价）
 	CreatedAt time.Time      `json:"created_at"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 
@@ -35,24 +40,81 @@ type ChatHistory struct {
 
 // MemberEmbedding 向量记忆表 (member_embeddings) —— 长期记忆 (RAG 核心)
 // 注意：实际向量存储在 Pinecone，此处仅存储 VectorID 作为关联
-// 使用 NVIDIA llama-3.2-nemoretriever 模型 (原 2048 维，通过 Matryoshka 截断为 1024 维)
+// 默认使用 NVIDIA llama-3.2-nemoretriever 模型 (原 2048 维，通过 Matryoshka 截断为 EMBEDDING_DIM 维，默认 1024)
 type MemberEmbedding struct {
 	ID             uint      `gorm:"primaryKey" json:"id"`
-	VectorID       string    `gorm:"index" json:"vector_id"`           // Pinecone 中的向量 ID
-	ContentSummary string    `gorm:"type:text" json:"content_summary"` // 切片后的文本
-	RefMsgID       uint      `gorm:"index" json:"ref_msg_id"`          // 关联到原始消息表
+	VectorID       string    `gorm:"index" json:"vector_id"`            // Pinecone 中的向量 ID
+	Namespace      string    `gorm:"index" json:"namespace"`            // 所在 Pinecone namespace
+	ContentSummary string    `gorm:"type:text" json:"content_summary"`  // 切片后的文本
+	RefMsgID       uint      `gorm:"index" json:"ref_msg_id"`           // 关联到原始消息表
+	WindowStartID  uint      `json:"window_start_id"`                   // 连发合并的记忆：窗口中最早一条消息的 ID（窗口为同一会话中该条到 RefMsgID 之间的消息），0 表示单条
+	OwnerQQ        string    `gorm:"index" json:"owner_qq"`             // 记忆归属的用户（问答对挂在机器人回复上，归属为提问者），为空时为原始消息的发送者
+	Pinned         bool      `gorm:"index;default:false" json:"pinned"` // 置顶的重要记忆：清理与对账都不会删除，检索时加权
+	Shared         bool      `gorm:"default:false" json:"shared"`       // 个人信息已由本人共享（与 Pinecone metadata 同步，对账重建向量时恢复）
 	CreatedAt      time.Time `json:"created_at"`
 
 	RefMsg ChatHistory `gorm:"foreignKey:RefMsgID" json:"ref_msg,omitempty"`
 }
 
+// ReplyFeedback 回复反馈表 (reply_feedbacks) —— 用户对机器人回复的 👍/👎
+type ReplyFeedback struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	ChatHistoryID uint      `gorm:"uniqueIndex:idx_feedback_reply_user" json:"chat_history_id"` // 被评价的机器人回复
+	UserQQ        string    `gorm:"uniqueIndex:idx_feedback_reply_user" json:"user_qq"`         // 评价人
+	GroupID       int64     `gorm:"index" json:"group_id"`
+	Rating        int       `json:"rating"` // 1 好评，-1 差评
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+
+	ChatHistory ChatHistory `gorm:"foreignKey:ChatHistoryID" json:"chat_history,omitempty"`
+}
+
+// GoldTransaction 金币流水表 (gold_transactions) —— 每次金币变动一条记录（User.Gold 只经由 service.ChangeGold 修改）
+type GoldTransaction struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"index" json:"user_id"`
+	Delta     int64     `json:"delta"`   // 变动数额（负数为支出）
+	Balance   int64     `json:"balance"` // 变动后的余额
+	Reason    string    `json:"reason"`  // 变动原因，如 sign_in、bonus
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
 // Group 群组配置表 (groups) —— 环境感知
 type Group struct {
-	GroupID    int64          `gorm:"primaryKey" json:"group_id"`
-	IsActive   bool           `gorm:"default:true" json:"is_active"`
-	RAGEnabled bool           `gorm:"default:true" json:"rag_enabled"`
-	Config     string         `gorm:"type:jsonb" json:"config"` // JSONB 类型
-	CreatedAt  time.Time      `json:"created_at"`
-	UpdatedAt  time.Time      `json:"updated_at"`
-	DeletedAt  gorm.DeletedAt `gorm:"index" json:"-"`
+	GroupID          int64          `gorm:"primaryKey" json:"group_id"`
+	GroupName        string         `json:"group_name"`   // 群名称（开启群信息同步后由 OneBot 获取）
+	MemberCount      int            `json:"member_count"` // 群成员数
+	IsActive         bool           `gorm:"default:true" json:"is_active"`
+	RAGEnabled       bool           `gorm:"default:true" json:"rag_enabled"`
+	ProactiveEnabled bool           `gorm:"default:true" json:"proactive_enabled"` // 主动插嘴开关（与 RAG 归档相互独立）
+	CareEnabled      bool           `gorm:"default:true" json:"care_enabled"`      // 主动关怀（随访）开关（与主动插嘴相互独立）
+	Config           string         `gorm:"type:jsonb;default:'{}'" json:"config"` // JSONB 类型，结构见 GroupConfig
+	CreatedAt        time.Time      `json:"created_at"`
+	UpdatedAt        time.Time      `json:"updated_at"`
+	DeletedAt        gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// GroupConfig 群组扩展配置，序列化后存入 Group.Config (JSONB)
+type GroupConfig struct {
+	MemoryBlocklist []string `json:"memory_blocklist,omitempty"` // 不归档到 RAG 的 QQ 号（机器人、公告号等）
+
+	RandomReplyProb     float64 `json:"random_reply_prob,omitempty"`     // 未被 @ 时随口接话的概率 (0-1)，0 表示关闭
+	RandomReplyCooldown int     `json:"random_reply_cooldown,omitempty"` // 随口接话的冷却时间（分钟），0 使用默认值
+
+	EnabledTools []string `json:"enabled_tools,omitempty"` // 普通成员可用的工具白名单，为空表示全部可用（超级用户不受限制）
+
+	MaxReplyTokens int `json:"max_reply_tokens,omitempty"` // 回复长度上限（token），0 使用全局默认值
+
+	OffBehavior string `json:"off_behavior,omitempty"` // 机器人关闭时被 @ 的表现："silent"（默认，不回复）或 "notice"（限频提示已静音）
+
+	ArchiveSampleRate *float64 `json:"archive_sample_rate,omitempty"` // 未 @ 机器人的消息进入分类+向量化的比例 (0-1)，未设置时全部处理
+
+	ProactiveDailyCap *int `json:"proactive_daily_cap,omitempty"` // 每天主动插嘴的上限，未设置时使用全局默认值，0 表示不限制
+
+	ReplyStyle string `json:"reply_style,omitempty"` // 回复被 @ 的消息时的形式："at"（默认，@ 对方）、"quote"（引用原消息）或 "both"
+
+	Theme string `json:"theme,omitempty"` // 群聊主题（如"考研学习""原神"），注入系统提示词影响语气，为空表示无主题
+
+	Language     string `json:"language,omitempty"`      // 回复语言（如 "English"），为空使用中文
+	CareTemplate string `json:"care_template,omitempty"` // 本群的关怀兜底模板（占位符同 CARE_FALLBACK_TEMPLATE），为空使用全局模板
 }
@@ -0,0 +1,206 @@
+package onebot
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/RomiChan/websocket"
+	"github.com/tidwall/gjson"
+	zero "github.com/wdvxdr1123/ZeroBot"
+)
+
+// ErrNotConnected 连接断开期间调用 API 时返回
+var ErrNotConnected = errors.New("onebot websocket not connected")
+
+// WSClient 正向 WebSocket 驱动，与 ZeroBot 自带的 driver.WSClient 协议一致，
+// 区别在于重连间隔可配置（指数退避），并对外暴露连接状态供健康检查使用。
+// 仅支持 ws:// 与 wss:// 地址（不支持 ZeroBot 的 ws+unix 扩展）
+type WSClient struct {
+	URL         string
+	AccessToken string
+	// ReconnectInterval 首次重连前的等待时间，之后每次失败翻倍
+	ReconnectInterval time.Duration
+	// MaxReconnectInterval 重连等待时间的上限
+	MaxReconnectInterval time.Duration
+
+	seq    atomic.Uint64
+	mu     sync.Mutex // 写锁：websocket 写入不是并发安全的
+	conn   *websocket.Conn
+	selfID int64
+	calls  sync.Map // echo → chan zero.APIResponse
+
+	connected atomic.Bool
+	downSince atomic.Int64 // 断开时刻（unix 秒），已连接时为 0
+}
+
+// NewWSClient 创建正向 WebSocket 驱动
+func NewWSClient(url, accessToken string, interval, maxInterval time.Duration) *WSClient {
+	ws := &WSClient{
+		URL:                  url,
+		AccessToken:          accessToken,
+		ReconnectInterval:    interval,
+		MaxReconnectInterval: maxInterval,
+	}
+	ws.downSince.Store(time.Now().Unix())
+	return ws
+}
+
+// Connected 当前是否与 OneBot 服务端保持连接
+func (ws *WSClient) Connected() bool {
+	return ws.connected.Load()
+}
+
+// DownSince 连接断开的时刻；已连接时返回零值
+func (ws *WSClient) DownSince() time.Time {
+	sec := ws.downSince.Load()
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
+
+// Connect 连接服务端，失败时按退避间隔重试直到成功
+func (ws *WSClient) Connect() {
+	header := http.Header{
+		"X-Client-Role": []string{"Universal"},
+		"User-Agent":    []string{"ZeroBot/1.8.2"},
+	}
+	if ws.AccessToken != "" {
+		header["Authorization"] = []string{"Bearer " + ws.AccessToken}
+	}
+
+	wait := ws.ReconnectInterval
+	for attempt := 1; ; attempt++ {
+		err := ws.dial(header)
+		if err == nil {
+			break
+		}
+		log.Printf("[WS] Failed to connect to %s (attempt %d, retry in %s): %v", ws.URL, attempt, wait, err)
+		time.Sleep(wait)
+		wait = min(wait*2, max(ws.MaxReconnectInterval, ws.ReconnectInterval))
+	}
+
+	ws.connected.Store(true)
+	ws.downSince.Store(0)
+	zero.APICallers.Store(ws.selfID, ws)
+	log.Printf("[WS] Connected to %s, account %d", ws.URL, ws.selfID)
+}
+
+// dial 建立连接并读取握手时服务端发来的 self_id
+func (ws *WSClient) dial(header http.Header) error {
+	conn, res, err := websocket.DefaultDialer.Dial(ws.URL, header)
+	if err != nil {
+		return err
+	}
+	_ = res.Body.Close()
+
+	var rsp struct {
+		SelfID int64 `json:"self_id"`
+	}
+	if err := conn.ReadJSON(&rsp); err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	ws.mu.Lock()
+	ws.conn = conn
+	ws.mu.Unlock()
+	ws.selfID = rsp.SelfID
+	return nil
+}
+
+// Listen 监听事件，连接断开时标记为不可用并重连
+func (ws *WSClient) Listen(handler func([]byte, zero.APICaller)) {
+	for {
+		t, payload, err := ws.conn.ReadMessage()
+		if err != nil {
+			ws.markDown(err)
+			ws.Connect()
+			continue
+		}
+		if t != websocket.TextMessage {
+			continue
+		}
+
+		rsp := gjson.ParseBytes(payload)
+		if rsp.Get("echo").Exists() { // API 调用的返回
+			if c, ok := ws.calls.LoadAndDelete(rsp.Get("echo").Uint()); ok {
+				msg := rsp.Get("message").Str
+				if msg == "" {
+					msg = rsp.Get("msg").Str
+				}
+				ch := c.(chan zero.APIResponse)
+				ch <- zero.APIResponse{
+					Status:  rsp.Get("status").String(),
+					Data:    rsp.Get("data"),
+					Message: msg,
+					Wording: rsp.Get("wording").Str,
+					RetCode: rsp.Get("retcode").Int(),
+					Echo:    rsp.Get("echo").Uint(),
+				}
+				close(ch)
+			}
+			continue
+		}
+		if rsp.Get("meta_event_type").Str == "heartbeat" {
+			continue
+		}
+		handler(payload, ws)
+	}
+}
+
+// markDown 记录断开状态，并让等待中的 API 调用立即返回
+func (ws *WSClient) markDown(err error) {
+	ws.connected.Store(false)
+	ws.downSince.Store(time.Now().Unix())
+	zero.APICallers.Delete(ws.selfID)
+	log.Printf("[WS] Connection to %s lost: %v", ws.URL, err)
+
+	ws.mu.Lock()
+	_ = ws.conn.Close()
+	ws.mu.Unlock()
+
+	ws.calls.Range(func(key, value any) bool {
+		if c, ok := ws.calls.LoadAndDelete(key); ok {
+			close(c.(chan zero.APIResponse))
+		}
+		return true
+	})
+}
+
+// CallAPI 发送 API 请求并等待响应
+func (ws *WSClient) CallAPI(c context.Context, req zero.APIRequest) (zero.APIResponse, error) {
+	if !ws.Connected() {
+		return zero.APIResponse{}, ErrNotConnected
+	}
+
+	ch := make(chan zero.APIResponse, 1)
+	req.Echo = ws.seq.Add(1)
+	ws.calls.Store(req.Echo, ch)
+
+	ws.mu.Lock()
+	err := ws.conn.WriteJSON(&req)
+	ws.mu.Unlock()
+	if err != nil {
+		ws.calls.Delete(req.Echo)
+		log.Printf("[WS] Failed to send %s: %v", req.Action, err)
+		return zero.APIResponse{}, err
+	}
+
+	select {
+	case rsp, ok := <-ch:
+		if !ok {
+			return zero.APIResponse{}, io.ErrClosedPipe
+		}
+		return rsp, nil
+	case <-c.Done():
+		ws.calls.Delete(req.Echo)
+		return zero.APIResponse{}, c.Err()
+	}
+}
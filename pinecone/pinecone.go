@@ -31,8 +31,8 @@ var (
 
 // InitPinecone 初始化 Pinecone 客户端
 func InitPinecone() {
-	apiKey := config.Cfg.PineconeAPIKey
-	indexName := config.Cfg.PineconeIndex
+	apiKey := config.Get().PineconeAPIKey
+	indexName := config.Get().PineconeIndex
 
 	var err error
 	PCClient, err = pinecone.NewClient(pinecone.NewClientParams{
@@ -137,14 +137,86 @@ func QueryWithScore(ctx context.Context, namespace string, vector []float32, top
 		return nil, err
 	}
 
-	var matches []Match
-	for _, m := range resp.Matches {
-		if m.Vector != nil {
-			matches = append(matches, Match{
-				ID:    m.Vector.Id,
-				Score: m.Score,
-			})
+	return toMatches(resp.Matches), nil
+}
+
+// toMatches 提取查询结果的 ID 和分数。
+// SDK 把 ID 放在 ScoredVector.Vector.Id 中，未请求向量值（IncludeValues）时 Vector 依然存在、只是 Values 为空，
+// 因此不能以向量值是否返回来筛选结果；只跳过缺少 ID 的异常结果并记录日志，避免悄悄少返回
+func toMatches(scored []*pinecone.ScoredVector) []Match {
+	matches := make([]Match, 0, len(scored))
+	skipped := 0
+	for _, m := range scored {
+		if m == nil || m.Vector == nil || m.Vector.Id == "" {
+			skipped++
+			continue
 		}
+		matches = append(matches, Match{
+			ID:    m.Vector.Id,
+			Score: m.Score,
+		})
+	}
+	if skipped > 0 {
+		log.Printf("[Pinecone] Skipped %d of %d matches without an ID", skipped, len(scored))
+	}
+	return matches
+}
+
+// UpdateMetadata 更新指定 namespace 中向量的 metadata（仅覆盖传入的字段）
+func UpdateMetadata(ctx context.Context, namespace, id string, metadata map[string]interface{}) error {
+	idx, err := getIndexWithNamespace(namespace)
+	if err != nil {
+		return err
+	}
+
+	metaStruct, err := structpb.NewStruct(metadata)
+	if err != nil {
+		return fmt.Errorf("failed to create metadata struct: %v", err)
+	}
+
+	return idx.UpdateVector(ctx, &pinecone.UpdateVectorRequest{
+		Id:       id,
+		Metadata: metaStruct,
+	})
+}
+
+// UpdateValues 更新指定 namespace 中向量的值，metadata 保持不变
+func UpdateValues(ctx context.Context, namespace, id string, values []float32) error {
+	idx, err := getIndexWithNamespace(namespace)
+	if err != nil {
+		return err
+	}
+
+	return idx.UpdateVector(ctx, &pinecone.UpdateVectorRequest{
+		Id:     id,
+		Values: values,
+	})
+}
+
+// FetchExistingIDs 返回给定 ID 中实际存在于指定 namespace 的向量
+func FetchExistingIDs(ctx context.Context, namespace string, ids []string) (map[string]bool, error) {
+	idx, err := getIndexWithNamespace(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := idx.FetchVectors(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	existing := make(map[string]bool, len(resp.Vectors))
+	for id := range resp.Vectors {
+		existing[id] = true
+	}
+	return existing, nil
+}
+
+// DeleteFromNamespace 删除指定 namespace 中的向量
+func DeleteFromNamespace(ctx context.Context, namespace string, ids []string) error {
+	idx, err := getIndexWithNamespace(namespace)
+	if err != nil {
+		return err
 	}
-	return matches, nil
+	return idx.DeleteVectorsById(ctx, ids)
 }
@@ -0,0 +1,60 @@
+package pinecone
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pinecone-io/go-pinecone/pinecone"
+)
+
+func TestToMatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		scored []*pinecone.ScoredVector
+		want   []Match
+	}{
+		{"empty", nil, []Match{}},
+		{
+			name: "without values",
+			scored: []*pinecone.ScoredVector{
+				{Vector: &pinecone.Vector{Id: "msg_1"}, Score: 0.91},
+				{Vector: &pinecone.Vector{Id: "msg_2"}, Score: 0.75},
+			},
+			want: []Match{{ID: "msg_1", Score: 0.91}, {ID: "msg_2", Score: 0.75}},
+		},
+		{
+			name:   "with values",
+			scored: []*pinecone.ScoredVector{{Vector: &pinecone.Vector{Id: "msg_3", Values: []float32{0.1, 0.2}}, Score: 0.5}},
+			want:   []Match{{ID: "msg_3", Score: 0.5}},
+		},
+		{
+			name: "nil entries skipped",
+			scored: []*pinecone.ScoredVector{
+				nil,
+				{Vector: nil, Score: 0.9},
+				{Vector: &pinecone.Vector{Id: "msg_4"}, Score: 0.6},
+			},
+			want: []Match{{ID: "msg_4", Score: 0.6}},
+		},
+		{
+			name: "entries without id skipped",
+			scored: []*pinecone.ScoredVector{
+				{Vector: &pinecone.Vector{Values: []float32{0.3}}, Score: 0.8},
+				{Vector: &pinecone.Vector{Id: "msg_5"}, Score: 0.4},
+			},
+			want: []Match{{ID: "msg_5", Score: 0.4}},
+		},
+		{
+			name:   "all invalid",
+			scored: []*pinecone.ScoredVector{nil, {Vector: &pinecone.Vector{}}},
+			want:   []Match{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := toMatches(tt.scored); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("toMatches = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
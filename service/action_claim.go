@@ -0,0 +1,92 @@
+package service
+
+import (
+	"log"
+	"net/http"
+	"regexp"
+	"sync/atomic"
+
+	"gin-bot/config"
+)
+
+// 模型声称执行了操作却没有调用工具时的处理方式（FC_ACTION_CHECK）
+const (
+	ActionCheckOff   = "off"   // 不检查
+	ActionCheckLog   = "log"   // 只记录日志（默认）
+	ActionCheckRetry = "retry" // 记录日志并附上纠正提示重试一次（tool_choice=auto，由模型决定是否调用工具）
+)
+
+// actionClaimRegex 匹配"已经关闭了""帮你设好了""搞定"这类声称操作已完成的说法
+var actionClaimRegex = regexp.MustCompile(`(已经?|都)(帮你|给你|为你)?(` + actionVerbs + `)|(帮你|给你)(` + actionVerbs + `)(好了|了)|(设置|操作|调整|修改)(好了|完成|成功)|搞定[了啦]`)
+
+// actionVerbs 工具对应的操作动词（开关、设置、删除、提醒等）
+const actionVerbs = `关闭|关掉|关了|开启|打开|开了|启用|停用|设置|设好|设定|定好|调成|调到|改成|改为|改好|切换|删除|删掉|删了|取消|移除|添加|加上|加好|置顶|共享|屏蔽|记下|记好`
+
+// unbackedClaims 进程启动以来检测到的无工具调用的操作声明次数（用于衡量模型"说了没做"的频率）
+var unbackedClaims atomic.Int64
+
+// actionClaimNudge 重试时追加的提示：指出上一条回复并未真正执行操作
+// 正则可能误判闲聊（如"搞定啦"），因此不强制调用工具，允许模型确认无需操作后不调用
+const actionClaimNudge = "系统检查：你上一条回复声称已经完成了操作，但没有调用任何工具，所以实际上什么都没有发生。如果用户确实要求了操作，请调用对应的工具真正执行；如果用户并没有要求执行操作，不要调用任何工具。"
+
+// claimsAction 判断回复是否声称已执行某项操作
+func claimsAction(reply string) bool {
+	return actionClaimRegex.MatchString(reply)
+}
+
+// hasActionTools 可用工具中是否有会产生副作用的工具（全是只读工具时声称"已完成操作"不可能有对应工具）
+func hasActionTools(fcTools []FCTool) bool {
+	for _, t := range fcTools {
+		if !isReadOnlyTool(t.Function.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyActionClaim 检查没有调用工具的纯文本回复：声称执行了操作时记录日志，
+// retry 模式下附上纠正提示以 tool_choice=auto 重试一次，模型改为调用工具则继续走工具流程，仍不调用则沿用原回复。
+// 返回 handled=false 时调用方使用原回复；temperature 为改走工具流程后生成回复使用的场景温度
+func verifyActionClaim(env fcEnv, reply string, messages []ChatMessage, fcTools []FCTool, media []string, groupID int64, userID int64, isSuperUser bool, client *http.Client, temperature float64) (string, bool) {
+	mode := config.Get().FCActionCheck
+	if mode == ActionCheckOff || !claimsAction(reply) || !hasActionTools(fcTools) {
+		return "", false
+	}
+
+	count := unbackedClaims.Add(1)
+	log.Printf("[FC] Reply claims an action without calling a tool (group %d, user %d, total %d): %q",
+		groupID, userID, count, reply)
+	if mode != ActionCheckRetry {
+		return "", false
+	}
+
+	retryMessages := make([]map[string]interface{}, 0, len(messages)+2)
+	for _, m := range messages {
+		retryMessages = append(retryMessages, map[string]interface{}{"role": m.Role, "content": m.Content})
+	}
+	retryMessages = append(retryMessages,
+		map[string]interface{}{"role": "assistant", "content": reply},
+		map[string]interface{}{"role": "user", "content": actionClaimNudge},
+	)
+	reqBody := map[string]interface{}{
+		"model":       NVIDIA_FC_MODEL,
+		"messages":    retryMessages,
+		"tools":       fcTools,
+		"tool_choice": "auto",
+		"temperature": 0.2,
+		"max_tokens":  MaxReplyTokens(groupID),
+	}
+
+	resp, err := postFCRequest(env, client, reqBody)
+	if err != nil || len(resp.Choices) == 0 || len(resp.Choices[0].Message.ToolCalls) == 0 {
+		log.Printf("[FC] Action claim retry did not produce a tool call (err: %v)", err)
+		return "", false
+	}
+
+	log.Printf("[FC] Action claim retry called %d tool(s)", len(resp.Choices[0].Message.ToolCalls))
+	result, err := handleToolCalls(env, resp.Choices[0].Message.ToolCalls, messages, fcTools, media, groupID, userID, isSuperUser, client, temperature)
+	if err != nil {
+		return "", false
+	}
+	return result, true
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"time"
+
+	"gin-bot/config"
+	"gin-bot/database"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// groupAllowlistKey Redis 中保存群组白名单的 Set
+const groupAllowlistKey = "bot:group_allowlist"
+
+// groupAllowlistSeededKey 白名单已初始化的标记。Redis 的 Set 移除最后一个成员后会被删除，
+// 只看 Set 是否存在的话，名单被清空后重启又会用配置重新填充；有标记时以 Redis 为准（包括空名单）
+const groupAllowlistSeededKey = "bot:group_allowlist:seeded"
+
+// GroupAllowlistEnabled 是否启用群组白名单（配置了 BOT_GROUP_ALLOWLIST 时启用）
+func GroupAllowlistEnabled() bool {
+	return len(config.Get().GroupAllowlist) > 0
+}
+
+// InitGroupAllowlist 首次启动时用配置中的白名单初始化 Redis，之后以 Redis 中的名单为准（支持运行时增删）
+// 启动时 Redis 不可用的，重连成功后由调度器再次调用
+func InitGroupAllowlist() {
+	rdb := database.Redis()
+	if !GroupAllowlistEnabled() || rdb == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	n, err := rdb.Exists(ctx, groupAllowlistSeededKey, groupAllowlistKey).Result()
+	if err != nil {
+		log.Printf("[Allowlist] Failed to check group allowlist: %v", err)
+		return
+	}
+	if n > 0 {
+		// 旧版本只写了 Set 没有标记，补上标记，之后清空名单也不会被重新填充
+		if err := rdb.SetNX(ctx, groupAllowlistSeededKey, 1, 0).Err(); err != nil {
+			log.Printf("[Allowlist] Failed to mark group allowlist: %v", err)
+		}
+		return
+	}
+	members := make([]interface{}, len(config.Get().GroupAllowlist))
+	for i, id := range config.Get().GroupAllowlist {
+		members[i] = id
+	}
+	_, err = rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SAdd(ctx, groupAllowlistKey, members...)
+		pipe.Set(ctx, groupAllowlistSeededKey, 1, 0)
+		return nil
+	})
+	if err != nil {
+		log.Printf("[Allowlist] Failed to seed group allowlist: %v", err)
+	}
+}
+
+// IsGroupAllowed 群组是否在白名单内；未启用白名单时总是允许，Redis 不可用时退回配置中的名单
+func IsGroupAllowed(groupID int64) bool {
+	if !GroupAllowlistEnabled() {
+		return true
+	}
+	if database.Redis() != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		ok, err := database.Redis().SIsMember(ctx, groupAllowlistKey, groupID).Result()
+		if err == nil {
+			return ok
+		}
+		log.Printf("[Allowlist] Redis lookup failed, using configured list: %v", err)
+	}
+	return slices.Contains(config.Get().GroupAllowlist, groupID)
+}
+
+// ListAllowedGroups 当前白名单中的群组
+func ListAllowedGroups() ([]int64, error) {
+	if database.Redis() == nil {
+		return config.Get().GroupAllowlist, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	members, err := database.Redis().SMembers(ctx, groupAllowlistKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int64, 0, len(members))
+	for _, m := range members {
+		if id, err := strconv.ParseInt(m, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// UpdateGroupAllowlist 运行时添加或移除白名单中的群组（同时写入初始化标记，移除最后一个群后名单保持为空）
+func UpdateGroupAllowlist(groupID int64, add bool) error {
+	rdb := database.Redis()
+	if rdb == nil {
+		return fmt.Errorf("redis not connected")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if add {
+			pipe.SAdd(ctx, groupAllowlistKey, groupID)
+		} else {
+			pipe.SRem(ctx, groupAllowlistKey, groupID)
+		}
+		pipe.Set(ctx, groupAllowlistSeededKey, 1, 0)
+		return nil
+	})
+	return err
+}
+
+// filterAllowedGroups 过滤掉白名单外的群（未启用白名单时原样返回）
+func filterAllowedGroups(ids []int64) []int64 {
+	if !GroupAllowlistEnabled() {
+		return ids
+	}
+	return slices.DeleteFunc(ids, func(id int64) bool { return !IsGroupAllowed(id) })
+}
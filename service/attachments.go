@@ -0,0 +1,196 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"gin-bot/config"
+)
+
+// maxTaskAttachments 单个提醒最多携带的附件数
+const maxTaskAttachments = 5
+
+// mediaCQRegex 匹配消息中的图片、文件、语音、视频 CQ 码
+var mediaCQRegex = regexp.MustCompile(`\[CQ:(image|file|record|video),[^\]]*\]`)
+
+// mediaPlaceholderNames 附件占位符中使用的类型名
+var mediaPlaceholderNames = map[string]string{
+	"image":  "图片",
+	"file":   "文件",
+	"record": "语音",
+	"video":  "视频",
+}
+
+// extractMediaPlaceholders 把消息中的附件 CQ 码替换为 [图片1] 之类的占位符（模型难以原样复制冗长的 CQ 码），
+// 返回替换后的文本与按出现顺序排列的原始 CQ 码
+func extractMediaPlaceholders(text string) (string, []string) {
+	var media []string
+	replaced := mediaCQRegex.ReplaceAllStringFunc(text, func(code string) string {
+		kind := mediaCQRegex.FindStringSubmatch(code)[1]
+		media = append(media, code)
+		return fmt.Sprintf("[%s%d]", mediaPlaceholderNames[kind], len(media))
+	})
+	return replaced, media
+}
+
+// resolveAttachments 将工具参数中的附件（占位符、CQ 码或 URL）解析为可发送的内容，无法识别的项会被忽略
+func resolveAttachments(items []interface{}, media []string) []string {
+	var resolved []string
+	for _, item := range items {
+		s, _ := item.(string)
+		s = strings.TrimSpace(s)
+		switch {
+		case s == "":
+			continue
+		case mediaCQRegex.MatchString(s):
+			s = mediaCQRegex.FindString(s)
+		case strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://"):
+			s = urlAttachmentCQ(s) // 裸 URL 发出去只是一行链接，按扩展名包装为 CQ 码
+		default:
+			s = lookupMediaPlaceholder(s, media)
+		}
+		if s != "" && len(resolved) < maxTaskAttachments {
+			resolved = append(resolved, s)
+		}
+	}
+	return resolved
+}
+
+// lookupMediaPlaceholder 按 [图片1] 这样的占位符找回原始 CQ 码（序号在所有附件中统一编号）
+func lookupMediaPlaceholder(ref string, media []string) string {
+	ref = strings.Trim(ref, "[]【】 ")
+	for i, code := range media {
+		kind := mediaCQRegex.FindStringSubmatch(code)[1]
+		if ref == fmt.Sprintf("%s%d", mediaPlaceholderNames[kind], i+1) {
+			return code
+		}
+	}
+	return ""
+}
+
+// appendAttachments 在提醒内容后附上附件（附件在创建提醒时已统一为 CQ 码；旧任务中的裸 URL 单独成行）
+func appendAttachments(content string, attachments []string) string {
+	if len(attachments) == 0 {
+		return content
+	}
+	return content + "\n" + strings.Join(attachments, "\n")
+}
+
+// attachmentExtKinds 按 URL 扩展名决定包装成哪种 CQ 码，未收录的扩展名作为文件发送，没有扩展名的按图片处理
+// （QQ 图片链接通常不带扩展名）
+var attachmentExtKinds = map[string]string{
+	".jpg": "image", ".jpeg": "image", ".png": "image", ".gif": "image", ".webp": "image", ".bmp": "image",
+	".mp3": "record", ".amr": "record", ".silk": "record", ".wav": "record", ".ogg": "record", ".m4a": "record",
+	".mp4": "video", ".mov": "video", ".avi": "video", ".mkv": "video", ".webm": "video",
+}
+
+// urlAttachmentCQ 把 http(s) 链接包装为对应类型的 CQ 码，如 [CQ:image,file=https://...]
+func urlAttachmentCQ(rawURL string) string {
+	name := ""
+	if u, err := url.Parse(rawURL); err == nil {
+		name = path.Base(u.Path)
+	}
+	ext := strings.ToLower(path.Ext(name))
+	kind, ok := attachmentExtKinds[ext]
+	switch {
+	case ok:
+	case ext == "":
+		kind = "image"
+	default:
+		return fmt.Sprintf("[CQ:file,file=%s,name=%s]", escapeCQParam(rawURL), escapeCQParam(name))
+	}
+	return fmt.Sprintf("[CQ:%s,file=%s]", kind, escapeCQParam(rawURL))
+}
+
+// cqParamEscaper / cqParamUnescaper CQ 码参数值中的转义（& [ ] ,）
+var (
+	cqParamEscaper   = strings.NewReplacer("&", "&amp;", "[", "&#91;", "]", "&#93;", ",", "&#44;")
+	cqParamUnescaper = strings.NewReplacer("&amp;", "&", "&#91;", "[", "&#93;", "]", "&#44;", ",")
+)
+
+// escapeCQParam 转义 CQ 码参数值
+func escapeCQParam(v string) string {
+	return cqParamEscaper.Replace(v)
+}
+
+// cqParam 读取 CQ 码中指定参数的值（已反转义），不存在时返回空
+func cqParam(code string, key string) string {
+	body := strings.TrimSuffix(strings.TrimPrefix(code, "[CQ:"), "]")
+	for _, kv := range strings.Split(body, ",")[1:] {
+		if k, v, ok := strings.Cut(kv, "="); ok && k == key {
+			return cqParamUnescaper.Replace(v)
+		}
+	}
+	return ""
+}
+
+// attachmentCacheTimeout 缓存一个提醒全部附件的总时限（在工具执行时限内完成，超时的附件保留原样）
+const attachmentCacheTimeout = 5 * time.Second
+
+// cacheAttachments 把图片、语音、视频附件下载下来以 base64:// 形式内联到 CQ 码中。
+// QQ 的图片链接和 file 缓存名过一段时间就会失效，延后很久发送的提醒会发不出附件；
+// 超过 TASK_ATTACHMENT_CACHE_KB 或下载失败的附件保留原样，文件附件不缓存
+func cacheAttachments(attachments []string) []string {
+	limit := int64(config.Get().TaskAttachmentCacheKB) * 1024
+	if limit <= 0 {
+		return attachments
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), attachmentCacheTimeout)
+	defer cancel()
+
+	cached := make([]string, len(attachments))
+	for i, a := range attachments {
+		cached[i] = a
+		m := mediaCQRegex.FindStringSubmatch(a)
+		if m == nil || m[1] == "file" {
+			continue
+		}
+		src := cqParam(a, "url")
+		if src == "" {
+			src = cqParam(a, "file")
+		}
+		if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+			continue
+		}
+		data, err := downloadAttachment(ctx, src, limit)
+		if err != nil {
+			log.Printf("[Scheduler] Failed to cache attachment %s, keeping link: %v", src, err)
+			continue
+		}
+		cached[i] = fmt.Sprintf("[CQ:%s,file=base64://%s]", m[1], base64.StdEncoding.EncodeToString(data))
+	}
+	return cached
+}
+
+// downloadAttachment 下载附件，超过 limit 字节时返回错误
+func downloadAttachment(ctx context.Context, src string, limit int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := config.GetHTTPClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("larger than %d bytes", limit)
+	}
+	return data, nil
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveAttachmentsWrapsURLs(t *testing.T) {
+	media := []string{"[CQ:image,file=abc.image,url=https://multimedia.nt.qq.com.cn/download?appid=1407&amp;rkey=x]"}
+	items := []interface{}{
+		"https://example.com/cat.PNG",
+		"https://gchat.qpic.cn/gchatpic_new/0/0-0-ABC/0",
+		"https://example.com/voice.amr",
+		"https://example.com/clip.mp4?x=1",
+		"https://example.com/report.pdf",
+		"https://example.com/a,b.jpg",
+		"[图片1]",
+		"不是附件",
+	}
+	want := []string{
+		"[CQ:image,file=https://example.com/cat.PNG]",
+		"[CQ:image,file=https://gchat.qpic.cn/gchatpic_new/0/0-0-ABC/0]",
+		"[CQ:record,file=https://example.com/voice.amr]",
+		"[CQ:video,file=https://example.com/clip.mp4?x=1]",
+	}
+	got := resolveAttachments(items, media)
+	// maxTaskAttachments 限制了数量，后面几项单独验证
+	if !reflect.DeepEqual(got[:4], want) || len(got) != maxTaskAttachments {
+		t.Fatalf("resolveAttachments = %q, want prefix %q", got, want)
+	}
+	if got[4] != "[CQ:file,file=https://example.com/report.pdf,name=report.pdf]" {
+		t.Errorf("pdf attachment = %q", got[4])
+	}
+
+	got = resolveAttachments(items[5:], media)
+	want = []string{"[CQ:image,file=https://example.com/a&#44;b.jpg]", media[0]}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("resolveAttachments = %q, want %q", got, want)
+	}
+}
+
+func TestCacheAttachments(t *testing.T) {
+	cfg := setupTestEnv(t)
+	cfg.TaskAttachmentCacheKB = 1
+
+	small := []byte("\x89PNG small image")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/small":
+			if r.URL.Query().Get("rkey") != "x" {
+				http.Error(w, "bad rkey", http.StatusForbidden)
+				return
+			}
+			w.Write(small)
+		case "/large":
+			w.Write([]byte(strings.Repeat("x", 2048)))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	attachments := []string{
+		"[CQ:image,file=abc.image,url=" + escapeCQParam(server.URL+"/small?appid=1&rkey=x") + "]",
+		"[CQ:image,file=" + server.URL + "/large]",
+		"[CQ:image,file=" + server.URL + "/missing]",
+		"[CQ:file,file=" + server.URL + "/small,name=a.pdf]",
+		"[CQ:image,file=abc.image]",
+	}
+	got := cacheAttachments(attachments)
+	want := append([]string{"[CQ:image,file=base64://" + base64.StdEncoding.EncodeToString(small) + "]"}, attachments[1:]...)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("cacheAttachments = %q, want %q", got, want)
+	}
+
+	cfg.TaskAttachmentCacheKB = 0
+	if got := cacheAttachments(attachments); !reflect.DeepEqual(got, attachments) {
+		t.Errorf("cache disabled: got %q, want unchanged", got)
+	}
+}
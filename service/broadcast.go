@@ -0,0 +1,115 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"gin-bot/config"
+	"gin-bot/database"
+	"gin-bot/models"
+)
+
+// broadcastConfirmWindow 广播预览后等待确认的有效期
+const broadcastConfirmWindow = 5 * time.Minute
+
+// pendingBroadcast 等待确认的广播；Groups 为预览时列出的群，确认后只发送到这些群
+type pendingBroadcast struct {
+	Content   string
+	Groups    []int64
+	Turn      uint64 // 预览所在的对话轮次，确认必须来自之后的用户消息
+	CreatedAt time.Time
+}
+
+var (
+	pendingBroadcasts   = make(map[int64]pendingBroadcast) // 发起人 QQ -> 待确认的广播
+	pendingBroadcastsMu sync.Mutex
+)
+
+// ActiveGroupIDs 返回机器人处于开启状态的群：groups 表中开启的群，
+// 以及有聊天记录但从未设置过开关的群（仅当 GROUP_DEFAULT_ACTIVE 开启时）；启用群组白名单时只保留名单内的群
+func ActiveGroupIDs() ([]int64, error) {
+	var ids []int64
+	err := database.DB.Model(&models.Group{}).Where("is_active = ?", true).Pluck("group_id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+	if !defaultGroupSwitches().Active {
+		return filterAllowedGroups(ids), nil
+	}
+
+	var implicit []int64
+	err = database.DB.Model(&models.ChatHistory{}).
+		Where("group_id <> 0 AND group_id NOT IN (?)", database.DB.Model(&models.Group{}).Select("group_id")).
+		Distinct().
+		Pluck("group_id", &implicit).Error
+	if err != nil {
+		return nil, err
+	}
+	return filterAllowedGroups(append(ids, implicit...)), nil
+}
+
+// PrepareBroadcast 记录待确认的广播内容，返回将要发送的群（可读名称）
+func PrepareBroadcast(userID int64, content string) ([]string, error) {
+	ids, err := ActiveGroupIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[int64]models.Group)
+	var groups []models.Group
+	database.DB.Where("group_id IN ?", ids).Find(&groups)
+	for _, g := range groups {
+		known[g.GroupID] = g
+	}
+	labels := make([]string, len(ids))
+	for i, id := range ids {
+		group, ok := known[id]
+		if !ok {
+			group = models.Group{GroupID: id}
+		}
+		labels[i] = GroupLabel(group)
+	}
+
+	pendingBroadcastsMu.Lock()
+	pendingBroadcasts[userID] = pendingBroadcast{Content: content, Groups: ids, Turn: currentUserTurn(userID), CreatedAt: Now()}
+	pendingBroadcastsMu.Unlock()
+	return labels, nil
+}
+
+// ConfirmBroadcast 确认并在后台把该用户最近一次预览的广播发送到预览时列出的群，发送间隔按配置限流，
+// 结束后向发起人所在会话（reportGroupID 为 0 时私聊）汇报送达的群数量。
+// 与预览处于同一轮对话的确认会被拒绝（预览保留），防止模型在用户确认前连续调用直接发送
+func ConfirmBroadcast(userID int64, reportGroupID int64) (int, error) {
+	pendingBroadcastsMu.Lock()
+	pending, ok := pendingBroadcasts[userID]
+	if ok && pending.Turn == currentUserTurn(userID) {
+		pendingBroadcastsMu.Unlock()
+		return 0, fmt.Errorf("广播尚未得到用户确认：请先把预览告诉用户，等用户回复确认后再发送")
+	}
+	delete(pendingBroadcasts, userID)
+	pendingBroadcastsMu.Unlock()
+
+	if !ok || Since(pending.CreatedAt) > broadcastConfirmWindow {
+		return 0, fmt.Errorf("没有待确认的广播（或已超过 %d 分钟），请重新发起", int(broadcastConfirmWindow.Minutes()))
+	}
+	if GlobalSender == nil {
+		return 0, fmt.Errorf("消息发送器未初始化")
+	}
+
+	groups := pending.Groups
+	go func() {
+		sent := 0
+		for i, gid := range groups {
+			if i > 0 {
+				time.Sleep(config.Get().BroadcastInterval)
+			}
+			GlobalSender(gid, 0, pending.Content)
+			sent++
+		}
+		log.Printf("[Broadcast] User %d broadcast to %d groups", userID, sent)
+		GlobalSender(reportGroupID, userID, fmt.Sprintf("广播完成，共发送到 %d 个群", sent))
+	}()
+	return len(groups), nil
+}
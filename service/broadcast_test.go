@@ -0,0 +1,74 @@
+package service
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConfirmBroadcastRequiresLaterTurn(t *testing.T) {
+	setupTestEnv(t)
+	useMockClock(t, time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC))
+
+	const userID = 10001
+	var mu sync.Mutex
+	var sentTo []int64
+	done := make(chan struct{})
+	prevSender := GlobalSender
+	GlobalSender = func(groupID int64, uid int64, content string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if uid == userID {
+			close(done) // 汇报消息，广播已发完
+			return
+		}
+		sentTo = append(sentTo, groupID)
+	}
+	t.Cleanup(func() {
+		GlobalSender = prevSender
+		pendingBroadcastsMu.Lock()
+		delete(pendingBroadcasts, userID)
+		pendingBroadcastsMu.Unlock()
+	})
+
+	// 预览与确认在同一轮对话：拒绝，且预览保留
+	beginUserTurn(userID)
+	pendingBroadcastsMu.Lock()
+	pendingBroadcasts[userID] = pendingBroadcast{Content: "今晚维护", Groups: []int64{111, 222}, Turn: currentUserTurn(userID), CreatedAt: Now()}
+	pendingBroadcastsMu.Unlock()
+
+	if _, err := ConfirmBroadcast(userID, 0); err == nil {
+		t.Fatal("confirm in the preview turn should be rejected")
+	}
+	pendingBroadcastsMu.Lock()
+	_, kept := pendingBroadcasts[userID]
+	pendingBroadcastsMu.Unlock()
+	if !kept {
+		t.Fatal("rejected confirm should keep the pending broadcast")
+	}
+
+	// 用户下一条消息中确认：发送到预览时列出的群
+	beginUserTurn(userID)
+	count, err := ConfirmBroadcast(userID, 0)
+	if err != nil {
+		t.Fatalf("confirm in a later turn: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("count = %d, want 2", count)
+	}
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("broadcast did not finish")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Equal(sentTo, []int64{111, 222}) {
+		t.Errorf("sent to %v, want the previewed groups [111 222]", sentTo)
+	}
+
+	if _, err := ConfirmBroadcast(userID, 0); err == nil {
+		t.Error("second confirm should find no pending broadcast")
+	}
+}
@@ -0,0 +1,279 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gin-bot/config"
+	"gin-bot/database"
+)
+
+// 主动关怀的确认模式（CARE_CONFIRM_MODE）
+const (
+	CareConfirmAuto   = "auto"   // 直接安排随访（默认）
+	CareConfirmAsk    = "ask"    // 先询问用户，用户同意后才安排
+	CareConfirmReview = "review" // 记录待审核，超级用户批准后才安排
+)
+
+// careFollowUpDelay 原话没有提到时间时，随访距离触发消息的时间
+const careFollowUpDelay = 4 * time.Hour
+
+const (
+	careAckKeyPrefix  = "care:pending:ack:"   // 等待用户确认的随访（按群+用户，带 TTL）
+	careReviewKey     = "care:pending:review" // 等待超级用户审核的随访（Hash：任务 ID -> 任务 JSON）
+	careLastKeyPrefix = "care:last:"          // 用户最近一次触发随访的标记（TTL 为冷却时间）
+)
+
+// 用户对"晚点再问问你"的回应
+var (
+	careAckYesWords = []string{"好", "好的", "好啊", "好呀", "行", "可以", "嗯", "嗯嗯", "要", "ok", "👌"}
+	careAckNoWords  = []string{"不用", "不用了", "算了", "别", "不要", "不必", "no"}
+)
+
+// careAckWindow 询问发出后多久内，未引用任何消息的"好""嗯"也视为对询问的回应；
+// 超过这个时间只认引用了询问消息的回应，避免把回答别的话题的"嗯"当作同意随访
+const careAckWindow = 2 * time.Minute
+
+// pendingCareAck 等待用户回应的随访询问
+type pendingCareAck struct {
+	Task    ScheduledTask `json:"task"`
+	Prompt  string        `json:"prompt"`   // 发给用户的询问，用于识别引用
+	AskedAt int64         `json:"asked_at"` // 询问发出的时间戳
+}
+
+// careTaskSeq 随访任务 ID 的进程内序号
+var careTaskSeq atomic.Uint64
+
+// careTaskID 随访任务的 ID（以 proactive_ 开头供调度器识别）：同一秒内可能为多个用户安排随访，
+// 只用时间戳会撞 ID 而互相覆盖，因此带上用户 QQ 和序号
+func careTaskID(userID int64) string {
+	return fmt.Sprintf("proactive_%d_%d_%d", Now().Unix(), userID, careTaskSeq.Add(1))
+}
+
+// RequestCareFollowUp 分类器判定需要主动关怀时调用，按确认模式安排随访、询问用户或提交审核
+func RequestCareFollowUp(groupID int64, userID int64, reason string, content string) {
+	if !claimCareCooldown(userID) {
+		log.Printf("[Proactive] User %d is in care cooldown, skipping follow-up", userID)
+		return
+	}
+
+	// 按原话中提到的时间安排随访（如"下午面试"在面试结束后问），没提时间则按默认延迟
+	event, followUp := planCareFollowUp(content, Now().In(botLocation()))
+	task := ScheduledTask{
+		ID:       careTaskID(userID),
+		Type:     "once",
+		Content:  reason + "|" + content, // 传入原因和原始消息
+		GroupID:  groupID,
+		UserID:   userID,
+		TargetAt: followUp.Unix(),
+	}
+	if !event.IsZero() {
+		task.EventAt = event.Unix()
+	}
+
+	switch config.Get().CareConfirmMode {
+	case CareConfirmAsk:
+		prompt := renderCareConfirm(task.Content)
+		ack := pendingCareAck{Task: task, Prompt: prompt, AskedAt: Now().Unix()}
+		if err := savePendingCareAck(careAckKey(groupID, userID), ack, config.Get().CareConfirmTimeout); err != nil {
+			log.Printf("[Proactive] Failed to save pending follow-up: %v", err)
+			return
+		}
+		if GlobalSender != nil {
+			GlobalSender(groupID, userID, prompt)
+		}
+		log.Printf("[Proactive] Asked user %d to confirm follow-up %s", userID, task.ID)
+	case CareConfirmReview:
+		if err := savePendingReview(task); err != nil {
+			log.Printf("[Proactive] Failed to save follow-up for review: %v", err)
+			return
+		}
+		log.Printf("[Proactive] Follow-up %s awaiting review (group %d, user %d): %s", task.ID, groupID, userID, reason)
+	default:
+		if err := AddTask(task); err != nil && !errors.Is(err, ErrTaskNotPersisted) {
+			log.Printf("[Proactive] Failed to add follow-up task: %v", err)
+		}
+	}
+}
+
+// IsCareAckText 消息（去掉引用和 @ 后）是否是对随访询问的"好"/"不用"之类的回应
+func IsCareAckText(content string) bool {
+	text := careAckText(content)
+	return slices.Contains(careAckYesWords, text) || slices.Contains(careAckNoWords, text)
+}
+
+// careAckText 去掉引用和 @ 后的小写消息文本
+func careAckText(content string) string {
+	return strings.ToLower(strings.TrimSpace(feedbackStripRegex.ReplaceAllString(content, "")))
+}
+
+// HandleCareAck 处理用户对随访询问的回应：同意则安排随访，拒绝则取消。
+// quotedText 为消息引用的那条消息的纯文本（未引用时为空）：引用了询问消息，或未引用任何消息且在 careAckWindow 内，
+// 才当作回应；其余消息（如引用别的消息回"嗯"、隔了很久的"好"）交给正常流程处理。
+// 返回给用户的回复，以及该消息是否已被当作回应处理
+func HandleCareAck(groupID int64, userID int64, content string, quotedText string) (string, bool) {
+	if config.Get().CareConfirmMode != CareConfirmAsk || database.Redis() == nil || !IsCareAckText(content) {
+		return "", false
+	}
+	yes := slices.Contains(careAckYesWords, careAckText(content))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	key := careAckKey(groupID, userID)
+	data, err := database.Redis().Get(ctx, key).Result()
+	if err != nil {
+		return "", false
+	}
+	var ack pendingCareAck
+	if err := json.Unmarshal([]byte(data), &ack); err != nil || ack.Task.ID == "" {
+		log.Printf("[Proactive] Failed to unmarshal pending follow-up: %v", err)
+		return "", false
+	}
+	if !isCareAckFor(ack, strings.Contains(content, "[CQ:reply,"), quotedText) {
+		return "", false
+	}
+	// 并发的两条回应只处理先删掉询问的那条
+	if n, err := database.Redis().Del(ctx, key).Result(); err != nil || n == 0 {
+		return "", false
+	}
+	if !yes {
+		return "好，那就不打扰啦～", true
+	}
+
+	task := ack.Task
+	if err := AddTask(task); err != nil && !errors.Is(err, ErrTaskNotPersisted) {
+		log.Printf("[Proactive] Failed to add follow-up task: %v", err)
+		return "", false
+	}
+	return "好嘞，晚点来问你～", true
+}
+
+// ListPendingCare 待审核的随访，已过随访时间的会被顺便清理
+func ListPendingCare() ([]ScheduledTask, error) {
+	if database.Redis() == nil {
+		return nil, fmt.Errorf("redis not connected")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	all, err := database.Redis().HGetAll(ctx, careReviewKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	now := Now().Unix()
+	tasks := make([]ScheduledTask, 0, len(all))
+	for id, data := range all {
+		var t ScheduledTask
+		if err := json.Unmarshal([]byte(data), &t); err != nil || t.TargetAt <= now {
+			database.Redis().HDel(ctx, careReviewKey, id)
+			continue
+		}
+		tasks = append(tasks, t)
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].TargetAt < tasks[j].TargetAt })
+	return tasks, nil
+}
+
+// ReviewPendingCare 批准（安排随访）或拒绝一条待审核的随访
+func ReviewPendingCare(id string, approve bool) (ScheduledTask, error) {
+	var task ScheduledTask
+	if database.Redis() == nil {
+		return task, fmt.Errorf("redis not connected")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	data, err := database.Redis().HGet(ctx, careReviewKey, id).Result()
+	if err != nil {
+		return task, fmt.Errorf("pending follow-up %s not found", id)
+	}
+	database.Redis().HDel(ctx, careReviewKey, id)
+
+	if err := json.Unmarshal([]byte(data), &task); err != nil {
+		return task, err
+	}
+	if !approve {
+		return task, nil
+	}
+	if task.TargetAt <= Now().Unix() {
+		return task, fmt.Errorf("follow-up %s has expired", id)
+	}
+	return task, AddTask(task)
+}
+
+// claimCareCooldown 检查并占用用户的随访冷却：冷却期内已安排过随访时返回 false
+// 冷却为 0 或 Redis 不可用时不限制
+func claimCareCooldown(userID int64) bool {
+	window := config.Get().CareUserCooldown
+	if window <= 0 || database.Redis() == nil {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	ok, err := database.Redis().SetNX(ctx, fmt.Sprintf("%s%d", careLastKeyPrefix, userID), Now().Unix(), window).Result()
+	if err != nil {
+		log.Printf("[Proactive] Failed to check care cooldown: %v", err)
+		return true
+	}
+	return ok
+}
+
+// careAckKey 等待用户确认的随访 Key
+func careAckKey(groupID int64, userID int64) string {
+	return fmt.Sprintf("%s%d:%d", careAckKeyPrefix, groupID, userID)
+}
+
+// isCareAckFor 判断一条"好"/"不用"是否是对该询问的回应：引用消息时须引用询问本身，
+// 未引用时须在询问发出后 careAckWindow 内
+func isCareAckFor(ack pendingCareAck, quoting bool, quotedText string) bool {
+	if quoting {
+		prompt := strings.TrimSpace(careCQRegex.ReplaceAllString(ack.Prompt, ""))
+		return prompt != "" && strings.Contains(quotedText, prompt)
+	}
+	return Since(time.Unix(ack.AskedAt, 0)) <= careAckWindow
+}
+
+// careCQRegex 询问文本中的 CQ 码（引用消息的纯文本里不含 CQ 码，比较前去掉）
+var careCQRegex = regexp.MustCompile(`\[CQ:[^\]]+\]`)
+
+// savePendingCareAck 保存等待用户回应的随访询问（带 TTL）
+func savePendingCareAck(key string, ack pendingCareAck, ttl time.Duration) error {
+	if database.Redis() == nil {
+		return fmt.Errorf("redis not connected")
+	}
+	data, err := json.Marshal(ack)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return database.Redis().Set(ctx, key, string(data), ttl).Err()
+}
+
+// savePendingReview 把待审核的随访写入审核 Hash
+func savePendingReview(task ScheduledTask) error {
+	if database.Redis() == nil {
+		return fmt.Errorf("redis not connected")
+	}
+	data, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return database.Redis().HSet(ctx, careReviewKey, task.ID, string(data)).Err()
+}
+
+// renderCareConfirm 用关怀任务内容填充询问模板，占位符同关怀模板
+func renderCareConfirm(taskContent string) string {
+	reason, origMsg := parseCareTask(taskContent)
+	return strings.NewReplacer("{reason}", reason, "{content}", origMsg).Replace(config.Get().CareConfirmTemplate)
+}
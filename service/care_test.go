@@ -0,0 +1,74 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCareTaskIDUnique(t *testing.T) {
+	cfg := setupTestEnv(t)
+	useMockClock(t, time.Date(2024, 5, 1, 15, 0, 0, 0, cfg.Location)) // 时间冻结，同一秒内安排多个随访
+
+	seen := make(map[string]bool)
+	for _, userID := range []int64{10001, 10001, 20002, 20002} {
+		id := careTaskID(userID)
+		if !strings.HasPrefix(id, "proactive_") {
+			t.Errorf("care task ID %q lacks the proactive_ prefix", id)
+		}
+		if seen[id] {
+			t.Errorf("duplicate care task ID %q", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestIsCareAckFor(t *testing.T) {
+	cfg := setupTestEnv(t)
+	asked := time.Date(2024, 5, 1, 15, 0, 0, 0, cfg.Location)
+	clk := useMockClock(t, asked)
+
+	ack := pendingCareAck{
+		Task:    ScheduledTask{ID: careTaskID(10001)},
+		Prompt:  "晚点我再来问问你面试怎么样？（回复「好」或「不用」）",
+		AskedAt: asked.Unix(),
+	}
+	quotedPrompt := " 晚点我再来问问你面试怎么样？（回复「好」或「不用」）" // 群聊中询问前带 @，纯文本只剩空格
+
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		quoting bool
+		quoted  string
+		want    bool
+	}{
+		{"right after the ask", 30 * time.Second, false, "", true},
+		{"long after the ask", 10 * time.Minute, false, "", false},
+		{"quoting the ask", 10 * time.Minute, true, quotedPrompt, true},
+		{"quoting another message", 30 * time.Second, true, "周末去爬山吗", false},
+		{"quoted text unavailable", 30 * time.Second, true, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk.Set(asked.Add(tt.elapsed))
+			if got := isCareAckFor(ack, tt.quoting, tt.quoted); got != tt.want {
+				t.Errorf("isCareAckFor = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsCareAckText(t *testing.T) {
+	for text, want := range map[string]bool{
+		"嗯":                  true,
+		" OK ":               true,
+		"[CQ:reply,id=42]不用": true,
+		"[CQ:at,qq=123] 好的":  true,
+		"好的我明天去":             false,
+		"今天天气不错":             false,
+	} {
+		if got := IsCareAckText(text); got != want {
+			t.Errorf("IsCareAckText(%q) = %v, want %v", text, got, want)
+		}
+	}
+}
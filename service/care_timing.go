@@ -0,0 +1,250 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	careAfterEvent   = time.Hour        // 事件开始后多久去问（估计事情已经结束）
+	careMinDelay     = 30 * time.Minute // 随访距离现在的最短时间
+	careMaxDelay     = 72 * time.Hour   // 随访距离现在的最长时间，超出则按默认延迟
+	careQuietHourEnd = 8                // 早于该时刻的随访顺延到 careMorningHour
+	careMorningHour  = 9
+)
+
+// careDayWords 日期词对应的天数偏移
+var careDayWords = []struct {
+	word   string
+	offset int
+}{
+	{"大后天", 3}, {"后天", 2}, {"明天", 1}, {"明儿", 1}, {"明早", 1}, {"明晚", 1}, {"今天", 0}, {"今儿", 0}, {"今晚", 0},
+}
+
+// carePeriodWords 时段词及其默认时刻（未给出具体钟点时使用），pm 表示该时段的钟点需加 12
+var carePeriodWords = []struct {
+	word string
+	hour int
+	pm   bool
+}{
+	{"凌晨", 3, false}, {"早上", 9, false}, {"早晨", 9, false}, {"明早", 9, false}, {"上午", 10, false},
+	{"中午", 12, false}, {"下午", 15, true}, {"傍晚", 18, true}, {"晚上", 20, true}, {"今晚", 20, true},
+	{"明晚", 20, true}, {"夜里", 22, true},
+}
+
+var (
+	// careClockRegex 具体钟点，如 "3点""15:30""三点半"
+	careClockRegex = regexp.MustCompile(`([0-9]{1,2}|[一二两三四五六七八九十]{1,3})\s*(?:点|:|：)\s*(半|[0-9]{1,2})?`)
+	// careAfterRegex 相对时间，如 "2小时后""半小时后""一个半小时以后""30分钟后"
+	careAfterRegex = regexp.MustCompile(`(半|[0-9]+|[一二两三四五六七八九十]{1,3})\s*个?\s*(半)?\s*(小时|钟头|分钟)\s*(?:后|以后|之后)`)
+)
+
+// parseChineseNumber 解析 0-99 的阿拉伯数字或中文数字
+func parseChineseNumber(s string) (int, bool) {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n, true
+	}
+	digits := map[rune]int{'一': 1, '二': 2, '两': 2, '三': 3, '四': 4, '五': 5, '六': 6, '七': 7, '八': 8, '九': 9}
+	runes := []rune(s)
+	switch {
+	case len(runes) == 1 && runes[0] == '十':
+		return 10, true
+	case len(runes) == 1:
+		n, ok := digits[runes[0]]
+		return n, ok
+	case len(runes) == 2 && runes[0] == '十':
+		n, ok := digits[runes[1]]
+		return 10 + n, ok
+	case len(runes) == 2 && runes[1] == '十':
+		n, ok := digits[runes[0]]
+		return n * 10, ok
+	case len(runes) == 3 && runes[1] == '十':
+		tens, ok1 := digits[runes[0]]
+		ones, ok2 := digits[runes[2]]
+		return tens*10 + ones, ok1 && ok2
+	}
+	return 0, false
+}
+
+// parseCareEventTime 从原话中解析所提事件的大致时间（如"下午面试""明天 9 点开会""两小时后考试"），
+// 解析不出时返回 false
+func parseCareEventTime(msg string, now time.Time) (time.Time, bool) {
+	if m := careAfterRegex.FindStringSubmatch(msg); m != nil {
+		var d time.Duration
+		unit := m[3]
+		if m[1] == "半" {
+			d = 30 * time.Minute
+			if unit == "分钟" {
+				return time.Time{}, false
+			}
+		} else if n, ok := parseChineseNumber(m[1]); ok {
+			d = time.Duration(n) * time.Minute
+			if unit != "分钟" {
+				d = time.Duration(n) * time.Hour
+				if m[2] == "半" {
+					d += 30 * time.Minute // "一个半小时"
+				}
+			}
+		}
+		if d > 0 {
+			return now.Add(d), true
+		}
+	}
+
+	dayOffset, hasDay := 0, false
+	for _, w := range careDayWords {
+		if strings.Contains(msg, w.word) {
+			dayOffset, hasDay = w.offset, true
+			break
+		}
+	}
+
+	period := -1
+	for i, p := range carePeriodWords {
+		if strings.Contains(msg, p.word) {
+			period = i
+			break
+		}
+	}
+	hasPeriod := period >= 0
+
+	hour, minute, hasClock := 0, 0, false
+	if m := findCareClock(msg); m != nil {
+		// "快一点""好一点"之类：中文数字加"点"且没有日期、时段或分钟时不当作钟点
+		_, digitErr := strconv.Atoi(m[1])
+		plausible := digitErr == nil || m[2] != "" || hasDay || hasPeriod
+		if h, ok := parseChineseNumber(m[1]); ok && h <= 24 && plausible {
+			hour, hasClock = h%24, true
+			switch m[2] {
+			case "":
+			case "半":
+				minute = 30
+			default:
+				if mm, err := strconv.Atoi(m[2]); err == nil && mm < 60 {
+					minute = mm
+				}
+			}
+		}
+	}
+
+	if hasPeriod {
+		p := carePeriodWords[period]
+		if !hasClock {
+			hour = p.hour
+		} else if p.pm && hour < 12 {
+			hour += 12
+		}
+	}
+
+	if !hasDay && !hasClock && !hasPeriod {
+		return time.Time{}, false
+	}
+	if !hasClock && !hasPeriod {
+		// 只有日期（如"明天面试"）：按上午估计
+		hour = 10
+	}
+	event := time.Date(now.Year(), now.Month(), now.Day()+dayOffset, hour, minute, 0, 0, now.Location())
+	if hasDay {
+		return event, true
+	}
+	// 没说日期时取最近一个还没过去太久的时刻：今天的钟点；只说上午的钟点时再试今晚（如下午说"8点开会"）；
+	// 都已过去很久则是明天的这个钟点（如深夜说"8点开会"指明早）
+	candidates := []time.Time{event}
+	if hasClock && !hasPeriod && hour < 12 {
+		candidates = append(candidates, event.Add(12*time.Hour))
+	}
+	for _, c := range candidates {
+		if !c.Before(now.Add(-2 * time.Hour)) {
+			return c, true
+		}
+	}
+	return event.AddDate(0, 0, 1), true
+}
+
+// findCareClock 查找原话中的钟点，跳过"第3点"这样的序数（列举要点而不是时间）
+func findCareClock(msg string) []string {
+	for _, loc := range careClockRegex.FindAllStringSubmatchIndex(msg, -1) {
+		if strings.HasSuffix(strings.TrimSpace(msg[:loc[0]]), "第") {
+			continue
+		}
+		m := make([]string, len(loc)/2)
+		for i := range m {
+			if loc[2*i] >= 0 {
+				m[i] = msg[loc[2*i]:loc[2*i+1]]
+			}
+		}
+		return m
+	}
+	return nil
+}
+
+// planCareFollowUp 根据原话中的时间安排随访：事件开始约一小时后去问，解析不出时间则按默认延迟；
+// 随访不早于 careMinDelay、不晚于 careMaxDelay，落在深夜的顺延到早上。返回事件时间（未知为零值）与随访时间
+func planCareFollowUp(msg string, now time.Time) (time.Time, time.Time) {
+	event, ok := parseCareEventTime(msg, now)
+	var followUp time.Time
+	switch {
+	case !ok:
+		followUp = now.Add(careFollowUpDelay)
+	case event.Add(careAfterEvent).Sub(now) > careMaxDelay:
+		followUp = now.Add(careFollowUpDelay)
+	default:
+		followUp = event.Add(careAfterEvent)
+		if followUp.Before(now.Add(careMinDelay)) {
+			followUp = now.Add(careMinDelay)
+		}
+	}
+	// 默认延迟同样可能落在深夜（如晚上十点说的话），一并顺延
+	if followUp.Hour() < careQuietHourEnd {
+		followUp = time.Date(followUp.Year(), followUp.Month(), followUp.Day(), careMorningHour, 0, 0, 0, followUp.Location())
+	}
+	return event, followUp
+}
+
+// describeCareEventTime 用自然的说法描述事件时间与现在的关系，供生成关怀消息
+func describeCareEventTime(eventAt int64, now time.Time) string {
+	if eventAt == 0 {
+		return "未提及具体时间（大约是几个小时前说的）"
+	}
+	event := time.Unix(eventAt, 0).In(now.Location())
+	when := event.Format("15:04")
+	switch days := dayDiff(event, now); days {
+	case 0:
+		when = "今天 " + when
+	case -1:
+		when = "昨天 " + when
+	case 1:
+		when = "明天 " + when
+	default:
+		when = event.Format("01-02 15:04")
+	}
+	if event.After(now) {
+		return fmt.Sprintf("%s（还没开始，还有约 %s）", when, formatDuration(event.Sub(now)))
+	}
+	return fmt.Sprintf("%s（已经过去约 %s，应该已经结束或正在进行）", when, formatDuration(now.Sub(event)))
+}
+
+// dayDiff a 比 b 早/晚几个自然日（按 b 的时区）
+func dayDiff(a, b time.Time) int {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	da := time.Date(ay, am, ad, 0, 0, 0, 0, time.UTC)
+	db := time.Date(by, bm, bd, 0, 0, 0, 0, time.UTC)
+	return int(da.Sub(db).Hours() / 24)
+}
+
+// formatDuration 将时长格式化为"X 小时 Y 分钟"
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	h, m := int(d.Hours()), int(d.Minutes())%60
+	switch {
+	case h == 0:
+		return fmt.Sprintf("%d 分钟", m)
+	case m == 0:
+		return fmt.Sprintf("%d 小时", h)
+	}
+	return fmt.Sprintf("%d 小时 %d 分钟", h, m)
+}
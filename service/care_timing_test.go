@@ -0,0 +1,83 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCareEventTime(t *testing.T) {
+	loc := time.FixedZone("CST", 8*3600)
+	at := func(day, hour, minute int) time.Time { return time.Date(2024, 5, day, hour, minute, 0, 0, loc) }
+	afternoon := at(1, 15, 0) // 2024-05-01 星期三 15:00
+	lateNight := at(1, 23, 30)
+
+	tests := []struct {
+		name   string
+		msg    string
+		now    time.Time
+		want   time.Time
+		wantOK bool
+	}{
+		{"morning clock said in the afternoon means evening", "8点开会", afternoon, at(1, 20, 0), true},
+		{"morning clock said late at night means tomorrow morning", "8点开会", lateNight, at(2, 8, 0), true},
+		{"upcoming clock today", "5点面试", afternoon, at(1, 17, 0), true},
+		{"24h clock", "17:30 有个会", afternoon, at(1, 17, 30), true},
+		{"half hour", "四点半去医院", afternoon, at(1, 16, 30), true},
+		{"tomorrow evening", "明晚有个饭局", afternoon, at(2, 20, 0), true},
+		{"tomorrow evening with clock", "明晚8点看演唱会", afternoon, at(2, 20, 0), true},
+		{"afternoon period with clock", "下午3点考试", at(1, 10, 0), at(1, 15, 0), true},
+		{"date only", "明天面试", afternoon, at(2, 10, 0), true},
+		{"relative hours", "两小时后考试", afternoon, at(1, 17, 0), true},
+		{"relative hours with measure word", "一个小时以后出发", afternoon, at(1, 16, 0), true},
+		{"relative minutes", "30分钟后开会", afternoon, at(1, 15, 30), true},
+		{"relative half hour", "半小时后到", afternoon, at(1, 15, 30), true},
+		{"relative hour and a half", "一个半小时后考试", afternoon, at(1, 16, 30), true},
+		{"degree word is not a clock", "好一点了", afternoon, time.Time{}, false},
+		{"faster is not a clock", "快一点吧", afternoon, time.Time{}, false},
+		{"ordinal point is not a clock", "第3点我不同意", afternoon, time.Time{}, false},
+		{"ordinal chinese point is not a clock", "第三点要注意", afternoon, time.Time{}, false},
+		{"no time", "最近压力好大", afternoon, time.Time{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseCareEventTime(tt.msg, tt.now)
+			if ok != tt.wantOK || !got.Equal(tt.want) {
+				t.Errorf("parseCareEventTime(%q) at %s = %s, %v; want %s, %v",
+					tt.msg, tt.now.Format("01-02 15:04"), got.Format("01-02 15:04"), ok, tt.want.Format("01-02 15:04"), tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestPlanCareFollowUp(t *testing.T) {
+	loc := time.FixedZone("CST", 8*3600)
+	at := func(day, hour, minute int) time.Time { return time.Date(2024, 5, day, hour, minute, 0, 0, loc) }
+
+	tests := []struct {
+		name      string
+		msg       string
+		now       time.Time
+		wantEvent time.Time
+		wantAt    time.Time
+	}{
+		{"an hour after the event", "8点开会", at(1, 15, 0), at(1, 20, 0), at(1, 21, 0)},
+		{"night event moved to morning", "8点开会", at(1, 23, 30), at(2, 8, 0), at(2, 9, 0)},
+		{"tomorrow evening", "明晚有个饭局", at(1, 15, 0), at(2, 20, 0), at(2, 21, 0)},
+		{"soon event waits the minimum delay", "半小时后到", at(1, 15, 0), at(1, 15, 30), at(1, 16, 30)},
+		{"event just passed waits the minimum delay", "2点开会", at(1, 15, 0), at(1, 14, 0), at(1, 15, 30)},
+		{"relative hours", "两小时后考试", at(1, 15, 0), at(1, 17, 0), at(1, 18, 0)},
+		{"no time uses default delay", "好一点了", at(1, 15, 0), time.Time{}, at(1, 19, 0)},
+		{"default delay at night moved to morning", "最近压力好大", at(1, 22, 0), time.Time{}, at(2, 9, 0)},
+		{"too far uses default delay", "大后天晚上8点答辩", at(1, 10, 0), at(4, 20, 0), at(1, 14, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, followUp := planCareFollowUp(tt.msg, tt.now)
+			if !event.Equal(tt.wantEvent) || !followUp.Equal(tt.wantAt) {
+				t.Errorf("planCareFollowUp(%q) = %s, %s; want %s, %s", tt.msg,
+					event.Format("01-02 15:04"), followUp.Format("01-02 15:04"),
+					tt.wantEvent.Format("01-02 15:04"), tt.wantAt.Format("01-02 15:04"))
+			}
+		})
+	}
+}
@@ -6,9 +6,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
+	"math/rand"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"gin-bot/config"
@@ -27,83 +30,36 @@ type ChatMessage struct {
 
 // GetAIResponse 获取 AI 回复，集成 RAG（带动态变脸与时间感）
 func GetAIResponse(userPrompt string) (string, error) {
-	now := time.Now()
-	bjTime := now.In(time.FixedZone("CST", 8*3600))
-	weekdayMap := map[string]string{
-		"Monday": "一", "Tuesday": "二", "Wednesday": "三", "Thursday": "四", "Friday": "五", "Saturday": "六", "Sunday": "日",
-	}
-	timeInfo := fmt.Sprintf("【北京时间：%s 星期%s】", bjTime.Format("2006-01-02 15:04"), weekdayMap[bjTime.Weekday().String()])
-
-	// 1. RAG 双 namespace 检索
-	contextTexts := []string{}
-	isTechScene := false
-	isPersonalScene := false
-	maxScore := float32(0.0)
-
-	queryVec, err := embedding.GetEmbedding(userPrompt, "query", 1024)
-	if err == nil {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+	timeInfo := timeInfoBlock()
 
-		// 检索个人信息 (NamespacePersonal)
-		pMatches, _ := pinecone.QueryWithScore(ctx, pinecone.NamespacePersonal, queryVec, 3, nil)
-		for _, m := range pMatches {
-			if m.Score > 0.7 {
-				isPersonalScene = true
-			}
-			if m.Score > maxScore {
-				maxScore = m.Score
-			}
-			var res models.MemberEmbedding
-			database.DB.Preload("RefMsg").Where("vector_id = ?", m.ID).First(&res)
-			if res.ContentSummary != "" {
-				relTime := formatRelativeTime(res.RefMsg.CreatedAt)
-				contextTexts = append(contextTexts, fmt.Sprintf("(%s前) %s", relTime, res.ContentSummary))
-			}
-		}
-
-		// 检索聊天记录 (NamespaceChat)
-		cMatches, _ := pinecone.QueryWithScore(ctx, pinecone.NamespaceChat, queryVec, 3, nil)
-		for _, m := range cMatches {
-			if m.Score > maxScore {
-				maxScore = m.Score
-			}
-			var res models.MemberEmbedding
-			database.DB.Preload("RefMsg").Where("vector_id = ?", m.ID).First(&res)
-			if res.ContentSummary != "" {
-				relTime := formatRelativeTime(res.RefMsg.CreatedAt)
-				contextTexts = append(contextTexts, fmt.Sprintf("(%s前) %s", relTime, res.ContentSummary))
-
-				lowContent := strings.ToLower(res.ContentSummary)
-				if strings.Contains(lowContent, "err") || strings.Contains(lowContent, "code") || strings.Contains(lowContent, "api") || strings.Contains(lowContent, "func") {
-					isTechScene = true
-				}
-			}
-		}
-	}
+	// 1. RAG 双 namespace 检索（无用户上下文，仅检索已共享的个人信息；整体限时 5 秒）
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	retrieved := retrieveContext(ctx, userPrompt, 0, 0)
 
 	// 2. 构建基础 Prompt
-	var contextBlock string
-	if len(contextTexts) > 0 {
-		contextBlock = "【脑海中的回忆片段】:\n" + strings.Join(contextTexts, "\n")
-	} else {
-		contextBlock = "【回忆】: (暂时没想起什么特别的)"
-	}
+	systemPrompt := chatSystemPrompt(timeInfo, retrieved.ContextBlock, retrieved.VibePrompt)
 
-	vibePrompt := ""
-	if isTechScene {
-		vibePrompt = "\n**[🔧 技术场景适配]**：现在像一个热心的技术大佬在帮群友排查 Bug 一样，直接指出重点，可以带点技术圈的吐槽，但要保证准确简练。"
-	} else if isPersonalScene {
-		vibePrompt = "\n**[💝 情感场景适配]**：回想起这位老朋友的私事了，用更多的同情和理解来回复。添加一些相关的例子或生活经验，让回复充满温度。"
+	messages := []ChatMessage{
+		{Role: "system", Content: systemPrompt},
+		{Role: "user", Content: userPrompt},
 	}
 
-	if maxScore > 0.85 {
-		vibePrompt += "\n**[⚡ 确定性强化]**：你对这段记忆非常确定，说话更有底气一点。"
-	} else if maxScore > 0.0 && maxScore < 0.6 {
-		vibePrompt += "\n**[❓ 模糊处理]**：记忆有点模糊，回复时可以带一句'我好像记得...'或者'不知道记错没'之类的话。"
+	maxTokens := MaxReplyTokens(0)
+	temperature := sceneTemperature(retrieved.TechScene, retrieved.PersonalScene)
+	reply, err := callNvidiaAPIWithOptions(messages, "mistralai/mixtral-8x7b-instruct-v0.1", maxTokens, temperature)
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(reply) == "" {
+		return emptyReplyFallback("empty content"), nil
 	}
+	return truncateReply(reply, maxTokens), nil
+}
 
-	systemPrompt := fmt.Sprintf(`你是"小黄"，一个混迹在群聊里的资深群友。你真心把群友当朋友，说话自然。
+// chatSystemPrompt GetAIResponse 的系统提示词（人设 + 时间 + 回忆 + 场景提示 + 被直接提问时的追加规则）
+func chatSystemPrompt(timeInfo string, contextBlock string, vibePrompt string) string {
+	return fmt.Sprintf(`你是"%s"，一个混迹在群聊里的资深群友。你真心把群友当朋友，说话自然。
 %s
 
 %s
@@ -119,60 +75,97 @@ func GetAIResponse(userPrompt string) (string, error) {
 1. **像朋友聊天**：用"你""我们"让对话更亲近。如果【回忆】里有相关信息，请利用它们，就像想起老朋友说过的话一样自然带出来。
 2. **绝对不要机械感**：禁止说"根据信息""检索结果""数据显示"这种话！回忆就是你脑子里记住的事。
 3. **富有时间感**：你是个有血有肉的人，如果回忆里显示某事是几小时前发生的，你可以自然地说出这个时间差细节。
-4. **共情优先**：如果用户情绪不好，先关心再给建议。
-`, timeInfo, contextBlock, vibePrompt)
-
-	messages := []ChatMessage{
-		{Role: "system", Content: systemPrompt},
-		{Role: "user", Content: userPrompt},
-	}
-
-	return callNvidiaAPI(messages, "mistralai/mixtral-8x7b-instruct-v0.1")
+4. **共情优先**：如果用户情绪不好，先关心再给建议。%s
+`, config.Get().BotName, timeInfo, contextBlock, vibePrompt, addressedRule(5))
 }
 
-// GetProactiveResponse 主动插嘴判断逻辑
-func GetProactiveResponse(userPrompt string, groupID int64, userID int64) (string, bool) {
-	queryVec, err := embedding.GetEmbedding(userPrompt, "query", 1024)
+// proactiveScoreThreshold 主动插嘴所需的最低记忆相似度
+const proactiveScoreThreshold = 0.88
+
+// findProactiveMemory 为主动插嘴检索最相关的记忆（本人个人信息 + 本群聊天），返回记忆与相似度
+// exclude 非空时跳过原文与之相同的记忆（回放已归档的历史消息时排除消息自身）
+func findProactiveMemory(ctx context.Context, userPrompt string, groupID int64, userID int64, exclude string) (models.MemberEmbedding, float32, error) {
+	var bestMatch models.MemberEmbedding
+	queryVec, err := embedding.GetEmbedding(ctx, userPrompt, "query", config.Get().EmbeddingDim)
 	if err != nil {
-		return "", false
+		return bestMatch, 0, err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
 	maxScore := float32(0.0)
-	var bestMatch models.MemberEmbedding
 
-	// 检索个人信息 (NamespacePersonal) - 强制 ID 隔离
-	pFilter := map[string]interface{}{
-		"user_qq": strconv.FormatInt(userID, 10),
-	}
-	pMatches, _ := pinecone.QueryWithScore(ctx, pinecone.NamespacePersonal, queryVec, 1, pFilter)
-	if len(pMatches) > 0 && pMatches[0].Score > maxScore {
-		maxScore = pMatches[0].Score
-		database.DB.Preload("RefMsg").Where("vector_id = ?", pMatches[0].ID).First(&bestMatch)
+	// 挑选分数最高且未超过最大年龄的记忆，避免插嘴时翻出陈年旧事
+	maxAge := config.Get().ProactiveMaxMemoryAge
+	pickBest := func(matches []pinecone.Match) {
+		for _, m := range matches {
+			if m.Score <= maxScore {
+				continue
+			}
+			var res models.MemberEmbedding
+			database.DB.Preload("RefMsg").Where("vector_id = ?", m.ID).First(&res)
+			if res.ContentSummary == "" {
+				continue
+			}
+			if maxAge > 0 && Since(res.RefMsg.CreatedAt) > maxAge {
+				continue
+			}
+			if exclude != "" && res.RefMsg.Content == exclude {
+				continue
+			}
+			maxScore = m.Score
+			bestMatch = res
+		}
 	}
 
+	// 检索个人信息 (NamespacePersonal) - 本人私有 + 他人共享
+	pFilter := personalFilter(strconv.FormatInt(userID, 10))
+	pMatches, _ := pinecone.QueryWithScore(ctx, pinecone.NamespacePersonal, queryVec, 3, pFilter)
+	pickBest(pMatches)
+
 	chatFilter := map[string]interface{}{"group_id": groupID}
-	cMatches, _ := pinecone.QueryWithScore(ctx, pinecone.NamespaceChat, queryVec, 1, chatFilter)
-	if len(cMatches) > 0 && cMatches[0].Score > maxScore {
-		maxScore = cMatches[0].Score
-		database.DB.Preload("RefMsg").Where("vector_id = ?", cMatches[0].ID).First(&bestMatch)
+	cMatches, _ := pinecone.QueryWithScore(ctx, pinecone.NamespaceChat, queryVec, 3, chatFilter)
+	pickBest(cMatches)
+
+	return bestMatch, maxScore, nil
+}
+
+// GetProactiveResponse 主动插嘴判断逻辑
+func GetProactiveResponse(userPrompt string, groupID int64, userID int64) (string, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	bestMatch, maxScore, err := findProactiveMemory(ctx, userPrompt, groupID, userID, "")
+	if err != nil {
+		return "", false
 	}
 
-	// 阈值判定：分数 > 0.88 才主动插嘴
-	if maxScore < 0.88 {
+	// 阈值判定：分数达到阈值才主动插嘴
+	if maxScore < proactiveScoreThreshold {
 		return "", false
 	}
 
 	relTime := formatRelativeTime(bestMatch.RefMsg.CreatedAt)
 	contextBlock := fmt.Sprintf("【突然想起的事】: (%s前) %s", relTime, bestMatch.ContentSummary)
 
-	now := time.Now()
-	timeInfo := fmt.Sprintf("【北京时间：%s】", now.Format("2006-01-02 15:04"))
+	systemPrompt := proactiveSystemPrompt(groupID, timeInfoBlock(), contextBlock)
 
-	systemPrompt := fmt.Sprintf(`你是"小黄"，一个资深群友。你刚才在偷听大家聊天，突然想起了一件非常相关的事，忍不住想插句嘴。
+	messages := []ChatMessage{
+		{Role: "system", Content: systemPrompt},
+		{Role: "user", Content: userPrompt},
+	}
 
+	maxTokens := MaxReplyTokens(groupID)
+	reply, err := callNvidiaAPIWithLimit(messages, "mistralai/mixtral-8x7b-instruct-v0.1", maxTokens)
+	if err != nil {
+		return "", false
+	}
+
+	return truncateReply(reply, maxTokens), true
+}
+
+// proactiveSystemPrompt 主动插嘴的系统提示词；没有被直接提问，不追加 addressedRule
+func proactiveSystemPrompt(groupID int64, timeInfo string, contextBlock string) string {
+	return fmt.Sprintf(`你是"%s"，一个资深群友。你刚才在偷听大家聊天，突然想起了一件非常相关的事，忍不住想插句嘴。
+%s
 %s
 %s
 
@@ -181,30 +174,103 @@ func GetProactiveResponse(userPrompt string, groupID int64, userID int64) (strin
 2. **相关性极强**：既然你开口了，说明这件事非常有价值。
 3. **简短有力**：插嘴不要太长，点到为止。
 4. **带有时间感**：提到的记忆如果有点久了，可以带上一句"好久之前了"或者"就在刚才"。
-`, timeInfo, contextBlock)
+`, config.Get().BotName, groupPersonaBlock(groupID), timeInfo, contextBlock)
+}
+
+// defaultRandomReplyCooldown 随口接话的默认冷却时间
+const defaultRandomReplyCooldown = 30 * time.Minute
+
+var (
+	randomReplyLast = make(map[int64]time.Time) // groupID -> 上次随口接话时间
+	randomReplyMu   sync.Mutex
+)
+
+// GetRandomReply 按群配置的概率对未被 @ 的消息随口接一句（活跃气氛用）
+// 调用方需自行确认机器人在该群处于开启状态
+func GetRandomReply(userPrompt string, groupID int64) (string, bool) {
+	cfg := GetGroupConfig(groupID)
+	if cfg.RandomReplyProb <= 0 {
+		return "", false
+	}
+	if InQuietHours() {
+		LogDrop("random_reply_quiet_hours", groupID, "")
+		return "", false
+	}
+	cooldown := defaultRandomReplyCooldown
+	if cfg.RandomReplyCooldown > 0 {
+		cooldown = time.Duration(cfg.RandomReplyCooldown) * time.Minute
+	}
+
+	randomReplyMu.Lock()
+	if Since(randomReplyLast[groupID]) < cooldown || rand.Float64() >= cfg.RandomReplyProb {
+		randomReplyMu.Unlock()
+		return "", false
+	}
+	randomReplyLast[groupID] = Now()
+	randomReplyMu.Unlock()
+
+	systemPrompt := randomReplySystemPrompt(groupID)
 
 	messages := []ChatMessage{
 		{Role: "system", Content: systemPrompt},
 		{Role: "user", Content: userPrompt},
 	}
 
-	reply, err := callNvidiaAPI(messages, "mistralai/mixtral-8x7b-instruct-v0.1")
-	if err != nil {
+	maxTokens := MaxReplyTokens(groupID)
+	reply, err := callNvidiaAPIWithLimit(messages, "mistralai/mixtral-8x7b-instruct-v0.1", maxTokens)
+	if err != nil || strings.TrimSpace(reply) == "" {
 		return "", false
 	}
+	return truncateReply(reply, maxTokens), true
+}
+
+// randomReplySystemPrompt 随口接话的系统提示词；没有被直接提问，不追加 addressedRule
+func randomReplySystemPrompt(groupID int64) string {
+	return fmt.Sprintf(`你是"%s"，一个混迹在群聊里的资深群友。你刚好刷到群友的这句话，想随口接一句。%s
 
-	return reply, true
+### 接话原则：
+1. **像路过的群友**：一句话就好，不超过 20 个字，可以吐槽、附和或者抖个机灵。
+2. **不要提问一大串**：不要长篇大论，不要说教。
+3. **不要暴露身份**：不要说"作为 AI"之类的话。`, config.Get().BotName, groupPersonaBlock(groupID))
+}
+
+// defaultAPIMaxTokens 非对话类调用（摘要、人设等）的 max_tokens
+const defaultAPIMaxTokens = 1024
+
+// defaultAPITemperature 未区分场景的调用使用的采样温度
+const defaultAPITemperature = 0.3
+
+// sceneTemperature 根据检测到的场景选择采样温度（技术场景优先于情感场景）
+func sceneTemperature(isTechScene, isPersonalScene bool) float64 {
+	switch {
+	case isTechScene:
+		return config.Get().SceneTempTech
+	case isPersonalScene:
+		return config.Get().SceneTempPersonal
+	default:
+		return config.Get().SceneTempCasual
+	}
 }
 
 func callNvidiaAPI(messages []ChatMessage, model string) (string, error) {
+	return callNvidiaAPIWithLimit(messages, model, defaultAPIMaxTokens)
+}
+
+// callNvidiaAPIWithLimit 同 callNvidiaAPI，指定生成的 max_tokens
+func callNvidiaAPIWithLimit(messages []ChatMessage, model string, maxTokens int) (string, error) {
+	return callNvidiaAPIWithOptions(messages, model, maxTokens, defaultAPITemperature)
+}
+
+// callNvidiaAPIWithOptions 同 callNvidiaAPI，指定生成的 max_tokens 与采样温度
+func callNvidiaAPIWithOptions(messages []ChatMessage, model string, maxTokens int, temperature float64) (string, error) {
 	reqBody := map[string]interface{}{
 		"model":       model,
 		"messages":    messages,
-		"temperature": 0.3,
-		"max_tokens":  1024,
+		"temperature": temperature,
+		"max_tokens":  maxTokens,
 	}
 
-	jsonData, err := json.Marshal(reqBody)
+	jsonData, err := marshalChatRequest(reqBody)
 	if err != nil {
 		return "", err
 	}
@@ -214,17 +280,19 @@ func callNvidiaAPI(messages []ChatMessage, model string) (string, error) {
 		return "", err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+config.Cfg.NvidiaAPIKey)
+	setLLMHeaders(req)
 
-	client := config.GetHTTPClient()
+	client := config.GetLLMHTTPClient()
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", err
+		return "", upstreamRequestError("chat API", err)
 	}
 	defer resp.Body.Close()
 
 	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", upstreamStatusError("chat API", resp.StatusCode, body)
+	}
 	var res struct {
 		Choices []struct {
 			Message struct {
@@ -232,24 +300,62 @@ func callNvidiaAPI(messages []ChatMessage, model string) (string, error) {
 			} `json:"message"`
 		} `json:"choices"`
 	}
-	if err := json.Unmarshal(body, &res); err != nil || len(res.Choices) == 0 {
-		return "", fmt.Errorf("api error: %s", string(body))
+	if err := json.Unmarshal(body, &res); err != nil {
+		return "", invalidOutputError("chat API", err, body)
+	}
+	if len(res.Choices) == 0 {
+		return "", invalidOutputError("chat API", fmt.Errorf("empty choices"), body)
 	}
 
 	return res.Choices[0].Message.Content, nil
 }
 
-// GetProactiveCareReply 生成主动关怀回复
-func GetProactiveCareReply(taskContent string, groupID int64) (string, error) {
+// parseCareTask 解析关怀任务内容（格式："缘由|原话"）
+func parseCareTask(taskContent string) (reason, origMsg string) {
 	parts := strings.Split(taskContent, "|")
-	reason := "随访"
-	origMsg := ""
+	reason = "随访"
 	if len(parts) >= 2 {
 		reason = parts[0]
 		origMsg = parts[1]
 	}
+	return reason, origMsg
+}
+
+// renderCareTemplate 用关怀任务内容填充兜底模板，支持 {reason}（提醒缘由）和 {content}（之前的话）
+// 群组设置了自己的关怀模板（如其他语言）时优先使用
+func renderCareTemplate(taskContent string, groupID int64) string {
+	reason, origMsg := parseCareTask(taskContent)
+	template := config.Get().CareFallbackTemplate
+	if groupID != 0 {
+		if t := GetGroupConfig(groupID).CareTemplate; t != "" {
+			template = t
+		}
+	}
+	return strings.NewReplacer("{reason}", reason, "{content}", origMsg).Replace(template)
+}
+
+// BuildCareMessage 生成主动关怀消息：默认由 LLM 生成，失败或配置关闭 LLM 时使用模板
+// eventAt 为原话所提事件的时间（0 表示未知），用于让措辞贴合事情的进展
+func BuildCareMessage(taskContent string, eventAt int64, groupID int64) string {
+	if config.Get().CareUseLLM {
+		reply, err := GetProactiveCareReply(taskContent, eventAt, groupID)
+		if err == nil && strings.TrimSpace(reply) != "" {
+			return reply
+		}
+		if err == nil {
+			err = fmt.Errorf("empty reply")
+		}
+		log.Printf("[Proactive] Care reply generation failed, using template: %v", err)
+	}
+	return renderCareTemplate(taskContent, groupID)
+}
+
+// GetProactiveCareReply 生成主动关怀回复
+func GetProactiveCareReply(taskContent string, eventAt int64, groupID int64) (string, error) {
+	reason, origMsg := parseCareTask(taskContent)
+	eventTime := describeCareEventTime(eventAt, Now().In(botLocation()))
 
-	systemPrompt := `你是"小黄"，一个像老朋友一样贴心的群友。你刚才在自己的记事本里看到几个小时前某个群友提到了一些事，现在你想主动打个招呼关心一下。
+	systemPrompt := `你是"%s"，一个像老朋友一样贴心的群友。你刚才在自己的记事本里看到几个小时前某个群友提到了一些事，现在你想主动打个招呼关心一下。%s
 
 ### 你的关怀原则：
 1. **极其自然**：不要说"我检测到你提到了..."，要说"诶，刚才看你说..."、"对了，下午那会儿你说...，现在好点没？"。
@@ -260,13 +366,21 @@ func GetProactiveCareReply(taskContent string, groupID int64) (string, error) {
 ### 当前背景：
 - 提醒缘由：%s
 - 之前的话：%s
+- 事情的时间：%s
 
+请根据事情的时间选择说法：已经结束的就问结果（如"面试结束了吧，咋样？"），还没开始的就打气，不要说错时态。
 请生成一段主动关怀的消息，不需要带任何前缀。`
 
-	prompt := fmt.Sprintf(systemPrompt, reason, origMsg)
+	// 与被 @ 时的回复共用群组人设（主题、语言），随访时不会突然换了语气
+	prompt := fmt.Sprintf(systemPrompt, config.Get().BotName, groupPersonaBlock(groupID), reason, origMsg, eventTime)
 	messages := []ChatMessage{
 		{Role: "system", Content: prompt},
 	}
 
-	return callNvidiaAPI(messages, "mistralai/mixtral-8x7b-instruct-v0.1")
+	maxTokens := MaxReplyTokens(groupID)
+	reply, err := callNvidiaAPIWithLimit(messages, "mistralai/mixtral-8x7b-instruct-v0.1", maxTokens)
+	if err != nil {
+		return "", err
+	}
+	return truncateReply(reply, maxTokens), nil
 }
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+
+	"gin-bot/config"
+)
+
+// ClassifierCategory 分类器的一个类别及其定义
+type ClassifierCategory struct {
+	Name       string
+	Definition string
+}
+
+// classifierPromptData 分类器 prompt 模板可用的字段
+type classifierPromptData struct {
+	Categories     []ClassifierCategory
+	ProactiveRules []string
+	Message        string
+}
+
+// defaultClassifierCategories 内置类别定义（类别名称与下游逻辑绑定，只允许改定义）
+var defaultClassifierCategories = []ClassifierCategory{
+	{Name: "personal", Definition: "持久性个人信息（职业、爱好、身份、性格特征）。"},
+	{Name: "temporary", Definition: "临时状态（饿了、去洗澡、在忙、困了、即时情绪）。"},
+	{Name: "chat", Definition: "普通闲聊或讨论话题。"},
+}
+
+// defaultProactiveRules 内置主动关怀触发条件
+var defaultProactiveRules = []string{
+	"强烈的负面情绪（极度焦虑、悲伤、受挫）。",
+	"明确的短期重大计划（明天面试、下午相亲、要去赶飞机）。",
+	"寻求帮助但未明确@机器人。",
+}
+
+// defaultClassifierPromptTemplate 内置分类器 prompt 模板（Go text/template 语法）
+const defaultClassifierPromptTemplate = `你是一个深度社交观察员。分析以下群聊消息并给出分类。
+
+### 分类规则：
+{{range .Categories}}- {{.Name}}: {{.Definition}}
+{{end}}
+### 主动性探测 (Proactive)：
+如果消息包含以下特征，请标记为触发主动关怀：
+{{range $i, $rule := .ProactiveRules}}{{inc $i}}. {{$rule}}
+{{end}}
+### 情绪 (Sentiment)：
+判断消息的情绪倾向：positive（开心、兴奋）、neutral（平淡）、negative（难过、焦虑、生气）。
+
+回复格式必须为："类型|是否触发(true/false)|原因描述|情绪"
+示例："personal|false|普通爱好描述|neutral" 或 "temporary|true|用户表达了极度焦虑|negative"
+
+消息：{{.Message}}`
+
+// classifierTemplateFuncs 模板可用的辅助函数（inc 用于生成从 1 开始的序号）
+var classifierTemplateFuncs = template.FuncMap{"inc": func(i int) int { return i + 1 }}
+
+var defaultClassifierTmpl = template.Must(template.New("classifier").Funcs(classifierTemplateFuncs).Parse(defaultClassifierPromptTemplate))
+
+var (
+	classifierPromptOnce sync.Once
+	classifierPromptTmpl *template.Template
+	classifierCategories []ClassifierCategory
+	classifierRules      []string
+)
+
+// loadClassifierPrompt 加载分类器 prompt 模板、类别定义与触发条件（仅首次分类时加载一次）
+// 自定义模板或配置有误时回退到内置版本
+func loadClassifierPrompt() {
+	classifierPromptTmpl = defaultClassifierTmpl
+	classifierCategories = defaultClassifierCategories
+	classifierRules = defaultProactiveRules
+
+	cfg := config.Get()
+	if cfg == nil {
+		return
+	}
+
+	if path := cfg.ClassifierPromptFile; path != "" {
+		if raw, err := os.ReadFile(path); err != nil {
+			log.Printf("[Classifier] Failed to read prompt file %s: %v", path, err)
+		} else if tmpl, err := template.New("classifier").Funcs(classifierTemplateFuncs).Parse(string(raw)); err != nil {
+			log.Printf("[Classifier] Invalid prompt template %s: %v", path, err)
+		} else {
+			classifierPromptTmpl = tmpl
+		}
+	}
+
+	if len(cfg.ClassifierCategories) > 0 {
+		classifierCategories = mergeClassifierCategories(cfg.ClassifierCategories)
+	}
+	if len(cfg.ClassifierProactiveRules) > 0 {
+		classifierRules = cfg.ClassifierProactiveRules
+	}
+}
+
+// mergeClassifierCategories 用 "名称:定义" 形式的配置覆盖内置类别定义，未知类别会被忽略
+func mergeClassifierCategories(overrides []string) []ClassifierCategory {
+	categories := make([]ClassifierCategory, len(defaultClassifierCategories))
+	copy(categories, defaultClassifierCategories)
+
+	for _, item := range overrides {
+		name, def, ok := strings.Cut(item, ":")
+		name, def = strings.TrimSpace(name), strings.TrimSpace(def)
+		if !ok || def == "" {
+			log.Printf("[Classifier] Ignored malformed category definition %q", item)
+			continue
+		}
+		found := false
+		for i := range categories {
+			if categories[i].Name == name {
+				categories[i].Definition = def
+				found = true
+			}
+		}
+		if !found {
+			log.Printf("[Classifier] Ignored unknown category %q", name)
+		}
+	}
+	return categories
+}
+
+// buildClassifierPrompt 渲染分类器 prompt
+func buildClassifierPrompt(content string) string {
+	classifierPromptOnce.Do(loadClassifierPrompt)
+
+	data := classifierPromptData{
+		Categories:     classifierCategories,
+		ProactiveRules: classifierRules,
+		Message:        content,
+	}
+
+	var buf bytes.Buffer
+	if err := classifierPromptTmpl.Execute(&buf, data); err != nil {
+		log.Printf("[Classifier] Failed to render prompt template: %v", err)
+		buf.Reset()
+		defaultClassifierTmpl.Execute(&buf, data)
+	}
+	return buf.String()
+}
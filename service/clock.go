@@ -0,0 +1,76 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock 时间来源：业务代码统一通过 Now/Since 读取当前时间，测试时可替换为 MockClock 冻结或推进时间
+// 注意仅替换"读时间"，time.Sleep / time.After 等等待操作仍使用真实时间
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock 系统时钟
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// MockClock 手动控制的时钟
+type MockClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewMockClock 创建停在指定时刻的时钟
+func NewMockClock(t time.Time) *MockClock {
+	return &MockClock{now: t}
+}
+
+// Now 返回当前设定的时刻
+func (c *MockClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Set 将时钟设置到指定时刻
+func (c *MockClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
+
+// Advance 将时钟向前推进
+func (c *MockClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+var (
+	clock   Clock = realClock{}
+	clockMu sync.RWMutex
+)
+
+// SetClock 替换全局时钟，传入 nil 恢复系统时钟
+func SetClock(c Clock) {
+	clockMu.Lock()
+	defer clockMu.Unlock()
+	if c == nil {
+		c = realClock{}
+	}
+	clock = c
+}
+
+// Now 当前时间（经由全局时钟）
+func Now() time.Time {
+	clockMu.RLock()
+	defer clockMu.RUnlock()
+	return clock.Now()
+}
+
+// Since 距指定时刻经过的时间（经由全局时钟）
+func Since(t time.Time) time.Duration {
+	return Now().Sub(t)
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"log"
+	"maps"
+	"regexp"
+	"strings"
+
+	"gin-bot/config"
+)
+
+// commandToolRegex 斜杠命令名（允许中文，如 /关机）及命令后的文字
+var commandToolRegex = regexp.MustCompile(`(?s)^/(\S+)\s*(.*)$`)
+
+// MatchCommandTool 判断消息是否为 COMMAND_TOOLS 中配置的斜杠命令，返回命令名
+func MatchCommandTool(content string) (string, bool) {
+	m := commandToolRegex.FindStringSubmatch(strings.TrimSpace(content))
+	if m == nil {
+		return "", false
+	}
+	if _, ok := config.Get().CommandTools[m[1]]; !ok {
+		return "", false
+	}
+	return m[1], true
+}
+
+// RunCommandTool 执行斜杠命令对应的工具并返回要发送的文字：
+// 配置了固定参数时直接执行（不经过模型），否则强制模型调用该工具，由模型从命令后的文字中提取参数
+func RunCommandTool(content string, groupID int64, userID int64, isSuperUser bool) (string, error) {
+	m := commandToolRegex.FindStringSubmatch(strings.TrimSpace(content))
+	if m == nil {
+		return "", nil
+	}
+	name := m[1]
+	cmd := config.Get().CommandTools[name]
+	log.Printf("[Command] /%s → %s by %d in group %d", name, cmd.Tool, userID, groupID)
+
+	tool, ok := findTool(cmd.Tool)
+	if !ok {
+		log.Printf("[Command] /%s is mapped to unknown tool %q", name, cmd.Tool)
+		return "这个命令配置有误，请联系管理员", nil
+	}
+	// 权限与本群工具白名单在调用模型前先检查，避免无谓的请求
+	if tool.RequireAdmin && !isSuperUser {
+		return "抱歉，这个操作只有管理员才能执行哦~", nil
+	}
+	if groupID != 0 && !isSuperUser && !IsToolAllowed(GetGroupConfig(groupID), tool.Name) {
+		return "这个功能在本群没有开放哦~", nil
+	}
+
+	if cmd.Args != nil {
+		result := executeToolWithTimeout(tool.Name, maps.Clone(cmd.Args), groupID, userID, isSuperUser)
+		if result.DirectReply != "" {
+			return result.DirectReply, nil
+		}
+		return result.Message, nil
+	}
+
+	// 命令名本身也带有意图（如 /关机），连同后面的文字一起交给模型填写参数
+	return GetAIResponseForcingTool(m[0], groupID, userID, isSuperUser, tool.Name)
+}
+
+// findTool 按名称查找工具定义
+func findTool(name string) (Tool, bool) {
+	for _, tool := range AvailableTools {
+		if tool.Name == name {
+			return tool, true
+		}
+	}
+	return Tool{}, false
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"gin-bot/config"
+)
+
+var (
+	replySlots   = make(map[int64]chan struct{}) // 群号 -> 正在生成的 AI 回复名额
+	replySlotsMu sync.Mutex
+)
+
+// groupReplySlots 获取群组的回复名额，容量随配置变化时换新（旧名额由持有者释放回旧通道）
+func groupReplySlots(groupID int64, limit int) chan struct{} {
+	replySlotsMu.Lock()
+	defer replySlotsMu.Unlock()
+	slots, ok := replySlots[groupID]
+	if !ok || cap(slots) != limit {
+		slots = make(chan struct{}, limit)
+		replySlots[groupID] = slots
+	}
+	return slots
+}
+
+// AcquireReplySlot 占用群组的 AI 回复名额（同一群同时生成的回复数不超过 AI_MAX_CONCURRENT_PER_GROUP），返回释放函数。
+// 名额已满时先调用 onQueued（如提示"稍等"），再排队等待最多 AI_REPLY_QUEUE_TIMEOUT；超时返回 false。
+// 私聊及未开启限制时不排队
+func AcquireReplySlot(groupID int64, onQueued func()) (func(), bool) {
+	limit := config.Get().MaxConcurrentReplies
+	if groupID == 0 || limit <= 0 {
+		return func() {}, true
+	}
+	slots := groupReplySlots(groupID, limit)
+	release := func() { <-slots }
+
+	select {
+	case slots <- struct{}{}:
+		return release, true
+	default:
+	}
+
+	if onQueued != nil {
+		onQueued()
+	}
+	timer := time.NewTimer(config.Get().ReplyQueueTimeout)
+	defer timer.Stop()
+	select {
+	case slots <- struct{}{}:
+		return release, true
+	case <-timer.C:
+		return nil, false
+	}
+}
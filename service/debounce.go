@@ -0,0 +1,148 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"gin-bot/config"
+)
+
+// debounceMaxFactor 合并等待的总时长上限（窗口的倍数），避免用户一直在发导致迟迟不回复
+const debounceMaxFactor = 4
+
+// debounceMaxFragments 每个用户保留的近期未 @ 片段数
+const debounceMaxFragments = 5
+
+// debounceSweepSize 近期片段表超过该大小时清理过期的条目
+const debounceSweepSize = 1000
+
+// promptFragment 一条未 @ 机器人的近期消息（可能是提问的前半句）
+type promptFragment struct {
+	text string
+	at   time.Time
+}
+
+// pendingPrompt 等待合并的连发提问
+type pendingPrompt struct {
+	parts     []string
+	messageID int64 // 被 @ 的那条消息，回复时引用它
+	started   time.Time
+	timer     *time.Timer
+	flush     func(prompt string, messageID int64)
+}
+
+var (
+	debounceMu      sync.Mutex
+	pendingPrompts  = make(map[string]*pendingPrompt)   // "群号:用户" -> 等待合并的提问
+	recentFragments = make(map[string][]promptFragment) // "群号:用户" -> 近期未 @ 的消息
+)
+
+// debounceKey 合并提问的 Key（私聊 groupID 为 0）
+func debounceKey(groupID int64, userID int64) string {
+	return fmt.Sprintf("%d:%d", groupID, userID)
+}
+
+// add 追加一段非空内容
+func (p *pendingPrompt) add(text string) {
+	if text = strings.TrimSpace(text); text != "" {
+		p.parts = append(p.parts, text)
+	}
+}
+
+// DebounceAddressed 把 @ 机器人（或私聊）的提问放入合并窗口（REPLY_DEBOUNCE）：
+// 窗口内同一用户的后续消息（@ 或不 @ 都算）会并入，窗口开始前不久未 @ 的消息作为前半句一并带上；
+// 每来一条新消息窗口顺延，总等待不超过窗口的 debounceMaxFactor 倍，到时用合并后的提问调用 flush。
+// 未开启合并时返回 false，由调用方直接回复
+func DebounceAddressed(groupID int64, userID int64, messageID int64, prompt string, flush func(prompt string, messageID int64)) bool {
+	window := config.Get().ReplyDebounce
+	if window <= 0 {
+		return false
+	}
+	key := debounceKey(groupID, userID)
+
+	debounceMu.Lock()
+	defer debounceMu.Unlock()
+	if p, ok := pendingPrompts[key]; ok {
+		p.add(prompt)
+		p.extend(window)
+		return true
+	}
+
+	p := &pendingPrompt{messageID: messageID, started: Now(), flush: flush}
+	for _, f := range recentFragments[key] {
+		if Since(f.at) <= window {
+			p.add(f.text)
+		}
+	}
+	delete(recentFragments, key)
+	p.add(prompt)
+	p.timer = time.AfterFunc(window, func() { flushPending(key, p) })
+	pendingPrompts[key] = p
+	return true
+}
+
+// AppendPendingPrompt 处理未 @ 机器人的消息：该用户有等待合并的提问时并入并返回 true；
+// 否则记为近期片段（之后 @ 机器人时可能是提问的前半句），返回 false
+func AppendPendingPrompt(groupID int64, userID int64, content string) bool {
+	window := config.Get().ReplyDebounce
+	content = strings.TrimSpace(content)
+	if window <= 0 || content == "" {
+		return false
+	}
+	key := debounceKey(groupID, userID)
+
+	debounceMu.Lock()
+	defer debounceMu.Unlock()
+	if p, ok := pendingPrompts[key]; ok {
+		p.add(content)
+		p.extend(window)
+		return true
+	}
+
+	if len(recentFragments) > debounceSweepSize {
+		for k, frags := range recentFragments {
+			if Since(frags[len(frags)-1].at) > window {
+				delete(recentFragments, k)
+			}
+		}
+	}
+	var kept []promptFragment
+	for _, f := range recentFragments[key] {
+		if Since(f.at) <= window {
+			kept = append(kept, f)
+		}
+	}
+	kept = append(kept, promptFragment{text: content, at: Now()})
+	if len(kept) > debounceMaxFragments {
+		kept = kept[len(kept)-debounceMaxFragments:]
+	}
+	recentFragments[key] = kept
+	return false
+}
+
+// extend 收到新片段后顺延窗口（不超过总等待上限）；调用方需持有 debounceMu
+func (p *pendingPrompt) extend(window time.Duration) {
+	if Since(p.started)+window <= window*debounceMaxFactor {
+		p.timer.Reset(window)
+	}
+}
+
+// flushPending 窗口结束：取出合并后的提问并回复（计时器可能在已取出后再次触发，此时忽略）
+func flushPending(key string, p *pendingPrompt) {
+	debounceMu.Lock()
+	if pendingPrompts[key] != p {
+		debounceMu.Unlock()
+		return
+	}
+	delete(pendingPrompts, key)
+	prompt := strings.Join(p.parts, "\n")
+	debounceMu.Unlock()
+
+	if len(p.parts) > 1 {
+		log.Printf("[Debounce] Merged %d messages from %s into one prompt", len(p.parts), key)
+	}
+	p.flush(prompt, p.messageID)
+}
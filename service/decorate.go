@@ -0,0 +1,29 @@
+package service
+
+import (
+	"strings"
+
+	"gin-bot/config"
+)
+
+// renderDecoration 填充回复前后缀模板中的 {bot_name}
+func renderDecoration(template string) string {
+	if template == "" {
+		return ""
+	}
+	return strings.ReplaceAll(template, "{bot_name}", config.Get().BotName)
+}
+
+// DecorateReply 给回复加上 REPLY_PREFIX / REPLY_SUFFIX。
+// 需在清理模型输出的 CQ 码之后、加 @/引用 CQ 码之前调用，前缀因此紧跟在 @ 之后；
+// 一条回复拆成多段发送时，前缀只加在第一段（first），后缀只加在最后一段（last）。
+// 只装饰发出去的消息，存档（SaveBotReply）保存未装饰的正文，避免模型从对话历史里学着自己写前缀
+func DecorateReply(reply string, first bool, last bool) string {
+	if first {
+		reply = renderDecoration(config.Get().ReplyPrefix) + reply
+	}
+	if last {
+		reply += renderDecoration(config.Get().ReplySuffix)
+	}
+	return reply
+}
@@ -0,0 +1,34 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"gin-bot/database"
+)
+
+// seenMessageTTL 已处理消息的记录时间，覆盖 OneBot 断线重连后的重放窗口即可
+const seenMessageTTL = 10 * time.Minute
+
+// seenMessageKey 已处理消息的 Key（消息 ID 只在同一账号内唯一）
+func seenMessageKey(selfID int64, messageID int64) string {
+	return fmt.Sprintf("bot:seen_msg:%d:%d", selfID, messageID)
+}
+
+// MarkMessageSeen 记录消息已处理，返回 false 表示该消息此前已投递过（重复投递应丢弃）
+// 缺少消息 ID 或 Redis 不可用时不做去重，按新消息处理
+func MarkMessageSeen(selfID int64, messageID int64) bool {
+	if database.Redis() == nil || messageID == 0 {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	first, err := database.Redis().SetNX(ctx, seenMessageKey(selfID, messageID), 1, seenMessageTTL).Result()
+	if err != nil {
+		log.Printf("[Dedup] Failed to mark message %d: %v", messageID, err)
+		return true
+	}
+	return first
+}
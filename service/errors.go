@@ -0,0 +1,33 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// 服务层错误类型，调用方可用 errors.Is 区分失败原因并给出不同提示
+var (
+	ErrRateLimited         = errors.New("upstream rate limited")         // 上游限流（HTTP 429）
+	ErrUpstreamUnavailable = errors.New("upstream unavailable")          // 网络错误、超时或上游返回非 200
+	ErrModelInvalidOutput  = errors.New("model returned invalid output") // 响应无法解析或缺少必要字段
+)
+
+// upstreamStatusError 将上游非 200 响应包装为对应的错误类型
+func upstreamStatusError(api string, status int, body []byte) error {
+	kind := ErrUpstreamUnavailable
+	if status == http.StatusTooManyRequests {
+		kind = ErrRateLimited
+	}
+	return fmt.Errorf("%w: %s error (%d): %s", kind, api, status, string(body))
+}
+
+// upstreamRequestError 包装请求发送阶段的网络错误（连接失败、超时等）
+func upstreamRequestError(api string, err error) error {
+	return fmt.Errorf("%w: %s request failed: %w", ErrUpstreamUnavailable, api, err)
+}
+
+// invalidOutputError 包装模型响应解析失败的错误
+func invalidOutputError(api string, err error, body []byte) error {
+	return fmt.Errorf("%w: %s parse response error: %v, body: %s", ErrModelInvalidOutput, api, err, string(body))
+}
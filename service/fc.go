@@ -8,15 +8,14 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"gin-bot/config"
-	"gin-bot/database"
-	"gin-bot/embedding"
 	"gin-bot/models"
-	"gin-bot/pinecone"
 )
 
 const (
@@ -30,7 +29,7 @@ type FCChatRequest struct {
 	Model       string        `json:"model"`
 	Messages    []ChatMessage `json:"messages"`
 	Tools       []FCTool      `json:"tools,omitempty"`
-	ToolChoice  string        `json:"tool_choice,omitempty"` // "auto", "none", "required"
+	ToolChoice  interface{}   `json:"tool_choice,omitempty"` // "auto", "none", "required" 或 forcedToolChoice 指定的函数
 	MaxTokens   int           `json:"max_tokens,omitempty"`
 	Temperature float64       `json:"temperature,omitempty"`
 }
@@ -73,88 +72,121 @@ type FCToolCall struct {
 	} `json:"function"`
 }
 
+// MemoryProvenance 参与生成回复的一条记忆来源（供超级用户调试）
+type MemoryProvenance struct {
+	VectorID  string  `json:"vector_id"`
+	Namespace string  `json:"namespace"`
+	Score     float32 `json:"score"`
+	Text      string  `json:"text"`
+}
+
+var (
+	userTurns   = make(map[int64]uint64) // 用户 QQ -> 已开始的 FC 对话轮次（每条用户消息一轮）
+	userTurnsMu sync.Mutex
+)
+
+// beginUserTurn 用户发来新消息、开始一轮 FC 对话时调用，返回新的轮次
+func beginUserTurn(userID int64) uint64 {
+	userTurnsMu.Lock()
+	defer userTurnsMu.Unlock()
+	userTurns[userID]++
+	return userTurns[userID]
+}
+
+// currentUserTurn 返回用户当前所处的 FC 对话轮次，用于识别同一轮内的连续工具调用
+func currentUserTurn(userID int64) uint64 {
+	userTurnsMu.Lock()
+	defer userTurnsMu.Unlock()
+	return userTurns[userID]
+}
+
 // GetAIResponseWithFC 带 Function Calling 能力的 AI 回复 (集成时间感与动态变脸)
 func GetAIResponseWithFC(userPrompt string, groupID int64, userID int64, isSuperUser bool) (string, error) {
-	now := time.Now()
-	timeInfo := fmt.Sprintf("【北京时间：%s】", now.Format("2006-01-02 15:04"))
-
-	// 1. RAG 双 namespace 检索
-	contextTexts := []string{}
-	isTechScene := false
-	isPersonalScene := false
-	maxScore := float32(0.0)
-
-	queryVec, err := embedding.GetEmbedding(userPrompt, "query", 1024)
-	if err == nil {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-
-		// 检索个人信息 (NamespacePersonal) - 强制 ID 隔离
-		pFilter := map[string]interface{}{
-			"user_qq": strconv.FormatInt(userID, 10),
-		}
-		pMatches, _ := pinecone.QueryWithScore(ctx, pinecone.NamespacePersonal, queryVec, 3, pFilter)
-		for _, m := range pMatches {
-			if m.Score > 0.7 {
-				isPersonalScene = true
-			}
-			if m.Score > maxScore {
-				maxScore = m.Score
-			}
-			var res models.MemberEmbedding
-			database.DB.Preload("RefMsg").Where("vector_id = ?", m.ID).First(&res)
-			if res.ContentSummary != "" {
-				relTime := formatRelativeTime(res.RefMsg.CreatedAt)
-				contextTexts = append(contextTexts, fmt.Sprintf("(%s前) %s", relTime, res.ContentSummary))
-			}
-		}
+	reply, _, err := GetAIResponseWithProvenance(userPrompt, groupID, userID, isSuperUser)
+	return reply, err
+}
 
-		// 检索聊天记录 (NamespaceChat)
-		chatFilter := map[string]interface{}{
-			"group_id": groupID,
-		}
-		cMatches, _ := pinecone.QueryWithScore(ctx, pinecone.NamespaceChat, queryVec, 3, chatFilter)
-		for _, m := range cMatches {
-			if m.Score > maxScore {
-				maxScore = m.Score
-			}
-			var res models.MemberEmbedding
-			database.DB.Preload("RefMsg").Where("vector_id = ?", m.ID).First(&res)
-			if res.ContentSummary != "" {
-				relTime := formatRelativeTime(res.RefMsg.CreatedAt)
-				contextTexts = append(contextTexts, fmt.Sprintf("(%s前) %s", relTime, res.ContentSummary))
-
-				lowContent := strings.ToLower(res.ContentSummary)
-				if strings.Contains(lowContent, "err") || strings.Contains(lowContent, "code") || strings.Contains(lowContent, "api") || strings.Contains(lowContent, "func") {
-					isTechScene = true
-				}
-			}
-		}
+// GetAIResponseWithProvenance 同 GetAIResponseWithFC，并额外返回参与回复的记忆来源
+// 仅对超级用户返回来源信息，普通用户得到的来源列表始终为空
+func GetAIResponseWithProvenance(userPrompt string, groupID int64, userID int64, isSuperUser bool) (string, []MemoryProvenance, error) {
+	reply, provenance, err := getAIResponseWithFC(liveFCEnv(), userPrompt, groupID, userID, isSuperUser, "")
+	if !isSuperUser {
+		provenance = nil
 	}
+	return reply, provenance, err
+}
 
-	// 2. 构建系统 Prompt (小黄人设 + 动态变脸 + 时间感)
-	var contextBlock string
-	if len(contextTexts) > 0 {
-		contextBlock = "【脑海中的回忆片段】:\n" + strings.Join(contextTexts, "\n")
-	} else {
-		contextBlock = "【回忆】: (暂时没想起什么特别的)"
+// GetAIResponseForcingTool 同 GetAIResponseWithFC，但强制模型调用指定工具（tool_choice 指定函数），
+// 用于斜杠命令等意图明确、不应由模型决定是否调用工具的场景
+func GetAIResponseForcingTool(userPrompt string, groupID int64, userID int64, isSuperUser bool, toolName string) (string, error) {
+	reply, _, err := getAIResponseWithFC(liveFCEnv(), userPrompt, groupID, userID, isSuperUser, toolName)
+	return reply, err
+}
+
+// forcedToolChoice 构建指定函数的 tool_choice（OpenAI 格式）
+func forcedToolChoice(toolName string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":     "function",
+		"function": map[string]interface{}{"name": toolName},
 	}
+}
 
-	vibePrompt := ""
-	if isTechScene {
-		vibePrompt = "\n**[🔧 技术场景适配]**：现在像一个热心的技术大佬在帮群友排查 Bug 一样，直接指出重点，可以带点技术圈的吐槽，但要保证准确简练。"
-	} else if isPersonalScene {
-		vibePrompt = "\n**[💝 情感场景适配]**：回想起这位老朋友的私事了，用更多的同情和理解来回复。添加一些相关的例子或生活经验，让回复充满温度。"
+// FormatProvenance 将记忆来源格式化为回复末尾的脚注
+func FormatProvenance(provenance []MemoryProvenance) string {
+	if len(provenance) == 0 {
+		return "\n\n📎 参考记忆: (无)"
+	}
+	var sb strings.Builder
+	sb.WriteString("\n\n📎 参考记忆:")
+	for _, p := range provenance {
+		text := []rune(p.Text)
+		if len(text) > 30 {
+			text = append(text[:30], []rune("...")...)
+		}
+		sb.WriteString(fmt.Sprintf("\n[%s] %s (%.3f) %s", p.Namespace, p.VectorID, p.Score, string(text)))
 	}
+	return sb.String()
+}
+
+// fcEnv Function Calling 管线依赖的外部服务：模型接口地址、工具执行与记忆检索。
+// 线上使用 liveFCEnv，测试时传入 mock，不替换任何全局变量
+type fcEnv struct {
+	chatURL  string
+	execTool func(name string, args map[string]interface{}, groupID int64, userID int64, isSuperUser bool) ToolResult
+	retrieve func(ctx context.Context, prompt string, groupID int64, userID int64) retrievalResult
+}
+
+// liveFCEnv 线上使用的真实服务
+func liveFCEnv() fcEnv {
+	return fcEnv{chatURL: NVIDIA_CHAT_URL, execTool: executeToolWithTimeout, retrieve: retrieveContext}
+}
+
+// getAIResponseWithFC FC 回复的实现；forceTool 不为空时强制模型调用该工具（须在本群可用的工具中）
+// 模型生成的文字按 token 上限截断，工具要求原样发送的内容（DirectReply）不截断
+func getAIResponseWithFC(env fcEnv, userPrompt string, groupID int64, userID int64, isSuperUser bool, forceTool string) (string, []MemoryProvenance, error) {
+	beginUserTurn(userID)
+	timeInfo := timeInfoBlock()
+
+	// 消息中的图片/文件替换为占位符，工具（如定时提醒）可按占位符引用原始附件
+	userPrompt, media := extractMediaPlaceholders(userPrompt)
 
-	if maxScore > 0.85 {
-		vibePrompt += "\n**[⚡ 确定性强化]**：你对这段记忆非常确定，说话更有底气一点。"
-	} else if maxScore > 0.0 && maxScore < 0.6 {
-		vibePrompt += "\n**[❓ 模糊处理]**：记忆有点模糊，回复时可以带一句'我好像记得...'进行模糊处理。"
+	// 1. RAG 双 namespace 检索（整体限时 5 秒）
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	retrieved := env.retrieve(ctx, userPrompt, groupID, userID)
+	contextBlock, vibePrompt, provenance := retrieved.ContextBlock, retrieved.VibePrompt, retrieved.Provenance
+
+	// 2. 构建系统 Prompt (小黄人设 + 动态变脸 + 时间感)
+	// 正在对话的群友：优先使用对方设置的称呼
+	speakerInfo := ""
+	if speaker := GetUserByQQ(strconv.FormatInt(userID, 10)); speaker.Alias != "" {
+		speakerInfo = fmt.Sprintf("\n【正在和你聊天的是】：%s（QQ 昵称：%s），请用“%s”称呼对方。", speaker.Alias, speaker.Nickname, speaker.Alias)
+	} else if speaker.Nickname != "" {
+		speakerInfo = fmt.Sprintf("\n【正在和你聊天的是】：%s", speaker.Nickname)
 	}
 
-	systemPrompt := fmt.Sprintf(`你是"小黄"，一个混迹在群聊里的资深群友。你真心把群友当朋友，说话自然。
-%s
+	systemPrompt := fmt.Sprintf(`你是"%s"，一个混迹在群聊里的资深群友。你真心把群友当朋友，说话自然。
+%s%s%s
 你可以使用工具来执行操作（如开关机器人、查询状态、甚至设置未来提醒），也可以直接回答问题。
 
 %s
@@ -169,19 +201,35 @@ func GetAIResponseWithFC(userPrompt string, groupID int64, userID int64, isSuper
 1. 如果用户意图明确需要工具，请调用对应工具
 2. 绝对不要说"根据信息""检索结果"这种话！要把背景信息当作你自己的记忆。
 3. 保持像朋友边喝奶茶边聊天一样自然。
-4. 如果回忆里有几天前或几小时前的细节，请自然地在回复中体现出来，展现你有极好的记性。`, timeInfo, contextBlock, vibePrompt)
+4. 如果回忆里有几天前或几小时前的细节，请自然地在回复中体现出来，展现你有极好的记性。%s`, config.Get().BotName, timeInfo, groupPersonaBlock(groupID), speakerInfo, contextBlock, vibePrompt, addressedRule(5))
 
 	// 3. 转换工具格式
-	fcTools := make([]FCTool, len(AvailableTools))
-	for i, tool := range AvailableTools {
-		fcTools[i] = FCTool{
+	// 群聊中的普通成员只能看到本群白名单内的工具
+	var groupCfg models.GroupConfig
+	if groupID != 0 && !isSuperUser {
+		groupCfg = GetGroupConfig(groupID)
+	}
+	fcTools := make([]FCTool, 0, len(AvailableTools))
+	for _, tool := range AvailableTools {
+		if !IsToolAllowed(groupCfg, tool.Name) {
+			continue
+		}
+		fcTools = append(fcTools, FCTool{
 			Type: "function",
 			Function: FCFunction{
 				Name:        tool.Name,
 				Description: tool.Description,
 				Parameters:  tool.Parameters,
 			},
+		})
+	}
+
+	var toolChoice interface{} = "auto"
+	if forceTool != "" {
+		if !slices.ContainsFunc(fcTools, func(t FCTool) bool { return t.Function.Name == forceTool }) {
+			return "", nil, fmt.Errorf("tool %s is not available here", forceTool)
 		}
+		toolChoice = forcedToolChoice(forceTool)
 	}
 
 	// 4. 构建请求
@@ -194,157 +242,346 @@ func GetAIResponseWithFC(userPrompt string, groupID int64, userID int64, isSuper
 		Model:       NVIDIA_FC_MODEL,
 		Messages:    messages,
 		Tools:       fcTools,
-		ToolChoice:  "auto",
-		Temperature: 0.2,
-		MaxTokens:   2048,
+		ToolChoice:  toolChoice,
+		Temperature: fcToolChoiceTemperature,
+		MaxTokens:   MaxReplyTokens(groupID),
 	}
+	// 场景温度只用于拿到工具结果后生成回复的请求
+	replyTemperature := sceneTemperature(retrieved.TechScene, retrieved.PersonalScene)
 
-	jsonData, err := json.Marshal(reqBody)
+	jsonData, err := marshalChatRequest(reqBody)
 	if err != nil {
-		return "", err
+		return "", provenance, err
 	}
 
 	// 调试：打印请求 JSON
 	// log.Printf("[FC] Request JSON: %s", string(jsonData))
 
 	// 5. 发送请求
-	req, err := http.NewRequest("POST", NVIDIA_CHAT_URL, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequest("POST", env.chatURL, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return "", err
+		return "", provenance, err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+config.Cfg.NvidiaAPIKey)
+	setLLMHeaders(req)
 	req.Header.Set("Accept", "application/json")
 
-	client := config.GetHTTPClientWithTimeout(120 * time.Second)
+	client := config.GetLLMHTTPClientWithTimeout(120 * time.Second)
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", err
+		return "", provenance, upstreamRequestError("FC API", err)
 	}
 	defer resp.Body.Close()
 
 	body, _ := io.ReadAll(resp.Body)
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("FC API error (%d): %s", resp.StatusCode, string(body))
+		return "", provenance, upstreamStatusError("FC API", resp.StatusCode, body)
 	}
 
 	var fcResp FCChatResponse
 	if err := json.Unmarshal(body, &fcResp); err != nil {
-		return "", fmt.Errorf("parse response error: %v, body: %s", err, string(body))
+		return "", provenance, invalidOutputError("FC API", err, body)
 	}
 
 	if len(fcResp.Choices) == 0 {
-		return "我不知道该怎么回答你...", nil
+		return emptyReplyFallback("empty choices"), provenance, nil
 	}
 
 	choice := fcResp.Choices[0]
 
 	// 6. 检查是否有工具调用
 	if len(choice.Message.ToolCalls) > 0 {
-		return handleToolCalls(choice.Message.ToolCalls, messages, groupID, userID, isSuperUser, client)
+		reply, err := handleToolCalls(env, choice.Message.ToolCalls, messages, fcTools, media, groupID, userID, isSuperUser, client, replyTemperature)
+		return reply, provenance, err
 	}
 
-	// 7. 直接返回内容
+	// 7. 直接返回内容（声称执行了操作却没有调用工具时先核对）
 	if choice.Message.Content != "" {
-		return choice.Message.Content, nil
+		if reply, handled := verifyActionClaim(env, choice.Message.Content, messages, fcTools, media, groupID, userID, isSuperUser, client, replyTemperature); handled {
+			return reply, provenance, nil
+		}
+		return truncateReply(choice.Message.Content, MaxReplyTokens(groupID)), provenance, nil
 	}
 
-	return "我不知道该怎么回答你...", nil
+	return emptyReplyFallback("empty content, finish_reason=" + choice.FinishReason), provenance, nil
 }
 
+// fcToolChoiceTemperature 首次 FC 请求（由模型决定是否调用、调用哪个工具）使用的固定低温度，
+// 避免高温场景下工具选择和参数随机漂移；模型未调用工具时这一轮的文字即为回复
+const fcToolChoiceTemperature = 0.2
+
+// maxToolRounds 单次对话中工具调用的最大轮数，防止模型反复调用工具陷入死循环
+const maxToolRounds = 5
+
 // handleToolCalls 处理工具调用
-func handleToolCalls(toolCalls []FCToolCall, messages []ChatMessage, groupID int64, userID int64, isSuperUser bool, client *http.Client) (string, error) {
-	// 执行所有工具调用
-	toolResults := []struct {
-		ToolCallID string
-		Result     ToolResult
-	}{}
-
-	for _, tc := range toolCalls {
-		log.Printf("[FC] Calling tool: %s with args: %s", tc.Function.Name, tc.Function.Arguments)
+// 执行模型请求的工具后把结果回传给模型；若模型继续请求工具则继续执行，
+// 直到模型给出纯文本回复、达到 maxToolRounds 上限或检测到重复调用为止
+// media 为用户消息中被替换成占位符的附件 CQ 码；temperature 为生成回复使用的场景温度
+func handleToolCalls(env fcEnv, toolCalls []FCToolCall, messages []ChatMessage, fcTools []FCTool, media []string, groupID int64, userID int64, isSuperUser bool, client *http.Client, temperature float64) (string, error) {
+	// 沿用原始消息（含 RAG 回忆与人设的 system prompt），在其后追加工具调用轮次
+	fullMessages := make([]map[string]interface{}, 0, len(messages)+2*maxToolRounds)
+	for _, m := range messages {
+		fullMessages = append(fullMessages, map[string]interface{}{"role": m.Role, "content": m.Content})
+	}
 
-		// 解析参数
-		var args map[string]interface{}
-		if tc.Function.Arguments != "" && tc.Function.Arguments != "{}" {
-			if err := json.Unmarshal([]byte(tc.Function.Arguments), &args); err != nil {
-				log.Printf("[FC] Failed to parse arguments: %v", err)
-				args = make(map[string]interface{})
+	executed := make(map[string]bool) // 已执行过的 "工具名|参数"，用于检测循环
+	var fallback string               // 生成失败时直接返回的工具结果
+	var directReplies []string        // 工具要求原样发送的内容，附在最终回复之后
+
+	// withDirect 截断模型生成的回复后再附上工具的原样输出，原样输出不受 token 上限影响
+	withDirect := func(reply string) string {
+		reply = truncateReply(reply, MaxReplyTokens(groupID))
+		if len(directReplies) == 0 {
+			return reply
+		}
+		if reply == "" {
+			return strings.Join(directReplies, "\n\n")
+		}
+		return reply + "\n\n" + strings.Join(directReplies, "\n\n")
+	}
+
+	for round := 1; ; round++ {
+		// 执行本轮所有工具调用（结果顺序与 toolCalls 一致）
+		results := executeToolCalls(env, toolCalls, media, groupID, userID, isSuperUser)
+
+		var resultMsgs []string
+		var toolMessages []map[string]interface{}
+		allDirect := true
+		for i, tc := range toolCalls {
+			executed[tc.Function.Name+"|"+tc.Function.Arguments] = true
+
+			resultJSON, _ := json.Marshal(results[i])
+			toolMessages = append(toolMessages, map[string]interface{}{
+				"role":         "tool",
+				"tool_call_id": tc.ID,
+				"content":      string(resultJSON),
+			})
+			if results[i].DirectReply != "" {
+				// 重复调用复用同一结果，原样回复只发一次
+				if !slices.Contains(directReplies, results[i].DirectReply) {
+					directReplies = append(directReplies, results[i].DirectReply)
+				}
+				continue
 			}
+			allDirect = false
+			resultMsgs = append(resultMsgs, results[i].Message)
+		}
+		fallback = strings.Join(resultMsgs, "\n")
+
+		// 本轮结果全部需要原样发送：不再请求模型改写
+		if allDirect {
+			return withDirect(""), nil
+		}
+
+		// 添加 assistant 消息 (包含 tool_calls) 与 tool 消息 (工具执行结果)
+		fullMessages = append(fullMessages, map[string]interface{}{
+			"role":       "assistant",
+			"content":    "",
+			"tool_calls": toolCalls,
+		})
+		fullMessages = append(fullMessages, toolMessages...)
+
+		// 达到轮数上限后不再提供工具，强制模型给出文本回复
+		reqBody := map[string]interface{}{
+			"model":       NVIDIA_FC_MODEL,
+			"messages":    fullMessages,
+			"temperature": temperature,
+			"max_tokens":  MaxReplyTokens(groupID),
+		}
+		if round < maxToolRounds {
+			reqBody["tools"] = fcTools
+			reqBody["tool_choice"] = "auto"
 		} else {
-			args = make(map[string]interface{})
+			log.Printf("[FC] Reached max tool rounds (%d), requesting final answer", maxToolRounds)
 		}
 
-		// 执行工具（带权限检查）
-		result := ExecuteTool(tc.Function.Name, args, groupID, userID, isSuperUser)
-		log.Printf("[FC] Tool result: %+v", result)
+		finalResp, err := postFCRequest(env, client, reqBody)
+		if err != nil || len(finalResp.Choices) == 0 {
+			// 如果请求失败，直接返回工具结果
+			return withDirect(fallback), nil
+		}
+
+		msg := finalResp.Choices[0].Message
+		if len(msg.ToolCalls) == 0 || round >= maxToolRounds {
+			if msg.Content != "" {
+				return withDirect(msg.Content), nil
+			}
+			return withDirect(fallback), nil
+		}
+
+		// 新一轮调用与已执行的完全相同，说明模型在原地打转
+		repeated := true
+		for _, tc := range msg.ToolCalls {
+			if !executed[tc.Function.Name+"|"+tc.Function.Arguments] {
+				repeated = false
+				break
+			}
+		}
+		if repeated {
+			log.Printf("[FC] Detected repeated tool calls in round %d, stopping loop", round+1)
+			if msg.Content != "" {
+				return withDirect(msg.Content), nil
+			}
+			return withDirect(fallback), nil
+		}
 
-		toolResults = append(toolResults, struct {
-			ToolCallID string
-			Result     ToolResult
-		}{tc.ID, result})
+		toolCalls = msg.ToolCalls
 	}
+}
 
-	// 构建包含工具结果的消息，让 AI 生成最终回复
-	// 添加 assistant 消息 (包含 tool_calls)
-	assistantMsg := map[string]interface{}{
-		"role":       "assistant",
-		"content":    "",
-		"tool_calls": toolCalls,
+// executeToolWithTimeout 在超时时间内执行工具，超时则返回 timeoutResult 让模型直接回复
+// 超时的工具会在后台继续执行完毕（操作可能仍会生效），其结果被丢弃；超时配置为 0 表示不限时
+func executeToolWithTimeout(name string, args map[string]interface{}, groupID int64, userID int64, isSuperUser bool) ToolResult {
+	timeout := toolTimeout(name)
+	if timeout <= 0 {
+		return ExecuteTool(name, args, groupID, userID, isSuperUser)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	done := make(chan ToolResult, 1)
+	go func() {
+		done <- ExecuteTool(name, args, groupID, userID, isSuperUser)
+	}()
+
+	select {
+	case result := <-done:
+		return result
+	case <-ctx.Done():
+		log.Printf("[FC] Tool %s timed out after %s", name, timeout)
+		return timeoutResult(name, timeout)
 	}
+}
 
-	// 添加 tool 消息 (工具执行结果)
-	var toolMessages []map[string]interface{}
-	for _, tr := range toolResults {
-		resultJSON, _ := json.Marshal(tr.Result)
-		toolMessages = append(toolMessages, map[string]interface{}{
-			"role":         "tool",
-			"tool_call_id": tr.ToolCallID,
-			"content":      string(resultJSON),
-		})
+// timeoutResult 工具超时的结果：只读工具视为没有拿到结果；有副作用的工具仍在后台执行，
+// 操作是否生效未知，要求模型如实告知用户结果待确认，而不是说操作失败
+func timeoutResult(name string, timeout time.Duration) ToolResult {
+	if isReadOnlyTool(name) {
+		return ToolResult{Success: false, Message: fmt.Sprintf("工具 %s 执行超时（%s），没有拿到结果。请不要重试，直接根据已有信息回复用户。", name, timeout)}
 	}
+	return ToolResult{Success: false, Message: fmt.Sprintf("工具 %s 执行超时（%s），操作仍可能在后台完成，结果未知。请不要重试，也不要说操作失败或已完成，告诉用户结果暂时无法确认，稍后可以查看。", name, timeout)}
+}
 
-	// 构建完整消息列表
-	fullMessages := []map[string]interface{}{
-		{"role": "system", "content": "你是一个智能群聊助手。根据工具执行结果，用自然、简洁、有趣的语言回复用户。"},
-		{"role": "user", "content": messages[len(messages)-1].Content},
-		assistantMsg,
+// executeToolCalls 执行一轮工具调用，返回与 toolCalls 顺序一一对应的结果
+// 连续的只读工具并发执行；有副作用的工具作为屏障单独按顺序执行，保证结果确定
+// 参数中的附件占位符（如 [图片1]）会先替换为 media 中对应的原始 CQ 码
+// 同一轮中工具名与参数完全相同的重复调用只执行一次，重复项复用其结果（避免重复建提醒、开关来回切换）
+func executeToolCalls(env fcEnv, toolCalls []FCToolCall, media []string, groupID int64, userID int64, isSuperUser bool) []ToolResult {
+	results := make([]ToolResult, len(toolCalls))
+
+	argsList := make([]map[string]interface{}, len(toolCalls))
+	firstCall := make(map[string]int) // 调用键 -> 首次出现的下标
+	duplicateOf := make(map[int]int)  // 重复调用的下标 -> 首次出现的下标
+	for i, tc := range toolCalls {
+		args := parseToolArgs(tc.Function.Arguments)
+		if items, ok := args["attachments"].([]interface{}); ok {
+			var resolved []interface{}
+			for _, a := range resolveAttachments(items, media) {
+				resolved = append(resolved, a)
+			}
+			args["attachments"] = resolved
+		}
+		argsList[i] = args
+
+		key := toolCallKey(tc.Function.Name, args)
+		if first, ok := firstCall[key]; ok {
+			duplicateOf[i] = first
+			log.Printf("[FC] Collapsed duplicate tool call: %s with args: %s", tc.Function.Name, tc.Function.Arguments)
+			continue
+		}
+		firstCall[key] = i
 	}
-	fullMessages = append(fullMessages, toolMessages...)
 
-	// 请求 AI 生成最终回复
-	reqBody := map[string]interface{}{
-		"model":       NVIDIA_FC_MODEL,
-		"messages":    fullMessages,
-		"temperature": 0.5,
-		"max_tokens":  512,
+	run := func(i int) {
+		tc := toolCalls[i]
+		log.Printf("[FC] Calling tool: %s with args: %s", tc.Function.Name, tc.Function.Arguments)
+		// 执行工具（带权限检查与超时）
+		results[i] = env.execTool(tc.Function.Name, argsList[i], groupID, userID, isSuperUser)
+		log.Printf("[FC] Tool result: %+v", results[i])
 	}
 
-	jsonData, _ := json.Marshal(reqBody)
-	req, _ := http.NewRequest("POST", NVIDIA_CHAT_URL, bytes.NewBuffer(jsonData))
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+config.Cfg.NvidiaAPIKey)
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, max(config.Get().ToolMaxParallel, 1))
+	for i, tc := range toolCalls {
+		if _, dup := duplicateOf[i]; dup {
+			continue
+		}
+		if !isReadOnlyTool(tc.Function.Name) {
+			wg.Wait() // 等待前面的只读工具完成，再执行有副作用的工具
+			run(i)
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			run(i)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, first := range duplicateOf {
+		results[i] = results[first]
+	}
+	return results
+}
+
+// toolCallKey 工具调用的去重键：工具名 + 规范化后的参数（json.Marshal 会按键排序）
+func toolCallKey(name string, args map[string]interface{}) string {
+	normalized, _ := json.Marshal(args)
+	return name + "|" + string(normalized)
+}
+
+// parseToolArgs 解析工具参数 JSON，失败时返回空参数
+func parseToolArgs(raw string) map[string]interface{} {
+	args := make(map[string]interface{})
+	if raw == "" || raw == "{}" {
+		return args
+	}
+	if err := json.Unmarshal([]byte(raw), &args); err == nil {
+		return args
+	}
+
+	// 部分模型会把参数包在 ```json 代码块里、附带说明文字或二次编码成字符串，尝试容错提取
+	if obj, ok := extractJSONObject(raw); ok {
+		args = make(map[string]interface{})
+		if err := json.Unmarshal([]byte(obj), &args); err == nil {
+			log.Printf("[FC] Recovered malformed arguments: %q", raw)
+			return args
+		}
+	}
+	log.Printf("[FC] Failed to parse arguments: %q", raw)
+	return make(map[string]interface{})
+}
+
+// postFCRequest 发送 Chat Completions 请求并解析响应
+func postFCRequest(env fcEnv, client *http.Client, reqBody interface{}) (*FCChatResponse, error) {
+	jsonData, err := marshalChatRequest(reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", env.chatURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	setLLMHeaders(req)
 
 	resp, err := client.Do(req)
 	if err != nil {
-		// 如果第二次请求失败，直接返回工具结果
-		return toolResults[0].Result.Message, nil
+		return nil, upstreamRequestError("FC API", err)
 	}
 	defer resp.Body.Close()
 
 	body, _ := io.ReadAll(resp.Body)
 	if resp.StatusCode != http.StatusOK {
-		return toolResults[0].Result.Message, nil
-	}
-
-	var finalResp FCChatResponse
-	if err := json.Unmarshal(body, &finalResp); err != nil {
-		return toolResults[0].Result.Message, nil
+		return nil, upstreamStatusError("FC API", resp.StatusCode, body)
 	}
 
-	if len(finalResp.Choices) > 0 && finalResp.Choices[0].Message.Content != "" {
-		return finalResp.Choices[0].Message.Content, nil
+	var fcResp FCChatResponse
+	if err := json.Unmarshal(body, &fcResp); err != nil {
+		return nil, invalidOutputError("FC API", err, body)
 	}
-
-	return toolResults[0].Result.Message, nil
+	return &fcResp, nil
 }
@@ -0,0 +1,182 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gin-bot/config"
+	"gin-bot/database"
+	"gin-bot/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// positiveFeedbackWords / negativeFeedbackWords 引用机器人回复时视为反馈的内容
+var (
+	positiveFeedbackWords = []string{"👍", "好评", "赞", "+1"}
+	negativeFeedbackWords = []string{"👎", "差评", "踩", "-1"}
+)
+
+// 表情 ID：QQ 小黄脸 76(赞)/77(踩)，以及 emoji 回应中 👍/👎 的码点
+var (
+	positiveFeedbackFaces = []string{"76", "128077"}
+	negativeFeedbackFaces = []string{"77", "128078"}
+)
+
+var (
+	feedbackStripRegex = regexp.MustCompile(`\[CQ:(?:reply|at),[^\]]*\]`)
+	feedbackFaceRegex  = regexp.MustCompile(`^\[CQ:face,id=(\d+)[^\]]*\]$`)
+)
+
+// ParseFeedbackRating 判断消息（去掉引用和 at 后）是否为反馈，返回 1（好评）、-1（差评）或 0（不是反馈）
+func ParseFeedbackRating(content string) int {
+	text := strings.TrimSpace(feedbackStripRegex.ReplaceAllString(content, ""))
+	if m := feedbackFaceRegex.FindStringSubmatch(text); m != nil {
+		return FeedbackRatingFromEmoji(m[1])
+	}
+	if slices.Contains(positiveFeedbackWords, text) {
+		return 1
+	}
+	if slices.Contains(negativeFeedbackWords, text) {
+		return -1
+	}
+	return 0
+}
+
+// FeedbackRatingFromEmoji 将表情 ID（小黄脸或 emoji 回应）映射为评价
+func FeedbackRatingFromEmoji(emojiID string) int {
+	if slices.Contains(positiveFeedbackFaces, emojiID) {
+		return 1
+	}
+	if slices.Contains(negativeFeedbackFaces, emojiID) {
+		return -1
+	}
+	return 0
+}
+
+// SaveBotReply 将机器人账号发出的消息记入 ChatHistory（仅存档并标记 FromBot，不进入 RAG），供反馈关联；
+// content 传未经 DecorateReply 装饰的正文；返回写入的记录（未写入时 ID 为 0），回复需要与提问成对存入记忆时使用
+func SaveBotReply(selfID int64, groupID int64, messageID int64, content string) models.ChatHistory {
+	if messageID == 0 {
+		return models.ChatHistory{}
+	}
+
+	var bot models.User
+	if err := database.DB.FirstOrCreate(&bot, models.User{QQ: strconv.FormatInt(selfID, 10)}).Error; err != nil {
+		log.Printf("[Feedback] Failed to load bot user: %v", err)
+		return models.ChatHistory{}
+	}
+	if bot.Nickname != config.Get().BotName {
+		bot.Nickname = config.Get().BotName
+		database.DB.Model(&bot).Update("nickname", bot.Nickname)
+	}
+
+	history := models.ChatHistory{
+		UserID:    bot.ID,
+		GroupID:   groupID,
+		MessageID: messageID,
+		Content:   content,
+		FromBot:   true,
+	}
+	if err := database.DB.Create(&history).Error; err != nil {
+		log.Printf("[Feedback] Failed to save bot reply: %v", err)
+		return models.ChatHistory{}
+	}
+	return history
+}
+
+// ArchiveBotMessage 存档同时登录的其他机器人账号在群里发的消息（ARCHIVE_BOT_MESSAGES）：
+// 保留该账号自己的昵称（不改成 BOT_NAME），标记 FromBot 与 Archived，不进入 RAG，也不能被当作本机器人的回复评价
+func ArchiveBotMessage(userID int64, groupID int64, messageID int64, nickname string, content string) {
+	if messageID == 0 {
+		return
+	}
+
+	var user models.User
+	if err := database.DB.FirstOrCreate(&user, models.User{QQ: strconv.FormatInt(userID, 10)}).Error; err != nil {
+		log.Printf("[Feedback] Failed to load bot account %d: %v", userID, err)
+		return
+	}
+	if nickname != "" && user.Nickname != nickname {
+		database.DB.Model(&user).Update("nickname", nickname)
+	}
+
+	history := models.ChatHistory{
+		UserID:    user.ID,
+		GroupID:   groupID,
+		MessageID: messageID,
+		Content:   content,
+		FromBot:   true,
+		Archived:  true,
+	}
+	if err := database.DB.Create(&history).Error; err != nil {
+		log.Printf("[Feedback] Failed to archive message from bot account %d: %v", userID, err)
+	}
+}
+
+// RecordReplyFeedback 记录用户对某条机器人回复的评价，同一用户重复评价以最后一次为准
+func RecordReplyFeedback(selfID int64, messageID int64, userQQ string, groupID int64, rating int) error {
+	var reply models.ChatHistory
+	err := database.DB.
+		Joins("JOIN users ON users.id = chat_histories.user_id").
+		Where("chat_histories.message_id = ? AND users.qq = ? AND NOT chat_histories.archived", messageID, strconv.FormatInt(selfID, 10)).
+		First(&reply).Error
+	if err != nil {
+		return fmt.Errorf("reply %d not found: %w", messageID, err)
+	}
+
+	feedback := models.ReplyFeedback{
+		ChatHistoryID: reply.ID,
+		UserQQ:        userQQ,
+		GroupID:       groupID,
+		Rating:        rating,
+	}
+	err = database.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "chat_history_id"}, {Name: "user_qq"}},
+		DoUpdates: clause.AssignmentColumns([]string{"rating", "updated_at"}),
+	}).Create(&feedback).Error
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[Feedback] %s rated reply %d (msg %d) as %d", userQQ, reply.ID, messageID, rating)
+	return nil
+}
+
+// FeedbackSummary 反馈统计
+type FeedbackSummary struct {
+	Positive    int64    `json:"positive"`
+	Negative    int64    `json:"negative"`
+	RecentWorst []string `json:"recent_worst"` // 最近被差评的回复内容
+}
+
+// GetFeedbackSummary 统计反馈，groupID 为 0 时统计全部群组
+func GetFeedbackSummary(groupID int64, limit int) (FeedbackSummary, error) {
+	var summary FeedbackSummary
+	scope := func() *gorm.DB {
+		q := database.DB.Model(&models.ReplyFeedback{})
+		if groupID != 0 {
+			q = q.Where("group_id = ?", groupID)
+		}
+		return q
+	}
+
+	if err := scope().Where("rating > 0").Count(&summary.Positive).Error; err != nil {
+		return summary, err
+	}
+	if err := scope().Where("rating < 0").Count(&summary.Negative).Error; err != nil {
+		return summary, err
+	}
+
+	var worst []models.ReplyFeedback
+	scope().Preload("ChatHistory").Where("rating < 0").Order("updated_at DESC").Limit(limit).Find(&worst)
+	for _, f := range worst {
+		summary.RecentWorst = append(summary.RecentWorst, f.ChatHistory.Content)
+	}
+	return summary, nil
+}
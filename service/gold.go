@@ -0,0 +1,72 @@
+package service
+
+import (
+	"errors"
+	"log"
+
+	"gin-bot/database"
+	"gin-bot/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 金币变动原因（记录在流水中，展示时转换为中文）
+const (
+	GoldReasonSignIn = "sign_in" // 签到
+	GoldReasonBonus  = "bonus"   // 奖励
+)
+
+// goldReasonLabels 流水原因的中文描述，未收录的原因原样展示
+var goldReasonLabels = map[string]string{
+	GoldReasonSignIn: "签到",
+	GoldReasonBonus:  "奖励",
+}
+
+// ErrInsufficientGold 金币余额不足
+var ErrInsufficientGold = errors.New("insufficient gold")
+
+// goldReasonLabel 流水原因的中文描述
+func goldReasonLabel(reason string) string {
+	if label, ok := goldReasonLabels[reason]; ok {
+		return label
+	}
+	return reason
+}
+
+// ChangeGold 变动用户金币并记录一条流水，返回变动后的余额。
+// 所有金币变动都必须经由此函数，不要直接修改 User.Gold，否则流水与余额对不上；
+// 余额不足以支出时返回 ErrInsufficientGold，不做任何修改
+func ChangeGold(userID uint, delta int64, reason string) (int64, error) {
+	var balance int64
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		// 锁定用户行，避免并发变动（如同时签到）互相覆盖余额
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+			return err
+		}
+		balance = user.Gold + delta
+		if balance < 0 {
+			return ErrInsufficientGold
+		}
+		if err := tx.Model(&user).Update("gold", balance).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.GoldTransaction{UserID: userID, Delta: delta, Balance: balance, Reason: reason}).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	log.Printf("[Gold] User %d %+d (%s), balance %d", userID, delta, reason, balance)
+	return balance, nil
+}
+
+// ListGoldTransactions 用户最近的金币流水，最新的在前
+func ListGoldTransactions(userID uint, limit int) ([]models.GoldTransaction, error) {
+	var records []models.GoldTransaction
+	err := database.DB.Where("user_id = ?", userID).
+		Order("created_at DESC, id DESC").
+		Limit(limit).
+		Find(&records).Error
+	return records, err
+}
@@ -0,0 +1,298 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"gin-bot/config"
+	"gin-bot/database"
+	"gin-bot/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// FirstOrCreateGroup 读取群组记录，不存在时按默认开关（GROUP_DEFAULT_ACTIVE / GROUP_DEFAULT_RAG）创建
+// 默认值显式写入，与群组不存在时 IsBotActive 等按同一默认值判断的行为保持一致。
+// 开关字段带 default:true 标签，GORM 用结构体创建时会把 false 替换为默认值，因此用 map 写入
+func FirstOrCreateGroup(groupID int64) (models.Group, error) {
+	var group models.Group
+	err := database.DB.Where("group_id = ?", groupID).First(&group).Error
+	if err == nil || !errors.Is(err, gorm.ErrRecordNotFound) {
+		return group, err
+	}
+
+	sw := defaultGroupSwitches()
+	now := Now()
+	err = database.DB.Model(&models.Group{}).Clauses(clause.OnConflict{DoNothing: true}).
+		Create(map[string]interface{}{
+			"group_id":          groupID,
+			"is_active":         sw.Active,
+			"rag_enabled":       sw.RAG,
+			"proactive_enabled": sw.Proactive,
+			"care_enabled":      sw.Care,
+			"created_at":        now,
+			"updated_at":        now,
+		}).Error
+	if err != nil {
+		return group, err
+	}
+	// 并发创建时以先写入的记录为准
+	err = database.DB.Where("group_id = ?", groupID).First(&group).Error
+	return group, err
+}
+
+// defaultGroupSwitches 群组记录不存在时使用的开关：机器人与记忆按配置，主动插嘴与关怀默认开启
+func defaultGroupSwitches() groupSwitches {
+	sw := groupSwitches{Active: true, RAG: true, Proactive: true, Care: true}
+	if cfg := config.Get(); cfg != nil {
+		sw.Active, sw.RAG = cfg.GroupDefaultActive, cfg.GroupDefaultRAG
+	}
+	return sw
+}
+
+// groupSwitchesTTL 群组开关的缓存时间：每条消息都要检查开关，缓存避免反复查库；
+// 本进程内的开关工具会立即清除缓存，直接改库时最多延迟这么久生效
+const groupSwitchesTTL = 10 * time.Second
+
+// groupSwitches 群组的四个功能开关
+type groupSwitches struct {
+	Active    bool
+	RAG       bool
+	Proactive bool
+	Care      bool
+	loadedAt  time.Time
+}
+
+var (
+	groupSwitchCache   = make(map[int64]groupSwitches)
+	groupSwitchCacheMu sync.RWMutex
+)
+
+// getGroupSwitches 读取群组开关（带缓存），群组不存在时使用默认开关；数据库出错时同样按默认开关处理且不缓存
+func getGroupSwitches(groupID int64) groupSwitches {
+	groupSwitchCacheMu.RLock()
+	cached, ok := groupSwitchCache[groupID]
+	groupSwitchCacheMu.RUnlock()
+	if ok && Since(cached.loadedAt) < groupSwitchesTTL {
+		return cached
+	}
+
+	sw := defaultGroupSwitches()
+	sw.loadedAt = Now()
+	var group models.Group
+	err := database.DB.Where("group_id = ?", groupID).First(&group).Error
+	switch {
+	case err == nil:
+		sw.Active, sw.RAG, sw.Proactive, sw.Care = group.IsActive, group.RAGEnabled, group.ProactiveEnabled, group.CareEnabled
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		log.Printf("[Group] Failed to load switches for group %d: %v", groupID, err)
+		return sw
+	}
+
+	groupSwitchCacheMu.Lock()
+	groupSwitchCache[groupID] = sw
+	groupSwitchCacheMu.Unlock()
+	return sw
+}
+
+// invalidateGroupSwitches 开关修改后清除缓存，下次读取时重新查库
+func invalidateGroupSwitches(groupID int64) {
+	groupSwitchCacheMu.Lock()
+	delete(groupSwitchCache, groupID)
+	groupSwitchCacheMu.Unlock()
+}
+
+// GetGroupConfig 读取群组扩展配置，群组不存在或配置为空时返回零值
+func GetGroupConfig(groupID int64) models.GroupConfig {
+	var cfg models.GroupConfig
+	var group models.Group
+	if err := database.DB.Where("group_id = ?", groupID).First(&group).Error; err != nil {
+		return cfg
+	}
+	if group.Config == "" {
+		return cfg
+	}
+	if err := json.Unmarshal([]byte(group.Config), &cfg); err != nil {
+		log.Printf("[Group] Failed to parse config for group %d: %v", groupID, err)
+	}
+	return cfg
+}
+
+// UpdateGroupConfig 读取-修改-写回群组扩展配置
+func UpdateGroupConfig(groupID int64, fn func(cfg *models.GroupConfig)) (models.GroupConfig, error) {
+	group, err := FirstOrCreateGroup(groupID)
+	if err != nil {
+		return models.GroupConfig{}, err
+	}
+
+	var cfg models.GroupConfig
+	if group.Config != "" {
+		if err := json.Unmarshal([]byte(group.Config), &cfg); err != nil {
+			log.Printf("[Group] Failed to parse config for group %d, resetting: %v", groupID, err)
+		}
+	}
+
+	fn(&cfg)
+
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return cfg, err
+	}
+	return cfg, database.DB.Model(&group).Update("config", string(data)).Error
+}
+
+// IsMemoryBlocked 检查用户是否在群组的记忆黑名单中
+func IsMemoryBlocked(groupID int64, qq string) bool {
+	for _, blocked := range GetGroupConfig(groupID).MemoryBlocklist {
+		if blocked == qq {
+			return true
+		}
+	}
+	return false
+}
+
+// ArchiveSampleRate 群组的归档采样率，未设置时为 1（全部处理）
+func ArchiveSampleRate(groupID int64) float64 {
+	if rate := GetGroupConfig(groupID).ArchiveSampleRate; rate != nil {
+		return *rate
+	}
+	return 1
+}
+
+// UpdateGroupMeta 保存从 OneBot 获取的群名称和成员数
+func UpdateGroupMeta(groupID int64, name string, memberCount int) error {
+	group, err := FirstOrCreateGroup(groupID)
+	if err != nil {
+		return err
+	}
+	if group.GroupName == name && group.MemberCount == memberCount {
+		return nil
+	}
+	return database.DB.Model(&group).Updates(map[string]interface{}{
+		"group_name":   name,
+		"member_count": memberCount,
+	}).Error
+}
+
+// GroupLabel 群组的可读名称，如 "摸鱼群（123456，42 人）"；未同步群信息时为 "群 123456"
+func GroupLabel(group models.Group) string {
+	if group.GroupName == "" {
+		return fmt.Sprintf("群 %d", group.GroupID)
+	}
+	return fmt.Sprintf("%s（%d，%d 人）", group.GroupName, group.GroupID, group.MemberCount)
+}
+
+// 机器人关闭时被 @ 的表现
+const (
+	OffBehaviorSilent = "silent"
+	OffBehaviorNotice = "notice"
+)
+
+// 回复被 @ 的消息时的形式
+const (
+	ReplyStyleAt    = "at"
+	ReplyStyleQuote = "quote"
+	ReplyStyleBoth  = "both"
+)
+
+// ReplyPrefixCQ 群聊回复的开头：按群组配置 @ 对方、引用对方的原消息或两者都用
+// 引用需要原消息 ID，缺失时退回 @；引用 CQ 码必须位于消息开头
+func ReplyPrefixCQ(groupID int64, userID int64, messageID int64) string {
+	style := GetGroupConfig(groupID).ReplyStyle
+	if messageID == 0 {
+		style = ReplyStyleAt
+	}
+	quote := fmt.Sprintf("[CQ:reply,id=%d]", messageID)
+	switch style {
+	case ReplyStyleQuote:
+		return quote
+	case ReplyStyleBoth:
+		return quote + MentionCQ(userID) + " "
+	}
+	return MentionCQ(userID) + " "
+}
+
+// offNoticeCooldown 同一群内"已静音"提示的最短间隔
+const offNoticeCooldown = 10 * time.Minute
+
+// offNoticeText 机器人关闭时被 @ 的提示
+const offNoticeText = "我现在被管理员静音了，暂时不能回复哦～"
+
+var (
+	offNoticeLast = make(map[int64]time.Time) // groupID -> 上次提示时间
+	offNoticeMu   sync.Mutex
+)
+
+// GetOffNotice 机器人关闭时被 @ 应回复的提示；群组配置为静默或仍在冷却中时返回 false
+func GetOffNotice(groupID int64) (string, bool) {
+	if GetGroupConfig(groupID).OffBehavior != OffBehaviorNotice {
+		return "", false
+	}
+
+	offNoticeMu.Lock()
+	defer offNoticeMu.Unlock()
+	if Since(offNoticeLast[groupID]) < offNoticeCooldown {
+		return "", false
+	}
+	offNoticeLast[groupID] = Now()
+	return offNoticeText, true
+}
+
+// IsToolAllowed 按群组工具白名单判断工具是否可用：白名单为空时全部可用
+func IsToolAllowed(cfg models.GroupConfig, toolName string) bool {
+	if len(cfg.EnabledTools) == 0 {
+		return true
+	}
+	for _, name := range cfg.EnabledTools {
+		if name == toolName {
+			return true
+		}
+	}
+	return false
+}
+
+// maxGroupThemeLength 群主题的最大字数
+const maxGroupThemeLength = 30
+
+// groupThemeBlock 群主题的提示词片段，未设置主题（或私聊）时返回空
+func groupThemeBlock(groupID int64) string {
+	if groupID == 0 {
+		return ""
+	}
+	theme := GetGroupConfig(groupID).Theme
+	if theme == "" {
+		return ""
+	}
+	return fmt.Sprintf("\n【群聊主题】：这是一个关于%s的群，说话时贴合群里的氛围和常聊的话题。", theme)
+}
+
+// maxGroupLanguageLength 回复语言描述的最大字数
+const maxGroupLanguageLength = 20
+
+// groupPersonaBlock 群组人设的提示词片段（主题 + 回复语言），被 @ 回复、插嘴、接话和主动关怀共用，
+// 保证机器人在同一个群里的语气一致；未设置（或私聊）时返回空
+func groupPersonaBlock(groupID int64) string {
+	if groupID == 0 {
+		return ""
+	}
+	block := groupThemeBlock(groupID)
+	if lang := GetGroupConfig(groupID).Language; lang != "" {
+		block += fmt.Sprintf("\n【回复语言】：本群使用%s交流，无论下面的说明用什么语言写成，都请只用%s回复。", lang, lang)
+	}
+	return block
+}
+
+// MaxReplyTokens 群组的回复长度上限（token），未设置时使用全局配置 MAX_REPLY_TOKENS
+func MaxReplyTokens(groupID int64) int {
+	if groupID != 0 {
+		if n := GetGroupConfig(groupID).MaxReplyTokens; n > 0 {
+			return n
+		}
+	}
+	return config.Get().MaxReplyTokens
+}
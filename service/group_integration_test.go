@@ -0,0 +1,86 @@
+//go:build integration
+
+package service
+
+import (
+	"math/rand"
+	"os"
+	"testing"
+
+	"gin-bot/config"
+	"gin-bot/database"
+	"gin-bot/models"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setupIntegrationDB 连接 TEST_DB_DSN 指定的 Postgres（未设置时跳过），并迁移测试用到的表。
+// 运行方式：TEST_DB_DSN="host=... dbname=gin_bot_test ..." go test -tags integration ./service
+func setupIntegrationDB(t *testing.T) *config.Config {
+	t.Helper()
+	dsn := os.Getenv("TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DB_DSN not set")
+	}
+	cfg := setupTestEnv(t)
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("connect %s: %v", dsn, err)
+	}
+	if err := db.AutoMigrate(&models.Group{}); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	database.DB = db
+	return cfg
+}
+
+// testGroupID 随机的测试群号，测试结束后删除对应记录
+func testGroupID(t *testing.T) int64 {
+	groupID := -1 - rand.Int63n(1<<40) // 负数群号不会与真实群冲突
+	t.Cleanup(func() {
+		database.DB.Unscoped().Where("group_id = ?", groupID).Delete(&models.Group{})
+		invalidateGroupSwitches(groupID)
+	})
+	return groupID
+}
+
+func TestFirstOrCreateGroupDefaultsOn(t *testing.T) {
+	cfg := setupIntegrationDB(t)
+	cfg.GroupDefaultActive, cfg.GroupDefaultRAG = true, true
+	groupID := testGroupID(t)
+
+	group, err := FirstOrCreateGroup(groupID)
+	if err != nil {
+		t.Fatalf("FirstOrCreateGroup: %v", err)
+	}
+	if !group.IsActive || !group.RAGEnabled || !group.ProactiveEnabled || !group.CareEnabled {
+		t.Errorf("new group switches = active:%v rag:%v proactive:%v care:%v, want all true",
+			group.IsActive, group.RAGEnabled, group.ProactiveEnabled, group.CareEnabled)
+	}
+
+	// 再次调用读取已有记录，不重复创建
+	again, err := FirstOrCreateGroup(groupID)
+	if err != nil || again.GroupID != groupID || !again.CreatedAt.Equal(group.CreatedAt) {
+		t.Errorf("second call = %+v, %v; want existing row", again, err)
+	}
+}
+
+func TestFirstOrCreateGroupDefaultsOff(t *testing.T) {
+	cfg := setupIntegrationDB(t)
+	cfg.GroupDefaultActive, cfg.GroupDefaultRAG = false, false
+	groupID := testGroupID(t)
+
+	group, err := FirstOrCreateGroup(groupID)
+	if err != nil {
+		t.Fatalf("FirstOrCreateGroup: %v", err)
+	}
+	// default:true 标签不能把显式写入的 false 替换掉
+	if group.IsActive || group.RAGEnabled {
+		t.Errorf("new group active:%v rag:%v, want both false", group.IsActive, group.RAGEnabled)
+	}
+	if !group.ProactiveEnabled || !group.CareEnabled {
+		t.Errorf("new group proactive:%v care:%v, want both true", group.ProactiveEnabled, group.CareEnabled)
+	}
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"gin-bot/database"
+)
+
+// WSStatus 提供 OneBot 连接状态，由 main 注入（驱动不在 service 包内）
+type WSStatus interface {
+	Connected() bool
+	DownSince() time.Time
+}
+
+// StartHealthServer 启动健康检查 HTTP 服务（addr 为空则不启动）：
+// /healthz 进程存活即返回 200；/readyz 在 OneBot 连接断开或数据库不可用时返回 503，
+// 响应正文逐行列出各项检查结果
+func StartHealthServer(addr string, ws WSStatus) {
+	if addr == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		ready, lines := readiness(r.Context(), ws)
+		if !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		fmt.Fprintln(w, strings.Join(lines, "\n"))
+	})
+
+	go func() {
+		log.Printf("[Health] Listening on %s", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("[Health] Server stopped: %v", err)
+		}
+	}()
+}
+
+// readiness 检查 OneBot 连接和数据库；Redis 缺失时机器人可降级运行，不影响就绪状态
+func readiness(ctx context.Context, ws WSStatus) (bool, []string) {
+	ready := true
+	var lines []string
+
+	if ws.Connected() {
+		lines = append(lines, "onebot: ok")
+	} else {
+		ready = false
+		line := "onebot: disconnected"
+		if since := ws.DownSince(); !since.IsZero() {
+			line += fmt.Sprintf(" for %s", time.Since(since).Round(time.Second))
+		}
+		lines = append(lines, line)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	if sqlDB, err := database.DB.DB(); err != nil {
+		ready = false
+		lines = append(lines, "database: "+err.Error())
+	} else if err := sqlDB.PingContext(ctx); err != nil {
+		ready = false
+		lines = append(lines, "database: "+err.Error())
+	} else {
+		lines = append(lines, "database: ok")
+	}
+
+	if database.Redis() == nil {
+		lines = append(lines, "redis: disabled")
+	} else if err := database.Redis().Ping(ctx).Err(); err != nil {
+		lines = append(lines, "redis: "+err.Error()+" (degraded)")
+	} else {
+		lines = append(lines, "redis: ok")
+	}
+
+	return ready, lines
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"gin-bot/config"
+	"gin-bot/database"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setupTestEnv 为测试准备最小运行环境：默认配置，以及不连接数据库的 GORM（DryRun，查询均返回空结果）。
+// 测试结束后恢复原来的配置与数据库连接
+func setupTestEnv(t *testing.T) *config.Config {
+	t.Helper()
+	prevCfg, prevDB, prevRDB := config.Get(), database.DB, database.Redis()
+	t.Cleanup(func() {
+		config.Set(prevCfg)
+		database.DB = prevDB
+		database.SetRedis(prevRDB)
+	})
+
+	cfg := &config.Config{
+		BotName:         "小黄",
+		Location:        time.FixedZone("CST", 8*3600),
+		MaxReplyTokens:  512,
+		ToolMaxParallel: 4,
+		FCActionCheck:   ActionCheckOff,
+	}
+	config.Set(cfg)
+
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=127.0.0.1"}), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+		Logger:               logger.Discard,
+	})
+	if err != nil {
+		t.Fatalf("open dry-run db: %v", err)
+	}
+	database.DB = db
+	database.SetRedis(nil)
+	return cfg
+}
+
+// useMockClock 把全局时钟替换为停在 start 的 MockClock，测试结束后恢复系统时钟
+func useMockClock(t *testing.T, start time.Time) *MockClock {
+	t.Helper()
+	clk := NewMockClock(start)
+	SetClock(clk)
+	t.Cleanup(func() { SetClock(nil) })
+	return clk
+}
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"gin-bot/database"
+)
+
+// lastReplyTTL 最近一次回复的缓存时间（只用于"你刚说啥"重发，过期后重新提问即可）
+const lastReplyTTL = 10 * time.Minute
+
+// repeatRequestRegex 请求机器人重复上一句回复的说法
+var repeatRequestRegex = regexp.MustCompile(`^(你)?(刚才?|刚刚)?(说|讲)(了)?(啥|什么|的啥|的什么)[?？!！。~]*$|^(再|重新)(说|发)(一遍|一次|一下)[?？!！。~]*$|^没(看到|收到|看见)[?？!！。~]*$`)
+
+// lastReplyKey 按群+用户缓存最近一次回复的 Key
+func lastReplyKey(groupID int64, userID int64) string {
+	return fmt.Sprintf("bot:last_reply:%d:%d", groupID, userID)
+}
+
+// IsRepeatRequest 判断消息（已去掉 @ 和称呼）是否在请求重复上一句，如"你刚说啥""再说一遍"
+func IsRepeatRequest(prompt string) bool {
+	return repeatRequestRegex.MatchString(strings.TrimSpace(prompt))
+}
+
+// CacheLastReply 缓存发给该用户的最近一次回复，供重发使用
+func CacheLastReply(groupID int64, userID int64, reply string) {
+	if database.Redis() == nil || reply == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := database.Redis().Set(ctx, lastReplyKey(groupID, userID), reply, lastReplyTTL).Err(); err != nil {
+		log.Printf("[Chat] Failed to cache last reply: %v", err)
+	}
+}
+
+// GetLastReply 读取发给该用户的最近一次回复（不重新生成）
+func GetLastReply(groupID int64, userID int64) (string, bool) {
+	if database.Redis() == nil {
+		return "", false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	reply, err := database.Redis().Get(ctx, lastReplyKey(groupID, userID)).Result()
+	if err != nil || reply == "" {
+		return "", false
+	}
+	return reply, true
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"gin-bot/config"
+)
+
+// reservedRequestFields 请求体中由代码决定的字段，额外参数不会覆盖
+var reservedRequestFields = map[string]bool{
+	"model":       true,
+	"messages":    true,
+	"tools":       true,
+	"tool_choice": true,
+}
+
+// marshalChatRequest 序列化 Chat Completions 请求体，并合并 MODEL_EXTRA_PARAMS 中该模型（及 "*"）的额外参数
+// 额外参数只补充请求中没有的字段：temperature、max_tokens 等仍由场景和群组配置决定
+func marshalChatRequest(reqBody any) ([]byte, error) {
+	data, err := json.Marshal(reqBody)
+	if err != nil || len(config.Get().ModelExtraParams) == 0 {
+		return data, err
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, err
+	}
+	model, _ := body["model"].(string)
+	for _, key := range []string{model, "*"} {
+		for k, v := range config.Get().ModelExtraParams[key] {
+			if _, exists := body[k]; !exists && !reservedRequestFields[k] {
+				body[k] = v
+			}
+		}
+	}
+	return json.Marshal(body)
+}
+
+// setLLMHeaders 设置模型请求的通用请求头（含 LLM_EXTRA_HEADERS）
+func setLLMHeaders(req *http.Request) {
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+config.Get().NvidiaAPIKey)
+	for k, v := range config.Get().LLMExtraHeaders {
+		req.Header.Set(k, v)
+	}
+}
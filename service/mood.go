@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"gin-bot/config"
+	"gin-bot/database"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// moodSmoothing 每条消息的情绪在对话情绪中所占的权重：越小越"记仇"，单条消息难以让情绪突然翻转
+const moodSmoothing = 0.4
+
+// moodThreshold 对话情绪超过该值（绝对值）时才影响回复语气
+const moodThreshold = 0.3
+
+// moodKey 对话情绪的 Key（私聊 groupID 为 0）
+func moodKey(groupID int64, userID int64) string {
+	return fmt.Sprintf("bot:mood:%d:%d", groupID, userID)
+}
+
+// sentimentValue 分类器输出的情绪标签转为数值，无法识别时返回 false
+func sentimentValue(label string) (float64, bool) {
+	switch strings.ToLower(strings.TrimSpace(label)) {
+	case "positive":
+		return 1, true
+	case "negative":
+		return -1, true
+	case "neutral":
+		return 0, true
+	}
+	return 0, false
+}
+
+// UpdateConversationMood 用一条消息的情绪更新该群友的对话情绪（指数平滑，范围 -1 ~ 1）
+// 情绪在 MOOD_TTL 内没有新消息即过期，下次对话重新开始；MOOD_TTL 为 0 或 Redis 不可用时不记录
+func UpdateConversationMood(groupID int64, userID int64, sentiment string) {
+	ttl := config.Get().MoodTTL
+	if database.Redis() == nil || ttl <= 0 || userID == 0 {
+		return
+	}
+	value, ok := sentimentValue(sentiment)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	key := moodKey(groupID, userID)
+
+	mood := value
+	if prev, err := database.Redis().Get(ctx, key).Float64(); err == nil {
+		mood = prev*(1-moodSmoothing) + value*moodSmoothing
+	} else if !errors.Is(err, redis.Nil) {
+		log.Printf("[Mood] Failed to read mood for %d in group %d: %v", userID, groupID, err)
+		return
+	}
+
+	if err := database.Redis().Set(ctx, key, strconv.FormatFloat(mood, 'f', 3, 64), ttl).Err(); err != nil {
+		log.Printf("[Mood] Failed to save mood for %d in group %d: %v", userID, groupID, err)
+	}
+}
+
+// GetConversationMood 读取该群友当前的对话情绪，没有记录时返回 false
+func GetConversationMood(groupID int64, userID int64) (float64, bool) {
+	if database.Redis() == nil || userID == 0 {
+		return 0, false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	mood, err := database.Redis().Get(ctx, moodKey(groupID, userID)).Float64()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			log.Printf("[Mood] Failed to read mood for %d in group %d: %v", userID, groupID, err)
+		}
+		return 0, false
+	}
+	return mood, true
+}
+
+// moodVibePrompt 根据对话情绪生成语气提示，让几轮对话里的语气保持一致；情绪平稳时返回空
+func moodVibePrompt(groupID int64, userID int64) string {
+	mood, ok := GetConversationMood(groupID, userID)
+	switch {
+	case !ok:
+		return ""
+	case mood <= -moodThreshold:
+		return "\n**[🌧 情绪延续]**：这几轮聊下来对方情绪一直不太好，保持耐心和温柔，别突然开玩笑或损人。"
+	case mood >= moodThreshold:
+		return "\n**[☀️ 情绪延续]**：这几轮聊得挺开心，继续保持轻松活泼的语气。"
+	}
+	return ""
+}
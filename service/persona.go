@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gin-bot/config"
+	"gin-bot/database"
+	"gin-bot/models"
+	"gin-bot/pinecone"
+
+	"gorm.io/gorm"
+)
+
+// personaFactLimit 生成人设摘要时最多参考的个人信息条数
+const personaFactLimit = 20
+
+// RunNightlyPersonaUpdate 为自上次摘要后有新个人信息的用户重新生成人设摘要
+// 每个用户调用一次 LLM，调用之间按配置间隔限流；ctx 取消时提前结束
+func RunNightlyPersonaUpdate(ctx context.Context) {
+	if database.DB == nil {
+		return
+	}
+
+	var users []models.User
+	err := personalMemoryQuery().
+		Where("users.persona_at IS NULL OR member_embeddings.created_at > users.persona_at").
+		Select("users.*").
+		Group("users.id").
+		Limit(config.Get().PersonaUpdateBatch).
+		Find(&users).Error
+	if err != nil {
+		log.Printf("[Persona] Failed to find users to update: %v", err)
+		return
+	}
+	if len(users) == 0 {
+		log.Println("[Persona] No users need persona update")
+		return
+	}
+
+	log.Printf("[Persona] Updating persona for %d users", len(users))
+	updated := 0
+	for i, user := range users {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				log.Printf("[Persona] Cancelled after %d/%d users", updated, len(users))
+				return
+			case <-time.After(config.Get().PersonaUpdateInterval):
+			}
+		}
+
+		if err := updateUserPersona(&user); err != nil {
+			log.Printf("[Persona] Failed to update persona for %s: %v", user.QQ, err)
+			continue
+		}
+		updated++
+	}
+	log.Printf("[Persona] Nightly update finished: %d/%d users updated", updated, len(users))
+}
+
+// personalMemoryQuery 用户 ⋈ 原始消息 ⋈ 个人信息向量记录
+func personalMemoryQuery() *gorm.DB {
+	return database.DB.Model(&models.User{}).
+		Joins("JOIN chat_histories ON chat_histories.user_id = users.id").
+		Joins("JOIN member_embeddings ON member_embeddings.ref_msg_id = chat_histories.id").
+		Where("member_embeddings.namespace = ?", pinecone.NamespacePersonal)
+}
+
+// updateUserPersona 基于用户最近的个人信息和旧摘要生成新的人设摘要
+func updateUserPersona(user *models.User) error {
+	var facts []string
+	err := personalMemoryQuery().
+		Where("users.id = ?", user.ID).
+		Order("member_embeddings.created_at DESC").
+		Limit(personaFactLimit).
+		Pluck("member_embeddings.content_summary", &facts).Error
+	if err != nil {
+		return err
+	}
+	if len(facts) == 0 {
+		return fmt.Errorf("no personal facts")
+	}
+
+	prompt := fmt.Sprintf(`你是一个群聊观察员。请根据下面这位群友的旧人设摘要和最近提到的个人信息，写一段新的人设摘要。
+
+### 要求：
+1. 100 字以内，第三人称，只写稳定的特征（职业、爱好、性格、身份等）。
+2. 新信息与旧摘要冲突时以新信息为准。
+3. 只输出摘要本身。
+
+### 旧摘要：
+%s
+
+### 最近的个人信息：
+%s`, user.Persona, "- "+strings.Join(facts, "\n- "))
+
+	persona, err := callNvidiaAPI([]ChatMessage{{Role: "user", Content: prompt}}, CLASSIFIER_MODEL)
+	if err != nil {
+		return err
+	}
+	persona = strings.TrimSpace(persona)
+	if persona == "" {
+		return fmt.Errorf("empty persona")
+	}
+
+	now := Now()
+	return database.DB.Model(user).Updates(map[string]interface{}{
+		"persona":    persona,
+		"persona_at": &now,
+	}).Error
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"gin-bot/database"
+	"gin-bot/models"
+	"gin-bot/pinecone"
+)
+
+// pinnedScoreBoost 置顶记忆在检索时额外加的相似度，让重要事实更容易被想起
+const pinnedScoreBoost = 0.1
+
+// pinMemoryMinScore 按描述查找要置顶的记忆时的最低相似度
+const pinMemoryMinScore = 0.7
+
+// pinnedListLimit 列出置顶记忆时的最大条数
+const pinnedListLimit = 20
+
+// boostPinned 置顶记忆的检索分数加权（不超过 1）
+func boostPinned(score float32, pinned bool) float32 {
+	if !pinned {
+		return score
+	}
+	return min(score+pinnedScoreBoost, 1)
+}
+
+// pinnedMark 回忆片段中置顶记忆的标记，提示模型这是必须记住的事实
+func pinnedMark(pinned bool) string {
+	if pinned {
+		return "【重要】"
+	}
+	return ""
+}
+
+// pinnedHiddenText 群里展示未共享的个人信息时的占位文字
+const pinnedHiddenText = "（未共享的个人信息）"
+
+// FindMemoryToPin 按描述在本群（groupID 为 0 时不限群）的聊天记录和个人信息中查找最相近的一条记忆；
+// 在群里只查找已共享的个人信息，避免按描述找出并公开他人的私密信息
+func FindMemoryToPin(ctx context.Context, description string, groupID int64) (models.MemberEmbedding, error) {
+	var best models.MemberEmbedding
+	queryVec, err := getQueryEmbedding(ctx, description)
+	if err != nil {
+		return best, err
+	}
+
+	var bestScore float32
+	for _, ns := range []string{pinecone.NamespaceChat, pinecone.NamespacePersonal} {
+		var filter map[string]interface{}
+		if groupID != 0 {
+			filter = map[string]interface{}{"group_id": groupID}
+			if ns == pinecone.NamespacePersonal {
+				filter["shared"] = true
+			}
+		}
+		matches, err := pinecone.QueryWithScore(ctx, ns, queryVec, 1+queryTopKSlack, filter)
+		if err != nil {
+			log.Printf("[Pin] Query %s namespace failed: %v", ns, err)
+			continue
+		}
+		for _, m := range matches {
+			if m.Score < pinMemoryMinScore || m.Score <= bestScore {
+				continue
+			}
+			var emb models.MemberEmbedding
+			if database.DB.Where("vector_id = ?", m.ID).First(&emb).Error != nil {
+				continue
+			}
+			best, bestScore = emb, m.Score
+		}
+	}
+	if best.ID == 0 {
+		return best, errors.New("memory not found")
+	}
+	return best, nil
+}
+
+// privateInGroup 记忆是否为群里（groupID 不为 0）不能公开的未共享个人信息
+func privateInGroup(emb models.MemberEmbedding, groupID int64) bool {
+	return groupID != 0 && emb.Namespace == pinecone.NamespacePersonal && !emb.Shared
+}
+
+// pinnedDisplayText 展示记忆内容，群里隐藏未共享的个人信息
+func pinnedDisplayText(emb models.MemberEmbedding, groupID int64) string {
+	if privateInGroup(emb, groupID) {
+		return pinnedHiddenText
+	}
+	return emb.ContentSummary
+}
+
+// SetMemoryPinned 置顶或取消置顶一条记忆：先更新 Pinecone metadata，成功后再写数据库
+func SetMemoryPinned(ctx context.Context, emb *models.MemberEmbedding, pinned bool) error {
+	if err := pinecone.UpdateMetadata(ctx, emb.Namespace, emb.VectorID, map[string]interface{}{"pinned": pinned}); err != nil {
+		return fmt.Errorf("failed to update vector %s: %v", emb.VectorID, err)
+	}
+	if err := database.DB.Model(emb).Update("pinned", pinned).Error; err != nil {
+		return err
+	}
+	log.Printf("[Pin] Memory %s pinned=%v", emb.VectorID, pinned)
+	return nil
+}
+
+// ListPinnedMemories 列出本群（groupID 为 0 时为全部）的置顶记忆，最新的在前
+func ListPinnedMemories(groupID int64) ([]models.MemberEmbedding, error) {
+	query := database.DB.Preload("RefMsg").
+		Where("pinned = ?", true).
+		Order("created_at DESC").
+		Limit(pinnedListLimit)
+	if groupID != 0 {
+		query = query.Where("ref_msg_id IN (?)",
+			database.DB.Model(&models.ChatHistory{}).Unscoped().Select("id").Where("group_id = ?", groupID))
+	}
+	var records []models.MemberEmbedding
+	err := query.Find(&records).Error
+	return records, err
+}
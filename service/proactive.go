@@ -0,0 +1,218 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gin-bot/config"
+	"gin-bot/database"
+
+	redis "github.com/redis/go-redis/v9"
+)
+
+// proactiveCountKey 群组当天主动插嘴次数的 Redis Key（按机器人时区的日期划分）
+func proactiveCountKey(groupID int64, day time.Time) string {
+	return fmt.Sprintf("bot:proactive_count:%d:%s", groupID, day.Format("20060102"))
+}
+
+// ProactiveDailyCap 群组每天主动插嘴的上限，未单独设置时使用全局默认值（0 表示不限制）
+func ProactiveDailyCap(groupID int64) int {
+	if limit := GetGroupConfig(groupID).ProactiveDailyCap; limit != nil {
+		return *limit
+	}
+	return config.Get().ProactiveDailyCap
+}
+
+// ProactiveCapReached 群组今天的主动插嘴次数是否已达上限；Redis 不可用时不限制
+func ProactiveCapReached(groupID int64) bool {
+	limit := ProactiveDailyCap(groupID)
+	if limit <= 0 || database.Redis() == nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	count, err := database.Redis().Get(ctx, proactiveCountKey(groupID, Now().In(botLocation()))).Int()
+	if err != nil {
+		return false
+	}
+	return count >= limit
+}
+
+// proactiveCooldown 同一群两次主动插嘴的最短间隔
+const proactiveCooldown = 5 * time.Minute
+
+var (
+	proactiveLast   = make(map[int64]time.Time) // 群号 -> 上次主动插嘴的时间
+	proactiveLastMu sync.Mutex
+)
+
+// ProactiveCoolingDown 群组是否还在主动插嘴的冷却期内（距上次插嘴不足 proactiveCooldown）
+func ProactiveCoolingDown(groupID int64) bool {
+	proactiveLastMu.Lock()
+	defer proactiveLastMu.Unlock()
+	last, ok := proactiveLast[groupID]
+	return ok && Since(last) < proactiveCooldown
+}
+
+// InQuietHours 当前是否处于免打扰时段（PROACTIVE_QUIET_HOURS），期间不主动插嘴、不随口接话
+func InQuietHours() bool {
+	start, end, ok := parseQuietHours(config.Get().ProactiveQuietHours)
+	if !ok {
+		return false
+	}
+	hour := Now().In(botLocation()).Hour()
+	if start < end {
+		return hour >= start && hour < end
+	}
+	return hour >= start || hour < end // 跨午夜，如 23-8
+}
+
+// parseQuietHours 解析 "23-8" 形式的时段，留空、格式不对或起止相同时返回 false
+func parseQuietHours(spec string) (int, int, bool) {
+	from, to, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false
+	}
+	start, err1 := strconv.Atoi(strings.TrimSpace(from))
+	end, err2 := strconv.Atoi(strings.TrimSpace(to))
+	if err1 != nil || err2 != nil || start < 0 || start > 23 || end < 0 || end > 24 || start == end {
+		return 0, 0, false
+	}
+	return start, end % 24, true
+}
+
+// groupActivityKey 群组最近消息时间的有序集合 Key（用于判断群是否活跃）
+func groupActivityKey(groupID int64) string {
+	return fmt.Sprintf("bot:group_activity:%d", groupID)
+}
+
+// groupLastActiveKey 群组最近有消息的标记（沉寂超过阈值后自动过期）
+func groupLastActiveKey(groupID int64) string {
+	return fmt.Sprintf("bot:group_last_active:%d", groupID)
+}
+
+// groupWarmupKey 群组冷场后重新活跃的预热期标记
+func groupWarmupKey(groupID int64) string {
+	return fmt.Sprintf("bot:group_warmup:%d", groupID)
+}
+
+// RecordGroupActivity 记录一条群消息：更新最近活跃时间（沉寂后重新活跃时进入预热期），
+// 并在开启活跃度门槛时记录窗口内的消息
+func RecordGroupActivity(groupID int64) {
+	recordGroupWake(groupID)
+
+	window := config.Get().ProactiveActivityWindow
+	if config.Get().ProactiveMinMessages <= 0 || window <= 0 || database.Redis() == nil {
+		return
+	}
+	now := Now()
+	key := groupActivityKey(groupID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	pipe := database.Redis().TxPipeline()
+	pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.UnixMilli()), Member: now.UnixNano()})
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.Add(-window).UnixMilli(), 10))
+	pipe.Expire(ctx, key, window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("[Proactive] Failed to record activity for group %d: %v", groupID, err)
+	}
+}
+
+// recordGroupWake 刷新群组的最近活跃标记；标记已过期（沉寂超过 PROACTIVE_IDLE_THRESHOLD）说明群刚被唤醒，开始预热期
+func recordGroupWake(groupID int64) {
+	idle, warmup := config.Get().ProactiveIdleThreshold, config.Get().ProactiveWarmup
+	if idle <= 0 || warmup <= 0 || database.Redis() == nil {
+		return
+	}
+	key := groupLastActiveKey(groupID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	pipe := database.Redis().TxPipeline()
+	exists := pipe.Exists(ctx, key)
+	pipe.Set(ctx, key, Now().Unix(), idle)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("[Proactive] Failed to record last activity for group %d: %v", groupID, err)
+		return
+	}
+	if exists.Val() == 0 {
+		if err := database.Redis().Set(ctx, groupWarmupKey(groupID), 1, warmup).Err(); err != nil {
+			log.Printf("[Proactive] Failed to start warm-up for group %d: %v", groupID, err)
+		}
+	}
+}
+
+// GroupWarmingUp 群组是否处于冷场后的预热期（期间不主动插嘴）；Redis 不可用时不限制
+func GroupWarmingUp(groupID int64) bool {
+	if config.Get().ProactiveIdleThreshold <= 0 || config.Get().ProactiveWarmup <= 0 || database.Redis() == nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	n, err := database.Redis().Exists(ctx, groupWarmupKey(groupID)).Result()
+	return err == nil && n > 0
+}
+
+// GroupActiveEnough 群组最近是否足够活跃，冷清的群不主动插嘴；Redis 不可用时不限制
+func GroupActiveEnough(groupID int64) bool {
+	minMessages := config.Get().ProactiveMinMessages
+	window := config.Get().ProactiveActivityWindow
+	if minMessages <= 0 || window <= 0 || database.Redis() == nil {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	since := strconv.FormatInt(Now().Add(-window).UnixMilli(), 10)
+	count, err := database.Redis().ZCount(ctx, groupActivityKey(groupID), since, "+inf").Result()
+	if err != nil {
+		return true
+	}
+	return count >= int64(minMessages)
+}
+
+// RecordProactiveReply 记录一次主动插嘴：开始本群的冷却，并累加当天次数（计数在当地午夜后自动过期）
+func RecordProactiveReply(groupID int64) {
+	proactiveLastMu.Lock()
+	proactiveLast[groupID] = Now()
+	proactiveLastMu.Unlock()
+
+	if database.Redis() == nil {
+		return
+	}
+	now := Now().In(botLocation())
+	midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+	key := proactiveCountKey(groupID, now)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	pipe := database.Redis().TxPipeline()
+	pipe.Incr(ctx, key)
+	pipe.ExpireAt(ctx, key, midnight.Add(time.Hour))
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("[Proactive] Failed to record interjection for group %d: %v", groupID, err)
+	}
+}
+
+// EvaluateProactive 回放主动性判断（调参用）：把一条消息送入插嘴检索与关怀分类，
+// 返回是否会主动插嘴（最相关记忆的相似度达到阈值）、相似度、是否会触发关怀随访及分类原因。
+// 按当前的记忆与配置评估，不受冷却、每日上限、群开关等限制，也不会发送消息或创建任务
+func EvaluateProactive(content string, groupID, userID int64) (wouldInterject bool, score float32, wouldCare bool, reason string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, s, err := findProactiveMemory(ctx, content, groupID, userID, content); err != nil {
+		log.Printf("[Proactive] Evaluate: retrieval failed: %v", err)
+	} else {
+		score = s
+		wouldInterject = s >= proactiveScoreThreshold
+	}
+
+	cls := parseClassification(classifyWithAI(content))
+	return wouldInterject, score, cls.Proactive, cls.Reason
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInQuietHours(t *testing.T) {
+	tests := []struct {
+		spec string
+		hour int
+		want bool
+	}{
+		{"0-8", 0, true},
+		{"0-8", 7, true},
+		{"0-8", 8, false},
+		{"0-8", 23, false},
+		{"23-8", 23, true},
+		{"23-8", 3, true},
+		{"23-8", 8, false},
+		{"23-8", 22, false},
+		{"22-24", 23, true},
+		{"22-24", 0, false},
+		{" 1 - 6 ", 5, true},
+		{"", 3, false},
+		{"8-8", 8, false},
+		{"night", 3, false},
+		{"25-8", 3, false},
+	}
+	for _, tt := range tests {
+		cfg := setupTestEnv(t)
+		cfg.ProactiveQuietHours = tt.spec
+		useMockClock(t, time.Date(2024, 5, 1, tt.hour, 30, 0, 0, cfg.Location))
+		if got := InQuietHours(); got != tt.want {
+			t.Errorf("InQuietHours(%q) at %02d:30 = %v, want %v", tt.spec, tt.hour, got, tt.want)
+		}
+	}
+}
+
+func TestProactiveCooldown(t *testing.T) {
+	cfg := setupTestEnv(t)
+	clk := useMockClock(t, time.Date(2024, 5, 1, 12, 0, 0, 0, cfg.Location))
+	const groupID = -42
+
+	if ProactiveCoolingDown(groupID) {
+		t.Fatal("cooling down before any interjection")
+	}
+	RecordProactiveReply(groupID)
+	t.Cleanup(func() {
+		proactiveLastMu.Lock()
+		delete(proactiveLast, groupID)
+		proactiveLastMu.Unlock()
+	})
+	if !ProactiveCoolingDown(groupID) {
+		t.Error("not cooling down right after an interjection")
+	}
+	clk.Advance(proactiveCooldown - time.Second)
+	if !ProactiveCoolingDown(groupID) {
+		t.Error("cooldown ended early")
+	}
+	clk.Advance(time.Second)
+	if ProactiveCoolingDown(groupID) {
+		t.Error("still cooling down after proactiveCooldown")
+	}
+}
@@ -4,14 +4,18 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math/rand"
 	"net/http"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"gin-bot/config"
 	"gin-bot/database"
@@ -23,44 +27,111 @@ import (
 // 轻量 AI 分类器模型
 const CLASSIFIER_MODEL = "mistralai/ministral-14b-instruct-2512"
 
-// 个人信息关键词模式（降级使用）
-var personalPatterns = []*regexp.Regexp{
-	regexp.MustCompile(`我(喜欢|爱|讨厌|不喜欢|偏好)`),
-	regexp.MustCompile(`我(是|叫|名字)`),
-	regexp.MustCompile(`我的(爱好|兴趣|习惯|工作|职业|年龄|生日)`),
-	regexp.MustCompile(`(我今年|我属|我住在|我来自)`),
+// PatternPack 一组按语言划分的正则分类规则（AI 分类器不可用时降级使用）
+type PatternPack struct {
+	Lang      string
+	Personal  []*regexp.Regexp // 持久性个人信息
+	Temporary []*regexp.Regexp // 临时状态（优先于个人信息判断）
+}
+
+var (
+	patternPacks   = []PatternPack{zhPatternPack, enPatternPack}
+	patternPacksMu sync.RWMutex
+	customPackOnce sync.Once
+)
+
+// zhPatternPack 中文个人信息模式
+var zhPatternPack = PatternPack{
+	Lang: "zh",
+	Personal: []*regexp.Regexp{
+		regexp.MustCompile(`我(喜欢|爱|讨厌|不喜欢|偏好)`),
+		regexp.MustCompile(`我(是|叫|名字)`),
+		regexp.MustCompile(`我的(爱好|兴趣|习惯|工作|职业|年龄|生日)`),
+		regexp.MustCompile(`(我今年|我属|我住在|我来自)`),
+	},
+	Temporary: []*regexp.Regexp{
+		regexp.MustCompile(`(先|要|准备|得)?去(洗澡|睡觉|睡了|吃饭|上班|上课|开会|做饭|买菜|健身|跑步|拿快递|取快递|遛狗|打游戏|午睡)`),
+		regexp.MustCompile(`(正在|在)(忙|开会|上课|加班|吃饭|洗澡|路上|排队|睡觉|通勤)`),
+		regexp.MustCompile(`(困|饿|累|渴|冷|热|烦|饱|撑)(了|死了|炸了)`),
+		regexp.MustCompile(`(刚|刚刚)(起床|睡醒|下班|放学|到家|吃完)`),
+		regexp.MustCompile(`(先撤|溜了|下线了|先下了|晚安)`),
+	},
+}
+
+// enPatternPack 英文个人信息模式
+var enPatternPack = PatternPack{
+	Lang: "en",
+	Personal: []*regexp.Regexp{
+		regexp.MustCompile(`(?i)\bi\s*(?:'?m|am)\s+(?:a|an)\s+\w+`),
+		regexp.MustCompile(`(?i)\bi\s+(?:like|love|hate|prefer|enjoy|dislike)\b`),
+		regexp.MustCompile(`(?i)\bmy\s+(?:name|birthday|job|hobby|hobbies|favou?rite|age)\b`),
+		regexp.MustCompile(`(?i)\b(?:i\s*(?:'?m|am)\s+from|i\s+live\s+in|i\s+work\s+(?:as|at|in)|call\s+me)\b`),
+		regexp.MustCompile(`(?i)\bi\s*(?:'?m|am)\s+\d+\s+years?\s+old\b`),
+	},
+	Temporary: []*regexp.Regexp{
+		regexp.MustCompile(`(?i)\bi\s*(?:'?m|am)\s+(?:so\s+|really\s+|super\s+)?(?:hungry|tired|sleepy|bored|busy|exhausted|sick)\b`),
+		regexp.MustCompile(`(?i)\b(?:brb|gtg|afk)\b`),
+		regexp.MustCompile(`(?i)\b(?:going|gonna|off)\s+(?:to\s+)?(?:shower|sleep|bed|eat|lunch|dinner|work|the gym)\b`),
+		regexp.MustCompile(`(?i)\b(?:taking a shower|heading (?:out|home)|in a meeting|on my way)\b`),
+	},
+}
+
+// RegisterPatternPack 注册额外的语言规则包
+func RegisterPatternPack(pack PatternPack) {
+	patternPacksMu.Lock()
+	defer patternPacksMu.Unlock()
+	patternPacks = append(patternPacks, pack)
+}
+
+// loadCustomPatternPack 从配置加载自定义个人信息模式（仅首次分类时加载一次）
+func loadCustomPatternPack() {
+	cfg := config.Get()
+	if cfg == nil || len(cfg.CustomPersonalPatterns) == 0 {
+		return
+	}
+	pack := PatternPack{Lang: "custom"}
+	for _, expr := range cfg.CustomPersonalPatterns {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			log.Printf("[Classifier] Invalid custom personal pattern %q: %v", expr, err)
+			continue
+		}
+		pack.Personal = append(pack.Personal, re)
+	}
+	if len(pack.Personal) > 0 {
+		RegisterPatternPack(pack)
+	}
 }
 
 // classifyWithRegex 使用正则判断消息类型（降级方案）
+// 临时状态优先于个人信息判断（"我去洗澡了"不应被当作长期记忆）
 func classifyWithRegex(content string) string {
-	for _, pattern := range personalPatterns {
-		if pattern.MatchString(content) {
-			return "personal"
+	customPackOnce.Do(loadCustomPatternPack)
+
+	patternPacksMu.RLock()
+	defer patternPacksMu.RUnlock()
+
+	for _, pack := range patternPacks {
+		for _, pattern := range pack.Temporary {
+			if pattern.MatchString(content) {
+				return "temporary"
+			}
+		}
+	}
+	for _, pack := range patternPacks {
+		for _, pattern := range pack.Personal {
+			if pattern.MatchString(content) {
+				return "personal"
+			}
 		}
 	}
 	return "chat"
 }
 
 // classifyWithAI 使用轻量 AI 判断消息类型并探测主动性触发点
-// 返回格式: 类型|是否主动频率(true/false)|原因
+// 返回格式: 类型|是否主动频率(true/false)|原因|情绪
 func classifyWithAI(content string) string {
-	prompt := fmt.Sprintf(`你是一个深度社交观察员。分析以下群聊消息并给出分类。
-
-### 分类规则：
-- personal: 持久性个人信息（职业、爱好、身份、性格特征）。
-- temporary: 临时状态（饿了、去洗澡、在忙、困了、即时情绪）。
-- chat: 普通闲聊或讨论话题。
-
-### 主动性探测 (Proactive)：
-如果消息包含以下特征，请标记为触发主动关怀：
-1. 强烈的负面情绪（极度焦虑、悲伤、受挫）。
-2. 明确的短期重大计划（明天面试、下午相亲、要去赶飞机）。
-3. 寻求帮助但未明确@机器人。
-
-回复格式必须为："类型|是否触发(true/false)|原因描述"
-示例："personal|false|普通爱好描述" 或 "temporary|true|用户表达了极度焦虑"
-
-消息：%s`, content)
+	prompt := buildClassifierPrompt(content)
 
 	reqBody := map[string]interface{}{
 		"model": CLASSIFIER_MODEL,
@@ -71,28 +142,83 @@ func classifyWithAI(content string) string {
 		"temperature": 0.1,
 	}
 
-	jsonData, err := json.Marshal(reqBody)
+	jsonData, err := marshalChatRequest(reqBody)
 	if err != nil {
 		return classifyWithRegex(content) + "|false|marshal_error"
 	}
 
+	// 分类对延迟敏感：仅对限流/网络类错误快速重试，次数与主对话独立配置
+	attempts := max(config.Get().ClassifierAttempts, 1)
+	for attempt := 1; ; attempt++ {
+		result, err := requestClassification(jsonData)
+		if err == nil {
+			return result
+		}
+		retryable := errors.Is(err, ErrRateLimited) || errors.Is(err, ErrUpstreamUnavailable)
+		if !retryable || attempt >= attempts {
+			log.Printf("[Classifier] AI request failed, using regex: %v", err)
+			if retryable {
+				return classifyWithRegex(content) + "|false|fallback"
+			}
+			return classifyWithRegex(content) + "|false|error"
+		}
+		log.Printf("[Classifier] AI request failed (attempt %d/%d), retrying: %v", attempt, attempts, err)
+		time.Sleep(config.Get().ClassifierRetryDelay)
+	}
+}
+
+// classification 分类器输出解析后的结果
+type classification struct {
+	Type      string // personal / temporary / chat
+	Proactive bool   // 是否触发主动关怀
+	Reason    string
+	Sentiment string // 情绪标签，模板未输出时为空
+}
+
+// parseClassification 解析 "类型|是否触发|原因|情绪" 格式的分类结果，缺失或无效的字段取默认值
+func parseClassification(raw string) classification {
+	parts := strings.Split(raw, "|")
+	cls := classification{Type: "chat"}
+
+	if len(parts) >= 1 {
+		cls.Type = strings.ToLower(strings.TrimSpace(parts[0]))
+		// 校验合法性
+		if !strings.Contains("personal|temporary|chat", cls.Type) {
+			cls.Type = "chat"
+		}
+	}
+	if len(parts) >= 2 {
+		cls.Proactive = strings.TrimSpace(parts[1]) == "true"
+	}
+	if len(parts) >= 3 {
+		cls.Reason = strings.TrimSpace(parts[2])
+	}
+	if len(parts) >= 4 {
+		cls.Sentiment = strings.TrimSpace(parts[3])
+	}
+	return cls
+}
+
+// requestClassification 发送一次分类请求，返回模型输出的原始分类结果
+func requestClassification(jsonData []byte) (string, error) {
 	req, err := http.NewRequest("POST", NVIDIA_CHAT_URL, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return classifyWithRegex(content) + "|false|request_error"
+		return "", err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+config.Cfg.NvidiaAPIKey)
+	setLLMHeaders(req)
 
-	client := config.GetHTTPClientWithTimeout(5 * time.Second)
+	client := config.GetLLMHTTPClientWithTimeout(config.Get().ClassifierTimeout)
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Printf("[Classifier] AI request failed: %v", err)
-		return classifyWithRegex(content) + "|false|fallback"
+		return "", upstreamRequestError("classifier", err)
 	}
 	defer resp.Body.Close()
 
 	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", upstreamStatusError("classifier", resp.StatusCode, body)
+	}
 	var result struct {
 		Choices []struct {
 			Message struct {
@@ -101,132 +227,255 @@ func classifyWithAI(content string) string {
 		} `json:"choices"`
 	}
 
-	if err := json.Unmarshal(body, &result); err != nil || len(result.Choices) == 0 {
-		return classifyWithRegex(content) + "|false|error"
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", invalidOutputError("classifier", err, body)
+	}
+	if len(result.Choices) == 0 {
+		return "", invalidOutputError("classifier", fmt.Errorf("empty choices"), body)
+	}
+
+	return strings.TrimSpace(result.Choices[0].Message.Content), nil
+}
+
+// memoryText 存入向量库的文本：超过阈值的长消息使用摘要，摘要失败时退回原文
+func memoryText(historyID uint, content string) string {
+	if threshold := config.Get().SummarizeThreshold; threshold > 0 && utf8.RuneCountInString(content) > threshold {
+		summary, err := summarizeWithAI(content)
+		if err == nil {
+			return summary
+		}
+		log.Printf("[RAG] Failed to summarize msg %d, storing original: %v", historyID, err)
+	}
+	return content
+}
+
+// summarizeWithAI 使用轻量模型将长消息压缩为 1-2 句摘要
+func summarizeWithAI(content string) (string, error) {
+	messages := []ChatMessage{
+		{Role: "system", Content: "你是一个群聊记录整理员。请用 1-2 句中文概括下面这条消息的核心信息，保留人名、时间、地点等关键细节，只输出摘要本身。"},
+		{Role: "user", Content: content},
+	}
+
+	summary, err := callNvidiaAPI(messages, CLASSIFIER_MODEL)
+	if err != nil {
+		return "", err
+	}
+	summary = strings.TrimSpace(summary)
+	if summary == "" {
+		return "", fmt.Errorf("empty summary")
+	}
+	return summary, nil
+}
+
+// vectorMetadata 构建存入 Pinecone 的向量 metadata
+func vectorMetadata(namespace string, groupID int64, qq string, createdAt time.Time) map[string]interface{} {
+	metadata := map[string]interface{}{
+		"group_id":   groupID,
+		"user_qq":    qq,
+		"created_at": createdAt.Unix(), // 恢复时间戳
+		"pinned":     false,            // 置顶后由 pin_memory 改为 true
 	}
+	if namespace == pinecone.NamespacePersonal {
+		metadata["shared"] = false // 个人信息默认私有，需本人通过工具标记后才对他人可见
+	}
+	return metadata
+}
 
-	return strings.TrimSpace(result.Choices[0].Message.Content)
+// personalFilter 构建个人信息 namespace 的检索过滤器
+// 命中本人的全部个人信息，以及其他人标记为共享（shared=true）的个人信息
+func personalFilter(userQQ string) map[string]interface{} {
+	return map[string]interface{}{
+		"$or": []interface{}{
+			map[string]interface{}{"user_qq": userQQ},
+			map[string]interface{}{"shared": true},
+		},
+	}
 }
 
+const (
+	temporaryMemoryTTL = 2 * time.Hour // 临时状态在 Redis 中的默认保留时间
+	careMemoryGrace    = time.Hour     // 触发随访的临时状态在随访之后再多保留的时间
+)
+
 // SaveMessageToRAG 将消息存入 RAG 系统（三层存储 + 主动性探测）
-func SaveMessageToRAG(qq string, nickname string, groupID int64, content string) {
+// addressed 表示消息是否 @ 了机器人（或私聊）：这类消息总是完整处理，
+// 其余消息按群组采样率决定是否分类+向量化，未被采样的只存入 ChatHistory
+func SaveMessageToRAG(qq string, nickname string, groupID int64, messageID int64, content string, addressed bool) {
+	// 0. 黑名单用户（机器人、公告号等）直接跳过
+	if IsMemoryBlocked(groupID, qq) {
+		LogDrop("memory_blocklist", groupID, qq)
+		return
+	}
+
 	// 1. 记录原始消息到数据库
 	var user models.User
 	database.DB.FirstOrCreate(&user, models.User{QQ: qq})
 	if user.Nickname != nickname {
 		user.Nickname = nickname
-		database.DB.Save(&user)
+		database.DB.Model(&user).Update("nickname", user.Nickname)
 	}
 
 	history := models.ChatHistory{
-		UserID:  user.ID,
-		GroupID: groupID,
-		Content: content,
+		UserID:    user.ID,
+		GroupID:   groupID,
+		MessageID: messageID,
+		Content:   content,
 	}
 	if err := database.DB.Create(&history).Error; err != nil {
 		log.Printf("[RAG] Failed to save chat history: %v", err)
 		return
 	}
 
-	// 2. 使用 AI 分类并探测主动性
-	fullRes := classifyWithAI(content)
-	parts := strings.Split(fullRes, "|")
-	msgType := "chat"
-	isProactive := false
-	proactiveReason := ""
-
-	if len(parts) >= 1 {
-		msgType = strings.ToLower(strings.TrimSpace(parts[0]))
-		// 校验合法性
-		if !strings.Contains("personal|temporary|chat", msgType) {
-			msgType = "chat"
+	if !addressed && groupID != 0 {
+		if rate := ArchiveSampleRate(groupID); rate < 1 && rand.Float64() >= rate {
+			LogDrop("archive_sampled_out", groupID, qq)
+			return
 		}
 	}
-	if len(parts) >= 2 {
-		isProactive = strings.TrimSpace(parts[1]) == "true"
-	}
-	if len(parts) >= 3 {
-		proactiveReason = strings.TrimSpace(parts[2])
+
+	// 2. 使用 AI 分类并探测主动性
+	cls := parseClassification(classifyWithAI(content))
+	msgType, isProactive, proactiveReason := cls.Type, cls.Proactive, cls.Reason
+	// 自定义分类模板可能不输出情绪，此时不更新对话情绪
+	if cls.Sentiment != "" {
+		userIDInt, _ := strconv.ParseInt(qq, 10, 64)
+		UpdateConversationMood(groupID, userIDInt, cls.Sentiment)
 	}
 
 	// 3. 主动性处理 (Proactive Action)
-	if isProactive && IsBotActive(groupID) {
+	careRequested := isProactive && IsBotActive(groupID) && IsCareEnabled(groupID)
+	if careRequested {
 		log.Printf("[Proactive] Trigger detected! Reason: %s", proactiveReason)
-		// 自动安排一个 4 小时后的随访任务
+		// 按原话中的时间安排随访任务（按配置可能需要用户确认或超级用户审核）
 		go func() {
 			userIDInt, _ := strconv.ParseInt(qq, 10, 64)
-			task := ScheduledTask{
-				ID:       fmt.Sprintf("proactive_%d", time.Now().Unix()),
-				Type:     "once",
-				Content:  proactiveReason + "|" + content, // 传入原因和原始消息
-				GroupID:  groupID,
-				UserID:   userIDInt,
-				TargetAt: time.Now().Add(4 * time.Hour).Unix(),
-			}
-			err := AddTask(task)
-			if err != nil {
-				log.Printf("[Proactive] Failed to add follow-up task: %v", err)
-			}
+			RequestCareFollowUp(groupID, userIDInt, proactiveReason, content)
 		}()
 	}
 
 	// 4. 根据类型存入不同存储
-	switch msgType {
-	case "temporary":
-		// 临时状态 → Redis（TTL 2小时）
+	// 触发了随访的临时状态（如"下午面试好紧张"）：Redis 中的保留时间延长到随访之后，
+	// 并按配置同时存入聊天向量库，随访时检索仍能找到这件事
+	if msgType == "temporary" {
+		ttl := temporaryMemoryTTL
+		if careRequested {
+			_, followUp := planCareFollowUp(content, Now().In(botLocation()))
+			ttl = max(ttl, followUp.Sub(Now())+careMemoryGrace)
+		}
 		go func() {
 			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 			defer cancel()
-			err := database.SaveTemporaryMemory(ctx, groupID, qq, history.ID, content, 2*time.Hour)
+			err := database.SaveTemporaryMemory(ctx, groupID, qq, history.ID, content, ttl)
 			if err != nil {
 				log.Printf("[RAG] Failed to save to Redis: %v", err)
 				return
 			}
-			log.Printf("[RAG] Archived msg %d → Redis (temporary) from %s", history.ID, nickname)
+			log.Printf("[RAG] Archived msg %d → Redis (temporary, ttl %s) from %s", history.ID, ttl, nickname)
 		}()
+		if !careRequested || !config.Get().CarePersistTemporary {
+			return
+		}
+	}
 
-	case "personal", "chat":
-		// personal/chat → Pinecone
-		go func() {
-			vec, err := embedding.GetEmbedding(content, "passage", 1024)
-			if err != nil {
-				log.Printf("[RAG] Failed to get embedding for msg %d: %v", history.ID, err)
-				return
-			}
+	// personal → Pinecone personal namespace；chat（及需要随访的临时状态）→ chat namespace
+	namespace := pinecone.NamespaceChat
+	if msgType == "personal" {
+		namespace = pinecone.NamespacePersonal
+	}
+	text, windowStart := consecutiveWindow(history)
+	go archiveToPinecone(history, text, windowStart, namespace, groupID, qq, nickname)
+}
 
-			metadata := map[string]interface{}{
-				"group_id":   groupID,
-				"user_qq":    qq,
-				"created_at": time.Now().Unix(), // 恢复时间戳
-			}
+// archiveToPinecone 向量化 text（单条消息原文或合并后的多条消息）并存入指定 namespace，成功后写入 MemberEmbedding 记录；
+// windowStart 为连发合并窗口中最早一条消息的 ID（单条为 0），qq 为记忆的归属用户
+func archiveToPinecone(history models.ChatHistory, text string, windowStart uint, namespace string, groupID int64, qq string, nickname string) {
+	// 长文本先摘要：向量和 ContentSummary 使用摘要，原文保留在 ChatHistory
+	summary := memoryText(history.ID, text)
 
-			vectorID := fmt.Sprintf("msg_%d", history.ID)
-			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-			defer cancel()
+	// 归档在后台进行，没有上游时限，仅受 Embedding 客户端超时约束
+	vec, err := embedding.GetEmbedding(context.Background(), summary, "passage", config.Get().EmbeddingDim)
+	if err != nil {
+		log.Printf("[RAG] Failed to get embedding for msg %d: %v", history.ID, err)
+		return
+	}
 
-			var namespace string
-			if msgType == "personal" {
-				namespace = pinecone.NamespacePersonal
-			} else {
-				namespace = pinecone.NamespaceChat
-			}
+	metadata := vectorMetadata(namespace, groupID, qq, Now())
 
-			err = pinecone.UpsertToNamespace(ctx, namespace, vectorID, vec, metadata)
-			if err != nil {
-				log.Printf("[RAG] Failed to upsert to Pinecone: %v", err)
-				return
-			}
+	vectorID := fmt.Sprintf("msg_%d", history.ID)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-			go func() {
-				embRecord := models.MemberEmbedding{
-					VectorID:       vectorID,
-					ContentSummary: content,
-					RefMsgID:       history.ID,
-				}
-				database.DB.Create(&embRecord)
-			}()
+	// 仅在 upsert 成功后写入 DB 记录，避免记录指向不存在的向量
+	err = pinecone.UpsertToNamespace(ctx, namespace, vectorID, vec, metadata)
+	if err != nil {
+		log.Printf("[RAG] Failed to upsert to Pinecone: %v", err)
+		return
+	}
 
-			log.Printf("[RAG] Archived msg %d → %s namespace from %s", history.ID, namespace, nickname)
-		}()
+	embRecord := models.MemberEmbedding{
+		VectorID:       vectorID,
+		Namespace:      namespace,
+		ContentSummary: summary,
+		RefMsgID:       history.ID,
+		WindowStartID:  windowStart,
+		OwnerQQ:        qq,
+	}
+	if err := database.DB.Create(&embRecord).Error; err != nil {
+		// DB 写入失败时回滚向量，否则该向量检索命中后无法还原内容
+		log.Printf("[RAG] Failed to save embedding record for msg %d, removing vector: %v", history.ID, err)
+		if err := pinecone.DeleteFromNamespace(ctx, namespace, []string{vectorID}); err != nil {
+			log.Printf("[RAG] Failed to remove orphan vector %s: %v", vectorID, err)
+		}
+		return
+	}
+
+	log.Printf("[RAG] Archived msg %d → %s namespace from %s", history.ID, namespace, nickname)
+}
+
+// UpdateEditedMessage 用户编辑了已归档的消息时，同步更新 ChatHistory 和对应的向量
+// 未找到对应消息（未归档或不支持编辑事件）时直接返回
+func UpdateEditedMessage(groupID int64, messageID int64, newContent string) error {
+	var history models.ChatHistory
+	err := database.DB.Where("group_id = ? AND message_id = ?", groupID, messageID).First(&history).Error
+	if err != nil {
+		return nil
+	}
+	if history.Content == newContent {
+		return nil
+	}
+	if err := database.DB.Model(&history).Update("content", newContent).Error; err != nil {
+		return err
+	}
+
+	// 连发合并的记忆可能以后面的消息为原始消息，按窗口范围一并找出
+	var records []models.MemberEmbedding
+	windowCovers(history).Find(&records)
+	if len(records) == 0 {
+		log.Printf("[RAG] Edited msg %d has no vector, history updated only", history.ID)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	for _, r := range records {
+		text := newContent
+		if r.WindowStartID > 0 {
+			if text, err = windowText(history.GroupID, r.WindowStartID, r.RefMsgID); err != nil {
+				return fmt.Errorf("failed to load window of %s: %v", r.VectorID, err)
+			}
+		}
+		summary := memoryText(history.ID, text)
+		vec, err := embedding.GetEmbedding(ctx, summary, "passage", config.Get().EmbeddingDim)
+		if err != nil {
+			return fmt.Errorf("failed to re-embed edited msg %d: %v", history.ID, err)
+		}
+		// 只更新向量值，保留 shared 等 metadata
+		if err := pinecone.UpdateValues(ctx, r.Namespace, r.VectorID, vec); err != nil {
+			return fmt.Errorf("failed to update vector %s: %v", r.VectorID, err)
+		}
+		database.DB.Model(&r).Update("content_summary", summary)
 	}
+	log.Printf("[RAG] Re-embedded edited msg %d (%d vectors)", history.ID, len(records))
+	return nil
 }
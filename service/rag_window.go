@@ -0,0 +1,118 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gin-bot/config"
+	"gin-bot/database"
+	"gin-bot/models"
+	"gin-bot/pinecone"
+
+	"gorm.io/gorm"
+)
+
+// 多条消息合成一条记忆的方式（RAG_WINDOW）
+const (
+	RAGWindowOff         = "off"         // 逐条存储（默认）
+	RAGWindowConsecutive = "consecutive" // 同一用户连发的消息合并为一条记忆
+	RAGWindowReply       = "reply"       // 用户提问与机器人回复成对存储
+	RAGWindowBoth        = "both"        // 两者都开启
+)
+
+// ragWindowEnabled 是否开启了指定的合并方式
+func ragWindowEnabled(mode string) bool {
+	return config.Get().RAGWindow == mode || config.Get().RAGWindow == RAGWindowBoth
+}
+
+// consecutiveWindow 把同一用户在本条消息之前连发的消息（中间没有其他人发言、间隔不超过 RAG_WINDOW_GAP）
+// 与本条拼接为一段文字，单独一句"三点"因此能带上前一句"明天几点集合？"的上下文。
+// 返回拼接后的文字和窗口中最早一条消息的 ID；未开启或没有可合并的消息时返回原文和 0
+func consecutiveWindow(history models.ChatHistory) (string, uint) {
+	size := config.Get().RAGWindowSize
+	if !ragWindowEnabled(RAGWindowConsecutive) || size <= 1 {
+		return history.Content, 0
+	}
+
+	// 查询不按用户过滤（群聊私聊都一样），取本会话此前的几条记录，下面遇到其他人或机器人的消息即停止；
+	// 若在查询中按用户过滤，中间插入的他人发言（私聊中为机器人的回复）会被跳过，窗口就不再连续
+	var previous []models.ChatHistory
+	err := database.DB.
+		Where("group_id = ? AND id < ?", history.GroupID, history.ID).
+		Where("created_at >= ?", history.CreatedAt.Add(-config.Get().RAGWindowGap*time.Duration(size-1))).
+		Order("id DESC").
+		Limit(size - 1).
+		Find(&previous).Error
+	if err != nil {
+		log.Printf("[RAG] Failed to load window for msg %d: %v", history.ID, err)
+		return history.Content, 0
+	}
+
+	// 窗口必须是同一会话中连续的几条记录，之后编辑或清理其中任何一条都能按 ID 范围找到这条记忆
+	parts := []string{history.Content}
+	startID, next := uint(0), history.CreatedAt
+	for _, h := range previous {
+		if h.UserID != history.UserID || h.FromBot || next.Sub(h.CreatedAt) > config.Get().RAGWindowGap {
+			break
+		}
+		if text := strings.TrimSpace(h.Content); text != "" {
+			parts = append(parts, text)
+		}
+		startID, next = h.ID, h.CreatedAt
+	}
+	if startID == 0 {
+		return history.Content, 0
+	}
+	slices.Reverse(parts)
+	return strings.Join(parts, "\n"), startID
+}
+
+// windowText 按记录的窗口范围重新拼接文字（窗口内的消息被编辑后重建向量时使用）
+func windowText(groupID int64, startID uint, endID uint) (string, error) {
+	var rows []models.ChatHistory
+	err := database.DB.Where("group_id = ? AND id BETWEEN ? AND ?", groupID, startID, endID).
+		Order("id").
+		Find(&rows).Error
+	if err != nil {
+		return "", err
+	}
+	var parts []string
+	for _, h := range rows {
+		if text := strings.TrimSpace(h.Content); text != "" {
+			parts = append(parts, text)
+		}
+	}
+	return strings.Join(parts, "\n"), nil
+}
+
+// windowCovers 覆盖指定消息的记忆记录：以该消息为原始消息的，以及窗口范围包含该消息的连发合并记忆
+func windowCovers(history models.ChatHistory) *gorm.DB {
+	return database.DB.Where("ref_msg_id = ?", history.ID).
+		Or("window_start_id > 0 AND window_start_id <= ? AND ref_msg_id > ? AND ref_msg_id IN (?)",
+			history.ID, history.ID,
+			database.DB.Model(&models.ChatHistory{}).Unscoped().Select("id").Where("group_id = ?", history.GroupID))
+}
+
+// ArchiveReplyPair 把用户的提问和机器人的回复作为一条记忆存入 chat namespace（RAG_WINDOW 为 reply/both 时），
+// 向量挂在机器人回复那条 ChatHistory 上，归属（user_qq 与 OwnerQQ）记为提问者，便于之后检索"上次问过什么、怎么答的"
+func ArchiveReplyPair(reply models.ChatHistory, question string, groupID int64, userID int64) {
+	question = strings.TrimSpace(question)
+	if reply.ID == 0 || question == "" || !ragWindowEnabled(RAGWindowReply) {
+		return
+	}
+	qq := strconv.FormatInt(userID, 10)
+	if !IsRAGEnabled(groupID) || IsMemoryBlocked(groupID, qq) {
+		return
+	}
+
+	var user models.User
+	database.DB.Where("qq = ?", qq).First(&user)
+	name := DisplayName(user, userID)
+	answer := strings.TrimSpace(feedbackStripRegex.ReplaceAllString(reply.Content, ""))
+	text := fmt.Sprintf("%s：%s\n%s：%s", name, question, config.Get().BotName, answer)
+	archiveToPinecone(reply, text, 0, pinecone.NamespaceChat, groupID, qq, name)
+}
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"gin-bot/config"
+	"gin-bot/database"
+	"gin-bot/embedding"
+	"gin-bot/models"
+	"gin-bot/pinecone"
+
+	"gorm.io/gorm"
+)
+
+// reconcileBatchSize 每次向 Pinecone 批量确认的向量数
+const reconcileBatchSize = 100
+
+// ReconcileStats 一次对账的结果统计
+type ReconcileStats struct {
+	Checked  int
+	Missing  int
+	Reupsert int
+	Deleted  int
+	Failed   int // 缺失但处理失败、留待下次对账的记录
+	Unknown  int // namespace 未知而跳过的旧记录
+}
+
+// RunEmbeddingReconcile 找出向量已不存在于 Pinecone 的 MemberEmbedding 记录：
+// 原始消息仍在则重新向量化并 upsert，原始消息已删除则删除该记录；
+// 置顶的记忆即使原始消息被软删除也会重建，不会被删除。
+// namespace 未知的旧记录先尝试补全，仍无法确定的跳过（不知道向量在哪，不能据此判断缺失）
+func RunEmbeddingReconcile(ctx context.Context) ReconcileStats {
+	var stats ReconcileStats
+	if database.DB == nil {
+		return stats
+	}
+	BackfillEmbeddingNamespaces(ctx)
+
+	var lastID uint
+	for {
+		var records []models.MemberEmbedding
+		// 原始消息连同已软删除的一并加载，由 reconcileMissingVector 按是否置顶决定去留
+		err := database.DB.Preload("RefMsg", func(db *gorm.DB) *gorm.DB { return db.Unscoped() }).
+			Preload("RefMsg.User").
+			Where("id > ?", lastID).
+			Order("id").
+			Limit(reconcileBatchSize).
+			Find(&records).Error
+		if err != nil {
+			log.Printf("[Reconcile] Failed to load embedding records: %v", err)
+			break
+		}
+		if len(records) == 0 {
+			break
+		}
+		lastID = records[len(records)-1].ID
+
+		byNamespace := make(map[string][]models.MemberEmbedding)
+		for _, r := range records {
+			if r.Namespace == "" {
+				stats.Unknown++
+				continue
+			}
+			byNamespace[r.Namespace] = append(byNamespace[r.Namespace], r)
+		}
+
+		for namespace, group := range byNamespace {
+			ids := make([]string, len(group))
+			for i, r := range group {
+				ids[i] = r.VectorID
+			}
+
+			fetchCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			existing, err := pinecone.FetchExistingIDs(fetchCtx, namespace, ids)
+			cancel()
+			if err != nil {
+				log.Printf("[Reconcile] Failed to fetch vectors in %s namespace: %v", namespace, err)
+				continue
+			}
+
+			stats.Checked += len(group)
+			for _, r := range group {
+				if existing[r.VectorID] {
+					continue
+				}
+				stats.Missing++
+				reconcileMissingVector(ctx, r, &stats)
+			}
+		}
+
+		if ctx.Err() != nil {
+			log.Printf("[Reconcile] Cancelled: %+v", stats)
+			return stats
+		}
+	}
+
+	log.Printf("[Reconcile] Finished: checked=%d missing=%d reupserted=%d deleted=%d failed=%d unknown_namespace=%d",
+		stats.Checked, stats.Missing, stats.Reupsert, stats.Deleted, stats.Failed, stats.Unknown)
+	return stats
+}
+
+// BackfillEmbeddingNamespaces 为加入 namespace 字段之前的旧记录补上 namespace：
+// 旧向量存放在 personal 或 chat 中，按 VectorID 到两个 namespace 中查找，找到的写回；
+// 两边都找不到的保持为空（无法判断归属），Pinecone 出错时停止，留待下次启动或对账时继续
+func BackfillEmbeddingNamespaces(ctx context.Context) int {
+	if database.DB == nil {
+		return 0
+	}
+
+	filled := 0
+	var lastID uint
+	for {
+		var records []models.MemberEmbedding
+		err := database.DB.Where("namespace = ? AND id > ?", "", lastID).
+			Order("id").
+			Limit(reconcileBatchSize).
+			Find(&records).Error
+		if err != nil {
+			log.Printf("[Reconcile] Failed to load records without namespace: %v", err)
+			break
+		}
+		if len(records) == 0 {
+			break
+		}
+		lastID = records[len(records)-1].ID
+
+		ids := make([]string, len(records))
+		for i, r := range records {
+			ids[i] = r.VectorID
+		}
+		for _, namespace := range []string{pinecone.NamespacePersonal, pinecone.NamespaceChat} {
+			fetchCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			existing, err := pinecone.FetchExistingIDs(fetchCtx, namespace, ids)
+			cancel()
+			if err != nil {
+				log.Printf("[Reconcile] Namespace backfill stopped, failed to fetch %s namespace: %v", namespace, err)
+				return filled
+			}
+			for id := range existing {
+				res := database.DB.Model(&models.MemberEmbedding{}).
+					Where("vector_id = ? AND namespace = ?", id, "").
+					Update("namespace", namespace)
+				if res.Error != nil {
+					log.Printf("[Reconcile] Failed to backfill namespace of %s: %v", id, res.Error)
+					continue
+				}
+				filled += int(res.RowsAffected)
+			}
+		}
+	}
+
+	if filled > 0 {
+		log.Printf("[Reconcile] Backfilled namespace for %d legacy embedding records", filled)
+	}
+	return filled
+}
+
+// reconcileMissingVector 处理一条向量缺失的记录
+func reconcileMissingVector(ctx context.Context, r models.MemberEmbedding, stats *ReconcileStats) {
+	// 原始消息已被删除时，记录已无意义（置顶记忆的原始消息只要还在库中就保留）
+	if r.RefMsg.ID == 0 || (r.RefMsg.DeletedAt.Valid && !r.Pinned) {
+		if err := database.DB.Delete(&r).Error; err != nil {
+			log.Printf("[Reconcile] Failed to delete record %s: %v", r.VectorID, err)
+			stats.Failed++
+			return
+		}
+		stats.Deleted++
+		return
+	}
+
+	vec, err := embedding.GetEmbedding(ctx, r.ContentSummary, "passage", config.Get().EmbeddingDim)
+	if err != nil {
+		log.Printf("[Reconcile] Failed to re-embed %s: %v", r.VectorID, err)
+		stats.Failed++
+		return
+	}
+
+	// 问答对挂在机器人的回复上，归属为记录中的提问者而不是回复的发送者
+	owner := r.OwnerQQ
+	if owner == "" {
+		owner = r.RefMsg.User.QQ
+	}
+	metadata := vectorMetadata(r.Namespace, r.RefMsg.GroupID, owner, r.RefMsg.CreatedAt)
+	metadata["pinned"] = r.Pinned
+	if r.Namespace == pinecone.NamespacePersonal {
+		metadata["shared"] = r.Shared
+	}
+	upsertCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := pinecone.UpsertToNamespace(upsertCtx, r.Namespace, r.VectorID, vec, metadata); err != nil {
+		log.Printf("[Reconcile] Failed to re-upsert %s: %v", r.VectorID, err)
+		stats.Failed++
+		return
+	}
+	stats.Reupsert++
+}
+
+// registerReconcileJob 注册每日向量对账任务
+func registerReconcileJob() {
+	hour := config.Get().ReconcileHour
+	if hour < 0 || hour >= 24 {
+		return
+	}
+	_, err := CronManager.AddFunc(fmt.Sprintf("0 30 %d * * *", hour), func() {
+		RunEmbeddingReconcile(context.Background())
+	})
+	if err != nil {
+		log.Printf("[Scheduler] Failed to register reconcile job: %v", err)
+	}
+}
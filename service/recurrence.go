@@ -0,0 +1,62 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/robfig/cron/v3"
+)
+
+// taskCronParser 与调度器一致的 6 位（带秒）cron 解析器
+var taskCronParser = cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// validateCronExpr 校验周期任务的 cron 表达式
+func validateCronExpr(expr string) error {
+	if _, err := taskCronParser.Parse(expr); err != nil {
+		return fmt.Errorf("cron 表达式无效: %v", err)
+	}
+	return nil
+}
+
+// parseClock 解析 "HH:MM" 格式的时刻
+func parseClock(at string) (hour, minute int, err error) {
+	h, m, ok := strings.Cut(strings.TrimSpace(at), ":")
+	if !ok {
+		return 0, 0, fmt.Errorf("时间格式应为 HH:MM，如 09:00")
+	}
+	hour, err1 := strconv.Atoi(h)
+	minute, err2 := strconv.Atoi(m)
+	if err1 != nil || err2 != nil || hour < 0 || hour > 23 || minute < 0 || minute > 59 {
+		return 0, 0, fmt.Errorf("时间 %q 无效，应为 00:00 到 23:59", at)
+	}
+	return hour, minute, nil
+}
+
+// buildRecurrenceCron 将结构化的重复规则转换为 6 位 cron 表达式
+// weekday 取 1-7（周一到周日），仅 weekly 使用；dayOfMonth 取 1-31，仅 monthly 使用
+func buildRecurrenceCron(recurrence string, at string, weekday int, dayOfMonth int) (string, error) {
+	hour, minute, err := parseClock(at)
+	if err != nil {
+		return "", err
+	}
+
+	var expr string
+	switch recurrence {
+	case "daily":
+		expr = fmt.Sprintf("0 %d %d * * *", minute, hour)
+	case "weekly":
+		if weekday < 1 || weekday > 7 {
+			return "", fmt.Errorf("weekday 应为 1-7（周一到周日）")
+		}
+		expr = fmt.Sprintf("0 %d %d * * %d", minute, hour, weekday%7) // cron 中周日为 0
+	case "monthly":
+		if dayOfMonth < 1 || dayOfMonth > 31 {
+			return "", fmt.Errorf("day_of_month 应为 1-31")
+		}
+		expr = fmt.Sprintf("0 %d %d %d * *", minute, hour, dayOfMonth)
+	default:
+		return "", fmt.Errorf("recurrence 应为 daily、weekly 或 monthly")
+	}
+	return expr, validateCronExpr(expr)
+}
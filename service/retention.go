@@ -0,0 +1,89 @@
+package service
+
+import (
+	"fmt"
+	"log"
+
+	"gin-bot/config"
+	"gin-bot/database"
+	"gin-bot/models"
+
+	"gorm.io/gorm"
+)
+
+// HistoryPruneStats 一次聊天记录清理的结果统计
+type HistoryPruneStats struct {
+	Expired  int64 // 超过保留时长被删除的条数
+	Overflow int64 // 超出每群条数上限被删除的条数
+}
+
+// referencedHistoryIDs 仍被向量记忆或回复反馈引用的聊天记录，清理时保留（置顶记忆的原始消息因此始终保留）；
+// 连发合并的记忆包含窗口内每条消息的文字，窗口内的消息同样保留，之后编辑仍能重建该记忆
+func referencedHistoryIDs(db *gorm.DB) *gorm.DB {
+	return db.Where("id NOT IN (?)", database.DB.Model(&models.MemberEmbedding{}).Select("ref_msg_id")).
+		Where("id NOT IN (?)", database.DB.Model(&models.ReplyFeedback{}).Select("chat_history_id")).
+		Where(`NOT EXISTS (SELECT 1 FROM member_embeddings e JOIN chat_histories r ON r.id = e.ref_msg_id
+			WHERE e.window_start_id > 0 AND r.group_id = chat_histories.group_id
+			AND chat_histories.id BETWEEN e.window_start_id AND e.ref_msg_id)`)
+}
+
+// historyDeleteScope 按配置选择软删除或物理删除
+func historyDeleteScope() *gorm.DB {
+	if config.Get().HistoryHardDelete {
+		return database.DB.Unscoped()
+	}
+	return database.DB
+}
+
+// PruneChatHistory 按保留策略清理聊天记录：删除早于 HISTORY_MAX_AGE 的消息，
+// 并让每个群（私聊按用户）只保留最近 HISTORY_KEEP_PER_GROUP 条；仍被记忆或反馈引用的记录不会被删除
+func PruneChatHistory() HistoryPruneStats {
+	var stats HistoryPruneStats
+	if database.DB == nil || (config.Get().HistoryMaxAge <= 0 && config.Get().HistoryKeepPerGroup <= 0) {
+		return stats
+	}
+
+	if maxAge := config.Get().HistoryMaxAge; maxAge > 0 {
+		res := referencedHistoryIDs(historyDeleteScope()).
+			Where("created_at < ?", Now().Add(-maxAge)).
+			Delete(&models.ChatHistory{})
+		if res.Error != nil {
+			log.Printf("[Retention] Failed to prune expired chat history: %v", res.Error)
+		}
+		stats.Expired = res.RowsAffected
+	}
+
+	if keep := config.Get().HistoryKeepPerGroup; keep > 0 {
+		overflow := database.DB.Raw(`SELECT id FROM (
+			SELECT id, ROW_NUMBER() OVER (
+				PARTITION BY group_id, CASE WHEN group_id = 0 THEN user_id ELSE 0 END
+				ORDER BY created_at DESC, id DESC
+			) AS rn FROM chat_histories WHERE deleted_at IS NULL
+		) ranked WHERE rn > ?`, keep)
+		res := referencedHistoryIDs(historyDeleteScope()).
+			Where("id IN (?)", overflow).
+			Delete(&models.ChatHistory{})
+		if res.Error != nil {
+			log.Printf("[Retention] Failed to prune overflowing chat history: %v", res.Error)
+		}
+		stats.Overflow = res.RowsAffected
+	}
+
+	log.Printf("[Retention] Chat history pruned: expired=%d overflow=%d hard=%v",
+		stats.Expired, stats.Overflow, config.Get().HistoryHardDelete)
+	return stats
+}
+
+// registerHistoryPruneJob 注册每日聊天记录清理任务（保留策略可热重载，未配置时任务直接跳过）
+func registerHistoryPruneJob() {
+	hour := config.Get().HistoryPruneHour
+	if hour < 0 || hour >= 24 {
+		return
+	}
+	_, err := CronManager.AddFunc(fmt.Sprintf("0 45 %d * * *", hour), func() {
+		PruneChatHistory()
+	})
+	if err != nil {
+		log.Printf("[Scheduler] Failed to register history prune job: %v", err)
+	}
+}
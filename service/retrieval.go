@@ -0,0 +1,272 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gin-bot/config"
+	"gin-bot/database"
+	"gin-bot/embedding"
+	"gin-bot/models"
+	"gin-bot/pinecone"
+)
+
+// keywordFallbackScanLimit 关键词降级检索时扫描的最近消息条数
+const keywordFallbackScanLimit = 200
+
+// queryTopKSlack 检索时在条数上限之外多取的余量，弥补数据库中已无法解析的向量
+const queryTopKSlack = 2
+
+// queryNamespace 按条数上限检索指定 namespace，上限为 0 时跳过检索
+func queryNamespace(ctx context.Context, namespace string, vec []float32, limit int, filter map[string]interface{}) []pinecone.Match {
+	if limit <= 0 {
+		return nil
+	}
+	matches, err := pinecone.QueryWithScore(ctx, namespace, vec, uint32(limit+queryTopKSlack), filter)
+	if err != nil {
+		log.Printf("[RAG] Query %s namespace failed: %v", namespace, err)
+		return nil
+	}
+	return matches
+}
+
+// retrievalResult 一次 RAG 检索的结果：拼好的回忆片段、场景"变脸"提示与记忆来源
+type retrievalResult struct {
+	ContextBlock  string
+	VibePrompt    string
+	MaxScore      float32
+	TechScene     bool
+	PersonalScene bool
+	Provenance    []MemoryProvenance
+}
+
+// retrieveContext 检索与 prompt 相关的记忆并组装 prompt 片段（GetAIResponse 与 FC 管线共用）
+// userID 为 0 时只检索已共享的个人信息；聊天记录只检索 groupID 所在会话（0 为私聊）
+func retrieveContext(ctx context.Context, prompt string, groupID int64, userID int64) retrievalResult {
+	var res retrievalResult
+	contextTexts := []string{}
+
+	userQQ := ""
+	if userID != 0 {
+		userQQ = strconv.FormatInt(userID, 10)
+	}
+
+	queryVec, err := getQueryEmbedding(ctx, prompt)
+	if err == nil {
+		// 检索个人信息 (NamespacePersonal) - 本人私有 + 他人共享
+		pFilter := map[string]interface{}{"shared": true}
+		if userQQ != "" {
+			pFilter = personalFilter(userQQ)
+		}
+		pMatches := queryNamespace(ctx, pinecone.NamespacePersonal, queryVec, config.Get().PersonalTopK, pFilter)
+		pCount := 0
+		for _, m := range pMatches {
+			if pCount >= config.Get().PersonalTopK {
+				break
+			}
+			var emb models.MemberEmbedding
+			database.DB.Preload("RefMsg").Where("vector_id = ?", m.ID).First(&emb)
+			score := boostPinned(m.Score, emb.Pinned)
+			if score > 0.7 {
+				res.PersonalScene = true
+			}
+			if score > res.MaxScore {
+				res.MaxScore = score
+			}
+			if emb.ContentSummary != "" {
+				contextTexts = append(contextTexts, memoryLine(emb.RefMsg.CreatedAt, emb.Pinned, emb.ContentSummary))
+				res.Provenance = append(res.Provenance, MemoryProvenance{m.ID, pinecone.NamespacePersonal, score, emb.ContentSummary})
+				pCount++
+			}
+		}
+
+		// 检索聊天记录 (NamespaceChat)
+		cMatches := queryNamespace(ctx, pinecone.NamespaceChat, queryVec, config.Get().ChatTopK, chatMemoryFilter(groupID))
+		cCount := 0
+		for _, m := range cMatches {
+			if cCount >= config.Get().ChatTopK {
+				break
+			}
+			var emb models.MemberEmbedding
+			database.DB.Preload("RefMsg").Where("vector_id = ?", m.ID).First(&emb)
+			score := boostPinned(m.Score, emb.Pinned)
+			if score > res.MaxScore {
+				res.MaxScore = score
+			}
+			if emb.ContentSummary != "" {
+				contextTexts = append(contextTexts, memoryLine(emb.RefMsg.CreatedAt, emb.Pinned, emb.ContentSummary))
+				res.Provenance = append(res.Provenance, MemoryProvenance{m.ID, pinecone.NamespaceChat, score, emb.ContentSummary})
+				cCount++
+
+				lowContent := strings.ToLower(emb.ContentSummary)
+				if strings.Contains(lowContent, "err") || strings.Contains(lowContent, "code") || strings.Contains(lowContent, "api") || strings.Contains(lowContent, "func") {
+					res.TechScene = true
+				}
+			}
+		}
+	} else {
+		// 向量服务不可用：降级为关键词检索近期聊天记录，避免完全"失忆"
+		log.Printf("[RAG] Embedding unavailable, falling back to keyword search: %v", err)
+		for _, h := range keywordSearchHistory(prompt, groupID, userQQ, 3) {
+			contextTexts = append(contextTexts, memoryLine(h.CreatedAt, false, h.Content))
+			res.Provenance = append(res.Provenance, MemoryProvenance{fmt.Sprintf("history_%d", h.ID), "keyword", 0, h.Content})
+		}
+	}
+
+	res.ContextBlock = contextBlock(contextTexts)
+
+	if res.TechScene {
+		res.VibePrompt = "\n**[🔧 技术场景适配]**：现在像一个热心的技术大佬在帮群友排查 Bug 一样，直接指出重点，可以带点技术圈的吐槽，但要保证准确简练。"
+	} else if res.PersonalScene {
+		res.VibePrompt = "\n**[💝 情感场景适配]**：回想起这位老朋友的私事了，用更多的同情和理解来回复。添加一些相关的例子或生活经验，让回复充满温度。"
+	}
+
+	if res.MaxScore > 0.85 {
+		res.VibePrompt += "\n**[⚡ 确定性强化]**：你对这段记忆非常确定，说话更有底气一点。"
+	} else if res.MaxScore > 0.0 && res.MaxScore < 0.6 {
+		res.VibePrompt += "\n**[❓ 模糊处理]**：记忆有点模糊，回复时可以带一句'我好像记得...'或者'不知道记错没'之类的话。"
+	}
+
+	// 对话情绪：延续前几轮的情绪，避免语气忽冷忽热
+	res.VibePrompt += moodVibePrompt(groupID, userID)
+	return res
+}
+
+// memoryLine 回忆片段中的一行，如 "(3天前) 【重要】下周二要去面试"
+// "刚刚"与超过一个月时的具体日期不再加"前"（避免"刚刚前""2024-05-01前"）
+func memoryLine(createdAt time.Time, pinned bool, text string) string {
+	when := formatRelativeTime(createdAt)
+	if when != "刚刚" && !strings.Contains(when, "-") {
+		when += "前"
+	}
+	return fmt.Sprintf("(%s) %s%s", when, pinnedMark(pinned), text)
+}
+
+// contextBlock 把回忆片段拼成 prompt 中的回忆段落，没有片段时给出"没想起什么"的占位
+func contextBlock(lines []string) string {
+	if len(lines) == 0 {
+		return "【回忆】: (暂时没想起什么特别的)"
+	}
+	return "【脑海中的回忆片段】:\n" + strings.Join(lines, "\n")
+}
+
+// getQueryEmbedding 获取检索用向量，失败时快速重试一次（应对瞬时网络抖动）
+// ctx 为调用方的检索时限，超时后不再重试
+func getQueryEmbedding(ctx context.Context, text string) ([]float32, error) {
+	vec, err := embedding.GetEmbedding(ctx, text, "query", config.Get().EmbeddingDim)
+	if err == nil {
+		return vec, nil
+	}
+	log.Printf("[RAG] Query embedding failed, retrying once: %v", err)
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(300 * time.Millisecond):
+	}
+	return embedding.GetEmbedding(ctx, text, "query", config.Get().EmbeddingDim)
+}
+
+// chatMemoryFilter 聊天记录检索的过滤条件：始终限定在当前会话，私聊（groupID 为 0）不会检索到群聊记忆
+func chatMemoryFilter(groupID int64) map[string]interface{} {
+	return map[string]interface{}{"group_id": groupID}
+}
+
+// keywordSearchHistory 向量检索不可用时，在最近的 ChatHistory 中按关键词做降级检索
+// 始终限定在 groupID 所在会话；私聊（groupID 为 0）且 userQQ 不为空时再限定该用户
+func keywordSearchHistory(prompt string, groupID int64, userQQ string, limit int) []models.ChatHistory {
+	keywords := extractKeywords(prompt)
+	if len(keywords) == 0 {
+		return nil
+	}
+
+	query := database.DB.Where("from_bot = ?", false).Order("created_at DESC").Limit(keywordFallbackScanLimit)
+	query = query.Where("group_id = ?", groupID)
+	if groupID == 0 && userQQ != "" {
+		query = query.Where("user_id IN (?)",
+			database.DB.Model(&models.User{}).Select("id").Where("qq = ?", userQQ))
+	}
+
+	var recent []models.ChatHistory
+	if err := query.Find(&recent).Error; err != nil {
+		log.Printf("[RAG] Keyword fallback query failed: %v", err)
+		return nil
+	}
+
+	type scored struct {
+		history models.ChatHistory
+		hits    int
+	}
+	var candidates []scored
+	for _, h := range recent {
+		lower := strings.ToLower(h.Content)
+		hits := 0
+		for _, kw := range keywords {
+			if strings.Contains(lower, kw) {
+				hits++
+			}
+		}
+		if hits > 0 {
+			candidates = append(candidates, scored{h, hits})
+		}
+	}
+
+	// 命中关键词越多越靠前，同分时保持时间倒序
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].hits > candidates[j].hits
+	})
+
+	var results []models.ChatHistory
+	for i := 0; i < len(candidates) && i < limit; i++ {
+		results = append(results, candidates[i].history)
+	}
+	return results
+}
+
+// extractKeywords 提取检索关键词：英文/数字按词切分，中文按二元组切分
+func extractKeywords(text string) []string {
+	seen := make(map[string]bool)
+	var keywords []string
+	add := func(kw string) {
+		if !seen[kw] {
+			seen[kw] = true
+			keywords = append(keywords, kw)
+		}
+	}
+
+	var word []rune
+	var han []rune
+	flush := func() {
+		if len(word) >= 2 {
+			add(strings.ToLower(string(word)))
+		}
+		for i := 0; i+1 < len(han); i++ {
+			add(string(han[i : i+2]))
+		}
+		word, han = word[:0], han[:0]
+	}
+
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			if len(word) > 0 {
+				flush()
+			}
+			han = append(han, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if len(han) > 0 {
+				flush()
+			}
+			word = append(word, r)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return keywords
+}
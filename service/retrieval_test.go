@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"gin-bot/database"
+
+	"gorm.io/gorm"
+)
+
+func TestContextBlockFormatting(t *testing.T) {
+	cfg := setupTestEnv(t)
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, cfg.Location)
+	useMockClock(t, now)
+
+	lines := []string{
+		memoryLine(now.Add(-3*24*time.Hour), true, "下周二要去面试"),
+		memoryLine(now.Add(-2*time.Hour), false, "晚上想吃火锅"),
+		memoryLine(now.Add(-10*time.Second), false, "[CQ:face,id=178]"),
+		memoryLine(now.AddDate(0, -2, 0), false, "养了一只猫"),
+	}
+	want := "【脑海中的回忆片段】:\n" +
+		"(3天前) 【重要】下周二要去面试\n" +
+		"(2小时前) 晚上想吃火锅\n" +
+		"(刚刚) [CQ:face,id=178]\n" +
+		"(2024-03-10) 养了一只猫"
+	if got := contextBlock(lines); got != want {
+		t.Errorf("contextBlock =\n%s\nwant\n%s", got, want)
+	}
+
+	if got, want := contextBlock(nil), "【回忆】: (暂时没想起什么特别的)"; got != want {
+		t.Errorf("contextBlock(nil) = %q, want %q", got, want)
+	}
+}
+
+func TestRetrieveContextWithoutMemories(t *testing.T) {
+	setupTestEnv(t)
+	// 已取消的 ctx 使向量检索立即失败，走关键词降级；DryRun 数据库查不到记录
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	res := retrieveContext(ctx, "上次说的火锅店在哪", 30003, 20002)
+	if want := "【回忆】: (暂时没想起什么特别的)"; res.ContextBlock != want {
+		t.Errorf("ContextBlock = %q, want %q", res.ContextBlock, want)
+	}
+	if len(res.Provenance) != 0 || res.MaxScore != 0 || res.TechScene || res.PersonalScene {
+		t.Errorf("unexpected result for empty retrieval: %+v", res)
+	}
+}
+
+func TestRetrievalPrivateChatScope(t *testing.T) {
+	setupTestEnv(t)
+
+	// 私聊的聊天记录检索必须限定在 group_id = 0，不能带出任何群的记忆
+	for _, groupID := range []int64{0, 30003} {
+		filter := chatMemoryFilter(groupID)
+		if got, ok := filter["group_id"]; !ok || got != groupID || len(filter) != 1 {
+			t.Errorf("chatMemoryFilter(%d) = %v, want {group_id: %d}", groupID, filter, groupID)
+		}
+	}
+
+	// 关键词降级检索同样只查当前会话
+	var queries []string
+	var vars [][]interface{}
+	err := database.DB.Callback().Query().After("gorm:query").Register("test:capture", func(db *gorm.DB) {
+		queries = append(queries, db.Statement.SQL.String())
+		vars = append(vars, db.Statement.Vars)
+	})
+	if err != nil {
+		t.Fatalf("register callback: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		userID  int64
+		wantSQL string
+	}{
+		{"private chat with user", 20002, "user_id IN"},
+		{"private chat without user", 0, "group_id = $"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queries, vars = nil, nil
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			retrieveContext(ctx, "上次说的火锅店在哪", 0, tt.userID)
+
+			if len(queries) == 0 {
+				t.Fatal("keyword fallback did not query chat history")
+			}
+			sql := queries[len(queries)-1]
+			if !strings.Contains(sql, "group_id = $") || !strings.Contains(sql, tt.wantSQL) {
+				t.Errorf("fallback query not scoped to the private chat: %s", sql)
+			}
+			if !slices.Contains(vars[len(vars)-1], interface{}(int64(0))) {
+				t.Errorf("fallback query vars = %v, want group_id 0", vars[len(vars)-1])
+			}
+		})
+	}
+}
@@ -5,10 +5,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"gin-bot/config"
 	"gin-bot/database"
 
 	redis "github.com/redis/go-redis/v9"
@@ -24,6 +28,12 @@ type ScheduledTask struct {
 	UserID   int64  `json:"user_id"`   // 提醒对象
 	TimeExpr string `json:"time_expr"` // 10分钟后，或者 cron 表达式
 	TargetAt int64  `json:"target_at"` // 目标执行时间戳 (仅针对 once 类型)
+
+	Announcement bool `json:"announcement,omitempty"` // 群公告：发送时不 @ 任何人，与个人提醒分开管理
+
+	Attachments []string `json:"attachments,omitempty"` // 随提醒一起发送的图片/文件（CQ 码或 URL）
+	EventAt     int64    `json:"event_at,omitempty"`    // 主动关怀：原话所提事件的大致时间（0 表示未知）
+	CreatedBy   int64    `json:"created_by,omitempty"`  // 替别人设置提醒时的设置人（提醒对象为 UserID），本人设置时为 0
 }
 
 // MsgSender 统一消息发送函数类型
@@ -51,16 +61,35 @@ func InitScheduler(sender MsgSender) {
 	// 重载周期任务
 	go ReloadPeriodicTasks()
 
+	// 旧记录补上 namespace，人设摘要、记忆查看等按 namespace 查询的功能才能看到它们
+	go BackfillEmbeddingNamespaces(context.Background())
+
+	// 夜间人设摘要任务
+	if hour := config.Get().PersonaUpdateHour; hour >= 0 && hour < 24 {
+		_, err := CronManager.AddFunc(fmt.Sprintf("0 0 %d * * *", hour), func() {
+			RunNightlyPersonaUpdate(context.Background())
+		})
+		if err != nil {
+			log.Printf("[Scheduler] Failed to register persona update job: %v", err)
+		}
+	}
+
+	// 每日向量对账任务
+	registerReconcileJob()
+
+	// 每日聊天记录清理任务
+	registerHistoryPruneJob()
+
 	log.Println("Scheduler initialized successfully")
 }
 
 // ReloadPeriodicTasks 从 Redis 加载并恢复周期任务
 func ReloadPeriodicTasks() {
-	if database.RDB == nil {
+	if database.Redis() == nil {
 		return
 	}
 	ctx := context.Background()
-	all, err := database.RDB.HGetAll(ctx, HashKeyPeriodic).Result()
+	all, err := database.Redis().HGetAll(ctx, HashKeyPeriodic).Result()
 	if err != nil {
 		log.Printf("[Scheduler] Failed to reload periodic tasks: %v", err)
 		return
@@ -78,9 +107,7 @@ func ReloadPeriodicTasks() {
 		// 创建局部副本，避免闭包捕获循环变量
 		taskCopy := t
 		entryID, err := CronManager.AddFunc(taskCopy.TimeExpr, func() {
-			if GlobalSender != nil {
-				GlobalSender(taskCopy.GroupID, taskCopy.UserID, "【周期提醒】"+taskCopy.Content)
-			}
+			dispatchTask(taskCopy, taskCopy.Content)
 		})
 		if err == nil {
 			PeriodicEntries[id] = entryID
@@ -94,10 +121,13 @@ func ReloadPeriodicTasks() {
 // AddTask 添加任务
 func AddTask(t ScheduledTask) error {
 	if t.ID == "" {
-		t.ID = fmt.Sprintf("task_%d_%d", time.Now().UnixNano(), t.UserID)
+		t.ID = fmt.Sprintf("task_%d_%d", Now().UnixNano(), t.UserID)
 	}
 
-	if database.RDB == nil {
+	if database.Redis() == nil {
+		if keepTaskInMemory(t) {
+			return ErrTaskNotPersisted
+		}
 		return fmt.Errorf("redis not connected")
 	}
 
@@ -111,9 +141,7 @@ func AddTask(t ScheduledTask) error {
 		// 创建局部副本，避免闭包捕获循环变量
 		taskCopy := t
 		entryID, err := CronManager.AddFunc(taskCopy.TimeExpr, func() {
-			if GlobalSender != nil {
-				GlobalSender(taskCopy.GroupID, taskCopy.UserID, "【周期提醒】"+taskCopy.Content)
-			}
+			dispatchTask(taskCopy, taskCopy.Content)
 		})
 		if err != nil {
 			return err
@@ -122,71 +150,233 @@ func AddTask(t ScheduledTask) error {
 		// 记录映射和持久化详情
 		PeriodicEntries[t.ID] = entryID
 		data, _ := json.Marshal(t)
-		return database.RDB.HSet(ctx, HashKeyPeriodic, t.ID, string(data)).Err()
+		return database.Redis().HSet(ctx, HashKeyPeriodic, t.ID, string(data)).Err()
 	}
 
 	// 添加一次性任务 (Hash 存详情 + ZSet 调度)
 	data, _ := json.Marshal(t)
 	// 1. 存入 Hash 详情
-	if err := database.RDB.HSet(ctx, HashKeyOneshot, t.ID, string(data)).Err(); err != nil {
+	if err := database.Redis().HSet(ctx, HashKeyOneshot, t.ID, string(data)).Err(); err != nil {
+		if keepTaskInMemory(t) {
+			return ErrTaskNotPersisted
+		}
 		return err
 	}
 	// 2. 存入 ZSet 调度
-	err := database.RDB.ZAdd(ctx, ZSetKey, redis.Z{
+	err := database.Redis().ZAdd(ctx, ZSetKey, redis.Z{
 		Score:  float64(t.TargetAt),
 		Member: t.ID, // 仅存 ID
 	}).Err()
 	return err
 }
 
-// ListTasks 列出指定范围的任务
-func ListTasks(groupID int64, userID int64) []ScheduledTask {
+// ListTasks 列出指定范围的任务（userID 不为 0 时包括提醒该用户的和该用户替别人设置的），
+// 第二个返回值为无法解析而跳过的任务记录数
+// （损坏的记录读不出群号和用户，无法判断是否属于查询范围，按全部计数）
+func ListTasks(groupID int64, userID int64) ([]ScheduledTask, int) {
 	ctx := context.Background()
 	tasks := []ScheduledTask{}
+	corrupted := 0
 
-	if database.RDB == nil {
-		return tasks
+	// 0. Redis 不可用时暂存在内存中的一次性任务
+	for _, t := range listMemoryTasks() {
+		if (groupID == 0 || t.GroupID == groupID) && (userID == 0 || t.UserID == userID || t.CreatedBy == userID) {
+			tasks = append(tasks, t)
+		}
 	}
 
-	// 1. 获取一次性任务
-	onceData, err := database.RDB.HVals(ctx, HashKeyOneshot).Result()
-	if err != nil {
-		log.Printf("[Scheduler] Failed to get oneshot tasks: %v", err)
+	if database.Redis() == nil {
+		return tasks, 0
 	}
-	for _, d := range onceData {
-		var t ScheduledTask
-		if err := json.Unmarshal([]byte(d), &t); err != nil {
-			log.Printf("[Scheduler] Failed to unmarshal oneshot task: %v", err)
+
+	// 依次读取一次性任务与周期任务
+	for _, key := range []string{HashKeyOneshot, HashKeyPeriodic} {
+		all, err := database.Redis().HGetAll(ctx, key).Result()
+		if err != nil {
+			log.Printf("[Scheduler] Failed to get tasks from %s: %v", key, err)
 			continue
 		}
-		if (groupID == 0 || t.GroupID == groupID) && (userID == 0 || t.UserID == userID) {
-			tasks = append(tasks, t)
+		for id, d := range all {
+			var t ScheduledTask
+			if err := json.Unmarshal([]byte(d), &t); err != nil {
+				log.Printf("[Scheduler] Failed to unmarshal task %s in %s: %v", id, key, err)
+				corrupted++
+				continue
+			}
+			if (groupID == 0 || t.GroupID == groupID) && (userID == 0 || t.UserID == userID || t.CreatedBy == userID) {
+				tasks = append(tasks, t)
+			}
 		}
 	}
 
-	// 2. 获取周期任务
-	periodicData, err := database.RDB.HVals(ctx, HashKeyPeriodic).Result()
+	return tasks, corrupted
+}
+
+// CorruptedTask 无法解析的任务记录
+type CorruptedTask struct {
+	Key   string // 所在 Hash
+	ID    string
+	Raw   string // 原始内容（截断）
+	Error string
+}
+
+// corruptedTaskRawLimit 损坏记录展示的原始内容长度
+const corruptedTaskRawLimit = 60
+
+// FindCorruptedTasks 扫描任务存储，找出无法解析的记录
+func FindCorruptedTasks() ([]CorruptedTask, error) {
+	if database.Redis() == nil {
+		return nil, fmt.Errorf("redis not connected")
+	}
+	ctx := context.Background()
+	var corrupted []CorruptedTask
+	for _, key := range []string{HashKeyOneshot, HashKeyPeriodic} {
+		all, err := database.Redis().HGetAll(ctx, key).Result()
+		if err != nil {
+			return nil, err
+		}
+		for id, d := range all {
+			var t ScheduledTask
+			if err := json.Unmarshal([]byte(d), &t); err != nil {
+				raw := []rune(d)
+				if len(raw) > corruptedTaskRawLimit {
+					raw = append(raw[:corruptedTaskRawLimit], []rune("...")...)
+				}
+				corrupted = append(corrupted, CorruptedTask{Key: key, ID: id, Raw: string(raw), Error: err.Error()})
+			}
+		}
+	}
+	sort.Slice(corrupted, func(i, j int) bool { return corrupted[i].ID < corrupted[j].ID })
+	return corrupted, nil
+}
+
+// RemoveCorruptedTasks 删除无法解析的任务记录（删除前重新校验，不会误删已被修复的记录）
+// 一次性任务同时从调度 ZSet 中移除，避免轮询反复取到无法执行的 ID
+func RemoveCorruptedTasks() ([]CorruptedTask, error) {
+	corrupted, err := FindCorruptedTasks()
 	if err != nil {
-		log.Printf("[Scheduler] Failed to get periodic tasks: %v", err)
+		return nil, err
 	}
-	for _, d := range periodicData {
-		var t ScheduledTask
-		if err := json.Unmarshal([]byte(d), &t); err != nil {
-			log.Printf("[Scheduler] Failed to unmarshal periodic task: %v", err)
+	ctx := context.Background()
+	var removed []CorruptedTask
+	for _, c := range corrupted {
+		if err := database.Redis().HDel(ctx, c.Key, c.ID).Err(); err != nil {
+			log.Printf("[Scheduler] Failed to remove corrupted task %s: %v", c.ID, err)
 			continue
 		}
-		if (groupID == 0 || t.GroupID == groupID) && (userID == 0 || t.UserID == userID) {
-			tasks = append(tasks, t)
+		if c.Key == HashKeyOneshot {
+			database.Redis().ZRem(ctx, ZSetKey, c.ID)
+		}
+		log.Printf("[Scheduler] Removed corrupted task %s from %s", c.ID, c.Key)
+		removed = append(removed, c)
+	}
+	return removed, nil
+}
+
+// PeriodicEntryStatus 内存中一个周期任务的 cron 调度状态
+type PeriodicEntryStatus struct {
+	ID       string
+	TimeExpr string    // Redis 中的 cron 表达式（Redis 中没有该任务时为空）
+	Next     time.Time // 下次触发时间（未调度时为零值）
+}
+
+// SchedulerStatus 调度器内部状态快照，用于排查内存中的 cron 映射与 Redis 不一致的问题
+type SchedulerStatus struct {
+	Periodic       []PeriodicEntryStatus // PeriodicEntries 中的周期任务（按 ID 排序）
+	OneshotQueued  int64                 // 调度 ZSet 中的一次性任务数
+	OneshotMemory  int                   // Redis 不可用时暂存在内存中的一次性任务数
+	MissingInRedis []string              // 已加载到 cron 但 Redis 中没有详情的周期任务
+	NotLoaded      []string              // Redis 中有详情但没有加载到 cron 的周期任务
+	RedisError     string                // 读取 Redis 失败（或未连接）的原因
+}
+
+// InspectScheduler 对比内存中的周期任务映射与 Redis 中的任务数据，并给出每个周期任务的下次触发时间
+func InspectScheduler() SchedulerStatus {
+	status := SchedulerStatus{OneshotMemory: len(listMemoryTasks())}
+
+	schedulerMu.RLock()
+	loaded := make(map[string]cron.EntryID, len(PeriodicEntries))
+	for id, entryID := range PeriodicEntries {
+		loaded[id] = entryID
+	}
+	schedulerMu.RUnlock()
+
+	var stored map[string]string
+	if database.Redis() == nil {
+		status.RedisError = "redis not connected"
+	} else {
+		ctx := context.Background()
+		var err error
+		if stored, err = database.Redis().HGetAll(ctx, HashKeyPeriodic).Result(); err != nil {
+			status.RedisError = err.Error()
+		} else if status.OneshotQueued, err = database.Redis().ZCard(ctx, ZSetKey).Result(); err != nil {
+			status.RedisError = err.Error()
 		}
 	}
 
-	return tasks
+	for id, entryID := range loaded {
+		entry := PeriodicEntryStatus{ID: id}
+		if CronManager != nil {
+			entry.Next = CronManager.Entry(entryID).Next
+		}
+		if data, ok := stored[id]; ok {
+			var t ScheduledTask
+			if json.Unmarshal([]byte(data), &t) == nil {
+				entry.TimeExpr = t.TimeExpr
+			}
+		} else if stored != nil {
+			status.MissingInRedis = append(status.MissingInRedis, id)
+		}
+		status.Periodic = append(status.Periodic, entry)
+	}
+	for id := range stored {
+		if _, ok := loaded[id]; !ok {
+			status.NotLoaded = append(status.NotLoaded, id)
+		}
+	}
+
+	sort.Slice(status.Periodic, func(i, j int) bool { return status.Periodic[i].ID < status.Periodic[j].ID })
+	sort.Strings(status.MissingInRedis)
+	sort.Strings(status.NotLoaded)
+	return status
+}
+
+// ListAnnouncements 列出群公告任务（groupID 为 0 时列出所有群）
+func ListAnnouncements(groupID int64) []ScheduledTask {
+	var announcements []ScheduledTask
+	tasks, _ := ListTasks(groupID, 0)
+	for _, t := range tasks {
+		if t.Announcement {
+			announcements = append(announcements, t)
+		}
+	}
+	return announcements
+}
+
+// GetTask 按 ID 查找任务（一次性或周期）
+func GetTask(id string) (ScheduledTask, bool) {
+	var t ScheduledTask
+	if database.Redis() == nil {
+		return t, false
+	}
+	ctx := context.Background()
+	data, err := database.Redis().HGet(ctx, HashKeyOneshot, id).Result()
+	if err != nil {
+		data, err = database.Redis().HGet(ctx, HashKeyPeriodic, id).Result()
+	}
+	if err != nil || json.Unmarshal([]byte(data), &t) != nil {
+		return t, false
+	}
+	return t, true
 }
 
 // RemoveTask 移除任务
 func RemoveTask(id string) error {
 	ctx := context.Background()
-	if database.RDB == nil {
+	if removeMemoryTask(id) {
+		return nil
+	}
+	if database.Redis() == nil {
 		return fmt.Errorf("redis not connected")
 	}
 
@@ -196,14 +386,62 @@ func RemoveTask(id string) error {
 		CronManager.Remove(entryID)
 		delete(PeriodicEntries, id)
 		schedulerMu.Unlock()
-		database.RDB.HDel(ctx, HashKeyPeriodic, id)
+		database.Redis().HDel(ctx, HashKeyPeriodic, id)
 		return nil
 	}
 	schedulerMu.Unlock()
 
 	// 尝试从一次性任务中移除
-	database.RDB.ZRem(ctx, ZSetKey, id)
-	return database.RDB.HDel(ctx, HashKeyOneshot, id).Err()
+	database.Redis().ZRem(ctx, ZSetKey, id)
+	return database.Redis().HDel(ctx, HashKeyOneshot, id).Err()
+}
+
+// taskMessage 一次性任务要发送的内容：关怀随访由 LLM/模板生成，其余为提醒原文
+func taskMessage(t ScheduledTask) string {
+	if strings.HasPrefix(t.ID, "proactive_") {
+		return BuildCareMessage(t.Content, t.EventAt, t.GroupID)
+	}
+	return t.Content
+}
+
+// dispatchTask 发送任务消息：群公告不 @ 任何人，周期提醒加前缀
+func dispatchTask(t ScheduledTask, content string) {
+	if GlobalSender == nil {
+		log.Printf("[Scheduler] GlobalSender not set, task %s not delivered", t.ID)
+		return
+	}
+	// 群被移出白名单后，其中的提醒与关怀随访不再发送（任务照常出队，不会反复重试）
+	if t.GroupID != 0 && !IsGroupAllowed(t.GroupID) {
+		log.Printf("[Scheduler] Group %d not allowlisted, task %s dropped", t.GroupID, t.ID)
+		return
+	}
+	content = appendAttachments(content, t.Attachments)
+	if t.CreatedBy != 0 && t.CreatedBy != t.UserID {
+		content = DisplayName(GetUserByQQ(strconv.FormatInt(t.CreatedBy, 10)), t.CreatedBy) + "让我提醒你：" + content
+	}
+	switch {
+	case t.Announcement:
+		GlobalSender(t.GroupID, 0, "【群公告】"+content)
+	case t.Type == "periodic":
+		GlobalSender(t.GroupID, t.UserID, "【周期提醒】"+content)
+	default:
+		GlobalSender(t.GroupID, t.UserID, content)
+	}
+}
+
+// senderMissingLogged 发送函数缺失的日志只在状态变化时输出一次，避免每轮轮询刷屏
+var senderMissingLogged atomic.Bool
+
+// senderReady 消息发送函数是否已设置；未设置时到期的一次性任务保留到之后的轮询，不会被删除
+func senderReady() bool {
+	if GlobalSender != nil {
+		senderMissingLogged.Store(false)
+		return true
+	}
+	if !senderMissingLogged.Swap(true) {
+		log.Printf("[Scheduler] GlobalSender not set, keeping due tasks until it is available")
+	}
+	return false
 }
 
 // startZSetPoll 轮询 Redis ZSet 执行一次性任务
@@ -211,16 +449,25 @@ func startZSetPoll() {
 	ticker := time.NewTicker(5 * time.Second)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		if database.RDB == nil {
+	for tick := 1; ; tick++ {
+		<-ticker.C
+
+		// Redis 不可用时定期重连，恢复后重载周期任务；暂存的一次性任务在内存中执行或写回 Redis
+		if database.Redis() == nil && tick%redisReconnectEvery == 0 && database.ReconnectRedis() {
+			InitGroupAllowlist()
+			go ReloadPeriodicTasks()
+		}
+		runMemoryTasks()
+
+		if database.Redis() == nil || !senderReady() {
 			continue
 		}
 
 		ctx := context.Background()
-		now := time.Now().Unix()
+		now := Now().Unix()
 
 		// 获取已到期的任务 ID
-		ids, err := database.RDB.ZRangeByScore(ctx, ZSetKey, &redis.ZRangeBy{
+		ids, err := database.Redis().ZRangeByScore(ctx, ZSetKey, &redis.ZRangeBy{
 			Min: "0",
 			Max: fmt.Sprintf("%d", now),
 		}).Result()
@@ -230,36 +477,25 @@ func startZSetPoll() {
 
 		for _, id := range ids {
 			// 从 Hash 获取详情
-			data, err := database.RDB.HGet(ctx, HashKeyOneshot, id).Result()
+			data, err := database.Redis().HGet(ctx, HashKeyOneshot, id).Result()
 			if err != nil {
-				database.RDB.ZRem(ctx, ZSetKey, id)
+				database.Redis().ZRem(ctx, ZSetKey, id)
 				continue
 			}
 
 			var t ScheduledTask
 			if err := json.Unmarshal([]byte(data), &t); err != nil {
-				database.RDB.ZRem(ctx, ZSetKey, id)
-				database.RDB.HDel(ctx, HashKeyOneshot, id)
+				database.Redis().ZRem(ctx, ZSetKey, id)
+				database.Redis().HDel(ctx, HashKeyOneshot, id)
 				continue
 			}
 
 			// 执行并移除
-			if GlobalSender != nil {
-				content := t.Content
-				if strings.HasPrefix(t.ID, "proactive_") {
-					reply, err := GetProactiveCareReply(t.Content, t.GroupID)
-					if err == nil && reply != "" {
-						content = reply
-					} else {
-						content = "记得你说今天有事，一切还顺利吗？"
-					}
-				}
-				GlobalSender(t.GroupID, t.UserID, content)
-			}
+			dispatchTask(t, taskMessage(t))
 
 			// 清理
-			database.RDB.ZRem(ctx, ZSetKey, id)
-			database.RDB.HDel(ctx, HashKeyOneshot, id)
+			database.Redis().ZRem(ctx, ZSetKey, id)
+			database.Redis().HDel(ctx, HashKeyOneshot, id)
 		}
 	}
 }
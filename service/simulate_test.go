@@ -0,0 +1,250 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// MockLLMRequest mock LLM 收到的请求（只保留判断所需的字段）
+type MockLLMRequest struct {
+	Messages    []FCMessage // 对话消息（system/user/assistant/tool）
+	Tools       []string    // 本次请求提供给模型的工具名
+	Temperature float64     // 采样温度
+	ToolChoice  interface{} // tool_choice（"auto"、"required" 或指定函数）
+}
+
+// MockLLM 模拟模型：根据请求返回一条 assistant 消息（文本回复或工具调用）
+type MockLLM func(req MockLLMRequest) FCMessage
+
+// SimulateMessage 不经过 QQ，直接用一条消息跑完整的 Function Calling 管线，返回回复和模型调用的工具名。
+// 模型请求发往 mock LLM，工具只记录不执行（返回模拟结果），记忆检索返回空，全部通过 fcEnv 注入
+func SimulateMessage(t *testing.T, llm MockLLM, text string, groupID int64, userID int64, isSuperUser bool) (reply string, toolCalls []string) {
+	t.Helper()
+	server := httptest.NewServer(mockLLMHandler(t, llm))
+	defer server.Close()
+
+	var mu sync.Mutex // 只读工具会并发执行
+	env := fcEnv{
+		chatURL: server.URL,
+		execTool: func(name string, args map[string]interface{}, groupID int64, userID int64, isSuperUser bool) ToolResult {
+			mu.Lock()
+			defer mu.Unlock()
+			toolCalls = append(toolCalls, name)
+			return ToolResult{Success: true, Message: fmt.Sprintf("(模拟执行) %s 已完成", name)}
+		},
+		retrieve: func(ctx context.Context, prompt string, groupID int64, userID int64) retrievalResult {
+			return retrievalResult{}
+		},
+	}
+
+	reply, _, err := getAIResponseWithFC(env, text, groupID, userID, isSuperUser, "")
+	if err != nil {
+		t.Fatalf("simulate %q: %v", text, err)
+	}
+	return reply, toolCalls
+}
+
+// mockLLMHandler 将 OpenAI 兼容的 Chat Completions 请求转交给 mock LLM
+func mockLLMHandler(t *testing.T, m MockLLM) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Messages    []FCMessage `json:"messages"`
+			Tools       []FCTool    `json:"tools"`
+			Temperature float64     `json:"temperature"`
+			ToolChoice  interface{} `json:"tool_choice"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decode request: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		req := MockLLMRequest{Messages: body.Messages, Temperature: body.Temperature, ToolChoice: body.ToolChoice}
+		for _, tool := range body.Tools {
+			req.Tools = append(req.Tools, tool.Function.Name)
+		}
+		msg := m(req)
+		msg.Role = "assistant"
+
+		finishReason := "stop"
+		if len(msg.ToolCalls) > 0 {
+			finishReason = "tool_calls"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"choices": []map[string]interface{}{{"message": msg, "finish_reason": finishReason}},
+		})
+	})
+}
+
+// toolCallMessage 构造调用指定工具的 assistant 消息
+func toolCallMessage(name string, args string) FCMessage {
+	var tc FCToolCall
+	tc.ID = "call_1"
+	tc.Type = "function"
+	tc.Function.Name = name
+	tc.Function.Arguments = args
+	return FCMessage{ToolCalls: []FCToolCall{tc}}
+}
+
+func TestSimulateMessageTriggersTool(t *testing.T) {
+	setupTestEnv(t)
+
+	// 第一轮：用户要求关机时调用 toggle_bot；拿到工具结果后给出文字回复
+	llm := func(req MockLLMRequest) FCMessage {
+		last := req.Messages[len(req.Messages)-1]
+		if last.Role == "tool" {
+			return FCMessage{Content: "好的，已经关掉啦"}
+		}
+		if strings.Contains(last.Content, "关掉") && slices.Contains(req.Tools, "toggle_bot") {
+			return toolCallMessage("toggle_bot", `{"active":false}`)
+		}
+		return FCMessage{Content: "在呢"}
+	}
+
+	reply, calls := SimulateMessage(t, llm, "帮我把机器人关掉", 0, 10001, true)
+	if !slices.Equal(calls, []string{"toggle_bot"}) {
+		t.Errorf("tool calls = %v, want [toggle_bot]", calls)
+	}
+	if reply != "好的，已经关掉啦" {
+		t.Errorf("reply = %q", reply)
+	}
+
+	reply, calls = SimulateMessage(t, llm, "你好", 0, 10001, true)
+	if len(calls) != 0 || reply != "在呢" {
+		t.Errorf("plain chat: reply = %q, calls = %v", reply, calls)
+	}
+}
+
+func TestFCTemperatures(t *testing.T) {
+	cfg := setupTestEnv(t)
+	cfg.SceneTempCasual = 0.8
+
+	var temps []float64
+	llm := func(req MockLLMRequest) FCMessage {
+		temps = append(temps, req.Temperature)
+		if req.Messages[len(req.Messages)-1].Role == "tool" {
+			return FCMessage{Content: "好的，已经关掉啦"}
+		}
+		return toolCallMessage("toggle_bot", `{"active":false}`)
+	}
+	SimulateMessage(t, llm, "帮我把机器人关掉", 0, 10001, true)
+
+	// 选工具的首次请求用固定低温度，拿到工具结果后生成回复用场景温度
+	if want := []float64{fcToolChoiceTemperature, 0.8}; !slices.Equal(temps, want) {
+		t.Errorf("temperatures = %v, want %v", temps, want)
+	}
+}
+
+func TestDirectReplyNotTruncated(t *testing.T) {
+	cfg := setupTestEnv(t)
+	cfg.MaxReplyTokens = 10
+
+	direct := strings.Repeat("⏰ 每天 08:00 提醒：喝水、吃药、看看窗外。\n", 20)
+	longReply := strings.Repeat("今天也要元气满满哦！", 20)
+	llm := func(req MockLLMRequest) FCMessage {
+		if req.Messages[len(req.Messages)-1].Role == "tool" {
+			return FCMessage{Content: longReply}
+		}
+		return FCMessage{ToolCalls: []FCToolCall{
+			toolCallMessage("list_tasks", `{}`).ToolCalls[0],
+			toolCallMessage("get_status", `{}`).ToolCalls[0],
+		}}
+	}
+	server := httptest.NewServer(mockLLMHandler(t, llm))
+	defer server.Close()
+	env := fcEnv{
+		chatURL: server.URL,
+		execTool: func(name string, args map[string]interface{}, groupID int64, userID int64, isSuperUser bool) ToolResult {
+			if name == "list_tasks" {
+				return ToolResult{Success: true, Message: direct, DirectReply: direct}
+			}
+			return ToolResult{Success: true, Message: "一切正常"}
+		},
+		retrieve: func(ctx context.Context, prompt string, groupID int64, userID int64) retrievalResult {
+			return retrievalResult{}
+		},
+	}
+
+	reply, _, err := getAIResponseWithFC(env, "看看我的提醒", 0, 10001, true, "")
+	if err != nil {
+		t.Fatalf("getAIResponseWithFC: %v", err)
+	}
+	// 模型生成的部分按上限截断，原样输出完整附在其后
+	if !strings.HasSuffix(reply, "\n\n"+direct) {
+		t.Errorf("direct reply was cut: %q", reply)
+	}
+	generated := strings.TrimSuffix(reply, "\n\n"+direct)
+	if want := truncateReply(longReply, cfg.MaxReplyTokens); generated != want {
+		t.Errorf("generated part = %q, want %q", generated, want)
+	}
+	if generated == longReply {
+		t.Error("generated part should be truncated")
+	}
+}
+
+func TestActionClaimRetry(t *testing.T) {
+	cfg := setupTestEnv(t)
+	cfg.FCActionCheck = ActionCheckRetry
+
+	tests := []struct {
+		name      string
+		text      string
+		first     string // 首次请求的纯文本回复（声称完成了操作）
+		retryTool string // 重试时模型调用的工具，为空表示模型确认无需操作
+		wantReply string
+		wantCalls []string
+	}{
+		{"casual claim keeps reply", "作业写完了吗", "搞定啦，今天超顺利", "", "搞定啦，今天超顺利", nil},
+		{"unbacked action is executed", "帮我把机器人关掉", "已经关掉啦", "toggle_bot", "这次真的关掉啦", []string{"toggle_bot"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var retryChoice interface{}
+			llm := func(req MockLLMRequest) FCMessage {
+				last := req.Messages[len(req.Messages)-1]
+				switch {
+				case last.Role == "tool":
+					return FCMessage{Content: "这次真的关掉啦"}
+				case last.Content == actionClaimNudge:
+					retryChoice = req.ToolChoice
+					if tt.retryTool != "" {
+						return toolCallMessage(tt.retryTool, `{"active":false}`)
+					}
+					return FCMessage{Content: "没有需要操作的"}
+				}
+				return FCMessage{Content: tt.first}
+			}
+
+			reply, calls := SimulateMessage(t, llm, tt.text, 0, 10001, true)
+			// 重试不强制调用工具，误判的闲聊不会触发有副作用的工具
+			if retryChoice != "auto" {
+				t.Errorf("retry tool_choice = %v, want auto", retryChoice)
+			}
+			if reply != tt.wantReply || !slices.Equal(calls, tt.wantCalls) {
+				t.Errorf("reply = %q, calls = %v; want %q, %v", reply, calls, tt.wantReply, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestTimeoutResult(t *testing.T) {
+	// 有副作用的工具超时后仍可能生效，不能让模型告诉用户操作失败
+	action := timeoutResult("toggle_bot", 30*time.Second)
+	if action.Success || !strings.Contains(action.Message, "结果未知") || strings.Contains(action.Message, "没有拿到结果") {
+		t.Errorf("side-effect timeout message = %q, want outcome unknown", action.Message)
+	}
+
+	readOnly := timeoutResult("get_bot_status", 30*time.Second)
+	if readOnly.Success || !strings.Contains(readOnly.Message, "没有拿到结果") {
+		t.Errorf("read-only timeout message = %q", readOnly.Message)
+	}
+}
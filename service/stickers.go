@@ -0,0 +1,42 @@
+package service
+
+import (
+	"regexp"
+
+	"gin-bot/config"
+)
+
+// faceCQRegex 匹配 QQ 小黄脸表情 CQ 码并捕获表情 ID
+var faceCQRegex = regexp.MustCompile(`\[CQ:face,id=(\d+)[^\]]*\]`)
+
+// defaultStickerText 常用小黄脸表情 ID 对应的文字（可用 STICKER_TEXT 覆盖或补充）
+var defaultStickerText = map[string]string{
+	"0": "惊讶", "1": "撇嘴", "2": "色", "4": "得意", "5": "流泪", "6": "害羞",
+	"9": "大哭", "10": "尴尬", "11": "发怒", "12": "调皮", "13": "呲牙", "14": "微笑",
+	"15": "难过", "18": "抓狂", "20": "偷笑", "21": "可爱", "22": "白眼", "25": "困",
+	"26": "惊恐", "27": "流汗", "28": "憨笑", "32": "疑问", "34": "晕", "36": "衰",
+	"39": "再见", "49": "拥抱", "53": "蛋糕", "63": "玫瑰", "66": "爱心", "67": "心碎",
+	"76": "赞", "77": "踩", "99": "鼓掌", "101": "坏笑", "106": "委屈", "107": "快哭了",
+	"111": "可怜", "173": "泪奔", "174": "无奈", "178": "斜眼笑", "179": "doge",
+	"182": "笑哭", "212": "托腮", "264": "捂脸", "271": "吃瓜", "277": "汪汪",
+}
+
+// ExpandStickers 把消息中的小黄脸表情替换为 "[表情:笑哭]" 形式的文字，
+// 只发表情的消息因此也能参与分类、检索和接话；未收录的表情保持原样（随后会被当作 CQ 码清理）
+func ExpandStickers(content string) string {
+	cfg := config.Get()
+	if cfg == nil || !cfg.StickerTextEnabled {
+		return content
+	}
+	return faceCQRegex.ReplaceAllStringFunc(content, func(code string) string {
+		id := faceCQRegex.FindStringSubmatch(code)[1]
+		text, ok := cfg.StickerText[id]
+		if !ok {
+			text, ok = defaultStickerText[id]
+		}
+		if !ok || text == "" {
+			return code
+		}
+		return "[表情:" + text + "]"
+	})
+}
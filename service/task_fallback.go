@@ -0,0 +1,82 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync"
+
+	"gin-bot/config"
+	"gin-bot/database"
+)
+
+// ErrTaskNotPersisted 任务已暂存在内存中（Redis 不可用），重启前未写回 Redis 会丢失
+var ErrTaskNotPersisted = errors.New("task kept in memory until redis is available")
+
+// redisReconnectEvery Redis 不可用时，每隔多少轮轮询尝试重连一次
+const redisReconnectEvery = 6
+
+var (
+	memoryTasksMu sync.Mutex
+	memoryTasks   = make(map[string]ScheduledTask) // Redis 不可用时暂存的一次性任务
+)
+
+// keepTaskInMemory 暂存一次性任务（需开启 TASK_MEMORY_FALLBACK），返回是否已暂存
+func keepTaskInMemory(t ScheduledTask) bool {
+	if !config.Get().TaskMemoryFallback || t.Type == "periodic" {
+		return false
+	}
+	memoryTasksMu.Lock()
+	memoryTasks[t.ID] = t
+	memoryTasksMu.Unlock()
+	log.Printf("[Scheduler] Redis unavailable, keeping task %s in memory", t.ID)
+	return true
+}
+
+// listMemoryTasks 暂存在内存中的任务（按执行时间排序）
+func listMemoryTasks() []ScheduledTask {
+	memoryTasksMu.Lock()
+	defer memoryTasksMu.Unlock()
+	tasks := make([]ScheduledTask, 0, len(memoryTasks))
+	for _, t := range memoryTasks {
+		tasks = append(tasks, t)
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].TargetAt < tasks[j].TargetAt })
+	return tasks
+}
+
+// removeMemoryTask 移除暂存的任务，返回是否存在
+func removeMemoryTask(id string) bool {
+	memoryTasksMu.Lock()
+	defer memoryTasksMu.Unlock()
+	_, ok := memoryTasks[id]
+	delete(memoryTasks, id)
+	return ok
+}
+
+// runMemoryTasks 执行已到期的暂存任务；Redis 可用时把其余任务写回 Redis
+func runMemoryTasks() {
+	now := Now().Unix()
+	for _, t := range listMemoryTasks() {
+		if t.TargetAt <= now {
+			if !senderReady() {
+				continue
+			}
+			removeMemoryTask(t.ID)
+			dispatchTask(t, taskMessage(t))
+			continue
+		}
+		if database.Redis() == nil {
+			continue
+		}
+		removeMemoryTask(t.ID)
+		if err := AddTask(t); err != nil {
+			if !errors.Is(err, ErrTaskNotPersisted) {
+				log.Printf("[Scheduler] Failed to flush task %s to redis: %v", t.ID, err)
+				keepTaskInMemory(t)
+			}
+			continue
+		}
+		log.Printf("[Scheduler] Flushed in-memory task %s to redis", t.ID)
+	}
+}
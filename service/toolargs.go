@@ -0,0 +1,207 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ToolArgError 工具参数校验错误，逐条列出问题，便于模型据此修正后重试
+type ToolArgError struct {
+	Issues []string
+}
+
+func (e *ToolArgError) Error() string {
+	return "参数校验失败: " + strings.Join(e.Issues, "; ")
+}
+
+// coerceToolArgs 按工具声明的 JSON Schema 校验并修正参数
+// 处理模型常见的类型偏差（"true" → true、"3600" → 3600、数字 → 字符串），并检查必填项与枚举值
+func coerceToolArgs(schema map[string]interface{}, args map[string]interface{}) (map[string]interface{}, error) {
+	props, _ := schema["properties"].(map[string]interface{})
+	coerced := make(map[string]interface{}, len(args))
+	var issues []string
+
+	for name, value := range args {
+		prop, ok := props[name].(map[string]interface{})
+		if !ok {
+			coerced[name] = value // 未声明的参数原样保留，由工具自行忽略
+			continue
+		}
+		if value == nil {
+			continue // null 视为未提供，交给必填检查
+		}
+
+		typ, _ := prop["type"].(string)
+		v, err := coerceValue(typ, value)
+		if err != nil {
+			issues = append(issues, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		if enum := schemaStrings(prop["enum"]); len(enum) > 0 {
+			if v, err = matchEnum(v, enum); err != nil {
+				issues = append(issues, fmt.Sprintf("%s: %v", name, err))
+				continue
+			}
+		}
+		coerced[name] = v
+	}
+
+	for _, name := range schemaStrings(schema["required"]) {
+		if _, ok := coerced[name]; !ok && !containsIssue(issues, name) {
+			issues = append(issues, fmt.Sprintf("%s: 缺少必填参数", name))
+		}
+	}
+
+	if len(issues) > 0 {
+		return coerced, &ToolArgError{Issues: issues}
+	}
+	return coerced, nil
+}
+
+// coerceValue 将单个参数值转换为 schema 声明的类型
+func coerceValue(typ string, value interface{}) (interface{}, error) {
+	switch typ {
+	case "boolean":
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case float64:
+			if v == 0 || v == 1 {
+				return v == 1, nil
+			}
+		case string:
+			switch strings.ToLower(strings.TrimSpace(v)) {
+			case "true", "1", "yes", "on", "开", "开启", "是":
+				return true, nil
+			case "false", "0", "no", "off", "关", "关闭", "否":
+				return false, nil
+			}
+		}
+		return nil, fmt.Errorf("应为布尔值 true/false，收到 %v", value)
+
+	case "integer", "number":
+		var n float64
+		switch v := value.(type) {
+		case float64:
+			n = v
+		case string:
+			f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return nil, fmt.Errorf("应为数字，收到 %q", v)
+			}
+			n = f
+		default:
+			return nil, fmt.Errorf("应为数字，收到 %v", value)
+		}
+		if typ == "integer" && n != math.Trunc(n) {
+			return nil, fmt.Errorf("应为整数，收到 %v", n)
+		}
+		return n, nil
+
+	case "string":
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		case bool:
+			return strconv.FormatBool(v), nil
+		}
+		return nil, fmt.Errorf("应为字符串，收到 %v", value)
+	}
+
+	return value, nil // 未声明类型或复合类型不做转换
+}
+
+// matchEnum 校验枚举值（忽略大小写与首尾空白）
+func matchEnum(value interface{}, enum []string) (interface{}, error) {
+	s, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("应为以下之一: %s", strings.Join(enum, "/"))
+	}
+	for _, e := range enum {
+		if strings.EqualFold(strings.TrimSpace(s), e) {
+			return e, nil
+		}
+	}
+	return nil, fmt.Errorf("应为以下之一: %s，收到 %q", strings.Join(enum, "/"), s)
+}
+
+// schemaStrings 读取 schema 中的字符串列表（兼容 []string 与 JSON 解码得到的 []interface{}）
+func schemaStrings(v interface{}) []string {
+	switch list := v.(type) {
+	case []string:
+		return list
+	case []interface{}:
+		out := make([]string, 0, len(list))
+		for _, item := range list {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// containsIssue 判断某参数是否已记录过问题，避免重复报告
+func containsIssue(issues []string, name string) bool {
+	for _, issue := range issues {
+		if strings.HasPrefix(issue, name+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// extractJSONObject 从模型输出的参数字符串中提取第一个完整的 JSON 对象
+// 兼容 ```json 代码块、前后附带说明文字，以及整体被二次编码为 JSON 字符串的情况
+func extractJSONObject(raw string) (string, bool) {
+	s := strings.TrimSpace(raw)
+
+	// 二次编码："{\"key\": 1}"
+	if strings.HasPrefix(s, `"`) {
+		var inner string
+		if err := json.Unmarshal([]byte(s), &inner); err == nil {
+			s = strings.TrimSpace(inner)
+		}
+	}
+
+	start := strings.Index(s, "{")
+	if start < 0 {
+		return "", false
+	}
+
+	depth := 0
+	inString := false
+	escaped := false
+	for i := start; i < len(s); i++ {
+		c := s[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return s[start : i+1], true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCoerceToolArgs(t *testing.T) {
+	schema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"enabled": map[string]interface{}{"type": "boolean"},
+			"seconds": map[string]interface{}{"type": "integer"},
+			"ratio":   map[string]interface{}{"type": "number"},
+			"name":    map[string]interface{}{"type": "string"},
+			"mode":    map[string]interface{}{"type": "string", "enum": []interface{}{"add", "remove"}},
+			"options": map[string]interface{}{"type": "object"},
+			"items":   map[string]interface{}{"type": "array"},
+		},
+		"required": []interface{}{"mode"},
+	}
+
+	tests := []struct {
+		name   string
+		args   map[string]interface{}
+		want   map[string]interface{}
+		issues []string // 期望出现问题的参数名，为空表示校验通过
+	}{
+		{
+			name: "numeric strings",
+			args: map[string]interface{}{"mode": "add", "seconds": "3600", "ratio": " 0.5 "},
+			want: map[string]interface{}{"mode": "add", "seconds": 3600.0, "ratio": 0.5},
+		},
+		{
+			name: "boolean strings",
+			args: map[string]interface{}{"mode": "add", "enabled": "TRUE"},
+			want: map[string]interface{}{"mode": "add", "enabled": true},
+		},
+		{
+			name: "false string",
+			args: map[string]interface{}{"mode": "remove", "enabled": "false"},
+			want: map[string]interface{}{"mode": "remove", "enabled": false},
+		},
+		{
+			name: "zero as boolean",
+			args: map[string]interface{}{"mode": "add", "enabled": 0.0},
+			want: map[string]interface{}{"mode": "add", "enabled": false},
+		},
+		{
+			name: "number to string",
+			args: map[string]interface{}{"mode": "add", "name": 12345.0},
+			want: map[string]interface{}{"mode": "add", "name": "12345"},
+		},
+		{
+			name: "enum matched case-insensitively",
+			args: map[string]interface{}{"mode": " ADD "},
+			want: map[string]interface{}{"mode": "add"},
+		},
+		{
+			name: "nested values pass through",
+			args: map[string]interface{}{
+				"mode":    "add",
+				"options": map[string]interface{}{"seconds": "60", "deep": map[string]interface{}{"on": "true"}},
+				"items":   []interface{}{"1", 2.0, map[string]interface{}{"x": "y"}},
+			},
+			want: map[string]interface{}{
+				"mode":    "add",
+				"options": map[string]interface{}{"seconds": "60", "deep": map[string]interface{}{"on": "true"}},
+				"items":   []interface{}{"1", 2.0, map[string]interface{}{"x": "y"}},
+			},
+		},
+		{
+			name: "undeclared args kept and null dropped",
+			args: map[string]interface{}{"mode": "add", "extra": "x", "name": nil},
+			want: map[string]interface{}{"mode": "add", "extra": "x"},
+		},
+		{
+			name:   "fractional integer",
+			args:   map[string]interface{}{"mode": "add", "seconds": "1.5"},
+			want:   map[string]interface{}{"mode": "add"},
+			issues: []string{"seconds"},
+		},
+		{
+			name:   "unparseable values",
+			args:   map[string]interface{}{"mode": "add", "enabled": "maybe", "ratio": "abc"},
+			want:   map[string]interface{}{"mode": "add"},
+			issues: []string{"enabled", "ratio"},
+		},
+		{
+			name:   "missing required",
+			args:   map[string]interface{}{"seconds": 10.0},
+			want:   map[string]interface{}{"seconds": 10.0},
+			issues: []string{"mode"},
+		},
+		{
+			name:   "enum mismatch is not reported twice",
+			args:   map[string]interface{}{"mode": "toggle"},
+			want:   map[string]interface{}{},
+			issues: []string{"mode"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := coerceToolArgs(schema, tt.args)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("coerced = %#v, want %#v", got, tt.want)
+			}
+			if len(tt.issues) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var argErr *ToolArgError
+			if !errors.As(err, &argErr) {
+				t.Fatalf("error = %v, want *ToolArgError", err)
+			}
+			if len(argErr.Issues) != len(tt.issues) {
+				t.Errorf("issues = %q, want one per %q", argErr.Issues, tt.issues)
+			}
+			for _, name := range tt.issues {
+				if !containsIssue(argErr.Issues, name) {
+					t.Errorf("issues = %q, missing %s", argErr.Issues, name)
+				}
+			}
+		})
+	}
+}
+
+func TestCoerceToolArgsWithoutSchema(t *testing.T) {
+	args := map[string]interface{}{"a": "1", "b": map[string]interface{}{"c": true}}
+	got, err := coerceToolArgs(map[string]interface{}{}, args)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, args) {
+		t.Errorf("coerced = %#v, want args unchanged", got)
+	}
+	if (&ToolArgError{Issues: []string{"a: x", "b: y"}}).Error() != "参数校验失败: a: x; b: y" {
+		t.Error("ToolArgError message format changed")
+	}
+}
+
+func TestExtractJSONObject(t *testing.T) {
+	tests := []struct {
+		name   string
+		raw    string
+		want   string
+		wantOK bool
+	}{
+		{"plain", `{"a":1}`, `{"a":1}`, true},
+		{"fenced", "```json\n{\"seconds\": 60}\n```", `{"seconds": 60}`, true},
+		{"trailing prose", `{"a":1} 以上是参数`, `{"a":1}`, true},
+		{"leading prose", `参数如下：{"a":"b"}`, `{"a":"b"}`, true},
+		{"nested braces", `{"a":{"b":{"c":1}},"d":2} extra }`, `{"a":{"b":{"c":1}},"d":2}`, true},
+		{"braces inside strings", `{"text":"a } b { c","q":"\"}"}`, `{"text":"a } b { c","q":"\"}"}`, true},
+		{"double encoded", `"{\"a\": 1}"`, `{"a": 1}`, true},
+		{"first of two objects", `{"a":1}{"b":2}`, `{"a":1}`, true},
+		{"unterminated", `{"a":{"b":1}`, "", false},
+		{"no object", `好的，我来设置提醒`, "", false},
+		{"empty", ``, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := extractJSONObject(tt.raw)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("extractJSONObject(%q) = %q, %v; want %q, %v", tt.raw, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestParseToolArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want map[string]interface{}
+	}{
+		{"empty", "", map[string]interface{}{}},
+		{"empty object", "{}", map[string]interface{}{}},
+		{"valid", `{"seconds":60,"message":"喝水"}`, map[string]interface{}{"seconds": 60.0, "message": "喝水"}},
+		{"fenced", "```json\n{\"seconds\": 60}\n```", map[string]interface{}{"seconds": 60.0}},
+		{"trailing prose", `{"query":"生日"} 我会帮你查`, map[string]interface{}{"query": "生日"}},
+		{"nested", `好的 {"a":{"b":[1,{"c":"}"}]}}`, map[string]interface{}{"a": map[string]interface{}{"b": []interface{}{1.0, map[string]interface{}{"c": "}"}}}}},
+		{"double encoded", `"{\"enabled\": true}"`, map[string]interface{}{"enabled": true}},
+		{"malformed", `{"a":1,}`, map[string]interface{}{}},
+		{"unterminated", `{"a":`, map[string]interface{}{}},
+		{"not an object", `[1,2,3]`, map[string]interface{}{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseToolArgs(tt.raw)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseToolArgs(%q) = %#v, want %#v", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
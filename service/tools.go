@@ -1,11 +1,21 @@
 package service
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"gin-bot/config"
 	"gin-bot/database"
+	"gin-bot/embedding"
 	"gin-bot/models"
+	"gin-bot/pinecone"
+	"log"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"gorm.io/gorm"
 )
@@ -16,6 +26,8 @@ type Tool struct {
 	Description  string                 `json:"description"`
 	Parameters   map[string]interface{} `json:"parameters"`
 	RequireAdmin bool                   `json:"-"` // 是否需要管理员权限
+	ReadOnly     bool                   `json:"-"` // 是否无副作用（可与其他只读工具并发执行）
+	Timeout      time.Duration          `json:"-"` // 执行超时时间，0 使用全局默认值（TOOL_TIMEOUT）
 }
 
 // ToolCall AI 返回的工具调用请求
@@ -29,6 +41,10 @@ type ToolResult struct {
 	Success bool   `json:"success"`
 	Message string `json:"message"`
 	Data    any    `json:"data,omitempty"`
+
+	// DirectReply 非空时原样发给用户（如任务列表），不经过模型改写；
+	// 本轮工具全部带 DirectReply 时跳过二次生成，否则附在模型回复之后
+	DirectReply string `json:"-"`
 }
 
 // AvailableTools 定义所有可用的工具
@@ -51,6 +67,7 @@ var AvailableTools = []Tool{
 	{
 		Name:        "get_bot_status",
 		Description: "查询机器人当前在本群的状态（是否开启）。当用户询问机器人是否开着、什么状态时调用。",
+		ReadOnly:    true,
 		Parameters: map[string]interface{}{
 			"type":       "object",
 			"properties": map[string]interface{}{},
@@ -71,9 +88,40 @@ var AvailableTools = []Tool{
 			"required": []string{"enabled"},
 		},
 	},
+	{
+		Name:         "toggle_proactive",
+		Description:  "开启或关闭机器人在本群的主动插嘴功能（不影响记忆功能）。当用户嫌机器人插嘴太多、不想让它主动接话，或者想恢复主动接话时调用。",
+		RequireAdmin: true,
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"enabled": map[string]interface{}{
+					"type":        "boolean",
+					"description": "true 表示开启主动插嘴，false 表示关闭",
+				},
+			},
+			"required": []string{"enabled"},
+		},
+	},
+	{
+		Name:         "toggle_care",
+		Description:  "开启或关闭机器人的主动关怀（随访）功能：群友提到面试、情绪低落等事情后，机器人几小时后会主动来问候。当用户觉得被回访很别扭，或者想恢复这种关心时调用。",
+		RequireAdmin: true,
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"enabled": map[string]interface{}{
+					"type":        "boolean",
+					"description": "true 表示开启主动关怀，false 表示关闭",
+				},
+			},
+			"required": []string{"enabled"},
+		},
+	},
 	{
 		Name:        "get_rag_status",
 		Description: "查询RAG记忆功能当前状态。当用户问机器人是否在记录消息时调用。",
+		ReadOnly:    true,
 		Parameters: map[string]interface{}{
 			"type":       "object",
 			"properties": map[string]interface{}{},
@@ -81,7 +129,7 @@ var AvailableTools = []Tool{
 	},
 	{
 		Name:        "add_timer_task",
-		Description: "设置定时提醒任务。可以是单次提醒（如10分钟后提醒我喝水）或周期性闹钟（如每天早上9点提醒我打卡）。",
+		Description: "设置定时提醒任务。可以是单次提醒（如10分钟后提醒我喝水）或周期性闹钟（如每天早上9点提醒我打卡）。周期闹钟优先使用 recurrence + at 描述，只有无法表达时才使用 cron_expr。也可以替用户提醒群里的其他人（如'帮我提醒老王明天交报告'），此时传 target_user。",
 		Parameters: map[string]interface{}{
 			"type": "object",
 			"properties": map[string]interface{}{
@@ -98,9 +146,39 @@ var AvailableTools = []Tool{
 					"type":        "integer",
 					"description": "针对 once 类型，设置多少秒后执行提醒。请根据用户描述转换，如'一小时后'转为 3600。",
 				},
+				"recurrence": map[string]interface{}{
+					"type":        "string",
+					"enum":        []string{"daily", "weekly", "monthly"},
+					"description": "针对 periodic 类型：daily 每天，weekly 每周，monthly 每月。需配合 at 使用。",
+				},
+				"at": map[string]interface{}{
+					"type":        "string",
+					"description": "配合 recurrence 使用，提醒时刻（24 小时制 HH:MM），如 '09:00'。",
+				},
+				"weekday": map[string]interface{}{
+					"type":        "integer",
+					"description": "recurrence 为 weekly 时必填，1-7 分别表示周一到周日。",
+				},
+				"day_of_month": map[string]interface{}{
+					"type":        "integer",
+					"description": "recurrence 为 monthly 时必填，每月几号（1-31，没有该日期的月份会跳过）。",
+				},
 				"cron_expr": map[string]interface{}{
 					"type":        "string",
-					"description": "针对 periodic 类型，提供标准 Cron 表达式（带秒级，6位）。如每天早九点：'0 0 9 * * *'。",
+					"description": "【高级】针对 periodic 类型，recurrence 无法表达时才使用：标准 Cron 表达式（带秒级，6位）。如每个工作日早九点：'0 0 9 * * 1-5'。",
+				},
+				"announcement": map[string]interface{}{
+					"type":        "boolean",
+					"description": "【仅超级用户】设为群公告：到时间发到本群且不 @ 任何人，用 list_announcements 管理。普通提醒不要传。",
+				},
+				"attachments": map[string]interface{}{
+					"type":        "array",
+					"items":       map[string]interface{}{"type": "string"},
+					"description": "到时间随提醒一起发送的附件：用户消息中的占位符（如 '[图片1]'）或图片/文件的 URL。用户提到'带上这张图''这份文件'时传入。",
+				},
+				"target_user": map[string]interface{}{
+					"type":        "string",
+					"description": "提醒对象不是用户本人时填写（仅群聊）：对方的 QQ 号、消息中 @ 对方的 CQ 码（如 '[CQ:at,qq=123]'）或对方的称呼/昵称。提醒用户自己时不要传。",
 				},
 			},
 			"required": []string{"type", "content"},
@@ -109,6 +187,7 @@ var AvailableTools = []Tool{
 	{
 		Name:        "list_timer_tasks",
 		Description: "列出当前用户在本群设置的所有活跃定时提醒和周期闹钟。当用户想看自己设了哪些闹钟、想管理提醒时调用。",
+		ReadOnly:    true,
 		Parameters: map[string]interface{}{
 			"type":       "object",
 			"properties": map[string]interface{}{},
@@ -128,121 +207,1875 @@ var AvailableTools = []Tool{
 			"required": []string{"id"},
 		},
 	},
+	{
+		Name:         "list_announcements",
+		Description:  "列出本群已安排的群公告（定时发送、不 @ 个人的通知），不包含群友的个人提醒。管理员想查看或整理公告时调用。",
+		RequireAdmin: true,
+		ReadOnly:     true,
+		Parameters: map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{},
+		},
+	},
+	{
+		Name:         "remove_announcement",
+		Description:  "取消指定的群公告。需要提供公告 ID，建议先调用 list_announcements 获取。",
+		RequireAdmin: true,
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"id": map[string]interface{}{
+					"type":        "string",
+					"description": "要取消的公告 ID。",
+				},
+			},
+			"required": []string{"id"},
+		},
+	},
+	{
+		Name:         "test_cron",
+		Description:  "【超级用户】检查一个 6 位 cron 表达式（秒 分 时 日 月 周）是否有效，并列出接下来 3 次触发时间。用于在设置周期任务或群公告前确认表达式写对了。",
+		RequireAdmin: true,
+		ReadOnly:     true,
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"cron_expr": map[string]interface{}{
+					"type":        "string",
+					"description": "要检查的 cron 表达式，如 '0 0 9 * * 1'（每周一 9 点）。",
+				},
+			},
+			"required": []string{"cron_expr"},
+		},
+	},
+	{
+		Name:        "share_personal_fact",
+		Description: "将用户自己的某条个人信息标记为共享（或取消共享）。共享后其他群友和机器人聊天时也能被想起，比如'我是老师这件事可以告诉大家'。默认所有个人信息都是私有的。",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"fact": map[string]interface{}{
+					"type":        "string",
+					"description": "要共享的个人信息描述，如'我是老师'。",
+				},
+				"shared": map[string]interface{}{
+					"type":        "boolean",
+					"description": "true 表示共享给大家，false 表示改回私有。默认为 true。",
+				},
+			},
+			"required": []string{"fact"},
+		},
+	},
+	{
+		Name:        "whos_around",
+		Description: "查看群友们最近几个小时的临时动态（谁在忙、谁去吃饭了、谁去睡觉了），用于回答'大家都在干嘛''XX 去哪了'之类的问题。",
+		ReadOnly:    true,
+		Parameters: map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{},
+		},
+	},
+	{
+		Name:        "last_seen",
+		Description: "查询某个群友最近一次在本群说话是什么时候，用于回答'小明最近在吗''老王多久没冒泡了'之类的问题。",
+		ReadOnly:    true,
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"user": map[string]interface{}{
+					"type":        "string",
+					"description": "群友的 QQ 号、称呼或 QQ 昵称，如 '小明'。",
+				},
+			},
+			"required": []string{"user"},
+		},
+	},
+	{
+		Name:        "set_my_alias",
+		Description: "设置用户希望机器人怎么称呼自己，比如用户说'叫我老王'、'以后喊我小李'。传空字符串表示恢复使用 QQ 昵称。",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"alias": map[string]interface{}{
+					"type":        "string",
+					"description": "希望被称呼的名字，如'老王'。",
+				},
+			},
+			"required": []string{"alias"},
+		},
+	},
+	{
+		Name:        "search_my_memories",
+		Description: "按关键词或描述搜索机器人记住的关于当前用户的内容（个人信息和用户说过的话），用于回答'我之前是不是提过XX''我跟你说过我住哪吗'之类的问题。结果会直接列给用户。",
+		ReadOnly:    true,
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"query": map[string]interface{}{
+					"type":        "string",
+					"description": "要搜索的内容，如'推荐的那家店'。",
+				},
+				"limit": map[string]interface{}{
+					"type":        "integer",
+					"description": "最多返回几条，默认 5，最多 10。",
+				},
+			},
+			"required": []string{"query"},
+		},
+	},
+	{
+		Name:        "gold_history",
+		Description: "查询当前用户的金币余额和最近的金币收支记录（签到、奖励等），用于回答'我的金币怎么来的''最近花了多少金币'之类的问题。结果会直接列给用户。",
+		ReadOnly:    true,
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"limit": map[string]interface{}{
+					"type":        "integer",
+					"description": "最多返回几条，默认 10，最多 30。",
+				},
+			},
+		},
+	},
+	{
+		Name:         "inspect_user_memories",
+		Description:  "【超级用户】查看机器人记住的某个 QQ 用户的个人信息和最近聊天记录，用于管理和排查问题。访问会被审计记录。",
+		RequireAdmin: true,
+		ReadOnly:     true,
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"qq": map[string]interface{}{
+					"type":        "string",
+					"description": "要查看的用户 QQ 号。",
+				},
+			},
+			"required": []string{"qq"},
+		},
+	},
+	{
+		Name:         "set_random_reply",
+		Description:  "设置机器人在本群随口接话的概率和冷却时间：没人 @ 它时也会偶尔插一句活跃气氛。概率为 0 表示关闭。",
+		RequireAdmin: true,
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"probability": map[string]interface{}{
+					"type":        "number",
+					"description": "每条消息触发随口接话的概率，0 到 1 之间，如 0.05 表示 5%。0 表示关闭。",
+				},
+				"cooldown_minutes": map[string]interface{}{
+					"type":        "integer",
+					"description": "两次随口接话之间至少间隔多少分钟，默认 30。",
+				},
+			},
+			"required": []string{"probability"},
+		},
+	},
+	{
+		Name:         "set_max_reply_tokens",
+		Description:  "设置机器人在本群回复的长度上限（token 数，约等于汉字数），让回复更简短、成本更可控。传 0 恢复默认值。",
+		RequireAdmin: true,
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"max_tokens": map[string]interface{}{
+					"type":        "integer",
+					"description": "回复长度上限，如 200。0 表示恢复默认。",
+				},
+			},
+			"required": []string{"max_tokens"},
+		},
+	},
+	{
+		Name:         "set_off_behavior",
+		Description:  "设置机器人在本群被关闭后有人 @ 它时的表现：silent 完全不回复，notice 偶尔回复一句'我被管理员静音了'（有频率限制），避免群友以为机器人坏了。",
+		RequireAdmin: true,
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"behavior": map[string]interface{}{
+					"type":        "string",
+					"enum":        []string{OffBehaviorSilent, OffBehaviorNotice},
+					"description": "silent 表示静默，notice 表示提示已静音。",
+				},
+			},
+			"required": []string{"behavior"},
+		},
+	},
+	{
+		Name:         "set_reply_style",
+		Description:  "设置机器人在本群回复被 @ 的消息时的形式：at 只 @ 对方，quote 引用对方的原消息（群里消息多时更容易看出在回复谁），both 引用并 @。",
+		RequireAdmin: true,
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"style": map[string]interface{}{
+					"type":        "string",
+					"enum":        []string{ReplyStyleAt, ReplyStyleQuote, ReplyStyleBoth},
+					"description": "at 表示 @ 对方（默认），quote 表示引用原消息，both 表示引用并 @。",
+				},
+			},
+			"required": []string{"style"},
+		},
+	},
+	{
+		Name:         "set_archive_sample_rate",
+		Description:  "设置本群消息的记忆采样率：在消息很多的群里，只让一部分没有 @ 机器人的消息进入长期记忆，以节省开销。@ 机器人的消息始终会被记住。",
+		RequireAdmin: true,
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"rate": map[string]interface{}{
+					"type":        "number",
+					"description": "进入长期记忆的比例，0 到 1 之间，如 0.2 表示 20%。1 表示全部处理，0 表示只存档不记忆。",
+				},
+			},
+			"required": []string{"rate"},
+		},
+	},
+	{
+		Name:         "set_proactive_daily_cap",
+		Description:  "设置本群每天最多主动插嘴（未被 @ 时主动接话）的次数，避免在很活跃的日子里频繁打扰。每天当地午夜重置。",
+		RequireAdmin: true,
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"limit": map[string]interface{}{
+					"type":        "integer",
+					"description": "每天的次数上限，0 表示不限制，-1 表示恢复默认值。",
+				},
+			},
+			"required": []string{"limit"},
+		},
+	},
+	{
+		Name:         "set_group_theme",
+		Description:  "设置本群的主题（如'考研学习''原神''摄影'），机器人在本群说话时会贴合这个主题的氛围和话题。传空字符串清除主题。",
+		RequireAdmin: true,
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"theme": map[string]interface{}{
+					"type":        "string",
+					"description": "群主题，简短描述即可，如'考研学习'。空字符串表示清除。",
+				},
+			},
+			"required": []string{"theme"},
+		},
+	},
+	{
+		Name:         "set_group_language",
+		Description:  "设置机器人在本群使用的语言（如'English''日本語'），被 @ 回复、插嘴和主动关怀都会用这种语言。传空字符串恢复中文。",
+		RequireAdmin: true,
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"language": map[string]interface{}{
+					"type":        "string",
+					"description": "回复语言，如'English'。空字符串表示恢复中文。",
+				},
+				"care_template": map[string]interface{}{
+					"type":        "string",
+					"description": "可选：关怀消息生成失败时使用的本群模板（用该语言写），{reason} 为提醒缘由，{content} 为之前的话。不传则保持不变，空字符串表示使用全局模板。",
+				},
+			},
+			"required": []string{"language"},
+		},
+	},
+	{
+		Name:        "get_group_theme",
+		Description: "查看本群当前设置的主题和语言。",
+		ReadOnly:    true,
+		Parameters: map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{},
+		},
+	},
+	{
+		Name:         "broadcast_message",
+		Description:  "【超级用户】向所有开启了机器人的群发送公告（如'机器人今晚维护'）。必须分两步：第一次只传 message 进行预览，把将要发送的群数量告诉用户并请其确认；用户在下一条消息中明确确认后再以 confirm=true 调用才会真正发送（同一轮对话中的确认会被拒绝），发送对象为预览时列出的群。",
+		RequireAdmin: true,
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"message": map[string]interface{}{
+					"type":        "string",
+					"description": "要广播的公告内容（预览时必填）。",
+				},
+				"confirm": map[string]interface{}{
+					"type":        "boolean",
+					"description": "用户确认后传 true，发送最近一次预览的公告。",
+				},
+			},
+		},
+	},
+	{
+		Name:         "feedback_summary",
+		Description:  "【超级用户】查看群友对机器人回复的好评/差评统计，以及最近被差评的回复，用于分析哪些回答不好。",
+		RequireAdmin: true,
+		ReadOnly:     true,
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"all_groups": map[string]interface{}{
+					"type":        "boolean",
+					"description": "true 表示统计所有群，默认只统计本群。",
+				},
+			},
+		},
+	},
+	{
+		Name:         "manage_memory_blocklist",
+		Description:  "管理本群的记忆黑名单：黑名单中的 QQ（如其他机器人、公告号）发的消息不会被记录。可以添加、移除或查看名单。",
+		RequireAdmin: true,
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"action": map[string]interface{}{
+					"type":        "string",
+					"enum":        []string{"add", "remove", "list"},
+					"description": "add 添加，remove 移除，list 查看当前名单。",
+				},
+				"qq": map[string]interface{}{
+					"type":        "string",
+					"description": "目标 QQ 号，add/remove 时必填。",
+				},
+			},
+			"required": []string{"action"},
+		},
+	},
+	{
+		Name:         "pin_memory",
+		Description:  "置顶或取消置顶一条重要记忆（如'老板的生日是 3 月 5 日'）：置顶的记忆永远不会被清理，回忆时也更容易被想起。也可以查看本群已置顶的记忆。",
+		RequireAdmin: true,
+		Timeout:      15 * time.Second,
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"action": map[string]interface{}{
+					"type":        "string",
+					"enum":        []string{"pin", "unpin", "list"},
+					"description": "pin 置顶，unpin 取消置顶，list 查看已置顶的记忆。",
+				},
+				"memory": map[string]interface{}{
+					"type":        "string",
+					"description": "要置顶/取消置顶的记忆描述，如'老板生日'。pin/unpin 时与 vector_id 二选一。",
+				},
+				"vector_id": map[string]interface{}{
+					"type":        "string",
+					"description": "记忆 ID（如 msg_123，可从 list 或 inspect_user_memories 的结果中获得），比描述更精确。",
+				},
+			},
+			"required": []string{"action"},
+		},
+	},
+	{
+		Name:         "review_care_tasks",
+		Description:  "【超级用户】审核待确认的主动关怀随访（仅在 CARE_CONFIRM_MODE=review 时产生）：查看列表、批准或拒绝。",
+		RequireAdmin: true,
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"action": map[string]interface{}{
+					"type":        "string",
+					"enum":        []string{"list", "approve", "reject"},
+					"description": "list 查看待审核随访，approve 批准，reject 拒绝。",
+				},
+				"task_id": map[string]interface{}{
+					"type":        "string",
+					"description": "随访任务 ID，approve/reject 时必填。",
+				},
+			},
+			"required": []string{"action"},
+		},
+	},
+	{
+		Name:         "evaluate_proactive",
+		Description:  "【超级用户】调试主动性判断：把一条消息（直接给出内容，或给出历史消息 ID）送入主动插嘴检索和关怀分类，查看会不会插嘴、记忆相似度、会不会安排关怀随访及原因。不会真的发送或创建任务。",
+		RequireAdmin: true,
+		ReadOnly:     true,
+		Timeout:      30 * time.Second,
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"content": map[string]interface{}{
+					"type":        "string",
+					"description": "要评估的消息内容（与 message_id 二选一）。",
+				},
+				"message_id": map[string]interface{}{
+					"type":        "integer",
+					"description": "历史消息的 QQ 消息 ID，使用该消息的内容、群和发送者进行评估。",
+				},
+				"user_qq": map[string]interface{}{
+					"type":        "string",
+					"description": "按哪位群友的身份检索个人信息，默认为发起人（给出 message_id 时使用原发送者）。",
+				},
+			},
+		},
+	},
+	{
+		Name:         "repair_timer_tasks",
+		Description:  "【超级用户】排查定时任务存储：列出无法解析的损坏任务记录，或删除它们。",
+		RequireAdmin: true,
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"action": map[string]interface{}{
+					"type":        "string",
+					"enum":        []string{"list", "remove"},
+					"description": "list 查看损坏记录，remove 删除全部损坏记录。",
+				},
+			},
+			"required": []string{"action"},
+		},
+	},
+	{
+		Name:         "scheduler_debug",
+		Description:  "【超级用户】查看定时任务调度器的内部状态：已加载的周期任务数、排队中的一次性任务数、内存中的 cron 与 Redis 不一致的任务，以及每个周期任务的下次触发时间。",
+		RequireAdmin: true,
+		ReadOnly:     true,
+		Parameters: map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{},
+		},
+	},
+	{
+		Name:         "manage_group_allowlist",
+		Description:  "【超级用户】管理机器人可以工作的群组白名单（仅在配置了 BOT_GROUP_ALLOWLIST 时生效）：添加、移除或查看名单。",
+		RequireAdmin: true,
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"action": map[string]interface{}{
+					"type":        "string",
+					"enum":        []string{"add", "remove", "list"},
+					"description": "add 添加，remove 移除，list 查看当前名单。",
+				},
+				"group_id": map[string]interface{}{
+					"type":        "string",
+					"description": "目标群号，不填表示当前群。",
+				},
+			},
+			"required": []string{"action"},
+		},
+	},
+	{
+		Name:         "manage_group_tools",
+		Description:  "【超级用户】管理本群普通成员可用的功能（工具）白名单，比如不让群友设置定时提醒。可以查看、设置、添加、移除或恢复为全部开放。超级用户自己不受限制。",
+		RequireAdmin: true,
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"action": map[string]interface{}{
+					"type":        "string",
+					"enum":        []string{"list", "set", "add", "remove", "reset"},
+					"description": "list 查看，set 用 tools 覆盖白名单，add/remove 增删白名单中的工具（全部开放时 remove 表示只关闭这几个），reset 恢复全部开放。",
+				},
+				"tools": map[string]interface{}{
+					"type":        "string",
+					"description": "工具名，多个用逗号分隔，如 'get_bot_status,set_my_alias'。set/add/remove 时必填。",
+				},
+			},
+			"required": []string{"action"},
+		},
+	},
+}
+
+// allToolNames 所有工具的名称（按声明顺序）
+func allToolNames() []string {
+	names := make([]string, len(AvailableTools))
+	for i, tool := range AvailableTools {
+		names[i] = tool.Name
+	}
+	return names
+}
+
+// isReadOnlyTool 判断工具是否为只读工具（未知工具按有副作用处理）
+func isReadOnlyTool(name string) bool {
+	for _, tool := range AvailableTools {
+		if tool.Name == name {
+			return tool.ReadOnly
+		}
+	}
+	return false
+}
+
+// toolTimeout 返回工具的执行超时时间
+func toolTimeout(name string) time.Duration {
+	for _, tool := range AvailableTools {
+		if tool.Name == name && tool.Timeout > 0 {
+			return tool.Timeout
+		}
+	}
+	return config.Get().ToolTimeout
+}
+
+// ExecuteTool 执行指定的工具（带权限检查）
+// isSuperUser: 由调用方使用 ZeroBot 的 ctx.Event.IsSuperUser() 判断后传入
+func ExecuteTool(toolName string, args map[string]interface{}, groupID int64, userID int64, isSuperUser bool) ToolResult {
+	// 检查工具是否需要管理员权限，并按声明的 schema 校验参数
+	for _, tool := range AvailableTools {
+		if tool.Name != toolName {
+			continue
+		}
+		if tool.RequireAdmin && !isSuperUser {
+			return ToolResult{Success: false, Message: "抱歉，这个操作只有管理员才能执行哦~"}
+		}
+		if groupID != 0 && !isSuperUser && !IsToolAllowed(GetGroupConfig(groupID), toolName) {
+			return ToolResult{Success: false, Message: "这个功能在本群没有开放哦~"}
+		}
+		coerced, err := coerceToolArgs(tool.Parameters, args)
+		if err != nil {
+			result := ToolResult{Success: false, Message: err.Error() + "。请修正参数后重新调用。"}
+			var argErr *ToolArgError
+			if errors.As(err, &argErr) {
+				result.Data = map[string]interface{}{"errors": argErr.Issues}
+			}
+			return result
+		}
+		args = coerced
+		break
+	}
+
+	switch toolName {
+	case "toggle_bot":
+		return executeToggleBot(args, groupID)
+	case "get_bot_status":
+		return executeGetBotStatus(groupID)
+	case "toggle_rag":
+		return executeToggleRAG(args, groupID)
+	case "get_rag_status":
+		return executeGetRAGStatus(groupID)
+	case "toggle_proactive":
+		return executeToggleProactive(args, groupID)
+	case "toggle_care":
+		return executeToggleCare(args, groupID)
+	case "add_timer_task":
+		return executeAddTimerTask(args, groupID, userID, isSuperUser)
+	case "list_timer_tasks":
+		return executeListTimerTasks(groupID, userID, isSuperUser)
+	case "remove_timer_task":
+		return executeRemoveTimerTask(args)
+	case "list_announcements":
+		return executeListAnnouncements(groupID)
+	case "remove_announcement":
+		return executeRemoveAnnouncement(args, groupID)
+	case "test_cron":
+		return executeTestCron(args)
+	case "share_personal_fact":
+		return executeSharePersonalFact(args, userID)
+	case "whos_around":
+		return executeWhosAround(groupID)
+	case "last_seen":
+		return executeLastSeen(args, groupID)
+	case "set_my_alias":
+		return executeSetMyAlias(args, userID)
+	case "search_my_memories":
+		return executeSearchMyMemories(args, groupID, userID)
+	case "gold_history":
+		return executeGoldHistory(args, userID)
+	case "inspect_user_memories":
+		return executeInspectUserMemories(args, groupID, userID)
+	case "set_random_reply":
+		return executeSetRandomReply(args, groupID)
+	case "set_max_reply_tokens":
+		return executeSetMaxReplyTokens(args, groupID)
+	case "set_off_behavior":
+		return executeSetOffBehavior(args, groupID)
+	case "set_reply_style":
+		return executeSetReplyStyle(args, groupID)
+	case "set_archive_sample_rate":
+		return executeSetArchiveSampleRate(args, groupID)
+	case "set_proactive_daily_cap":
+		return executeSetProactiveDailyCap(args, groupID)
+	case "set_group_theme":
+		return executeSetGroupTheme(args, groupID)
+	case "set_group_language":
+		return executeSetGroupLanguage(args, groupID)
+	case "get_group_theme":
+		return executeGetGroupTheme(groupID)
+	case "broadcast_message":
+		return executeBroadcastMessage(args, groupID, userID)
+	case "feedback_summary":
+		return executeFeedbackSummary(args, groupID)
+	case "review_care_tasks":
+		return executeReviewCareTasks(args)
+	case "evaluate_proactive":
+		return executeEvaluateProactive(args, groupID, userID)
+	case "repair_timer_tasks":
+		return executeRepairTimerTasks(args)
+	case "scheduler_debug":
+		return executeSchedulerDebug()
+	case "manage_group_allowlist":
+		return executeManageGroupAllowlist(args, groupID)
+	case "manage_group_tools":
+		return executeManageGroupTools(args, groupID)
+	case "manage_memory_blocklist":
+		return executeManageMemoryBlocklist(args, groupID)
+	case "pin_memory":
+		return executePinMemory(args, groupID)
+	default:
+		return ToolResult{Success: false, Message: "未知的工具: " + toolName}
+	}
+}
+
+// executeAddTimerTask 添加定时任务
+func executeAddTimerTask(args map[string]interface{}, groupID int64, userID int64, isSuperUser bool) ToolResult {
+	taskType, _ := args["type"].(string)
+	content, _ := args["content"].(string)
+	announcement, _ := args["announcement"].(bool)
+
+	if announcement && !isSuperUser {
+		return ToolResult{Success: false, Message: "只有超级用户可以设置群公告"}
+	}
+	if announcement && groupID == 0 {
+		return ToolResult{Success: false, Message: "群公告只能在群里设置"}
+	}
+
+	// 替别人设置提醒：提醒对象为 target_user，设置人记录在 CreatedBy
+	targetID, targetName := userID, "你"
+	if target, _ := args["target_user"].(string); strings.TrimSpace(target) != "" && !announcement {
+		id, user, err := resolveTaskTarget(target, groupID, isSuperUser)
+		if err != nil {
+			return ToolResult{Success: false, Message: err.Error()}
+		}
+		if id != userID {
+			targetID, targetName = id, DisplayName(user, id)
+		}
+	}
+
+	task := ScheduledTask{
+		Type:         taskType,
+		Content:      content,
+		GroupID:      groupID,
+		UserID:       targetID, // 提醒对象
+		Announcement: announcement,
+	}
+	if targetID != userID {
+		task.CreatedBy = userID
+	}
+	if items, ok := args["attachments"].([]interface{}); ok {
+		task.Attachments = cacheAttachments(resolveAttachments(items, nil))
+	}
+
+	if taskType == "once" {
+		delaySec, ok := args["delay_seconds"].(float64)
+		if !ok {
+			return ToolResult{Success: false, Message: "单次任务需要提供有效的 delay_seconds"}
+		}
+		task.TargetAt = Now().Unix() + int64(delaySec)
+	} else if taskType == "periodic" {
+		if recurrence, _ := args["recurrence"].(string); recurrence != "" {
+			at, _ := args["at"].(string)
+			weekday, _ := args["weekday"].(float64)
+			dayOfMonth, _ := args["day_of_month"].(float64)
+			cronExpr, err := buildRecurrenceCron(recurrence, at, int(weekday), int(dayOfMonth))
+			if err != nil {
+				return ToolResult{Success: false, Message: "周期设置无效: " + err.Error()}
+			}
+			task.TimeExpr = cronExpr
+		} else {
+			cronExpr, ok := args["cron_expr"].(string)
+			if !ok || cronExpr == "" {
+				return ToolResult{Success: false, Message: "周期任务需要提供 recurrence + at，或有效的 cron_expr"}
+			}
+			if err := validateCronExpr(cronExpr); err != nil {
+				return ToolResult{Success: false, Message: err.Error()}
+			}
+			task.TimeExpr = cronExpr
+		}
+	}
+
+	if task.ID == "" {
+		task.ID = fmt.Sprintf("task_%d_%d", Now().UnixNano(), task.UserID)
+	}
+	err := AddTask(task)
+	if errors.Is(err, ErrTaskNotPersisted) {
+		return ToolResult{Success: true, Message: "设置成功，但提醒暂时只存在内存里（存储服务不可用），如果机器人在那之前重启就会丢失~ ID: " + task.ID}
+	}
+	if err != nil {
+		if database.Redis() == nil {
+			return ToolResult{Success: false, Message: "设置提醒失败：提醒存储服务暂时不可用，请稍后再试"}
+		}
+		return ToolResult{Success: false, Message: "设置提醒失败: " + err.Error()}
+	}
+
+	if announcement {
+		return ToolResult{Success: true, Message: "群公告已安排~ ID: " + task.ID}
+	}
+	return ToolResult{Success: true, Message: "设置成功！到时间我会提醒" + targetName + "的~ ID: " + task.ID}
+}
+
+// 替别人设置提醒的权限（TASK_ASSIGN_POLICY）
+const (
+	TaskAssignAnyone = "anyone" // 群里所有人都可以（默认）
+	TaskAssignAdmin  = "admin"  // 仅超级用户
+	TaskAssignOff    = "off"    // 不允许
+)
+
+// resolveTaskTarget 按权限配置解析提醒对象，返回的错误信息可直接展示给用户
+func resolveTaskTarget(target string, groupID int64, isSuperUser bool) (int64, models.User, error) {
+	if groupID == 0 {
+		return 0, models.User{}, errors.New("私聊里只能给自己设置提醒哦，想提醒别人请在群里说")
+	}
+	switch config.Get().TaskAssignPolicy {
+	case TaskAssignOff:
+		return 0, models.User{}, errors.New("这里不支持替别人设置提醒，可以让TA自己来设哦")
+	case TaskAssignAdmin:
+		if !isSuperUser {
+			return 0, models.User{}, errors.New("只有管理员才能替别人设置提醒哦")
+		}
+	}
+	id, user, err := ResolveGroupMember(target, groupID)
+	if errors.Is(err, ErrMemberNotFound) {
+		return 0, user, fmt.Errorf("不知道%s是谁，可以直接 @TA 再说一次", strings.TrimSpace(target))
+	}
+	if err != nil {
+		return 0, user, fmt.Errorf("查找提醒对象失败: %v", err)
+	}
+	return id, user, nil
+}
+
+// executeListTimerTasks 列出任务
+func executeListTimerTasks(groupID int64, userID int64, isSuperUser bool) ToolResult {
+	queryUserID := userID
+	if isSuperUser {
+		queryUserID = 0 // 超级用户查询全量
+	}
+
+	// 群公告由 list_announcements 单独管理
+	tasks, corrupted := ListTasks(groupID, queryUserID)
+	tasks = slices.DeleteFunc(tasks, func(t ScheduledTask) bool {
+		return t.Announcement
+	})
+	corruptedNote := ""
+	if corrupted > 0 {
+		corruptedNote = fmt.Sprintf("\n（另有%d条任务记录损坏，无法显示，可请超级用户修复）", corrupted)
+	}
+	if len(tasks) == 0 {
+		return ToolResult{Success: true, Message: "目前没有设置任何活跃的任务哦。" + corruptedNote}
+	}
+
+	msg := "你当前的定时任务如下：\n"
+	if isSuperUser {
+		msg = "本群当前活跃的定时任务如下（超级用户视图）：\n"
+	}
+
+	for _, t := range tasks {
+		timeStr := ""
+		if t.Type == "once" {
+			timeStr = time.Unix(t.TargetAt, 0).Format("2006-01-02 15:04:05")
+		} else {
+			timeStr = "周期性: " + t.TimeExpr
+		}
+
+		userLabel := ""
+		switch {
+		case isSuperUser:
+			userLabel = fmt.Sprintf(" [用户:%d]", t.UserID)
+		case t.UserID != userID:
+			userLabel = " [提醒" + DisplayName(GetUserByQQ(strconv.FormatInt(t.UserID, 10)), t.UserID) + "]"
+		case t.CreatedBy != 0:
+			userLabel = " [" + DisplayName(GetUserByQQ(strconv.FormatInt(t.CreatedBy, 10)), t.CreatedBy) + "设置]"
+		}
+
+		msg += fmt.Sprintf("- [%s] %s (%s)%s\n", t.ID, t.Content, timeStr, userLabel)
+	}
+	msg += corruptedNote
+	return ToolResult{Success: true, Message: msg, Data: tasks, DirectReply: strings.TrimSpace(msg)}
+}
+
+// executeRemoveTimerTask 移除任务
+func executeRemoveTimerTask(args map[string]interface{}) ToolResult {
+	id, ok := args["id"].(string)
+	if !ok || id == "" {
+		return ToolResult{Success: false, Message: "移除失败：请提供有效的任务 ID"}
+	}
+
+	if err := RemoveTask(id); err != nil {
+		return ToolResult{Success: false, Message: "取消任务失败: " + err.Error()}
+	}
+
+	return ToolResult{Success: true, Message: "成功取消了该任务！"}
+}
+
+// executeListAnnouncements 列出本群的群公告
+func executeListAnnouncements(groupID int64) ToolResult {
+	announcements := ListAnnouncements(groupID)
+	if len(announcements) == 0 {
+		return ToolResult{Success: true, Message: "本群目前没有安排群公告。"}
+	}
+
+	msg := "本群已安排的群公告：\n"
+	for _, t := range announcements {
+		timeStr := ""
+		if t.Type == "once" {
+			timeStr = time.Unix(t.TargetAt, 0).Format("2006-01-02 15:04:05")
+		} else {
+			timeStr = "周期性: " + t.TimeExpr
+		}
+		msg += fmt.Sprintf("- [%s] %s (%s)\n", t.ID, t.Content, timeStr)
+	}
+	return ToolResult{Success: true, Message: msg, Data: announcements, DirectReply: strings.TrimSpace(msg)}
+}
+
+// executeRemoveAnnouncement 取消群公告（只能取消公告，不会误删个人提醒）
+func executeRemoveAnnouncement(args map[string]interface{}, groupID int64) ToolResult {
+	id, ok := args["id"].(string)
+	if !ok || id == "" {
+		return ToolResult{Success: false, Message: "移除失败：请提供有效的公告 ID"}
+	}
+
+	task, found := GetTask(id)
+	if !found || !task.Announcement || (groupID != 0 && task.GroupID != groupID) {
+		return ToolResult{Success: false, Message: "本群没有这条群公告，请先用 list_announcements 确认 ID"}
+	}
+	if err := RemoveTask(id); err != nil {
+		return ToolResult{Success: false, Message: "取消公告失败: " + err.Error()}
+	}
+	return ToolResult{Success: true, Message: "已取消该群公告！"}
+}
+
+// whosAroundLimit 查看群友动态时读取的临时记忆条数上限
+const whosAroundLimit = 30
+
+// executeWhosAround 汇总群友最近的临时动态（每人只取最新一条）
+func executeWhosAround(groupID int64) ToolResult {
+	if groupID == 0 {
+		return ToolResult{Success: false, Message: "这个功能只能在群里用哦"}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	memories, err := database.GetRecentTemporaryMemories(ctx, groupID, whosAroundLimit)
+	if err != nil {
+		return ToolResult{Success: false, Message: "读取动态失败: " + err.Error()}
+	}
+	if len(memories) == 0 {
+		return ToolResult{Success: true, Message: "最近没看到大家说自己在干嘛"}
+	}
+
+	// 每人只保留最新一条（memories 按时间从旧到新）
+	latest := make(map[string]database.TemporaryMemory)
+	var order []string
+	for _, m := range memories {
+		if _, seen := latest[m.UserQQ]; !seen {
+			order = append(order, m.UserQQ)
+		}
+		latest[m.UserQQ] = m
+	}
+
+	var sb strings.Builder
+	sb.WriteString("群友最近的动态：")
+	for i := len(order) - 1; i >= 0; i-- {
+		m := latest[order[i]]
+		name := order[i]
+		if user := GetUserByQQ(m.UserQQ); user.Alias != "" {
+			name = user.Alias
+		} else if user.Nickname != "" {
+			name = user.Nickname
+		}
+		var history models.ChatHistory
+		when := ""
+		if database.DB.Select("created_at").First(&history, m.MsgID).Error == nil {
+			if rel := formatRelativeTime(history.CreatedAt); rel == "刚刚" {
+				when = "（刚刚）"
+			} else {
+				when = "（" + rel + "前）"
+			}
+		}
+		sb.WriteString(fmt.Sprintf("\n- %s：%s%s", name, m.Content, when))
+	}
+	return ToolResult{Success: true, Message: sb.String(), Data: map[string]int{"people": len(order)}}
+}
+
+// executeLastSeen 查询群友在本群最近一次发言的时间（QQ 号、称呼、昵称均可，重名时取最近说话的那位）
+func executeLastSeen(args map[string]interface{}, groupID int64) ToolResult {
+	if groupID == 0 {
+		return ToolResult{Success: false, Message: "这个功能只能在群里用哦"}
+	}
+	name, _ := args["user"].(string)
+	name = strings.TrimPrefix(strings.TrimSpace(name), "@")
+	if name == "" {
+		return ToolResult{Success: false, Message: "请告诉我要找谁"}
+	}
+
+	var history models.ChatHistory
+	err := database.DB.Preload("User").
+		Joins("JOIN users ON users.id = chat_histories.user_id").
+		Where("chat_histories.group_id = ? AND chat_histories.from_bot = ?", groupID, false).
+		Where("users.qq = ? OR users.alias = ? OR users.nickname = ?", name, name, name).
+		Order("chat_histories.created_at DESC").
+		First(&history).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ToolResult{Success: true, Message: fmt.Sprintf("没见过%s在这个群里说话，可能还没冒过泡，或者换了名字", name)}
+	}
+	if err != nil {
+		return ToolResult{Success: false, Message: "查询失败: " + err.Error()}
+	}
+
+	display := history.User.QQ
+	if history.User.Alias != "" {
+		display = history.User.Alias
+	} else if history.User.Nickname != "" {
+		display = history.User.Nickname
+	}
+	msg := display + "刚刚还在说话"
+	if rel := formatRelativeTime(history.CreatedAt); rel != "刚刚" {
+		if Since(history.CreatedAt) < 30*24*time.Hour {
+			msg = fmt.Sprintf("%s大概%s前还在说话", display, rel)
+		} else {
+			msg = fmt.Sprintf("%s上次说话还是 %s，好久没冒泡了", display, rel)
+		}
+	}
+	return ToolResult{Success: true, Message: msg, Data: map[string]interface{}{
+		"qq":        history.User.QQ,
+		"last_seen": history.CreatedAt.In(botLocation()).Format("2006-01-02 15:04"),
+	}}
+}
+
+// executeSetMyAlias 设置用户的称呼
+func executeSetMyAlias(args map[string]interface{}, userID int64) ToolResult {
+	alias, _ := args["alias"].(string)
+	if err := SetUserAlias(strconv.FormatInt(userID, 10), alias); err != nil {
+		return ToolResult{Success: false, Message: "设置失败: " + err.Error()}
+	}
+	if strings.TrimSpace(alias) == "" {
+		return ToolResult{Success: true, Message: "好的，以后还是叫你的 QQ 昵称"}
+	}
+	return ToolResult{Success: true, Message: "记住啦，以后就叫你" + strings.TrimSpace(alias), Data: map[string]string{"alias": strings.TrimSpace(alias)}}
+}
+
+// testCronFireCount test_cron 列出的触发次数
+const testCronFireCount = 3
+
+// executeTestCron 校验 cron 表达式（与调度器相同的解析规则）并给出接下来几次触发时间
+func executeTestCron(args map[string]interface{}) ToolResult {
+	expr, _ := args["cron_expr"].(string)
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return ToolResult{Success: false, Message: "请提供 cron 表达式"}
+	}
+	schedule, err := taskCronParser.Parse(expr)
+	if err != nil {
+		return ToolResult{Success: false, Message: fmt.Sprintf("cron 表达式 %q 无效: %v", expr, err)}
+	}
+
+	var times []string
+	next := Now().In(botLocation())
+	for i := 0; i < testCronFireCount; i++ {
+		next = schedule.Next(next)
+		if next.IsZero() {
+			break
+		}
+		times = append(times, next.Format("2006-01-02 15:04:05")+" 星期"+weekdayNames[next.Weekday()])
+	}
+	if len(times) == 0 {
+		return ToolResult{Success: true, Message: fmt.Sprintf("cron 表达式 %q 有效，但不会再触发", expr)}
+	}
+
+	msg := fmt.Sprintf("cron 表达式 %q 有效，接下来 %d 次触发（%s）：\n- %s", expr, len(times), botLocation(), strings.Join(times, "\n- "))
+	return ToolResult{Success: true, Message: msg, Data: times, DirectReply: msg}
+}
+
+// executeSharePersonalFact 标记个人信息的共享状态
+func executeSharePersonalFact(args map[string]interface{}, userID int64) ToolResult {
+	fact, ok := args["fact"].(string)
+	if !ok || fact == "" {
+		return ToolResult{Success: false, Message: "请说明要共享的是哪条个人信息"}
+	}
+	shared := true
+	if v, ok := args["shared"].(bool); ok {
+		shared = v
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	queryVec, err := embedding.GetEmbedding(ctx, fact, "query", config.Get().EmbeddingDim)
+	if err != nil {
+		return ToolResult{Success: false, Message: "记忆检索失败: " + err.Error()}
+	}
+
+	// 仅在本人的个人信息中查找，避免修改他人的记忆
+	filter := map[string]interface{}{"user_qq": strconv.FormatInt(userID, 10)}
+	matches, err := pinecone.QueryWithScore(ctx, pinecone.NamespacePersonal, queryVec, 1, filter)
+	if err != nil {
+		return ToolResult{Success: false, Message: "记忆检索失败: " + err.Error()}
+	}
+	if len(matches) == 0 || matches[0].Score < 0.7 {
+		return ToolResult{Success: false, Message: "没找到你说的这条个人信息哦"}
+	}
+
+	if err := pinecone.UpdateMetadata(ctx, pinecone.NamespacePersonal, matches[0].ID, map[string]interface{}{"shared": shared}); err != nil {
+		return ToolResult{Success: false, Message: "更新失败: " + err.Error()}
+	}
+
+	var res models.MemberEmbedding
+	database.DB.Where("vector_id = ?", matches[0].ID).First(&res)
+	if res.ID != 0 {
+		database.DB.Model(&res).Update("shared", shared)
+	}
+
+	if shared {
+		return ToolResult{Success: true, Message: "已共享这条信息：" + res.ContentSummary, Data: map[string]bool{"shared": true}}
+	}
+	return ToolResult{Success: true, Message: "已将这条信息改回私有：" + res.ContentSummary, Data: map[string]bool{"shared": false}}
+}
+
+// searchMemoryMinScore 搜索本人记忆时的最低相似度，过滤掉不相关的结果
+const searchMemoryMinScore = 0.5
+
+// executeSearchMyMemories 搜索当前用户自己的记忆（个人信息 + 聊天记录），结果直接发给用户
+// 私有的个人信息只在私聊中列出，避免在群里暴露
+func executeSearchMyMemories(args map[string]interface{}, groupID int64, userID int64) ToolResult {
+	query, _ := args["query"].(string)
+	if strings.TrimSpace(query) == "" {
+		return ToolResult{Success: false, Message: "请说明要搜索什么"}
+	}
+	limit := 5
+	if v, ok := args["limit"].(float64); ok && v > 0 {
+		limit = min(int(v), 10)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	queryVec, err := getQueryEmbedding(ctx, query)
+	if err != nil {
+		return ToolResult{Success: false, Message: "记忆检索失败: " + err.Error()}
+	}
+
+	type hit struct {
+		kind      string
+		summary   string
+		createdAt time.Time
+		score     float32
+	}
+	var hits []hit
+	userQQ := strconv.FormatInt(userID, 10)
+	for _, ns := range []string{pinecone.NamespacePersonal, pinecone.NamespaceChat} {
+		filter := map[string]interface{}{"user_qq": userQQ}
+		// 群里只列出已共享的个人信息和在本群说过的话，其他群和私聊的内容不在群里公开
+		if groupID != 0 && ns == pinecone.NamespacePersonal {
+			filter["shared"] = true
+		} else if groupID != 0 {
+			filter["group_id"] = groupID
+		}
+		matches, err := pinecone.QueryWithScore(ctx, ns, queryVec, uint32(limit+queryTopKSlack), filter)
+		if err != nil {
+			log.Printf("[RAG] Search memories in %s namespace failed: %v", ns, err)
+			continue
+		}
+		for _, m := range matches {
+			if m.Score < searchMemoryMinScore {
+				continue
+			}
+			var emb models.MemberEmbedding
+			database.DB.Preload("RefMsg").Where("vector_id = ?", m.ID).First(&emb)
+			if emb.ContentSummary == "" {
+				continue
+			}
+			kind := "聊天"
+			if ns == pinecone.NamespacePersonal {
+				kind = "个人信息"
+			}
+			hits = append(hits, hit{kind, emb.ContentSummary, emb.RefMsg.CreatedAt, m.Score})
+		}
+	}
+
+	sort.Slice(hits, func(i, j int) bool { return hits[i].score > hits[j].score })
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+
+	var sb strings.Builder
+	if len(hits) == 0 {
+		sb.WriteString("没找到和「" + query + "」相关的记忆")
+	} else {
+		sb.WriteString(fmt.Sprintf("和「%s」相关的记忆（%d 条）：", query, len(hits)))
+		for _, h := range hits {
+			sb.WriteString(fmt.Sprintf("\n- [%s] %s（%s）", h.kind, h.summary, h.createdAt.In(botLocation()).Format("2006-01-02 15:04")))
+		}
+	}
+	if groupID != 0 {
+		sb.WriteString("\n（群里只列出已共享的个人信息和在本群说过的话，私聊我可以查看全部）")
+	}
+
+	return ToolResult{Success: true, Message: sb.String(), Data: map[string]int{"count": len(hits)}, DirectReply: sb.String()}
+}
+
+// executeGoldHistory 列出当前用户的金币余额与最近流水
+func executeGoldHistory(args map[string]interface{}, userID int64) ToolResult {
+	limit := 10
+	if v, ok := args["limit"].(float64); ok && v > 0 {
+		limit = min(int(v), 30)
+	}
+
+	user := GetUserByQQ(strconv.FormatInt(userID, 10))
+	if user.ID == 0 {
+		msg := "你还没有金币记录"
+		return ToolResult{Success: true, Message: msg, DirectReply: msg}
+	}
+	records, err := ListGoldTransactions(user.ID, limit)
+	if err != nil {
+		return ToolResult{Success: false, Message: "查询金币记录失败: " + err.Error()}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("当前金币：%d", user.Gold))
+	if len(records) == 0 {
+		sb.WriteString("\n还没有金币收支记录")
+	} else {
+		sb.WriteString(fmt.Sprintf("\n最近 %d 条记录：", len(records)))
+		for _, r := range records {
+			sb.WriteString(fmt.Sprintf("\n- %s %s %+d（余额 %d）",
+				r.CreatedAt.In(botLocation()).Format("2006-01-02 15:04"), goldReasonLabel(r.Reason), r.Delta, r.Balance))
+		}
+	}
+
+	msg := sb.String()
+	return ToolResult{Success: true, Message: msg, Data: records, DirectReply: msg}
+}
+
+// executeInspectUserMemories 查看指定用户的记忆（超级用户，审计记录）
+func executeInspectUserMemories(args map[string]interface{}, groupID int64, operatorID int64) ToolResult {
+	qq, _ := args["qq"].(string)
+	if _, err := strconv.ParseInt(qq, 10, 64); err != nil {
+		return ToolResult{Success: false, Message: "请提供有效的 QQ 号"}
+	}
+
+	log.Printf("[Audit] Superuser %d inspected memories of %s (from group %d)", operatorID, qq, groupID)
+
+	var user models.User
+	if err := database.DB.Where("qq = ?", qq).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ToolResult{Success: true, Message: "没有关于 " + qq + " 的任何记录"}
+		}
+		return ToolResult{Success: false, Message: "数据库错误: " + err.Error()}
+	}
+
+	var facts []models.MemberEmbedding
+	personalMemoryQuery().
+		Where("users.id = ?", user.ID).
+		Select("member_embeddings.*").
+		Order("member_embeddings.created_at DESC").
+		Limit(20).
+		Find(&facts)
+
+	var histories []models.ChatHistory
+	database.DB.Where("user_id = ?", user.ID).Order("created_at DESC").Limit(10).Find(&histories)
+
+	var sb strings.Builder
+	if user.Alias != "" {
+		sb.WriteString(fmt.Sprintf("用户 %s（%s，称呼：%s）\n", qq, user.Nickname, user.Alias))
+	} else {
+		sb.WriteString(fmt.Sprintf("用户 %s（%s）\n", qq, user.Nickname))
+	}
+	if user.Persona != "" {
+		sb.WriteString("人设摘要：" + user.Persona + "\n")
+	}
+	sb.WriteString(fmt.Sprintf("个人信息（%d 条）：\n", len(facts)))
+	for _, f := range facts {
+		sb.WriteString(fmt.Sprintf("- [%s] (%s前) %s%s\n", f.VectorID, formatRelativeTime(f.CreatedAt), pinnedMark(f.Pinned), f.ContentSummary))
+	}
+	sb.WriteString(fmt.Sprintf("最近聊天（%d 条）：\n", len(histories)))
+	for _, h := range histories {
+		sb.WriteString(fmt.Sprintf("- [群%d] (%s前) %s\n", h.GroupID, formatRelativeTime(h.CreatedAt), h.Content))
+	}
+
+	return ToolResult{Success: true, Message: sb.String(), Data: map[string]int{
+		"personal_count": len(facts),
+		"history_count":  len(histories),
+	}}
+}
+
+// executeSetRandomReply 设置随口接话概率与冷却
+func executeSetRandomReply(args map[string]interface{}, groupID int64) ToolResult {
+	prob, ok := args["probability"].(float64)
+	if !ok || prob < 0 || prob > 1 {
+		return ToolResult{Success: false, Message: "参数 probability 需在 0 到 1 之间"}
+	}
+	cooldown, _ := args["cooldown_minutes"].(float64)
+	if cooldown < 0 {
+		return ToolResult{Success: false, Message: "参数 cooldown_minutes 不能为负数"}
+	}
+
+	cfg, err := UpdateGroupConfig(groupID, func(cfg *models.GroupConfig) {
+		cfg.RandomReplyProb = prob
+		if cooldown > 0 {
+			cfg.RandomReplyCooldown = int(cooldown)
+		}
+	})
+	if err != nil {
+		return ToolResult{Success: false, Message: "保存失败: " + err.Error()}
+	}
+
+	data := map[string]interface{}{"probability": cfg.RandomReplyProb, "cooldown_minutes": cfg.RandomReplyCooldown}
+	if prob == 0 {
+		return ToolResult{Success: true, Message: "随口接话已关闭", Data: data}
+	}
+	return ToolResult{Success: true, Message: fmt.Sprintf("随口接话已设置：概率 %.0f%%", prob*100), Data: data}
+}
+
+// executeSetMaxReplyTokens 设置群组的回复长度上限
+func executeSetMaxReplyTokens(args map[string]interface{}, groupID int64) ToolResult {
+	n, ok := args["max_tokens"].(float64)
+	if !ok || n < 0 || n > 4096 {
+		return ToolResult{Success: false, Message: "参数 max_tokens 需在 0 到 4096 之间"}
+	}
+
+	_, err := UpdateGroupConfig(groupID, func(cfg *models.GroupConfig) {
+		cfg.MaxReplyTokens = int(n)
+	})
+	if err != nil {
+		return ToolResult{Success: false, Message: "保存失败: " + err.Error()}
+	}
+
+	limit := MaxReplyTokens(groupID)
+	if n == 0 {
+		return ToolResult{Success: true, Message: fmt.Sprintf("已恢复默认回复长度上限（%d）", limit), Data: map[string]int{"max_tokens": limit}}
+	}
+	return ToolResult{Success: true, Message: fmt.Sprintf("本群回复长度上限已设置为 %d", limit), Data: map[string]int{"max_tokens": limit}}
+}
+
+// executeSetOffBehavior 设置机器人关闭时被 @ 的表现
+func executeSetOffBehavior(args map[string]interface{}, groupID int64) ToolResult {
+	behavior, _ := args["behavior"].(string)
+	if behavior != OffBehaviorSilent && behavior != OffBehaviorNotice {
+		return ToolResult{Success: false, Message: "参数 behavior 只能是 silent 或 notice"}
+	}
+
+	_, err := UpdateGroupConfig(groupID, func(cfg *models.GroupConfig) {
+		cfg.OffBehavior = behavior
+	})
+	if err != nil {
+		return ToolResult{Success: false, Message: "保存失败: " + err.Error()}
+	}
+
+	if behavior == OffBehaviorNotice {
+		return ToolResult{Success: true, Message: "好的，关闭后有人 @ 我时会提示已被静音", Data: map[string]string{"behavior": behavior}}
+	}
+	return ToolResult{Success: true, Message: "好的，关闭后有人 @ 我时不再回复", Data: map[string]string{"behavior": behavior}}
+}
+
+// executeSetReplyStyle 设置群组回复被 @ 消息时的形式
+func executeSetReplyStyle(args map[string]interface{}, groupID int64) ToolResult {
+	if groupID == 0 {
+		return ToolResult{Success: false, Message: "请在群聊中设置"}
+	}
+	style, _ := args["style"].(string)
+	if style != ReplyStyleAt && style != ReplyStyleQuote && style != ReplyStyleBoth {
+		return ToolResult{Success: false, Message: "参数 style 只能是 at、quote 或 both"}
+	}
+
+	_, err := UpdateGroupConfig(groupID, func(cfg *models.GroupConfig) {
+		if style == ReplyStyleAt {
+			cfg.ReplyStyle = ""
+		} else {
+			cfg.ReplyStyle = style
+		}
+	})
+	if err != nil {
+		return ToolResult{Success: false, Message: "保存失败: " + err.Error()}
+	}
+
+	data := map[string]string{"style": style}
+	switch style {
+	case ReplyStyleQuote:
+		return ToolResult{Success: true, Message: "好的，以后回复时会引用对方的原消息", Data: data}
+	case ReplyStyleBoth:
+		return ToolResult{Success: true, Message: "好的，以后回复时会引用原消息并 @ 对方", Data: data}
+	}
+	return ToolResult{Success: true, Message: "好的，以后回复时直接 @ 对方", Data: data}
+}
+
+// executeSetArchiveSampleRate 设置群组的归档采样率
+func executeSetArchiveSampleRate(args map[string]interface{}, groupID int64) ToolResult {
+	rate, ok := args["rate"].(float64)
+	if !ok || rate < 0 || rate > 1 {
+		return ToolResult{Success: false, Message: "参数 rate 需在 0 到 1 之间"}
+	}
+
+	_, err := UpdateGroupConfig(groupID, func(cfg *models.GroupConfig) {
+		if rate == 1 {
+			cfg.ArchiveSampleRate = nil
+		} else {
+			cfg.ArchiveSampleRate = &rate
+		}
+	})
+	if err != nil {
+		return ToolResult{Success: false, Message: "保存失败: " + err.Error()}
+	}
+
+	data := map[string]float64{"rate": rate}
+	if rate == 1 {
+		return ToolResult{Success: true, Message: "本群所有消息都会进入记忆", Data: data}
+	}
+	return ToolResult{Success: true, Message: fmt.Sprintf("记忆采样率已设置为 %.0f%%（@ 我的消息不受影响）", rate*100), Data: data}
 }
 
-// ExecuteTool 执行指定的工具（带权限检查）
-// isSuperUser: 由调用方使用 ZeroBot 的 ctx.Event.IsSuperUser() 判断后传入
-func ExecuteTool(toolName string, args map[string]interface{}, groupID int64, userID int64, isSuperUser bool) ToolResult {
-	// 检查工具是否需要管理员权限
-	for _, tool := range AvailableTools {
-		if tool.Name == toolName && tool.RequireAdmin {
-			if !isSuperUser {
-				return ToolResult{Success: false, Message: "抱歉，这个操作只有管理员才能执行哦~"}
+// executeSetProactiveDailyCap 设置群组每天主动插嘴的次数上限
+func executeSetProactiveDailyCap(args map[string]interface{}, groupID int64) ToolResult {
+	if groupID == 0 {
+		return ToolResult{Success: false, Message: "请在群聊中设置"}
+	}
+	raw, ok := args["limit"].(float64)
+	if !ok || raw < -1 {
+		return ToolResult{Success: false, Message: "参数 limit 需为 -1 或非负整数"}
+	}
+	limit := int(raw)
+
+	_, err := UpdateGroupConfig(groupID, func(cfg *models.GroupConfig) {
+		if limit < 0 {
+			cfg.ProactiveDailyCap = nil
+		} else {
+			cfg.ProactiveDailyCap = &limit
+		}
+	})
+	if err != nil {
+		return ToolResult{Success: false, Message: "保存失败: " + err.Error()}
+	}
+
+	effective := ProactiveDailyCap(groupID)
+	data := map[string]int{"limit": effective}
+	if effective == 0 {
+		return ToolResult{Success: true, Message: "本群主动插嘴不再限制每日次数", Data: data}
+	}
+	return ToolResult{Success: true, Message: fmt.Sprintf("本群每天最多主动插嘴 %d 次", effective), Data: data}
+}
+
+// executeSetGroupTheme 设置群聊主题
+func executeSetGroupTheme(args map[string]interface{}, groupID int64) ToolResult {
+	if groupID == 0 {
+		return ToolResult{Success: false, Message: "请在群聊中设置"}
+	}
+	theme, _ := args["theme"].(string)
+	theme = strings.TrimSpace(theme)
+	if strings.ContainsAny(theme, "\n\r") {
+		return ToolResult{Success: false, Message: "主题不能包含换行"}
+	}
+	if utf8.RuneCountInString(theme) > maxGroupThemeLength {
+		return ToolResult{Success: false, Message: fmt.Sprintf("主题最多 %d 个字", maxGroupThemeLength)}
+	}
+
+	_, err := UpdateGroupConfig(groupID, func(cfg *models.GroupConfig) {
+		cfg.Theme = theme
+	})
+	if err != nil {
+		return ToolResult{Success: false, Message: "保存失败: " + err.Error()}
+	}
+
+	if theme == "" {
+		return ToolResult{Success: true, Message: "已清除本群主题", Data: map[string]string{"theme": ""}}
+	}
+	return ToolResult{Success: true, Message: fmt.Sprintf("好的，以后在本群会按「%s」群的氛围说话", theme), Data: map[string]string{"theme": theme}}
+}
+
+// executeSetGroupLanguage 设置本群的回复语言（及可选的关怀兜底模板）
+func executeSetGroupLanguage(args map[string]interface{}, groupID int64) ToolResult {
+	if groupID == 0 {
+		return ToolResult{Success: false, Message: "请在群聊中设置"}
+	}
+	language, _ := args["language"].(string)
+	language = strings.TrimSpace(language)
+	if strings.ContainsAny(language, "\n\r") {
+		return ToolResult{Success: false, Message: "语言不能包含换行"}
+	}
+	if utf8.RuneCountInString(language) > maxGroupLanguageLength {
+		return ToolResult{Success: false, Message: fmt.Sprintf("语言最多 %d 个字", maxGroupLanguageLength)}
+	}
+	careTemplate, setTemplate := args["care_template"].(string)
+
+	cfg, err := UpdateGroupConfig(groupID, func(cfg *models.GroupConfig) {
+		cfg.Language = language
+		if setTemplate {
+			cfg.CareTemplate = strings.TrimSpace(careTemplate)
+		}
+	})
+	if err != nil {
+		return ToolResult{Success: false, Message: "保存失败: " + err.Error()}
+	}
+
+	data := map[string]string{"language": cfg.Language, "care_template": cfg.CareTemplate}
+	if language == "" {
+		return ToolResult{Success: true, Message: "已恢复在本群使用中文", Data: data}
+	}
+	return ToolResult{Success: true, Message: "好的，以后在本群会用" + language + "说话", Data: data}
+}
+
+// executeGetGroupTheme 查看群聊主题和语言
+func executeGetGroupTheme(groupID int64) ToolResult {
+	if groupID == 0 {
+		return ToolResult{Success: false, Message: "这个功能只能在群里用哦"}
+	}
+	cfg := GetGroupConfig(groupID)
+	data := map[string]string{"theme": cfg.Theme, "language": cfg.Language}
+	msg := "本群还没有设置主题"
+	if cfg.Theme != "" {
+		msg = "本群的主题是：" + cfg.Theme
+	}
+	if cfg.Language != "" {
+		msg += "；回复语言：" + cfg.Language
+	}
+	return ToolResult{Success: true, Message: msg, Data: data}
+}
+
+// executeBroadcastMessage 向所有开启的群广播公告（先预览，确认后发送）
+func executeBroadcastMessage(args map[string]interface{}, groupID int64, userID int64) ToolResult {
+	if confirm, _ := args["confirm"].(bool); confirm {
+		count, err := ConfirmBroadcast(userID, groupID)
+		if err != nil {
+			return ToolResult{Success: false, Message: err.Error()}
+		}
+		return ToolResult{Success: true, Message: fmt.Sprintf("开始向 %d 个群发送公告，完成后会通知你", count), Data: map[string]int{"groups": count}}
+	}
+
+	message, _ := args["message"].(string)
+	message = strings.TrimSpace(message)
+	if message == "" {
+		return ToolResult{Success: false, Message: "请提供要广播的内容"}
+	}
+
+	groups, err := PrepareBroadcast(userID, message)
+	if err != nil {
+		return ToolResult{Success: false, Message: "数据库错误: " + err.Error()}
+	}
+	return ToolResult{
+		Success: true,
+		Message: fmt.Sprintf("预览：将向 %d 个群（%s）发送「%s」。请向用户确认，等用户回复确认后再以 confirm=true 调用。", len(groups), strings.Join(groups, "、"), message),
+		Data:    map[string]int{"groups": len(groups)},
+	}
+}
+
+// executeFeedbackSummary 反馈统计
+func executeFeedbackSummary(args map[string]interface{}, groupID int64) ToolResult {
+	scope := groupID
+	if all, _ := args["all_groups"].(bool); all {
+		scope = 0
+	}
+
+	summary, err := GetFeedbackSummary(scope, 5)
+	if err != nil {
+		return ToolResult{Success: false, Message: "数据库错误: " + err.Error()}
+	}
+
+	msg := fmt.Sprintf("好评 %d 条，差评 %d 条", summary.Positive, summary.Negative)
+	if len(summary.RecentWorst) > 0 {
+		msg += "\n最近被差评的回复：\n- " + strings.Join(summary.RecentWorst, "\n- ")
+	}
+	return ToolResult{Success: true, Message: msg, Data: summary}
+}
+
+// executeManageMemoryBlocklist 管理记忆黑名单
+func executeManageMemoryBlocklist(args map[string]interface{}, groupID int64) ToolResult {
+	action, _ := args["action"].(string)
+	qq, _ := args["qq"].(string)
+
+	if action == "list" {
+		list := GetGroupConfig(groupID).MemoryBlocklist
+		if len(list) == 0 {
+			return ToolResult{Success: true, Message: "本群记忆黑名单为空", Data: list}
+		}
+		return ToolResult{Success: true, Message: "本群记忆黑名单: " + strings.Join(list, ", "), Data: list}
+	}
+
+	if action != "add" && action != "remove" {
+		return ToolResult{Success: false, Message: "参数 action 无效"}
+	}
+	if _, err := strconv.ParseInt(qq, 10, 64); err != nil {
+		return ToolResult{Success: false, Message: "请提供有效的 QQ 号"}
+	}
+
+	cfg, err := UpdateGroupConfig(groupID, func(cfg *models.GroupConfig) {
+		kept := cfg.MemoryBlocklist[:0]
+		for _, q := range cfg.MemoryBlocklist {
+			if q != qq {
+				kept = append(kept, q)
 			}
-			break
 		}
+		if action == "add" {
+			kept = append(kept, qq)
+		}
+		cfg.MemoryBlocklist = kept
+	})
+	if err != nil {
+		return ToolResult{Success: false, Message: "保存失败: " + err.Error()}
 	}
 
-	switch toolName {
-	case "toggle_bot":
-		return executeToggleBot(args, groupID)
-	case "get_bot_status":
-		return executeGetBotStatus(groupID)
-	case "toggle_rag":
-		return executeToggleRAG(args, groupID)
-	case "get_rag_status":
-		return executeGetRAGStatus(groupID)
-	case "add_timer_task":
-		return executeAddTimerTask(args, groupID, userID)
-	case "list_timer_tasks":
-		return executeListTimerTasks(groupID, userID, isSuperUser)
-	case "remove_timer_task":
-		return executeRemoveTimerTask(args)
-	default:
-		return ToolResult{Success: false, Message: "未知的工具: " + toolName}
+	if action == "add" {
+		return ToolResult{Success: true, Message: "已将 " + qq + " 加入记忆黑名单，之后不会再记录TA的消息", Data: cfg.MemoryBlocklist}
 	}
+	return ToolResult{Success: true, Message: "已将 " + qq + " 移出记忆黑名单", Data: cfg.MemoryBlocklist}
 }
 
-// executeAddTimerTask 添加定时任务
-func executeAddTimerTask(args map[string]interface{}, groupID int64, userID int64) ToolResult {
-	taskType, _ := args["type"].(string)
-	content, _ := args["content"].(string)
+// executePinMemory 置顶、取消置顶或列出重要记忆（群聊中只作用于本群的记忆）
+func executePinMemory(args map[string]interface{}, groupID int64) ToolResult {
+	action, _ := args["action"].(string)
 
-	task := ScheduledTask{
-		Type:    taskType,
-		Content: content,
-		GroupID: groupID,
-		UserID:  userID, // 记录下任务的用户 ID
+	if action == "list" {
+		records, err := ListPinnedMemories(groupID)
+		if err != nil {
+			return ToolResult{Success: false, Message: "读取失败: " + err.Error()}
+		}
+		if len(records) == 0 {
+			return ToolResult{Success: true, Message: "还没有置顶的记忆"}
+		}
+		var sb strings.Builder
+		sb.WriteString(fmt.Sprintf("已置顶的记忆（%d 条）：", len(records)))
+		for _, r := range records {
+			sb.WriteString(fmt.Sprintf("\n- [%s] %s", r.VectorID, pinnedDisplayText(r, groupID)))
+		}
+		return ToolResult{Success: true, Message: sb.String(), Data: map[string]int{"count": len(records)}, DirectReply: sb.String()}
 	}
 
-	if taskType == "once" {
-		delaySec, ok := args["delay_seconds"].(float64)
-		if !ok {
-			return ToolResult{Success: false, Message: "单次任务需要提供有效的 delay_seconds"}
+	if action != "pin" && action != "unpin" {
+		return ToolResult{Success: false, Message: "参数 action 无效"}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var emb models.MemberEmbedding
+	if vectorID, _ := args["vector_id"].(string); strings.TrimSpace(vectorID) != "" {
+		query := database.DB.Where("vector_id = ?", strings.TrimSpace(vectorID))
+		if groupID != 0 {
+			query = query.Where("ref_msg_id IN (?)",
+				database.DB.Model(&models.ChatHistory{}).Unscoped().Select("id").Where("group_id = ?", groupID))
 		}
-		task.TargetAt = time.Now().Unix() + int64(delaySec)
-	} else if taskType == "periodic" {
-		cronExpr, ok := args["cron_expr"].(string)
-		if !ok {
-			return ToolResult{Success: false, Message: "周期任务需要提供有效的 cron_expr"}
+		if err := query.First(&emb).Error; err != nil {
+			return ToolResult{Success: false, Message: "没找到 ID 为 " + vectorID + " 的记忆"}
 		}
-		task.TimeExpr = cronExpr
+		if privateInGroup(emb, groupID) {
+			return ToolResult{Success: false, Message: "这是未共享的个人信息，请在私聊中置顶"}
+		}
+	} else {
+		memory, _ := args["memory"].(string)
+		if strings.TrimSpace(memory) == "" {
+			return ToolResult{Success: false, Message: "请说明是哪条记忆（memory 或 vector_id）"}
+		}
+		found, err := FindMemoryToPin(ctx, memory, groupID)
+		if err != nil {
+			return ToolResult{Success: false, Message: "没找到和「" + memory + "」相关的记忆"}
+		}
+		emb = found
 	}
 
-	if err := AddTask(task); err != nil {
-		return ToolResult{Success: false, Message: "设置提醒失败: " + err.Error()}
+	pinned := action == "pin"
+	if err := SetMemoryPinned(ctx, &emb, pinned); err != nil {
+		return ToolResult{Success: false, Message: "更新失败: " + err.Error()}
+	}
+	data := map[string]interface{}{"vector_id": emb.VectorID, "pinned": pinned}
+	if pinned {
+		return ToolResult{Success: true, Message: "已置顶这条记忆，以后不会忘记：" + pinnedDisplayText(emb, groupID), Data: data}
+	}
+	return ToolResult{Success: true, Message: "已取消置顶：" + pinnedDisplayText(emb, groupID), Data: data}
+}
+
+// executeReviewCareTasks 审核待确认的主动关怀随访
+func executeReviewCareTasks(args map[string]interface{}) ToolResult {
+	action, _ := args["action"].(string)
+
+	if action == "list" {
+		tasks, err := ListPendingCare()
+		if err != nil {
+			return ToolResult{Success: false, Message: "读取失败: " + err.Error()}
+		}
+		if len(tasks) == 0 {
+			return ToolResult{Success: true, Message: "没有待审核的随访"}
+		}
+		var sb strings.Builder
+		sb.WriteString(fmt.Sprintf("待审核的随访（%d 条）：", len(tasks)))
+		for _, t := range tasks {
+			reason, origMsg := parseCareTask(t.Content)
+			sb.WriteString(fmt.Sprintf("\n- %s：群 %d 用户 %d，%s，原话「%s」，计划 %s",
+				t.ID, t.GroupID, t.UserID, reason, origMsg,
+				time.Unix(t.TargetAt, 0).In(botLocation()).Format("01-02 15:04")))
+		}
+		return ToolResult{Success: true, Message: sb.String(), Data: tasks}
 	}
 
-	return ToolResult{Success: true, Message: "设置成功！到时间我会提醒你的~ ID: " + task.ID}
+	if action != "approve" && action != "reject" {
+		return ToolResult{Success: false, Message: "参数 action 无效"}
+	}
+	id, _ := args["task_id"].(string)
+	if id == "" {
+		return ToolResult{Success: false, Message: "请提供 task_id"}
+	}
+	task, err := ReviewPendingCare(id, action == "approve")
+	if err != nil {
+		return ToolResult{Success: false, Message: "操作失败: " + err.Error()}
+	}
+	if action == "approve" {
+		return ToolResult{Success: true, Message: fmt.Sprintf("已批准随访 %s", id), Data: task}
+	}
+	return ToolResult{Success: true, Message: fmt.Sprintf("已拒绝随访 %s", id), Data: task}
 }
 
-// executeListTimerTasks 列出任务
-func executeListTimerTasks(groupID int64, userID int64, isSuperUser bool) ToolResult {
-	queryUserID := userID
-	if isSuperUser {
-		queryUserID = 0 // 超级用户查询全量
+// executeEvaluateProactive 回放一条消息的主动性判断
+func executeEvaluateProactive(args map[string]interface{}, groupID int64, userID int64) ToolResult {
+	content, _ := args["content"].(string)
+	content = strings.TrimSpace(content)
+	if qq, _ := args["user_qq"].(string); qq != "" {
+		id, err := strconv.ParseInt(qq, 10, 64)
+		if err != nil {
+			return ToolResult{Success: false, Message: "请提供有效的 QQ 号"}
+		}
+		userID = id
 	}
 
-	tasks := ListTasks(groupID, queryUserID)
-	if len(tasks) == 0 {
-		return ToolResult{Success: true, Message: "目前没有设置任何活跃的任务哦。"}
+	if mid, ok := args["message_id"].(float64); ok && mid != 0 {
+		var history models.ChatHistory
+		err := database.DB.Preload("User").Where("message_id = ? AND from_bot = ?", int64(mid), false).
+			Order("created_at DESC").First(&history).Error
+		if err != nil {
+			return ToolResult{Success: false, Message: "没有找到这条历史消息"}
+		}
+		content, groupID = history.Content, history.GroupID
+		if id, err := strconv.ParseInt(history.User.QQ, 10, 64); err == nil {
+			userID = id
+		}
+	}
+	if content == "" {
+		return ToolResult{Success: false, Message: "请提供 content 或 message_id"}
 	}
 
-	msg := "你当前的定时任务如下：\n"
-	if isSuperUser {
-		msg = "本群当前活跃的定时任务如下（超级用户视图）：\n"
+	interject, score, care, reason := EvaluateProactive(content, groupID, userID)
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("消息「%s」（群 %d，用户 %d）：", content, groupID, userID))
+	if interject {
+		sb.WriteString(fmt.Sprintf("\n- 主动插嘴：会（记忆相似度 %.3f ≥ %.2f）", score, proactiveScoreThreshold))
+	} else {
+		sb.WriteString(fmt.Sprintf("\n- 主动插嘴：不会（记忆相似度 %.3f < %.2f）", score, proactiveScoreThreshold))
+	}
+	if care {
+		sb.WriteString("\n- 关怀随访：会，原因：" + reason)
+	} else {
+		sb.WriteString("\n- 关怀随访：不会，分类说明：" + reason)
+	}
+	if groupID != 0 {
+		sb.WriteString(fmt.Sprintf("\n（本群开关：主动插嘴%s，关怀%s；未计入冷却和每日上限）",
+			onOffLabel(IsProactiveEnabled(groupID)), onOffLabel(IsCareEnabled(groupID))))
 	}
 
-	for _, t := range tasks {
-		timeStr := ""
-		if t.Type == "once" {
-			timeStr = time.Unix(t.TargetAt, 0).Format("2006-01-02 15:04:05")
-		} else {
-			timeStr = "周期性: " + t.TimeExpr
+	return ToolResult{Success: true, Message: sb.String(), Data: map[string]interface{}{
+		"would_interject": interject,
+		"score":           score,
+		"would_care":      care,
+		"reason":          reason,
+	}}
+}
+
+// onOffLabel 开关状态的中文描述
+func onOffLabel(on bool) string {
+	if on {
+		return "开启"
+	}
+	return "关闭"
+}
+
+// executeRepairTimerTasks 列出或删除无法解析的定时任务记录
+func executeRepairTimerTasks(args map[string]interface{}) ToolResult {
+	action, _ := args["action"].(string)
+
+	var corrupted []CorruptedTask
+	var err error
+	switch action {
+	case "list":
+		corrupted, err = FindCorruptedTasks()
+	case "remove":
+		corrupted, err = RemoveCorruptedTasks()
+	default:
+		return ToolResult{Success: false, Message: "参数 action 无效"}
+	}
+	if err != nil {
+		return ToolResult{Success: false, Message: "读取任务存储失败: " + err.Error()}
+	}
+	if len(corrupted) == 0 {
+		return ToolResult{Success: true, Message: "没有发现损坏的任务记录"}
+	}
+
+	var sb strings.Builder
+	if action == "remove" {
+		sb.WriteString(fmt.Sprintf("已删除 %d 条损坏的任务记录：", len(corrupted)))
+	} else {
+		sb.WriteString(fmt.Sprintf("发现 %d 条损坏的任务记录：", len(corrupted)))
+	}
+	for _, c := range corrupted {
+		sb.WriteString(fmt.Sprintf("\n- [%s] %s：%s（%s）", c.Key, c.ID, c.Raw, c.Error))
+	}
+	return ToolResult{Success: true, Message: sb.String(), Data: map[string]int{"count": len(corrupted)}}
+}
+
+// executeSchedulerDebug 报告调度器内部状态，直接暴露内存 cron 映射与 Redis 不一致的问题
+func executeSchedulerDebug() ToolResult {
+	status := InspectScheduler()
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("已加载周期任务 %d 个，一次性任务 ZSet 中 %d 个", len(status.Periodic), status.OneshotQueued))
+	if status.OneshotMemory > 0 {
+		sb.WriteString(fmt.Sprintf("，内存暂存 %d 个", status.OneshotMemory))
+	}
+	if status.RedisError != "" {
+		sb.WriteString("\n读取 Redis 失败，无法比对: " + status.RedisError)
+	}
+	if len(status.MissingInRedis) > 0 {
+		sb.WriteString("\n内存中有但 Redis 中没有（重启后会丢失）: " + strings.Join(status.MissingInRedis, ", "))
+	}
+	if len(status.NotLoaded) > 0 {
+		sb.WriteString("\nRedis 中有但未加载到 cron（不会触发）: " + strings.Join(status.NotLoaded, ", "))
+	}
+	if status.RedisError == "" && len(status.MissingInRedis) == 0 && len(status.NotLoaded) == 0 {
+		sb.WriteString("\n内存与 Redis 中的周期任务一致")
+	}
+	for _, p := range status.Periodic {
+		next := "未调度"
+		if !p.Next.IsZero() {
+			next = p.Next.In(botLocation()).Format("2006-01-02 15:04:05")
 		}
+		expr := p.TimeExpr
+		if expr == "" {
+			expr = "Redis 中无记录"
+		}
+		sb.WriteString(fmt.Sprintf("\n- %s [%s] 下次触发: %s", p.ID, expr, next))
+	}
 
-		userLabel := ""
-		if isSuperUser {
-			userLabel = fmt.Sprintf(" [用户:%d]", t.UserID)
+	msg := sb.String()
+	return ToolResult{Success: true, Message: msg, Data: status, DirectReply: msg}
+}
+
+// executeManageGroupAllowlist 管理机器人工作的群组白名单
+func executeManageGroupAllowlist(args map[string]interface{}, groupID int64) ToolResult {
+	if !GroupAllowlistEnabled() {
+		return ToolResult{Success: false, Message: "未配置 BOT_GROUP_ALLOWLIST，群组白名单未启用"}
+	}
+	action, _ := args["action"].(string)
+
+	if action == "list" {
+		ids, err := ListAllowedGroups()
+		if err != nil {
+			return ToolResult{Success: false, Message: "读取失败: " + err.Error()}
 		}
+		names := make([]string, len(ids))
+		for i, id := range ids {
+			names[i] = strconv.FormatInt(id, 10)
+		}
+		return ToolResult{Success: true, Message: "群组白名单: " + strings.Join(names, ", "), Data: ids}
+	}
 
-		msg += fmt.Sprintf("- [%s] %s (%s)%s\n", t.ID, t.Content, timeStr, userLabel)
+	if action != "add" && action != "remove" {
+		return ToolResult{Success: false, Message: "参数 action 无效"}
+	}
+	target := groupID
+	if raw, _ := args["group_id"].(string); raw != "" {
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || id <= 0 {
+			return ToolResult{Success: false, Message: "请提供有效的群号"}
+		}
+		target = id
+	}
+	if target == 0 {
+		return ToolResult{Success: false, Message: "私聊中请指定群号"}
+	}
+
+	if err := UpdateGroupAllowlist(target, action == "add"); err != nil {
+		return ToolResult{Success: false, Message: "保存失败: " + err.Error()}
+	}
+	if action == "add" {
+		return ToolResult{Success: true, Message: fmt.Sprintf("已将群 %d 加入白名单", target), Data: map[string]int64{"group_id": target}}
 	}
-	return ToolResult{Success: true, Message: msg, Data: tasks}
+	return ToolResult{Success: true, Message: fmt.Sprintf("已将群 %d 移出白名单", target), Data: map[string]int64{"group_id": target}}
 }
 
-// executeRemoveTimerTask 移除任务
-func executeRemoveTimerTask(args map[string]interface{}) ToolResult {
-	id, ok := args["id"].(string)
-	if !ok || id == "" {
-		return ToolResult{Success: false, Message: "移除失败：请提供有效的任务 ID"}
+// executeManageGroupTools 管理群组的工具白名单
+func executeManageGroupTools(args map[string]interface{}, groupID int64) ToolResult {
+	if groupID == 0 {
+		return ToolResult{Success: false, Message: "工具白名单只能在群聊中设置"}
 	}
+	action, _ := args["action"].(string)
+	raw, _ := args["tools"].(string)
 
-	if err := RemoveTask(id); err != nil {
-		return ToolResult{Success: false, Message: "取消任务失败: " + err.Error()}
+	if action == "list" {
+		list := GetGroupConfig(groupID).EnabledTools
+		if len(list) == 0 {
+			return ToolResult{Success: true, Message: "本群所有功能都对成员开放", Data: list}
+		}
+		return ToolResult{Success: true, Message: "本群成员可用的功能: " + strings.Join(list, ", "), Data: list}
 	}
 
-	return ToolResult{Success: true, Message: "成功取消了该任务！"}
+	var names []string
+	for _, name := range strings.Split(raw, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		known := false
+		for _, tool := range AvailableTools {
+			if tool.Name == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return ToolResult{Success: false, Message: "未知的工具: " + name}
+		}
+		names = append(names, name)
+	}
+	if action != "reset" && len(names) == 0 {
+		return ToolResult{Success: false, Message: "请提供工具名"}
+	}
+	// 白名单为空表示全部开放：add 不需要改动（保持全部开放，之后新增的工具也自动开放），
+	// remove 先以全部工具为起点再移除，否则只剩下被移除的几个以外什么都不剩
+	current := GetGroupConfig(groupID).EnabledTools
+	if action == "add" && len(current) == 0 {
+		return ToolResult{Success: true, Message: "本群所有功能都对成员开放，无需添加", Data: current}
+	}
+	if action == "remove" {
+		if len(current) == 0 {
+			current = allToolNames()
+		}
+		remaining := slices.DeleteFunc(slices.Clone(current), func(t string) bool {
+			return slices.Contains(names, t)
+		})
+		if len(remaining) == 0 {
+			return ToolResult{Success: false, Message: "移除后白名单为空（即全部开放），如确需全部开放请使用 reset"}
+		}
+	}
+
+	cfg, err := UpdateGroupConfig(groupID, func(cfg *models.GroupConfig) {
+		switch action {
+		case "set":
+			cfg.EnabledTools = names
+		case "reset":
+			cfg.EnabledTools = nil
+		case "add", "remove":
+			if len(cfg.EnabledTools) == 0 {
+				if action == "add" {
+					return // 读取之后被并发 reset 为全部开放
+				}
+				cfg.EnabledTools = allToolNames()
+			}
+			kept := cfg.EnabledTools[:0]
+			for _, t := range cfg.EnabledTools {
+				if !slices.Contains(names, t) {
+					kept = append(kept, t)
+				}
+			}
+			if action == "add" {
+				kept = append(kept, names...)
+			}
+			cfg.EnabledTools = kept
+		}
+	})
+	if err != nil {
+		return ToolResult{Success: false, Message: "保存失败: " + err.Error()}
+	}
+
+	if len(cfg.EnabledTools) == 0 {
+		return ToolResult{Success: true, Message: "本群所有功能都对成员开放", Data: cfg.EnabledTools}
+	}
+	return ToolResult{Success: true, Message: "本群成员可用的功能已更新: " + strings.Join(cfg.EnabledTools, ", "), Data: cfg.EnabledTools}
 }
 
 // executeToggleBot 开关机器人
@@ -253,10 +2086,9 @@ func executeToggleBot(args map[string]interface{}, groupID int64) ToolResult {
 	}
 
 	// 查找或创建群组配置
-	var group models.Group
-	result := database.DB.FirstOrCreate(&group, models.Group{GroupID: groupID})
-	if result.Error != nil {
-		return ToolResult{Success: false, Message: "数据库错误: " + result.Error.Error()}
+	group, err := FirstOrCreateGroup(groupID)
+	if err != nil {
+		return ToolResult{Success: false, Message: "数据库错误: " + err.Error()}
 	}
 
 	// 更新状态
@@ -264,6 +2096,7 @@ func executeToggleBot(args map[string]interface{}, groupID int64) ToolResult {
 	if err := database.DB.Save(&group).Error; err != nil {
 		return ToolResult{Success: false, Message: "保存失败: " + err.Error()}
 	}
+	invalidateGroupSwitches(groupID)
 
 	if active {
 		return ToolResult{Success: true, Message: "机器人已开启", Data: map[string]bool{"active": true}}
@@ -277,15 +2110,18 @@ func executeGetBotStatus(groupID int64) ToolResult {
 	result := database.DB.Where("group_id = ?", groupID).First(&group)
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			if !defaultGroupSwitches().Active {
+				return ToolResult{Success: true, Message: "机器人当前是关闭状态", Data: map[string]bool{"active": false}}
+			}
 			return ToolResult{Success: true, Message: "机器人当前是开启状态", Data: map[string]bool{"active": true}}
 		}
 		return ToolResult{Success: false, Message: "数据库错误: " + result.Error.Error()}
 	}
 
 	if group.IsActive {
-		return ToolResult{Success: true, Message: "机器人当前是开启状态", Data: map[string]bool{"active": true}}
+		return ToolResult{Success: true, Message: fmt.Sprintf("【%s】机器人当前是开启状态", GroupLabel(group)), Data: map[string]bool{"active": true}}
 	}
-	return ToolResult{Success: true, Message: "机器人当前是关闭状态", Data: map[string]bool{"active": false}}
+	return ToolResult{Success: true, Message: fmt.Sprintf("【%s】机器人当前是关闭状态", GroupLabel(group)), Data: map[string]bool{"active": false}}
 }
 
 // executeToggleRAG 开关 RAG 功能
@@ -295,16 +2131,16 @@ func executeToggleRAG(args map[string]interface{}, groupID int64) ToolResult {
 		return ToolResult{Success: false, Message: "参数 enabled 无效"}
 	}
 
-	var group models.Group
-	result := database.DB.FirstOrCreate(&group, models.Group{GroupID: groupID})
-	if result.Error != nil {
-		return ToolResult{Success: false, Message: "数据库错误: " + result.Error.Error()}
+	group, err := FirstOrCreateGroup(groupID)
+	if err != nil {
+		return ToolResult{Success: false, Message: "数据库错误: " + err.Error()}
 	}
 
 	group.RAGEnabled = enabled
 	if err := database.DB.Save(&group).Error; err != nil {
 		return ToolResult{Success: false, Message: "保存失败: " + err.Error()}
 	}
+	invalidateGroupSwitches(groupID)
 
 	if enabled {
 		return ToolResult{Success: true, Message: "记忆功能已开启", Data: map[string]bool{"rag_enabled": true}}
@@ -312,12 +2148,63 @@ func executeToggleRAG(args map[string]interface{}, groupID int64) ToolResult {
 	return ToolResult{Success: true, Message: "记忆功能已关闭", Data: map[string]bool{"rag_enabled": false}}
 }
 
+// executeToggleProactive 开关主动插嘴
+func executeToggleProactive(args map[string]interface{}, groupID int64) ToolResult {
+	enabled, ok := args["enabled"].(bool)
+	if !ok {
+		return ToolResult{Success: false, Message: "参数 enabled 无效"}
+	}
+
+	group, err := FirstOrCreateGroup(groupID)
+	if err != nil {
+		return ToolResult{Success: false, Message: "数据库错误: " + err.Error()}
+	}
+
+	group.ProactiveEnabled = enabled
+	if err := database.DB.Save(&group).Error; err != nil {
+		return ToolResult{Success: false, Message: "保存失败: " + err.Error()}
+	}
+	invalidateGroupSwitches(groupID)
+
+	if enabled {
+		return ToolResult{Success: true, Message: "主动插嘴已开启", Data: map[string]bool{"proactive_enabled": true}}
+	}
+	return ToolResult{Success: true, Message: "主动插嘴已关闭，记忆功能不受影响", Data: map[string]bool{"proactive_enabled": false}}
+}
+
+// executeToggleCare 开关主动关怀
+func executeToggleCare(args map[string]interface{}, groupID int64) ToolResult {
+	enabled, ok := args["enabled"].(bool)
+	if !ok {
+		return ToolResult{Success: false, Message: "参数 enabled 无效"}
+	}
+
+	group, err := FirstOrCreateGroup(groupID)
+	if err != nil {
+		return ToolResult{Success: false, Message: "数据库错误: " + err.Error()}
+	}
+
+	group.CareEnabled = enabled
+	if err := database.DB.Save(&group).Error; err != nil {
+		return ToolResult{Success: false, Message: "保存失败: " + err.Error()}
+	}
+	invalidateGroupSwitches(groupID)
+
+	if enabled {
+		return ToolResult{Success: true, Message: "主动关怀已开启", Data: map[string]bool{"care_enabled": true}}
+	}
+	return ToolResult{Success: true, Message: "主动关怀已关闭，不会再主动回访", Data: map[string]bool{"care_enabled": false}}
+}
+
 // executeGetRAGStatus 获取 RAG 状态
 func executeGetRAGStatus(groupID int64) ToolResult {
 	var group models.Group
 	result := database.DB.Where("group_id = ?", groupID).First(&group)
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			if !defaultGroupSwitches().RAG {
+				return ToolResult{Success: true, Message: "记忆功能当前是关闭状态", Data: map[string]bool{"rag_enabled": false}}
+			}
 			return ToolResult{Success: true, Message: "记忆功能当前是开启状态", Data: map[string]bool{"rag_enabled": true}}
 		}
 		return ToolResult{Success: false, Message: "数据库错误: " + result.Error.Error()}
@@ -331,20 +2218,20 @@ func executeGetRAGStatus(groupID int64) ToolResult {
 
 // IsBotActive 检查机器人在指定群是否开启
 func IsBotActive(groupID int64) bool {
-	var group models.Group
-	result := database.DB.Where("group_id = ?", groupID).First(&group)
-	if result.Error != nil {
-		return true // 默认开启
-	}
-	return group.IsActive
+	return getGroupSwitches(groupID).Active
 }
 
 // IsRAGEnabled 检查 RAG 是否在指定群开启
 func IsRAGEnabled(groupID int64) bool {
-	var group models.Group
-	result := database.DB.Where("group_id = ?", groupID).First(&group)
-	if result.Error != nil {
-		return true // 默认开启
-	}
-	return group.RAGEnabled
+	return getGroupSwitches(groupID).RAG
+}
+
+// IsProactiveEnabled 检查主动插嘴是否在指定群开启
+func IsProactiveEnabled(groupID int64) bool {
+	return getGroupSwitches(groupID).Proactive
+}
+
+// IsCareEnabled 检查主动关怀是否在指定群开启
+func IsCareEnabled(groupID int64) bool {
+	return getGroupSwitches(groupID).Care
 }
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gin-bot/database"
+	"gin-bot/models"
+
+	"gorm.io/gorm"
+)
+
+// maxAliasLength 称呼的最大字数
+const maxAliasLength = 16
+
+// GetUserByQQ 按 QQ 号读取用户，不存在时返回零值
+func GetUserByQQ(qq string) models.User {
+	var user models.User
+	database.DB.Where("qq = ?", qq).First(&user)
+	return user
+}
+
+// SetUserAlias 设置用户希望被称呼的名字，alias 为空表示清除
+func SetUserAlias(qq string, alias string) error {
+	alias = strings.TrimSpace(alias)
+	if strings.ContainsAny(alias, "[]\n\r") {
+		return fmt.Errorf("称呼不能包含方括号或换行")
+	}
+	if utf8.RuneCountInString(alias) > maxAliasLength {
+		return fmt.Errorf("称呼最多 %d 个字", maxAliasLength)
+	}
+
+	var user models.User
+	if err := database.DB.FirstOrCreate(&user, models.User{QQ: qq}).Error; err != nil {
+		return err
+	}
+	return database.DB.Model(&user).Update("alias", alias).Error
+}
+
+// cqEscaper 转义 CQ 码参数中的特殊字符
+var cqEscaper = strings.NewReplacer("&", "&amp;", "[", "&#91;", "]", "&#93;", ",", "&#44;")
+
+// MentionCQ 生成 @ 用户的 CQ 码，用户设置了称呼时一并带上（name 参数）
+func MentionCQ(userID int64) string {
+	qq := strconv.FormatInt(userID, 10)
+	if user := GetUserByQQ(qq); user.Alias != "" {
+		return "[CQ:at,qq=" + qq + ",name=" + cqEscaper.Replace(user.Alias) + "]"
+	}
+	return "[CQ:at,qq=" + qq + "]"
+}
+
+// DisplayName 用户的显示名：优先称呼，其次 QQ 昵称，都没有时用 QQ 号
+func DisplayName(user models.User, userID int64) string {
+	switch {
+	case user.Alias != "":
+		return user.Alias
+	case user.Nickname != "":
+		return user.Nickname
+	}
+	return strconv.FormatInt(userID, 10)
+}
+
+// mentionQQRegex 从 at CQ 码中提取 QQ 号
+var mentionQQRegex = regexp.MustCompile(`\[CQ:at,qq=(\d+)[^\]]*\]`)
+
+// ErrMemberNotFound 在群里找不到对应的群友
+var ErrMemberNotFound = errors.New("member not found")
+
+// ResolveGroupMember 把 at CQ 码、QQ 号或称呼/昵称（可带 @）解析为群友的 QQ 号；
+// 称呼和昵称只在本群说过话的人里查找，取最近说过话的那位
+func ResolveGroupMember(ref string, groupID int64) (int64, models.User, error) {
+	ref = strings.TrimSpace(ref)
+	if m := mentionQQRegex.FindStringSubmatch(ref); m != nil {
+		ref = m[1]
+	}
+	ref = strings.TrimPrefix(ref, "@")
+	if ref == "" {
+		return 0, models.User{}, ErrMemberNotFound
+	}
+	if qq, err := strconv.ParseInt(ref, 10, 64); err == nil && qq > 0 {
+		return qq, GetUserByQQ(ref), nil
+	}
+
+	var user models.User
+	err := database.DB.Model(&models.User{}).
+		Joins("JOIN chat_histories ON chat_histories.user_id = users.id").
+		Where("chat_histories.group_id = ? AND chat_histories.from_bot = ?", groupID, false).
+		Where("users.alias = ? OR users.nickname = ?", ref, ref).
+		Order("chat_histories.created_at DESC").
+		Select("users.*").
+		First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, user, ErrMemberNotFound
+	}
+	if err != nil {
+		return 0, user, err
+	}
+	qq, err := strconv.ParseInt(user.QQ, 10, 64)
+	if err != nil {
+		return 0, user, ErrMemberNotFound
+	}
+	return qq, user, nil
+}
@@ -2,12 +2,86 @@ package service
 
 import (
 	"fmt"
+	"log"
+	"math/rand"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"gin-bot/config"
 )
 
+// LogDrop 调试日志：记录消息未被处理（或跳过某一环节）的原因，仅在 DEBUG_LOG 开启时输出
+// user 为 QQ 号（int64 或字符串均可）
+func LogDrop(reason string, groupID int64, user any) {
+	if cfg := config.Get(); cfg == nil || !cfg.DebugLog {
+		return
+	}
+	log.Printf("[Drop] reason=%s group=%d user=%v", reason, groupID, user)
+}
+
+// defaultEmptyReply 关闭随机兜底时使用的固定回复
+const defaultEmptyReply = "我不知道该怎么回答你..."
+
+// defaultEmptyReplyFallbacks 模型返回空内容时随机选用的兜底回复（符合小黄人设）
+var defaultEmptyReplyFallbacks = []string{
+	"呃…脑子突然卡壳了，你再说一遍？🤔",
+	"好家伙，这题把我问住了 hhh",
+	"等等，我刚走神了😂 你说啥来着",
+	"让我缓缓…一时没想好怎么接",
+	"这个嘛…容我组织一下语言 💪",
+}
+
+// emptyReplyFallback 模型返回空回复时的兜底文案，并记录具体原因便于区分模型问题
+func emptyReplyFallback(cause string) string {
+	log.Printf("[Chat] Model returned empty reply (%s), using fallback", cause)
+	cfg := config.Get()
+	if cfg == nil || !cfg.EmptyReplyVariety {
+		return defaultEmptyReply
+	}
+	pool := cfg.EmptyReplyFallbacks
+	if len(pool) == 0 {
+		pool = defaultEmptyReplyFallbacks
+	}
+	return pool[rand.Intn(len(pool))]
+}
+
+// addressedRule 被直接提问时追加到规则列表末尾的一条（编号为 n），ADDRESSED_DIRECTIVE 为 none 时返回空
+// 只用于 @ 机器人/私聊的回复管线，主动插嘴与随口接话不受影响
+func addressedRule(n int) string {
+	cfg := config.Get()
+	if cfg == nil {
+		return ""
+	}
+	directive := strings.TrimSpace(cfg.AddressedDirective)
+	if directive == "" || strings.EqualFold(directive, "none") {
+		return ""
+	}
+	return fmt.Sprintf("\n%d. %s", n, directive)
+}
+
+// weekdayNames 星期的中文写法
+var weekdayNames = [...]string{"日", "一", "二", "三", "四", "五", "六"}
+
+// botLocation 机器人时区（未初始化配置时使用系统时区）
+func botLocation() *time.Location {
+	cfg := config.Get()
+	if cfg == nil || cfg.Location == nil {
+		return time.Local
+	}
+	return cfg.Location
+}
+
+// timeInfoBlock 提示词中的当前时间信息，如 "【当前时间：2024-05-01 20:30 星期三（Asia/Shanghai）】"
+func timeInfoBlock() string {
+	t := Now().In(botLocation())
+	return fmt.Sprintf("【当前时间：%s 星期%s（%s）】", t.Format("2006-01-02 15:04"), weekdayNames[t.Weekday()], botLocation())
+}
+
 // formatRelativeTime 将时间转换为相对时间描述（如：2小时，3天）
 func formatRelativeTime(t time.Time) string {
-	duration := time.Since(t)
+	duration := Since(t)
 
 	if duration.Seconds() < 60 {
 		return "刚刚"
@@ -21,5 +95,55 @@ func formatRelativeTime(t time.Time) string {
 	if duration.Hours() < 24*30 {
 		return fmt.Sprintf("%d天", int(duration.Hours()/24))
 	}
-	return t.Format("2006-01-02")
+	return t.In(botLocation()).Format("2006-01-02")
+}
+
+// estimateTokens 粗略估算文本的 token 数：汉字等 CJK 字符按 1 个计，其余按每 4 字节 1 个计
+func estimateTokens(s string) int {
+	cjk, other := 0, 0
+	for _, r := range s {
+		if isCJK(r) {
+			cjk++
+		} else {
+			other += utf8.RuneLen(r)
+		}
+	}
+	return cjk + (other+3)/4
+}
+
+// isCJK 判断是否为中日韩文字
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// sentenceEnds 可作为截断位置的句末标点
+const sentenceEnds = "。！？!?…~～\n"
+
+// truncateReply 回复超过 token 上限时的兜底截断：在上限内最后一个句末标点处截断，
+// 找不到合适的句末时硬截断并补省略号
+func truncateReply(reply string, maxTokens int) string {
+	if maxTokens <= 0 || estimateTokens(reply) <= maxTokens {
+		return reply
+	}
+
+	// 找到不超过上限的最长前缀
+	cut, cjk, other := 0, 0, 0
+	for i, r := range reply {
+		if isCJK(r) {
+			cjk++
+		} else {
+			other += utf8.RuneLen(r)
+		}
+		if cjk+(other+3)/4 > maxTokens {
+			break
+		}
+		cut = i + utf8.RuneLen(r)
+	}
+
+	kept := reply[:cut]
+	if i := strings.LastIndexAny(kept, sentenceEnds); i > len(kept)/2 {
+		_, size := utf8.DecodeRuneInString(kept[i:])
+		return strings.TrimSpace(kept[:i+size])
+	}
+	return strings.TrimSpace(kept) + "…"
 }
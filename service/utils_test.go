@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatRelativeTimeWithMockClock(t *testing.T) {
+	cfg := setupTestEnv(t)
+	start := time.Date(2024, 5, 1, 20, 30, 0, 0, cfg.Location)
+	clk := useMockClock(t, start)
+
+	steps := []struct {
+		advance time.Duration
+		want    string
+	}{
+		{0, "刚刚"},
+		{59 * time.Second, "刚刚"},
+		{time.Second, "1分钟"},
+		{58 * time.Minute, "59分钟"},
+		{time.Minute, "1小时"},
+		{22 * time.Hour, "23小时"},
+		{time.Hour, "1天"},
+		{28 * 24 * time.Hour, "29天"},
+		{24 * time.Hour, "2024-05-01"},
+	}
+	for _, step := range steps {
+		clk.Advance(step.advance)
+		if got := formatRelativeTime(start); got != step.want {
+			t.Errorf("after %v: formatRelativeTime = %q, want %q", clk.Now().Sub(start), got, step.want)
+		}
+	}
+}
+
+func TestTimeInfoBlockFollowsClock(t *testing.T) {
+	cfg := setupTestEnv(t)
+	clk := useMockClock(t, time.Date(2024, 5, 1, 23, 50, 0, 0, cfg.Location))
+
+	if got, want := timeInfoBlock(), "【当前时间：2024-05-01 23:50 星期三（CST）】"; got != want {
+		t.Errorf("timeInfoBlock = %q, want %q", got, want)
+	}
+	clk.Advance(20 * time.Minute)
+	if got, want := timeInfoBlock(), "【当前时间：2024-05-02 00:10 星期四（CST）】"; got != want {
+		t.Errorf("after advance: timeInfoBlock = %q, want %q", got, want)
+	}
+}
+
+func TestAddressedRule(t *testing.T) {
+	const directive = "直接回答对方的问题，不要反问"
+	tests := []struct {
+		name      string
+		directive string
+		want      string
+	}{
+		{"set", directive, "\n5. " + directive},
+		{"trimmed", "  " + directive + "\n", "\n5. " + directive},
+		{"empty", "", ""},
+		{"none", "none", ""},
+		{"none any case", " NONE ", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := setupTestEnv(t)
+			cfg.AddressedDirective = tt.directive
+			if got := addressedRule(5); got != tt.want {
+				t.Errorf("addressedRule(5) = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddressedRuleInPrompts(t *testing.T) {
+	const directive = "直接回答对方的问题，不要反问"
+	const memory = "【回忆】: (3天前) 周六早上九点在公园门口集合"
+
+	// fcSystemPrompt 跑一遍 FC 管线（检索到 memory 这条回忆），返回模型收到的系统提示词
+	fcSystemPrompt := func(t *testing.T) string {
+		var system string
+		server := httptest.NewServer(mockLLMHandler(t, func(req MockLLMRequest) FCMessage {
+			system = req.Messages[0].Content
+			return FCMessage{Content: "周六九点，公园门口见"}
+		}))
+		defer server.Close()
+		env := fcEnv{
+			chatURL: server.URL,
+			execTool: func(name string, args map[string]interface{}, groupID int64, userID int64, isSuperUser bool) ToolResult {
+				return ToolResult{}
+			},
+			retrieve: func(ctx context.Context, prompt string, groupID int64, userID int64) retrievalResult {
+				return retrievalResult{ContextBlock: memory}
+			},
+		}
+		if _, _, err := getAIResponseWithFC(env, "明天几点集合？", 30003, 20002, false, ""); err != nil {
+			t.Fatalf("getAIResponseWithFC: %v", err)
+		}
+		return system
+	}
+
+	for _, tt := range []struct {
+		name      string
+		directive string
+		want      bool
+	}{
+		{"directive set", directive, true},
+		{"directive empty", "", false},
+		{"directive none", "none", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := setupTestEnv(t)
+			cfg.AddressedDirective = tt.directive
+
+			// @ 机器人/私聊的回复管线：检索到回忆时，回忆与"必须正面回答"的要求同时出现在提示词中
+			addressed := map[string]string{
+				"GetAIResponse": chatSystemPrompt("", memory, ""),
+				"FC":            fcSystemPrompt(t),
+			}
+			for pipeline, prompt := range addressed {
+				if !strings.Contains(prompt, memory) {
+					t.Errorf("%s prompt lost the retrieved memory", pipeline)
+				}
+				if got := strings.Contains(prompt, "\n5. "+directive); got != tt.want {
+					t.Errorf("%s prompt contains directive = %v, want %v", pipeline, got, tt.want)
+				}
+				if !tt.want && strings.Contains(prompt, "\n5. ") {
+					t.Errorf("%s prompt has an empty rule 5", pipeline)
+				}
+				if tt.want && strings.Index(prompt, directive) < strings.Index(prompt, memory) {
+					t.Errorf("%s prompt: directive should follow the retrieved memory", pipeline)
+				}
+			}
+
+			// 主动插嘴与随口接话没有被直接提问，始终不追加
+			unaddressed := map[string]string{
+				"proactive":    proactiveSystemPrompt(30003, "", memory),
+				"random reply": randomReplySystemPrompt(30003),
+			}
+			for pipeline, prompt := range unaddressed {
+				if strings.Contains(prompt, directive) {
+					t.Errorf("%s prompt should not contain the addressed directive", pipeline)
+				}
+			}
+		})
+	}
+}